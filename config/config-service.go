@@ -1,16 +1,20 @@
 package main
 
 import (
+	"bytes"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
 
-	//"strings"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 )
@@ -43,6 +47,11 @@ type compressionType struct {
 	CompressionTypeName string `db:"compression_type_name" json:"compression_type_name,omitempty"`
 }
 
+type fileFormat struct {
+	FileFormatId   int    `db:"file_format_id" json:"file_format_id,omitempty"`
+	FileFormatName string `db:"file_format_name" json:"file_format_name,omitempty"`
+}
+
 /*
 //GCP config structure
 type GCPCredentials struct {
@@ -75,10 +84,42 @@ type stream_json struct {
 	AWSAcessKeyID           string                 `db:"aws_access_key_id" json:"aws_access_key_id,omitempty"`
 	AWSSecretAcessKey       string                 `db:"aws_secret_access_key" json:"aws_secret_access_key,omitempty"`
 	GCPJsonCredentials      map[string]interface{} `db:"gcp_json_credentials" json:"gcp_json_credentials,omitempty"`
-	AzureStorageAccountname string                 `db:"azure_storage_account_name" json:"azure_storage_account_name, omitempty"`
-	AzureStorageAccessKey   string                 `db:"azure_storage_access_key" json:"azure_storage_access_key, omitempty"`
-	NamenodeHost            string                 `db:"namenode_host" json:"namenode_host, omitempty"`
-	NamenodePort            int                    `db:"namenode_port" json:"namenode_port, omitempty"`
+	AzureStorageAccountname string                 `db:"azure_storage_account_name" json:"azure_storage_account_name,omitempty"`
+	AzureStorageAccessKey   string                 `db:"azure_storage_access_key" json:"azure_storage_access_key,omitempty"`
+	NamenodeHost            string                 `db:"namenode_host" json:"namenode_host,omitempty"`
+	NamenodePort            int                    `db:"namenode_port" json:"namenode_port,omitempty"`
+	EndpointURL             string                 `db:"endpoint_url" json:"endpoint_url,omitempty"`
+	S3ForcePathStyle        bool                   `db:"s3_force_path_style" json:"s3_force_path_style,omitempty"`
+	TLSSkipVerify           bool                   `db:"tls_skip_verify" json:"tls_skip_verify,omitempty"`
+	TLSCACert               string                 `db:"tls_ca_cert" json:"tls_ca_cert,omitempty"`
+	S3SignatureVersion      string                 `db:"s3_signature_version" json:"s3_signature_version,omitempty"`
+	AuthMode                string                 `db:"auth_mode" json:"auth_mode,omitempty"`
+	AWSRoleArn              string                 `db:"aws_role_arn" json:"aws_role_arn,omitempty"`
+	SecretRef               string                 `db:"secret_ref" json:"secret_ref,omitempty"`
+	FileNaming              string                 `db:"file_naming" json:"file_naming,omitempty"`
+	TableFormat             string                 `db:"table_format" json:"table_format,omitempty"`
+	FileFormatID            int                    `db:"file_format_id" json:"file_format_id,omitempty"`
+	ParquetRowGroupSize     int64                  `db:"parquet_row_group_size" json:"parquet_row_group_size,omitempty"`
+	ParquetPageSize         int                    `db:"parquet_page_size" json:"parquet_page_size,omitempty"`
+	ParquetDictionary       bool                   `db:"parquet_dictionary" json:"parquet_dictionary,omitempty"`
+	ParquetSortColumns      string                 `db:"parquet_sort_columns" json:"parquet_sort_columns,omitempty"`
+	CompactionEnabled       bool                   `db:"compaction_enabled" json:"compaction_enabled,omitempty"`
+	CompactionMinFiles      int                    `db:"compaction_min_files" json:"compaction_min_files,omitempty"`
+	CompactionTargetSize    int64                  `db:"compaction_target_size" json:"compaction_target_size,omitempty"`
+	RetentionDays           int                    `db:"retention_days" json:"retention_days,omitempty"`
+	RetentionDryRun         bool                   `db:"retention_dry_run" json:"retention_dry_run,omitempty"`
+	MaskingKeyRef           string                 `db:"masking_key_ref" json:"masking_key_ref,omitempty"`
+	Transformations         json.RawMessage        `db:"transformations" json:"transformations,omitempty"`
+	DedupKey                string                 `db:"dedup_key" json:"dedup_key,omitempty"`
+	DedupTTLSeconds         int                    `db:"dedup_ttl_seconds" json:"dedup_ttl_seconds,omitempty"`
+	SessionizationEnabled   bool                   `db:"sessionization_enabled" json:"sessionization_enabled,omitempty"`
+	SessionTimeoutSeconds   int                    `db:"session_timeout_seconds" json:"session_timeout_seconds,omitempty"`
+	EnrichmentEnabled       bool                   `db:"enrichment_enabled" json:"enrichment_enabled,omitempty"`
+	MetadataColumnsEnabled  bool                   `db:"metadata_columns_enabled" json:"metadata_columns_enabled,omitempty"`
+	EgressTargets           json.RawMessage        `db:"egress_targets" json:"egress_targets,omitempty"`
+	PostgresEnabled         bool                   `db:"postgres_enabled" json:"postgres_enabled,omitempty"`
+	PostgresURL             string                 `db:"postgres_url" json:"postgres_url,omitempty"`
+	PostgresSchema          string                 `db:"postgres_schema" json:"postgres_schema,omitempty"`
 }
 
 type stream_sql struct {
@@ -95,10 +136,260 @@ type stream_sql struct {
 	AWSAcessKeyID           sql.NullString `db:"aws_access_key_id" json:"aws_access_key_id,omitempty"`
 	AWSSecretAcessKey       sql.NullString `db:"aws_secret_access_key" json:"aws_secret_access_key,omitempty"`
 	GCPJsonCredentials      sql.NullString `db:"gcp_json_credentials" json:"gcp_json_credentials,omitempty"`
-	AzureStorageAccountname sql.NullString `db:"azure_storage_account_name" json:"azure_storage_account_name, omitempty"`
-	AzureStorageAccessKey   sql.NullString `db:"azure_storage_access_key" json:"azure_storage_access_key, omitempty"`
-	NamenodeHost            string         `db:"namenode_host" json:"namenode_host, omitempty"`
-	NamenodePort            int            `db:"namenode_port" json:"namenode_port, omitempty"`
+	AzureStorageAccountname sql.NullString `db:"azure_storage_account_name" json:"azure_storage_account_name,omitempty"`
+	AzureStorageAccessKey   sql.NullString `db:"azure_storage_access_key" json:"azure_storage_access_key,omitempty"`
+	NamenodeHost            string         `db:"namenode_host" json:"namenode_host,omitempty"`
+	NamenodePort            int            `db:"namenode_port" json:"namenode_port,omitempty"`
+	EndpointURL             sql.NullString `db:"endpoint_url" json:"endpoint_url,omitempty"`
+	S3ForcePathStyle        sql.NullBool   `db:"s3_force_path_style" json:"s3_force_path_style,omitempty"`
+	TLSSkipVerify           sql.NullBool   `db:"tls_skip_verify" json:"tls_skip_verify,omitempty"`
+	TLSCACert               sql.NullString `db:"tls_ca_cert" json:"tls_ca_cert,omitempty"`
+	S3SignatureVersion      sql.NullString `db:"s3_signature_version" json:"s3_signature_version,omitempty"`
+	AuthMode                sql.NullString `db:"auth_mode" json:"auth_mode,omitempty"`
+	AWSRoleArn              sql.NullString `db:"aws_role_arn" json:"aws_role_arn,omitempty"`
+	SecretRef               sql.NullString `db:"secret_ref" json:"secret_ref,omitempty"`
+	FileNaming              sql.NullString `db:"file_naming" json:"file_naming,omitempty"`
+	TableFormat             sql.NullString `db:"table_format" json:"table_format,omitempty"`
+	FileFormatID            sql.NullInt64  `db:"file_format_id" json:"file_format_id,omitempty"`
+	ParquetRowGroupSize     sql.NullInt64  `db:"parquet_row_group_size" json:"parquet_row_group_size,omitempty"`
+	ParquetPageSize         sql.NullInt64  `db:"parquet_page_size" json:"parquet_page_size,omitempty"`
+	ParquetDictionary       sql.NullBool   `db:"parquet_dictionary" json:"parquet_dictionary,omitempty"`
+	ParquetSortColumns      sql.NullString `db:"parquet_sort_columns" json:"parquet_sort_columns,omitempty"`
+	CompactionEnabled       sql.NullBool   `db:"compaction_enabled" json:"compaction_enabled,omitempty"`
+	CompactionMinFiles      sql.NullInt64  `db:"compaction_min_files" json:"compaction_min_files,omitempty"`
+	CompactionTargetSize    sql.NullInt64  `db:"compaction_target_size" json:"compaction_target_size,omitempty"`
+	RetentionDays           sql.NullInt64  `db:"retention_days" json:"retention_days,omitempty"`
+	RetentionDryRun         sql.NullBool   `db:"retention_dry_run" json:"retention_dry_run,omitempty"`
+	MaskingKeyRef           sql.NullString `db:"masking_key_ref" json:"masking_key_ref,omitempty"`
+	Transformations         sql.NullString `db:"transformations" json:"transformations,omitempty"`
+	DedupKey                sql.NullString `db:"dedup_key" json:"dedup_key,omitempty"`
+	DedupTTLSeconds         sql.NullInt64  `db:"dedup_ttl_seconds" json:"dedup_ttl_seconds,omitempty"`
+	SessionizationEnabled   sql.NullBool   `db:"sessionization_enabled" json:"sessionization_enabled,omitempty"`
+	SessionTimeoutSeconds   sql.NullInt64  `db:"session_timeout_seconds" json:"session_timeout_seconds,omitempty"`
+	EnrichmentEnabled       sql.NullBool   `db:"enrichment_enabled" json:"enrichment_enabled,omitempty"`
+	MetadataColumnsEnabled  sql.NullBool   `db:"metadata_columns_enabled" json:"metadata_columns_enabled,omitempty"`
+	EgressTargets           sql.NullString `db:"egress_targets" json:"egress_targets,omitempty"`
+	PostgresEnabled         sql.NullBool   `db:"postgres_enabled" json:"postgres_enabled,omitempty"`
+	PostgresURL             sql.NullString `db:"postgres_url" json:"postgres_url,omitempty"`
+	PostgresSchema          sql.NullString `db:"postgres_schema" json:"postgres_schema,omitempty"`
+}
+
+type compaction_json struct {
+	StreamID  string `json:"stream_id,omitempty"`
+	Partition string `json:"partition,omitempty"`
+}
+
+type compaction_run_sql struct {
+	CompactionRunID int            `db:"compaction_run_id" json:"compaction_run_id,omitempty"`
+	StreamID        sql.NullString `db:"stream_id" json:"stream_id,omitempty"`
+	PartitionPath   sql.NullString `db:"partition_path" json:"partition_path,omitempty"`
+	TriggerType     sql.NullString `db:"trigger_type" json:"trigger_type,omitempty"`
+	Status          sql.NullString `db:"status" json:"status,omitempty"`
+	FilesBefore     sql.NullInt64  `db:"files_before" json:"files_before,omitempty"`
+	FilesAfter      sql.NullInt64  `db:"files_after" json:"files_after,omitempty"`
+	BytesBefore     sql.NullInt64  `db:"bytes_before" json:"bytes_before,omitempty"`
+	BytesAfter      sql.NullInt64  `db:"bytes_after" json:"bytes_after,omitempty"`
+	ErrorMessage    sql.NullString `db:"error_message" json:"error_message,omitempty"`
+	StartedAt       sql.NullTime   `db:"started_at" json:"started_at,omitempty"`
+	FinishedAt      sql.NullTime   `db:"finished_at" json:"finished_at,omitempty"`
+}
+
+type retention_json struct {
+	StreamID string `json:"stream_id,omitempty"`
+}
+
+type retention_run_sql struct {
+	RetentionRunID int            `db:"retention_run_id" json:"retention_run_id,omitempty"`
+	StreamID       sql.NullString `db:"stream_id" json:"stream_id,omitempty"`
+	PartitionPath  sql.NullString `db:"partition_path" json:"partition_path,omitempty"`
+	PartitionEnd   sql.NullTime   `db:"partition_end" json:"partition_end,omitempty"`
+	DryRun         sql.NullBool   `db:"dry_run" json:"dry_run,omitempty"`
+	Status         sql.NullString `db:"status" json:"status,omitempty"`
+	Files          sql.NullInt64  `db:"files" json:"files,omitempty"`
+	Bytes          sql.NullInt64  `db:"bytes" json:"bytes,omitempty"`
+	ErrorMessage   sql.NullString `db:"error_message" json:"error_message,omitempty"`
+	ExecutedAt     sql.NullTime   `db:"executed_at" json:"executed_at,omitempty"`
+}
+
+type erasure_json struct {
+	StreamID      string        `json:"stream_id,omitempty"`
+	ErasureJobID  int           `json:"erasure_job_id,omitempty"`
+	SubjectField  string        `json:"subject_field,omitempty"`
+	SubjectValues []interface{} `json:"subject_values,omitempty"`
+}
+
+//format a stream is written in, erasure can only rewrite Parquet files
+type erasure_format_sql struct {
+	TableFormat    sql.NullString `db:"table_format"`
+	FileFormatName string         `db:"file_format_name"`
+}
+
+type erasure_job_sql struct {
+	ErasureJobID     int            `db:"erasure_job_id" json:"erasure_job_id,omitempty"`
+	StreamID         sql.NullString `db:"stream_id" json:"stream_id,omitempty"`
+	SubjectField     sql.NullString `db:"subject_field" json:"subject_field,omitempty"`
+	SubjectCount     sql.NullInt64  `db:"subject_count" json:"subject_count,omitempty"`
+	Status           sql.NullString `db:"status" json:"status,omitempty"`
+	PartitionsTotal  sql.NullInt64  `db:"partitions_total" json:"partitions_total,omitempty"`
+	PartitionsDone   sql.NullInt64  `db:"partitions_done" json:"partitions_done,omitempty"`
+	PartitionsFailed sql.NullInt64  `db:"partitions_failed" json:"partitions_failed,omitempty"`
+	FilesScanned     sql.NullInt64  `db:"files_scanned" json:"files_scanned,omitempty"`
+	FilesRewritten   sql.NullInt64  `db:"files_rewritten" json:"files_rewritten,omitempty"`
+	FilesDeleted     sql.NullInt64  `db:"files_deleted" json:"files_deleted,omitempty"`
+	RowsDeleted      sql.NullInt64  `db:"rows_deleted" json:"rows_deleted,omitempty"`
+	ErrorMessage     sql.NullString `db:"error_message" json:"error_message,omitempty"`
+	RequestedAt      sql.NullTime   `db:"requested_at" json:"requested_at,omitempty"`
+	StartedAt        sql.NullTime   `db:"started_at" json:"started_at,omitempty"`
+	FinishedAt       sql.NullTime   `db:"finished_at" json:"finished_at,omitempty"`
+}
+
+type erasure_job_partition_sql struct {
+	ErasureJobPartitionID int            `db:"erasure_job_partition_id" json:"erasure_job_partition_id,omitempty"`
+	ErasureJobID          sql.NullInt64  `db:"erasure_job_id" json:"erasure_job_id,omitempty"`
+	PartitionPath         sql.NullString `db:"partition_path" json:"partition_path,omitempty"`
+	Status                sql.NullString `db:"status" json:"status,omitempty"`
+	FilesScanned          sql.NullInt64  `db:"files_scanned" json:"files_scanned,omitempty"`
+	FilesRewritten        sql.NullInt64  `db:"files_rewritten" json:"files_rewritten,omitempty"`
+	FilesDeleted          sql.NullInt64  `db:"files_deleted" json:"files_deleted,omitempty"`
+	RowsDeleted           sql.NullInt64  `db:"rows_deleted" json:"rows_deleted,omitempty"`
+	ErrorMessage          sql.NullString `db:"error_message" json:"error_message,omitempty"`
+	FinishedAt            sql.NullTime   `db:"finished_at" json:"finished_at,omitempty"`
+}
+
+//completion report of an erasure job
+type erasure_report_json struct {
+	Job        erasure_job_sql             `json:"job"`
+	Partitions []erasure_job_partition_sql `json:"partitions"`
+}
+
+type masking_rule_json struct {
+	MaskingRuleID int    `json:"masking_rule_id,omitempty"`
+	StreamID      string `json:"stream_id,omitempty"`
+	JsonPath      string `json:"json_path,omitempty"`
+	MaskingAction string `json:"masking_action,omitempty"`
+	Pattern       string `json:"pattern,omitempty"`
+	Replacement   string `json:"replacement,omitempty"`
+	RuleOrder     int    `json:"rule_order,omitempty"`
+}
+
+type masking_rule_sql struct {
+	MaskingRuleID int            `db:"masking_rule_id" json:"masking_rule_id,omitempty"`
+	StreamID      sql.NullString `db:"stream_id" json:"stream_id,omitempty"`
+	JsonPath      sql.NullString `db:"json_path" json:"json_path,omitempty"`
+	MaskingAction sql.NullString `db:"masking_action" json:"masking_action,omitempty"`
+	Pattern       sql.NullString `db:"pattern" json:"pattern,omitempty"`
+	Replacement   sql.NullString `db:"replacement" json:"replacement,omitempty"`
+	RuleOrder     sql.NullInt64  `db:"rule_order" json:"rule_order,omitempty"`
+	CreatedAt     sql.NullTime   `db:"created_at" json:"created_at,omitempty"`
+}
+
+type filter_rule_json struct {
+	FilterRuleID   int             `json:"filter_rule_id,omitempty"`
+	StreamID       string          `json:"stream_id,omitempty"`
+	MessageType    string          `json:"message_type,omitempty"`
+	JsonPath       string          `json:"json_path,omitempty"`
+	FilterOperator string          `json:"filter_operator,omitempty"`
+	FilterValue    json.RawMessage `json:"filter_value,omitempty"`
+	FilterAction   string          `json:"filter_action,omitempty"`
+	SampleRate     *float64        `json:"sample_rate,omitempty"`
+	SampleKey      string          `json:"sample_key,omitempty"`
+	RuleOrder      int             `json:"rule_order,omitempty"`
+}
+
+type filter_rule_sql struct {
+	FilterRuleID   int             `db:"filter_rule_id" json:"filter_rule_id,omitempty"`
+	StreamID       sql.NullString  `db:"stream_id" json:"stream_id,omitempty"`
+	MessageType    sql.NullString  `db:"message_type" json:"message_type,omitempty"`
+	JsonPath       sql.NullString  `db:"json_path" json:"json_path,omitempty"`
+	FilterOperator sql.NullString  `db:"filter_operator" json:"filter_operator,omitempty"`
+	FilterValue    sql.NullString  `db:"filter_value" json:"filter_value,omitempty"`
+	FilterAction   sql.NullString  `db:"filter_action" json:"filter_action,omitempty"`
+	SampleRate     sql.NullFloat64 `db:"sample_rate" json:"sample_rate,omitempty"`
+	SampleKey      sql.NullString  `db:"sample_key" json:"sample_key,omitempty"`
+	RuleOrder      sql.NullInt64   `db:"rule_order" json:"rule_order,omitempty"`
+	CreatedAt      sql.NullTime    `db:"created_at" json:"created_at,omitempty"`
+}
+
+type rollup_aggregation_json struct {
+	Function string `json:"function"`
+	Field    string `json:"field,omitempty"`
+	As       string `json:"as,omitempty"`
+}
+
+type rollup_rule_json struct {
+	RollupRuleID  int                       `json:"rollup_rule_id,omitempty"`
+	StreamID      string                    `json:"stream_id,omitempty"`
+	RollupName    string                    `json:"rollup_name,omitempty"`
+	MessageType   string                    `json:"message_type,omitempty"`
+	GroupBy       []string                  `json:"group_by,omitempty"`
+	Aggregations  []rollup_aggregation_json `json:"aggregations,omitempty"`
+	WindowSeconds int                       `json:"window_seconds,omitempty"`
+}
+
+type rollup_rule_sql struct {
+	RollupRuleID  int            `db:"rollup_rule_id" json:"rollup_rule_id,omitempty"`
+	StreamID      sql.NullString `db:"stream_id" json:"stream_id,omitempty"`
+	RollupName    sql.NullString `db:"rollup_name" json:"rollup_name,omitempty"`
+	MessageType   sql.NullString `db:"message_type" json:"message_type,omitempty"`
+	GroupBy       sql.NullString `db:"group_by" json:"group_by,omitempty"`
+	Aggregations  sql.NullString `db:"aggregations" json:"aggregations,omitempty"`
+	WindowSeconds sql.NullInt64  `db:"window_seconds" json:"window_seconds,omitempty"`
+	CreatedAt     sql.NullTime   `db:"created_at" json:"created_at,omitempty"`
+}
+
+type webhook_json struct {
+	WebhookID        int    `json:"webhook_id,omitempty"`
+	StreamID         string `json:"stream_id,omitempty"`
+	MessageType      string `json:"message_type,omitempty"`
+	Content          string `json:"content,omitempty"`
+	Url              string `json:"url,omitempty"`
+	SigningSecretRef string `json:"signing_secret_ref,omitempty"`
+	BatchSize        int    `json:"batch_size,omitempty"`
+	BatchIntervalMs  int    `json:"batch_interval_ms,omitempty"`
+	MaxAttempts      int    `json:"max_attempts,omitempty"`
+	MaxConcurrency   int    `json:"max_concurrency,omitempty"`
+}
+
+type webhook_sql struct {
+	WebhookID        int            `db:"webhook_id" json:"webhook_id,omitempty"`
+	StreamID         sql.NullString `db:"stream_id" json:"stream_id,omitempty"`
+	MessageType      sql.NullString `db:"message_type" json:"message_type,omitempty"`
+	Content          sql.NullString `db:"content" json:"content,omitempty"`
+	Url              sql.NullString `db:"url" json:"url,omitempty"`
+	SigningSecretRef sql.NullString `db:"signing_secret_ref" json:"signing_secret_ref,omitempty"`
+	BatchSize        sql.NullInt64  `db:"batch_size" json:"batch_size,omitempty"`
+	BatchIntervalMs  sql.NullInt64  `db:"batch_interval_ms" json:"batch_interval_ms,omitempty"`
+	MaxAttempts      sql.NullInt64  `db:"max_attempts" json:"max_attempts,omitempty"`
+	MaxConcurrency   sql.NullInt64  `db:"max_concurrency" json:"max_concurrency,omitempty"`
+	CreatedAt        sql.NullTime   `db:"created_at" json:"created_at,omitempty"`
+}
+
+type webhook_failure_sql struct {
+	WebhookFailureID int            `db:"webhook_failure_id" json:"webhook_failure_id,omitempty"`
+	WebhookID        int            `db:"webhook_id" json:"webhook_id,omitempty"`
+	StreamID         sql.NullString `db:"stream_id" json:"stream_id,omitempty"`
+	Events           sql.NullString `db:"events" json:"events,omitempty"`
+	EventCount       sql.NullInt64  `db:"event_count" json:"event_count,omitempty"`
+	Attempts         sql.NullInt64  `db:"attempts" json:"attempts,omitempty"`
+	LastStatus       sql.NullInt64  `db:"last_status" json:"last_status,omitempty"`
+	LastError        sql.NullString `db:"last_error" json:"last_error,omitempty"`
+	FailedAt         sql.NullTime   `db:"failed_at" json:"failed_at,omitempty"`
+}
+
+type dedup_stat_sql struct {
+	StreamID          sql.NullString `db:"stream_id" json:"stream_id,omitempty"`
+	DuplicatesDropped sql.NullInt64  `db:"duplicates_dropped" json:"duplicates_dropped,omitempty"`
+	UpdatedAt         sql.NullTime   `db:"updated_at" json:"updated_at,omitempty"`
+}
+
+type filter_stat_sql struct {
+	FilterRuleID     int            `db:"filter_rule_id" json:"filter_rule_id,omitempty"`
+	StreamID         sql.NullString `db:"stream_id" json:"stream_id,omitempty"`
+	MessageType      sql.NullString `db:"message_type" json:"message_type,omitempty"`
+	EventsMatched    sql.NullInt64  `db:"events_matched" json:"events_matched,omitempty"`
+	EventsDropped    sql.NullInt64  `db:"events_dropped" json:"events_dropped,omitempty"`
+	EventsSampledOut sql.NullInt64  `db:"events_sampled_out" json:"events_sampled_out,omitempty"`
+	UpdatedAt        sql.NullTime   `db:"updated_at" json:"updated_at,omitempty"`
 }
 
 //	FUNCTION
@@ -135,6 +426,29 @@ func main() {
 	http.HandleFunc("/getAllFileStoreTypes", getAllFileStoreTypesHandler(db))     // GET
 	http.HandleFunc("/getAllPartitionTimes", getAllPartitionTimesHandler(db))     // GET
 	http.HandleFunc("/getAllCompressionTypes", getAllCompressionTypesHandler(db)) // GET
+	http.HandleFunc("/getAllFileFormats", getAllFileFormatsHandler(db))           // GET
+	http.HandleFunc("/triggerCompaction", triggerCompactionHandler())             // POST; `stream_id` required, `partition` optional
+	http.HandleFunc("/getCompactionRuns", getCompactionRunsHandler(db))           // POST; `stream_id` required
+	http.HandleFunc("/getRetentionRuns", getRetentionRunsHandler(db))             // POST; `stream_id` required
+	http.HandleFunc("/requestErasure", requestErasureHandler(db))                 // POST; `stream_id`, `subject_field` and `subject_values` required
+	http.HandleFunc("/getErasureJobs", getErasureJobsHandler(db))                 // POST; `stream_id` required
+	http.HandleFunc("/getErasureReport", getErasureReportHandler(db))             // POST; `stream_id` and `erasure_job_id` required
+	http.HandleFunc("/getMaskingRules", getMaskingRulesHandler(db))               // POST; `stream_id` required
+	http.HandleFunc("/createMaskingRule", createMaskingRuleHandler(db))           // POST; `stream_id`, `json_path` and `masking_action` required
+	http.HandleFunc("/deleteMaskingRule", deleteMaskingRuleHandler(db))           // DELETE; `masking_rule_id` required
+	http.HandleFunc("/previewTransformations", previewTransformationsHandler())   // POST; `payload` and `stream_id` or `transformations` required
+	http.HandleFunc("/getFilterRules", getFilterRulesHandler(db))                 // POST; `stream_id` required
+	http.HandleFunc("/createFilterRule", createFilterRuleHandler(db))             // POST; `stream_id` and `filter_action` required
+	http.HandleFunc("/deleteFilterRule", deleteFilterRuleHandler(db))             // DELETE; `filter_rule_id` required
+	http.HandleFunc("/getFilterStats", getFilterStatsHandler(db))                 // POST; `stream_id` required
+	http.HandleFunc("/getDedupStats", getDedupStatsHandler(db))                   // POST; `stream_id` required
+	http.HandleFunc("/getRollupRules", getRollupRulesHandler(db))                 // POST; `stream_id` required
+	http.HandleFunc("/createRollupRule", createRollupRuleHandler(db))             // POST; `stream_id`, `rollup_name`, `aggregations` and `window_seconds` required
+	http.HandleFunc("/deleteRollupRule", deleteRollupRuleHandler(db))             // DELETE; `rollup_rule_id` required
+	http.HandleFunc("/getWebhooks", getWebhooksHandler(db))                       // POST; `stream_id` required
+	http.HandleFunc("/createWebhook", createWebhookHandler(db))                   // POST; `stream_id` and `url` required
+	http.HandleFunc("/deleteWebhook", deleteWebhookHandler(db))                   // DELETE; `webhook_id` required
+	http.HandleFunc("/getWebhookFailures", getWebhookFailuresHandler(db))         // POST; `stream_id` required
 
 	// Run the web server
 	log.Fatal(http.ListenAndServe(":80", nil))
@@ -265,6 +579,49 @@ func getAllActiveStreamsHandler(db *sqlx.DB) func(http.ResponseWriter, *http.Req
 	})
 }
 
+//compressions the ingester has writers for, a stream asking for any other `compression_type_id` is refused
+//lzo and brotli are seeded but parquet-go v1.6.2 has no compressor for either
+var supportedCompressionTypes = map[string]bool{"uncompressed": true, "snappy": true, "gzip": true, "zstd": true, "lz4": true}
+
+//error of a `compression_type_id` no writer supports, nil when left out
+func checkCompressionType(db *sqlx.DB, compressionTypeID int) error {
+
+	if compressionTypeID < 1 {
+		return nil
+	}
+
+	var name string
+	err := db.Get(&name, "select compression_type_name from compression_types where compression_type_id = $1", compressionTypeID)
+	if err != nil {
+		return errors.New("`compression_type_id` " + strconv.Itoa(compressionTypeID) + " does not exist")
+	}
+	if !supportedCompressionTypes[strings.ToLower(name)] {
+		return errors.New("`compression_type_id` " + strconv.Itoa(compressionTypeID) + " (" + name + ") is not supported")
+	}
+	return nil
+}
+
+//file formats the ingester has writers for, a stream asking for any other `file_format_id` is refused
+var supportedFileFormats = map[string]bool{"parquet": true, "avro": true, "jsonl": true, "csv": true}
+
+//error of a `file_format_id` no writer supports, nil when left out
+func checkFileFormat(db *sqlx.DB, fileFormatID int) error {
+
+	if fileFormatID < 1 {
+		return nil
+	}
+
+	var name string
+	err := db.Get(&name, "select file_format_name from file_formats where file_format_id = $1", fileFormatID)
+	if err != nil {
+		return errors.New("`file_format_id` " + strconv.Itoa(fileFormatID) + " does not exist")
+	}
+	if !supportedFileFormats[strings.ToLower(name)] {
+		return errors.New("`file_format_id` " + strconv.Itoa(fileFormatID) + " (" + name + ") is not supported")
+	}
+	return nil
+}
+
 func createStreamHandler(db *sqlx.DB) func(http.ResponseWriter, *http.Request) {
 	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
 		switch req.Method {
@@ -286,6 +643,15 @@ func createStreamHandler(db *sqlx.DB) func(http.ResponseWriter, *http.Request) {
 
 			log.Println(reqStream.GCPJsonCredentials)
 
+			if err := checkCompressionType(db, reqStream.CompressionTypeID); err != nil {
+				http.Error(wrt, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			if err := checkFileFormat(db, reqStream.FileFormatID); err != nil {
+				http.Error(wrt, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+
 			// Send to database function
 			retStreams := []stream_sql{}
 			queryStr := buildQueryString_createStream(reqStream)
@@ -337,6 +703,15 @@ func updateStreamHandler(db *sqlx.DB) func(http.ResponseWriter, *http.Request) {
 				CheckError(err)
 			}
 
+			if err := checkCompressionType(db, reqStream.CompressionTypeID); err != nil {
+				http.Error(wrt, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			if err := checkFileFormat(db, reqStream.FileFormatID); err != nil {
+				http.Error(wrt, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+
 			// Send to database function
 			retStreams := []stream_sql{}
 			queryStr := buildQueryString_updateStream(reqStream)
@@ -655,131 +1030,1535 @@ func getAllCompressionTypesHandler(db *sqlx.DB) func(http.ResponseWriter, *http.
 	})
 }
 
-////////// HANDLER FUNCTIONS - End //////////
+func getAllFileFormatsHandler(db *sqlx.DB) func(http.ResponseWriter, *http.Request) {
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet:
+			ff := []fileFormat{}
+			err := db.Select(&ff, "select * from getAllFileFormats()")
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+			if len(ff) <= 0 {
+				wrt.WriteHeader(http.StatusNoContent)
+			} else {
+				jsonData, err := json.MarshalIndent(ff, "", "    ")
+				if err != nil {
+					jsonData = nil
+					wrt.WriteHeader(http.StatusInternalServerError)
+					http.Error(wrt, "Internal Server Error", http.StatusInternalServerError)
+					CheckError(err)
+				}
+				wrt.WriteHeader(http.StatusOK)
+				wrt.Write(jsonData)
+			}
+		case http.MethodPost:
+		case http.MethodPut:
+		case http.MethodDelete:
+		default:
+			wrt.WriteHeader(http.StatusMethodNotAllowed)
+			http.Error(wrt, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
 
-////////// HELPER FUNCTIONS - Start //////////
-//	FUNCTION
-// 	setDBConnectionString
-//	created by Gavin
-//	on 20220109
-//	last updated 20220111
-//	by Gavin
-//	Description:	Sets the `psqlCon` global variable. Looks up environment variables
-//					and defaults if none are present.
-func setDBConnectionString() {
-	var db_host, db_port, db_user, db_password, db_dbname = db_host_def, db_port_def, db_user_def, db_password_def, db_dbname_def
-	var db_host_env, db_user_env, db_password_env, db_dbname_env = os.Getenv("RTDL_DB_HOST"), os.Getenv("RTDL_DB_USER"), os.Getenv("RTDL_DB_PASSWORD"), os.Getenv("RTDL_DB_DBNAME")
-	db_port_env, err := strconv.Atoi(os.Getenv("RTDL_DB_PORT"))
-	if err != nil {
-		db_port_env = 0
-	}
+//control listener of the `ingest` service, serving `/compact` and `/erase` apart from the public ingest port
+func getIngestControlUrl() string {
 
-	if db_host_env != "" {
-		db_host = db_host_env
-	}
-	if db_port_env != 0 {
-		db_port = db_port_env
-	}
-	if db_user_env != "" {
-		db_user = db_user_env
-	}
-	if db_password_env != "" {
-		db_password = db_password_env
+	if ingestControlUrl := os.Getenv("RTDL_INGEST_CONTROL_URL"); ingestControlUrl != "" {
+		return ingestControlUrl
 	}
-	if db_dbname_env != "" {
-		db_dbname = db_dbname_env
-	}
-	psqlCon = fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable", db_host, db_port, db_user, db_password, db_dbname)
+	return "http://ingest:8081"
 }
 
-////////// HELPER FUNCTIONS - Start //////////
-//	FUNCTION
-// 	refreshIngestCacher
-//	created by Gavin
-//	on 20220111
-//	last updated 20220111
-//	by Gavin
-//	Description:	Calls the `refreshCache` endpoint on the `ingest` service
-func refreshIngestCache() {
-	refreshCacheResp, err := http.Get("http://ingest:8080/refreshCache")
-	if err != nil {
-		CheckError(err)
-	}
-	if refreshCacheResp != nil {
+//compaction runs asynchronously in the ingester, the request is handed over through the ingest service
+func triggerCompactionHandler() func(http.ResponseWriter, *http.Request) {
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodPost:
+			// Read json
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+			var reqCompaction compaction_json
+			err = json.Unmarshal(body, &reqCompaction)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
 
-	}
+			if reqCompaction.StreamID != "" {
+				jsonData, _ := json.Marshal(reqCompaction)
+				resp, err := http.Post(getIngestControlUrl()+"/compact", "application/json", bytes.NewReader(jsonData))
+				if err != nil {
+					log.Println(err)
+					http.Error(wrt, "Ingest service unavailable", http.StatusBadGateway)
+					return
+				}
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					http.Error(wrt, "Ingest service returned "+resp.Status, http.StatusBadGateway)
+					return
+				}
+				wrt.WriteHeader(http.StatusAccepted)
+			} else {
+				http.Error(wrt, "`stream_id` is required", http.StatusUnprocessableEntity)
+			}
+		case http.MethodGet:
+		case http.MethodPut:
+		case http.MethodDelete:
+		default:
+			wrt.WriteHeader(http.StatusMethodNotAllowed)
+			http.Error(wrt, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
 }
 
-//	FUNCTION
-// 	buildQueryString_createStream
-//	created by Gavin
-//	on 20220109
-//	last updated 20220111
-//	by Gavin
-//	Description:	Builds the query string used by the `createStream` function
-func buildQueryString_createStream(reqStream stream_json) (queryStr string) {
-	queryStr = "select * from createStream("
-	if reqStream.StreamAltID != "" {
-		queryStr = queryStr + "'" + reqStream.StreamAltID + "', "
-	} else {
-		queryStr = queryStr + "NULL, "
-	}
-	queryStr = queryStr + strconv.FormatBool(reqStream.Active) + ", "
-	if reqStream.MessageType != "" {
-		queryStr = queryStr + "'" + reqStream.MessageType + "', "
-	} else {
-		// TODO: Required field. Return error.
-		queryStr = queryStr + "NULL, "
-	}
-	if reqStream.FileStoreTypeID < 1 {
-		reqStream.FileStoreTypeID = 1
-	}
-	queryStr = queryStr + strconv.Itoa(reqStream.FileStoreTypeID) + ", "
-	if reqStream.Region != "" {
-		queryStr = queryStr + "'" + reqStream.Region + "', "
-	} else {
-		queryStr = queryStr + "NULL, "
-	}
-	if reqStream.BucketName != "" {
-		queryStr = queryStr + "'" + reqStream.BucketName + "', "
-	} else {
-		queryStr = queryStr + "NULL, "
-	}
-	if reqStream.FolderName != "" {
-		queryStr = queryStr + "'" + reqStream.FolderName + "', "
-	} else {
-		// TODO: Required field. Return error.
-		queryStr = queryStr + "NULL, "
-	}
-	if reqStream.PartitionTimeID < 1 {
-		reqStream.PartitionTimeID = 1
-	}
-	queryStr = queryStr + strconv.Itoa(reqStream.PartitionTimeID) + ", "
-	if reqStream.CompressionTypeID < 1 {
-		reqStream.CompressionTypeID = 1
-	}
-	queryStr = queryStr + strconv.Itoa(reqStream.CompressionTypeID) + ", "
-	if reqStream.AWSAcessKeyID != "" {
-		queryStr = queryStr + "'" + reqStream.AWSAcessKeyID + "', "
-	} else {
-		queryStr = queryStr + "NULL, "
-	}
-	if reqStream.AWSSecretAcessKey != "" {
-		queryStr = queryStr + "'" + reqStream.AWSSecretAcessKey + "', "
-	} else {
-		queryStr = queryStr + "NULL, "
-	}
-	if reqStream.GCPJsonCredentials != nil {
-		gcpCredsJson, _ := json.Marshal(reqStream.GCPJsonCredentials)
-		log.Println(string(gcpCredsJson))
-		//queryStr = queryStr + "'" + strings.Replace(strings.Replace(fmt.Sprintf("%v", reqStream.GCPJsonCredentials), "map[", "{", 1), "]", "}", 1) + "')"
-		queryStr = queryStr + "'" + string(gcpCredsJson) + "', "
-	} else {
-		queryStr = queryStr + "NULL, "
-	}
+func getCompactionRunsHandler(db *sqlx.DB) func(http.ResponseWriter, *http.Request) {
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodPost:
+			// Read json
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+			var reqCompaction compaction_json
+			err = json.Unmarshal(body, &reqCompaction)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
 
-	if reqStream.AzureStorageAccountname != "" {
-		queryStr = queryStr + "'" + reqStream.AzureStorageAccountname + "', "
+			// Query database
+			runs := []compaction_run_sql{}
+			if reqCompaction.StreamID != "" {
+				err := db.Select(&runs, "select * from getCompactionRuns($1)", reqCompaction.StreamID)
+				if err != nil {
+					wrt.WriteHeader(http.StatusBadRequest)
+					http.Error(wrt, "Bad Request", http.StatusBadRequest)
+					CheckError(err)
+				}
+				if len(runs) <= 0 {
+					wrt.WriteHeader(http.StatusNoContent)
+				} else {
+					jsonData, err := json.MarshalIndent(runs, "", "    ")
+					if err != nil {
+						jsonData = nil
+						wrt.WriteHeader(http.StatusInternalServerError)
+						http.Error(wrt, "Internal Server Error", http.StatusInternalServerError)
+						CheckError(err)
+					}
+					wrt.WriteHeader(http.StatusOK)
+					wrt.Write(jsonData)
+				}
+			} else {
+				http.Error(wrt, "`stream_id` is required", http.StatusUnprocessableEntity)
+			}
+		case http.MethodGet:
+		case http.MethodPut:
+		case http.MethodDelete:
+		default:
+			wrt.WriteHeader(http.StatusMethodNotAllowed)
+			http.Error(wrt, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func getRetentionRunsHandler(db *sqlx.DB) func(http.ResponseWriter, *http.Request) {
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodPost:
+			// Read json
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+			var reqRetention retention_json
+			err = json.Unmarshal(body, &reqRetention)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+
+			// Query database
+			runs := []retention_run_sql{}
+			if reqRetention.StreamID != "" {
+				err := db.Select(&runs, "select * from getRetentionRuns($1)", reqRetention.StreamID)
+				if err != nil {
+					wrt.WriteHeader(http.StatusBadRequest)
+					http.Error(wrt, "Bad Request", http.StatusBadRequest)
+					CheckError(err)
+				}
+				if len(runs) <= 0 {
+					wrt.WriteHeader(http.StatusNoContent)
+				} else {
+					jsonData, err := json.MarshalIndent(runs, "", "    ")
+					if err != nil {
+						jsonData = nil
+						wrt.WriteHeader(http.StatusInternalServerError)
+						http.Error(wrt, "Internal Server Error", http.StatusInternalServerError)
+						CheckError(err)
+					}
+					wrt.WriteHeader(http.StatusOK)
+					wrt.Write(jsonData)
+				}
+			} else {
+				http.Error(wrt, "`stream_id` is required", http.StatusUnprocessableEntity)
+			}
+		case http.MethodGet:
+		case http.MethodPut:
+		case http.MethodDelete:
+		default:
+			wrt.WriteHeader(http.StatusMethodNotAllowed)
+			http.Error(wrt, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+//erasure runs asynchronously in the ingester, the job is created here and handed over through the ingest service
+func requestErasureHandler(db *sqlx.DB) func(http.ResponseWriter, *http.Request) {
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodPost:
+			// Read json
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+			var reqErasure erasure_json
+			err = json.Unmarshal(body, &reqErasure)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+
+			if reqErasure.StreamID == "" || reqErasure.SubjectField == "" || len(reqErasure.SubjectValues) == 0 {
+				http.Error(wrt, "`stream_id`, `subject_field` and `subject_values` are required", http.StatusUnprocessableEntity)
+				return
+			}
+
+			//only Parquet files can be rewritten, a job on any other stream would fail on every partition
+			var formats []erasure_format_sql
+			err = db.Select(&formats, "select s.table_format, coalesce(f.file_format_name, 'parquet') as file_format_name from streams s left join file_formats f on f.file_format_id = s.file_format_id where s.stream_id = $1::uuid", reqErasure.StreamID)
+			if err != nil || len(formats) == 0 {
+				if err != nil {
+					log.Println(err)
+				}
+				http.Error(wrt, "stream "+reqErasure.StreamID+" not found", http.StatusNotFound)
+				return
+			}
+			if formats[0].TableFormat.Valid && formats[0].TableFormat.String != "" && formats[0].TableFormat.String != "none" {
+				http.Error(wrt, "table format streams have to be erased through the table format", http.StatusUnprocessableEntity)
+				return
+			}
+			if !strings.EqualFold(formats[0].FileFormatName, "parquet") {
+				http.Error(wrt, "only streams written as Parquet can be erased, stream writes "+formats[0].FileFormatName, http.StatusUnprocessableEntity)
+				return
+			}
+
+			// Query database
+			jobs := []erasure_job_sql{}
+			err = db.Select(&jobs, "select * from createErasureJob($1, $2, $3)", reqErasure.StreamID, reqErasure.SubjectField, len(reqErasure.SubjectValues))
+			if err != nil || len(jobs) == 0 {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+				return
+			}
+			reqErasure.ErasureJobID = jobs[0].ErasureJobID
+
+			jsonData, _ := json.Marshal(reqErasure)
+			resp, err := http.Post(getIngestControlUrl()+"/erase", "application/json", bytes.NewReader(jsonData))
+			if err == nil {
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					err = errors.New("ingest service returned " + resp.Status)
+				}
+			}
+			if err != nil {
+				log.Println(err)
+				db.Select(&jobs, "select * from failErasureJob($1, $2)", reqErasure.ErasureJobID, err.Error())
+				http.Error(wrt, "Ingest service unavailable", http.StatusBadGateway)
+				return
+			}
+
+			jsonData, err = json.MarshalIndent(jobs[0], "", "    ")
+			if err != nil {
+				wrt.WriteHeader(http.StatusInternalServerError)
+				http.Error(wrt, "Internal Server Error", http.StatusInternalServerError)
+				CheckError(err)
+			}
+			wrt.WriteHeader(http.StatusAccepted)
+			wrt.Write(jsonData)
+		case http.MethodGet:
+		case http.MethodPut:
+		case http.MethodDelete:
+		default:
+			wrt.WriteHeader(http.StatusMethodNotAllowed)
+			http.Error(wrt, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func getErasureJobsHandler(db *sqlx.DB) func(http.ResponseWriter, *http.Request) {
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodPost:
+			// Read json
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+			var reqErasure erasure_json
+			err = json.Unmarshal(body, &reqErasure)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+
+			// Query database
+			jobs := []erasure_job_sql{}
+			if reqErasure.StreamID != "" {
+				err := db.Select(&jobs, "select * from getErasureJobs($1)", reqErasure.StreamID)
+				if err != nil {
+					wrt.WriteHeader(http.StatusBadRequest)
+					http.Error(wrt, "Bad Request", http.StatusBadRequest)
+					CheckError(err)
+				}
+				if len(jobs) <= 0 {
+					wrt.WriteHeader(http.StatusNoContent)
+				} else {
+					jsonData, err := json.MarshalIndent(jobs, "", "    ")
+					if err != nil {
+						jsonData = nil
+						wrt.WriteHeader(http.StatusInternalServerError)
+						http.Error(wrt, "Internal Server Error", http.StatusInternalServerError)
+						CheckError(err)
+					}
+					wrt.WriteHeader(http.StatusOK)
+					wrt.Write(jsonData)
+				}
+			} else {
+				http.Error(wrt, "`stream_id` is required", http.StatusUnprocessableEntity)
+			}
+		case http.MethodGet:
+		case http.MethodPut:
+		case http.MethodDelete:
+		default:
+			wrt.WriteHeader(http.StatusMethodNotAllowed)
+			http.Error(wrt, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+//job with the outcome of every partition it scanned
+func getErasureReportHandler(db *sqlx.DB) func(http.ResponseWriter, *http.Request) {
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodPost:
+			// Read json
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+			var reqErasure erasure_json
+			err = json.Unmarshal(body, &reqErasure)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+
+			if reqErasure.StreamID == "" || reqErasure.ErasureJobID == 0 {
+				http.Error(wrt, "`stream_id` and `erasure_job_id` are required", http.StatusUnprocessableEntity)
+				return
+			}
+
+			// Query database
+			jobs := []erasure_job_sql{}
+			err = db.Select(&jobs, "select * from getErasureJobs($1)", reqErasure.StreamID)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+				return
+			}
+
+			var report erasure_report_json
+			found := false
+			for _, job := range jobs {
+				if job.ErasureJobID == reqErasure.ErasureJobID {
+					report.Job = job
+					found = true
+				}
+			}
+			if !found {
+				wrt.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			report.Partitions = []erasure_job_partition_sql{}
+			err = db.Select(&report.Partitions, "select * from getErasureJobPartitions($1)", reqErasure.ErasureJobID)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+				return
+			}
+
+			jsonData, err := json.MarshalIndent(report, "", "    ")
+			if err != nil {
+				wrt.WriteHeader(http.StatusInternalServerError)
+				http.Error(wrt, "Internal Server Error", http.StatusInternalServerError)
+				CheckError(err)
+			}
+			wrt.WriteHeader(http.StatusOK)
+			wrt.Write(jsonData)
+		case http.MethodGet:
+		case http.MethodPut:
+		case http.MethodDelete:
+		default:
+			wrt.WriteHeader(http.StatusMethodNotAllowed)
+			http.Error(wrt, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+//transformations are applied by the ingester, the preview is answered by the stateful functions service
+func previewTransformationsHandler() func(http.ResponseWriter, *http.Request) {
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodPost:
+			// Read json
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+
+			functionsUrl := os.Getenv("RTDL_FUNCTIONS_URL")
+			if functionsUrl == "" {
+				functionsUrl = "http://statefun-functions:8082"
+			}
+			resp, err := http.Post(functionsUrl+"/previewTransformations", "application/json", bytes.NewReader(body))
+			if err != nil {
+				log.Println(err)
+				http.Error(wrt, "Stateful functions service unavailable", http.StatusBadGateway)
+				return
+			}
+			defer resp.Body.Close()
+
+			respBody, _ := ioutil.ReadAll(resp.Body)
+			wrt.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+			wrt.WriteHeader(resp.StatusCode)
+			wrt.Write(respBody)
+		case http.MethodGet:
+		case http.MethodPut:
+		case http.MethodDelete:
+		default:
+			wrt.WriteHeader(http.StatusMethodNotAllowed)
+			http.Error(wrt, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func getMaskingRulesHandler(db *sqlx.DB) func(http.ResponseWriter, *http.Request) {
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodPost:
+			// Read json
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+			var reqRule masking_rule_json
+			err = json.Unmarshal(body, &reqRule)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+
+			// Query database
+			rules := []masking_rule_sql{}
+			if reqRule.StreamID != "" {
+				err := db.Select(&rules, "select * from getMaskingRules($1)", reqRule.StreamID)
+				if err != nil {
+					wrt.WriteHeader(http.StatusBadRequest)
+					http.Error(wrt, "Bad Request", http.StatusBadRequest)
+					CheckError(err)
+				}
+				if len(rules) <= 0 {
+					wrt.WriteHeader(http.StatusNoContent)
+				} else {
+					jsonData, err := json.MarshalIndent(rules, "", "    ")
+					if err != nil {
+						jsonData = nil
+						wrt.WriteHeader(http.StatusInternalServerError)
+						http.Error(wrt, "Internal Server Error", http.StatusInternalServerError)
+						CheckError(err)
+					}
+					wrt.WriteHeader(http.StatusOK)
+					wrt.Write(jsonData)
+				}
+			} else {
+				http.Error(wrt, "`stream_id` is required", http.StatusUnprocessableEntity)
+			}
+		case http.MethodGet:
+		case http.MethodPut:
+		case http.MethodDelete:
+		default:
+			wrt.WriteHeader(http.StatusMethodNotAllowed)
+			http.Error(wrt, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func createMaskingRuleHandler(db *sqlx.DB) func(http.ResponseWriter, *http.Request) {
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodPost:
+			// Read json
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+			var reqRule masking_rule_json
+			err = json.Unmarshal(body, &reqRule)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+
+			if reqRule.StreamID == "" || reqRule.JsonPath == "" {
+				http.Error(wrt, "`stream_id`, `json_path` and `masking_action` are required", http.StatusUnprocessableEntity)
+				return
+			}
+			switch reqRule.MaskingAction {
+			case "drop", "hash", "truncate_ip", "tokenize":
+			case "redact":
+				if _, err := regexp.Compile(reqRule.Pattern); err != nil {
+					http.Error(wrt, "`pattern` is not a valid regular expression: "+err.Error(), http.StatusUnprocessableEntity)
+					return
+				}
+			default:
+				http.Error(wrt, "`masking_action` must be one of drop, hash, truncate_ip, redact, tokenize", http.StatusUnprocessableEntity)
+				return
+			}
+
+			// Query database
+			rules := []masking_rule_sql{}
+			err = db.Select(&rules, "select * from createMaskingRule($1, $2, $3, $4, $5, $6)",
+				reqRule.StreamID, reqRule.JsonPath, reqRule.MaskingAction,
+				sql.NullString{String: reqRule.Pattern, Valid: reqRule.Pattern != ""},
+				sql.NullString{String: reqRule.Replacement, Valid: reqRule.Replacement != ""},
+				reqRule.RuleOrder)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+				return
+			}
+			refreshIngestCache()
+
+			jsonData, err := json.MarshalIndent(rules, "", "    ")
+			if err != nil {
+				jsonData = nil
+				wrt.WriteHeader(http.StatusInternalServerError)
+				http.Error(wrt, "Internal Server Error", http.StatusInternalServerError)
+				CheckError(err)
+			}
+			wrt.WriteHeader(http.StatusOK)
+			wrt.Write(jsonData)
+		case http.MethodGet:
+		case http.MethodPut:
+		case http.MethodDelete:
+		default:
+			wrt.WriteHeader(http.StatusMethodNotAllowed)
+			http.Error(wrt, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func deleteMaskingRuleHandler(db *sqlx.DB) func(http.ResponseWriter, *http.Request) {
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodDelete:
+			// Read json
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+			var reqRule masking_rule_json
+			err = json.Unmarshal(body, &reqRule)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+
+			// Query database
+			rules := []masking_rule_sql{}
+			if reqRule.MaskingRuleID != 0 {
+				err := db.Select(&rules, "select * from deleteMaskingRule($1)", reqRule.MaskingRuleID)
+				if err != nil {
+					wrt.WriteHeader(http.StatusBadRequest)
+					http.Error(wrt, "Bad Request", http.StatusBadRequest)
+					CheckError(err)
+				}
+				if len(rules) <= 0 {
+					http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				} else {
+					jsonData, err := json.MarshalIndent(rules, "", "    ")
+					if err != nil {
+						jsonData = nil
+						wrt.WriteHeader(http.StatusInternalServerError)
+						http.Error(wrt, "Internal Server Error", http.StatusInternalServerError)
+						CheckError(err)
+					}
+					wrt.WriteHeader(http.StatusOK)
+					wrt.Write(jsonData)
+					refreshIngestCache()
+				}
+			} else {
+				http.Error(wrt, "`masking_rule_id` is required", http.StatusUnprocessableEntity)
+			}
+		case http.MethodGet:
+		case http.MethodPost:
+		case http.MethodPut:
+		default:
+			wrt.WriteHeader(http.StatusMethodNotAllowed)
+			http.Error(wrt, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func getFilterRulesHandler(db *sqlx.DB) func(http.ResponseWriter, *http.Request) {
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodPost:
+			// Read json
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+			var reqRule filter_rule_json
+			err = json.Unmarshal(body, &reqRule)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+
+			// Query database
+			rules := []filter_rule_sql{}
+			if reqRule.StreamID != "" {
+				err := db.Select(&rules, "select * from getFilterRules($1)", reqRule.StreamID)
+				if err != nil {
+					wrt.WriteHeader(http.StatusBadRequest)
+					http.Error(wrt, "Bad Request", http.StatusBadRequest)
+					CheckError(err)
+				}
+				if len(rules) <= 0 {
+					wrt.WriteHeader(http.StatusNoContent)
+				} else {
+					jsonData, err := json.MarshalIndent(rules, "", "    ")
+					if err != nil {
+						jsonData = nil
+						wrt.WriteHeader(http.StatusInternalServerError)
+						http.Error(wrt, "Internal Server Error", http.StatusInternalServerError)
+						CheckError(err)
+					}
+					wrt.WriteHeader(http.StatusOK)
+					wrt.Write(jsonData)
+				}
+			} else {
+				http.Error(wrt, "`stream_id` is required", http.StatusUnprocessableEntity)
+			}
+		case http.MethodGet:
+		case http.MethodPut:
+		case http.MethodDelete:
+		default:
+			wrt.WriteHeader(http.StatusMethodNotAllowed)
+			http.Error(wrt, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func createFilterRuleHandler(db *sqlx.DB) func(http.ResponseWriter, *http.Request) {
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodPost:
+			// Read json
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+			var reqRule filter_rule_json
+			err = json.Unmarshal(body, &reqRule)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+
+			if reqRule.StreamID == "" {
+				http.Error(wrt, "`stream_id` and `filter_action` are required", http.StatusUnprocessableEntity)
+				return
+			}
+			if reqRule.FilterAction != "drop" && reqRule.FilterAction != "keep" {
+				http.Error(wrt, "`filter_action` must be one of drop, keep", http.StatusUnprocessableEntity)
+				return
+			}
+			if (reqRule.JsonPath == "") != (reqRule.FilterOperator == "") {
+				http.Error(wrt, "`json_path` and `filter_operator` go together, leave both out to match every event", http.StatusUnprocessableEntity)
+				return
+			}
+			var filterValue interface{}
+			if len(reqRule.FilterValue) > 0 {
+				json.Unmarshal(reqRule.FilterValue, &filterValue)
+			}
+			switch reqRule.FilterOperator {
+			case "", "exists", "not_exists":
+			case "equals", "gt", "gte", "lt", "lte":
+				if filterValue == nil {
+					http.Error(wrt, "`filter_value` is required for operator "+reqRule.FilterOperator, http.StatusUnprocessableEntity)
+					return
+				}
+			case "in":
+				if _, ok := filterValue.([]interface{}); !ok {
+					http.Error(wrt, "`filter_value` must be a JSON array for operator in", http.StatusUnprocessableEntity)
+					return
+				}
+			case "regex":
+				pattern, ok := filterValue.(string)
+				if !ok {
+					http.Error(wrt, "`filter_value` must be a string for operator regex", http.StatusUnprocessableEntity)
+					return
+				}
+				if _, err := regexp.Compile(pattern); err != nil {
+					http.Error(wrt, "`filter_value` is not a valid regular expression: "+err.Error(), http.StatusUnprocessableEntity)
+					return
+				}
+			default:
+				http.Error(wrt, "`filter_operator` must be one of equals, in, regex, exists, not_exists, gt, gte, lt, lte", http.StatusUnprocessableEntity)
+				return
+			}
+			sampleRate := 1.0
+			if reqRule.SampleRate != nil {
+				sampleRate = *reqRule.SampleRate
+			}
+			if sampleRate < 0 || sampleRate > 1 {
+				http.Error(wrt, "`sample_rate` must be between 0 and 1", http.StatusUnprocessableEntity)
+				return
+			}
+
+			// Query database
+			rules := []filter_rule_sql{}
+			err = db.Select(&rules, "select * from createFilterRule($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+				reqRule.StreamID,
+				sql.NullString{String: reqRule.MessageType, Valid: reqRule.MessageType != ""},
+				sql.NullString{String: reqRule.JsonPath, Valid: reqRule.JsonPath != ""},
+				sql.NullString{String: reqRule.FilterOperator, Valid: reqRule.FilterOperator != ""},
+				sql.NullString{String: string(reqRule.FilterValue), Valid: filterValue != nil},
+				reqRule.FilterAction, sampleRate,
+				sql.NullString{String: reqRule.SampleKey, Valid: reqRule.SampleKey != ""},
+				reqRule.RuleOrder)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+				return
+			}
+			refreshIngestCache()
+
+			jsonData, err := json.MarshalIndent(rules, "", "    ")
+			if err != nil {
+				jsonData = nil
+				wrt.WriteHeader(http.StatusInternalServerError)
+				http.Error(wrt, "Internal Server Error", http.StatusInternalServerError)
+				CheckError(err)
+			}
+			wrt.WriteHeader(http.StatusOK)
+			wrt.Write(jsonData)
+		case http.MethodGet:
+		case http.MethodPut:
+		case http.MethodDelete:
+		default:
+			wrt.WriteHeader(http.StatusMethodNotAllowed)
+			http.Error(wrt, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func deleteFilterRuleHandler(db *sqlx.DB) func(http.ResponseWriter, *http.Request) {
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodDelete:
+			// Read json
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+			var reqRule filter_rule_json
+			err = json.Unmarshal(body, &reqRule)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+
+			// Query database
+			rules := []filter_rule_sql{}
+			if reqRule.FilterRuleID != 0 {
+				err := db.Select(&rules, "select * from deleteFilterRule($1)", reqRule.FilterRuleID)
+				if err != nil {
+					wrt.WriteHeader(http.StatusBadRequest)
+					http.Error(wrt, "Bad Request", http.StatusBadRequest)
+					CheckError(err)
+				}
+				if len(rules) <= 0 {
+					http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				} else {
+					jsonData, err := json.MarshalIndent(rules, "", "    ")
+					if err != nil {
+						jsonData = nil
+						wrt.WriteHeader(http.StatusInternalServerError)
+						http.Error(wrt, "Internal Server Error", http.StatusInternalServerError)
+						CheckError(err)
+					}
+					wrt.WriteHeader(http.StatusOK)
+					wrt.Write(jsonData)
+					refreshIngestCache()
+				}
+			} else {
+				http.Error(wrt, "`filter_rule_id` is required", http.StatusUnprocessableEntity)
+			}
+		case http.MethodGet:
+		case http.MethodPost:
+		case http.MethodPut:
+		default:
+			wrt.WriteHeader(http.StatusMethodNotAllowed)
+			http.Error(wrt, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func getFilterStatsHandler(db *sqlx.DB) func(http.ResponseWriter, *http.Request) {
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodPost:
+			// Read json
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+			var reqStats filter_rule_json
+			err = json.Unmarshal(body, &reqStats)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+
+			// Query database
+			stats := []filter_stat_sql{}
+			if reqStats.StreamID != "" {
+				err := db.Select(&stats, "select * from getFilterStats($1)", reqStats.StreamID)
+				if err != nil {
+					wrt.WriteHeader(http.StatusBadRequest)
+					http.Error(wrt, "Bad Request", http.StatusBadRequest)
+					CheckError(err)
+				}
+				if len(stats) <= 0 {
+					wrt.WriteHeader(http.StatusNoContent)
+				} else {
+					jsonData, err := json.MarshalIndent(stats, "", "    ")
+					if err != nil {
+						jsonData = nil
+						wrt.WriteHeader(http.StatusInternalServerError)
+						http.Error(wrt, "Internal Server Error", http.StatusInternalServerError)
+						CheckError(err)
+					}
+					wrt.WriteHeader(http.StatusOK)
+					wrt.Write(jsonData)
+				}
+			} else {
+				http.Error(wrt, "`stream_id` is required", http.StatusUnprocessableEntity)
+			}
+		case http.MethodGet:
+		case http.MethodPut:
+		case http.MethodDelete:
+		default:
+			wrt.WriteHeader(http.StatusMethodNotAllowed)
+			http.Error(wrt, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func getDedupStatsHandler(db *sqlx.DB) func(http.ResponseWriter, *http.Request) {
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodPost:
+			// Read json
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+			var reqStream stream_json
+			err = json.Unmarshal(body, &reqStream)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+
+			// Query database
+			stats := []dedup_stat_sql{}
+			if reqStream.StreamID != "" {
+				err := db.Select(&stats, "select * from getDedupStats($1)", reqStream.StreamID)
+				if err != nil {
+					wrt.WriteHeader(http.StatusBadRequest)
+					http.Error(wrt, "Bad Request", http.StatusBadRequest)
+					CheckError(err)
+				}
+				if len(stats) <= 0 {
+					wrt.WriteHeader(http.StatusNoContent)
+				} else {
+					jsonData, err := json.MarshalIndent(stats, "", "    ")
+					if err != nil {
+						jsonData = nil
+						wrt.WriteHeader(http.StatusInternalServerError)
+						http.Error(wrt, "Internal Server Error", http.StatusInternalServerError)
+						CheckError(err)
+					}
+					wrt.WriteHeader(http.StatusOK)
+					wrt.Write(jsonData)
+				}
+			} else {
+				http.Error(wrt, "`stream_id` is required", http.StatusUnprocessableEntity)
+			}
+		case http.MethodGet:
+		case http.MethodPut:
+		case http.MethodDelete:
+		default:
+			wrt.WriteHeader(http.StatusMethodNotAllowed)
+			http.Error(wrt, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func getRollupRulesHandler(db *sqlx.DB) func(http.ResponseWriter, *http.Request) {
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodPost:
+			// Read json
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+			var reqRule rollup_rule_json
+			err = json.Unmarshal(body, &reqRule)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+
+			// Query database
+			rules := []rollup_rule_sql{}
+			if reqRule.StreamID != "" {
+				err := db.Select(&rules, "select * from getRollupRules($1)", reqRule.StreamID)
+				if err != nil {
+					wrt.WriteHeader(http.StatusBadRequest)
+					http.Error(wrt, "Bad Request", http.StatusBadRequest)
+					CheckError(err)
+				}
+				if len(rules) <= 0 {
+					wrt.WriteHeader(http.StatusNoContent)
+				} else {
+					jsonData, err := json.MarshalIndent(rules, "", "    ")
+					if err != nil {
+						jsonData = nil
+						wrt.WriteHeader(http.StatusInternalServerError)
+						http.Error(wrt, "Internal Server Error", http.StatusInternalServerError)
+						CheckError(err)
+					}
+					wrt.WriteHeader(http.StatusOK)
+					wrt.Write(jsonData)
+				}
+			} else {
+				http.Error(wrt, "`stream_id` is required", http.StatusUnprocessableEntity)
+			}
+		case http.MethodGet:
+		case http.MethodPut:
+		case http.MethodDelete:
+		default:
+			wrt.WriteHeader(http.StatusMethodNotAllowed)
+			http.Error(wrt, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+//rollup names become dataset names
+var rollupNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+func createRollupRuleHandler(db *sqlx.DB) func(http.ResponseWriter, *http.Request) {
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodPost:
+			// Read json
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+			var reqRule rollup_rule_json
+			err = json.Unmarshal(body, &reqRule)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+
+			if reqRule.StreamID == "" || reqRule.RollupName == "" || len(reqRule.Aggregations) == 0 || reqRule.WindowSeconds == 0 {
+				http.Error(wrt, "`stream_id`, `rollup_name`, `aggregations` and `window_seconds` are required", http.StatusUnprocessableEntity)
+				return
+			}
+			if !rollupNamePattern.MatchString(reqRule.RollupName) {
+				http.Error(wrt, "`rollup_name` must start with a letter and contain only letters, digits and underscores", http.StatusUnprocessableEntity)
+				return
+			}
+			if reqRule.WindowSeconds < 0 {
+				http.Error(wrt, "`window_seconds` must be positive", http.StatusUnprocessableEntity)
+				return
+			}
+			for _, aggregation := range reqRule.Aggregations {
+				switch aggregation.Function {
+				case "count":
+				case "sum", "min", "max", "distinct_approx":
+					if aggregation.Field == "" {
+						http.Error(wrt, "`field` is required for aggregation function "+aggregation.Function, http.StatusUnprocessableEntity)
+						return
+					}
+				default:
+					http.Error(wrt, "aggregation `function` must be one of count, sum, min, max, distinct_approx", http.StatusUnprocessableEntity)
+					return
+				}
+			}
+			groupBy := sql.NullString{}
+			if len(reqRule.GroupBy) > 0 {
+				groupByJson, _ := json.Marshal(reqRule.GroupBy)
+				groupBy = sql.NullString{String: string(groupByJson), Valid: true}
+			}
+			aggregations, _ := json.Marshal(reqRule.Aggregations)
+
+			// Query database
+			rules := []rollup_rule_sql{}
+			err = db.Select(&rules, "select * from createRollupRule($1, $2, $3, $4, $5, $6)",
+				reqRule.StreamID, reqRule.RollupName,
+				sql.NullString{String: reqRule.MessageType, Valid: reqRule.MessageType != ""},
+				groupBy, string(aggregations), reqRule.WindowSeconds)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+				return
+			}
+			refreshIngestCache()
+
+			jsonData, err := json.MarshalIndent(rules, "", "    ")
+			if err != nil {
+				jsonData = nil
+				wrt.WriteHeader(http.StatusInternalServerError)
+				http.Error(wrt, "Internal Server Error", http.StatusInternalServerError)
+				CheckError(err)
+			}
+			wrt.WriteHeader(http.StatusOK)
+			wrt.Write(jsonData)
+		case http.MethodGet:
+		case http.MethodPut:
+		case http.MethodDelete:
+		default:
+			wrt.WriteHeader(http.StatusMethodNotAllowed)
+			http.Error(wrt, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func deleteRollupRuleHandler(db *sqlx.DB) func(http.ResponseWriter, *http.Request) {
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodDelete:
+			// Read json
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+			var reqRule rollup_rule_json
+			err = json.Unmarshal(body, &reqRule)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+
+			// Query database
+			rules := []rollup_rule_sql{}
+			if reqRule.RollupRuleID != 0 {
+				err := db.Select(&rules, "select * from deleteRollupRule($1)", reqRule.RollupRuleID)
+				if err != nil {
+					wrt.WriteHeader(http.StatusBadRequest)
+					http.Error(wrt, "Bad Request", http.StatusBadRequest)
+					CheckError(err)
+				}
+				if len(rules) <= 0 {
+					http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				} else {
+					jsonData, err := json.MarshalIndent(rules, "", "    ")
+					if err != nil {
+						jsonData = nil
+						wrt.WriteHeader(http.StatusInternalServerError)
+						http.Error(wrt, "Internal Server Error", http.StatusInternalServerError)
+						CheckError(err)
+					}
+					wrt.WriteHeader(http.StatusOK)
+					wrt.Write(jsonData)
+					refreshIngestCache()
+				}
+			} else {
+				http.Error(wrt, "`rollup_rule_id` is required", http.StatusUnprocessableEntity)
+			}
+		case http.MethodGet:
+		case http.MethodPost:
+		case http.MethodPut:
+		default:
+			wrt.WriteHeader(http.StatusMethodNotAllowed)
+			http.Error(wrt, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func getWebhooksHandler(db *sqlx.DB) func(http.ResponseWriter, *http.Request) {
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodPost:
+			// Read json
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+			var reqWebhook webhook_json
+			err = json.Unmarshal(body, &reqWebhook)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+
+			// Query database
+			webhooks := []webhook_sql{}
+			if reqWebhook.StreamID != "" {
+				err := db.Select(&webhooks, "select * from getWebhooks($1)", reqWebhook.StreamID)
+				if err != nil {
+					wrt.WriteHeader(http.StatusBadRequest)
+					http.Error(wrt, "Bad Request", http.StatusBadRequest)
+					CheckError(err)
+				}
+				if len(webhooks) <= 0 {
+					wrt.WriteHeader(http.StatusNoContent)
+				} else {
+					jsonData, err := json.MarshalIndent(webhooks, "", "    ")
+					if err != nil {
+						jsonData = nil
+						wrt.WriteHeader(http.StatusInternalServerError)
+						http.Error(wrt, "Internal Server Error", http.StatusInternalServerError)
+						CheckError(err)
+					}
+					wrt.WriteHeader(http.StatusOK)
+					wrt.Write(jsonData)
+				}
+			} else {
+				http.Error(wrt, "`stream_id` is required", http.StatusUnprocessableEntity)
+			}
+		case http.MethodGet:
+		case http.MethodPut:
+		case http.MethodDelete:
+		default:
+			wrt.WriteHeader(http.StatusMethodNotAllowed)
+			http.Error(wrt, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func createWebhookHandler(db *sqlx.DB) func(http.ResponseWriter, *http.Request) {
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodPost:
+			// Read json
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+			var reqWebhook webhook_json
+			err = json.Unmarshal(body, &reqWebhook)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+
+			if reqWebhook.StreamID == "" || reqWebhook.Url == "" {
+				http.Error(wrt, "`stream_id` and `url` are required", http.StatusUnprocessableEntity)
+				return
+			}
+			webhookUrl, err := url.Parse(reqWebhook.Url)
+			if err != nil || (webhookUrl.Scheme != "http" && webhookUrl.Scheme != "https") || webhookUrl.Host == "" {
+				http.Error(wrt, "`url` must be an absolute http or https URL", http.StatusUnprocessableEntity)
+				return
+			}
+			if reqWebhook.Content == "" {
+				reqWebhook.Content = "events"
+			}
+			if reqWebhook.Content != "events" && reqWebhook.Content != "partitions" {
+				http.Error(wrt, "`content` must be one of events, partitions", http.StatusUnprocessableEntity)
+				return
+			}
+			if reqWebhook.BatchSize < 0 || reqWebhook.BatchIntervalMs < 0 || reqWebhook.MaxAttempts < 0 || reqWebhook.MaxConcurrency < 0 {
+				http.Error(wrt, "`batch_size`, `batch_interval_ms`, `max_attempts` and `max_concurrency` must be positive", http.StatusUnprocessableEntity)
+				return
+			}
+			//unset settings take the table defaults
+			if reqWebhook.BatchSize == 0 {
+				reqWebhook.BatchSize = 1
+			}
+			if reqWebhook.BatchIntervalMs == 0 {
+				reqWebhook.BatchIntervalMs = 1000
+			}
+			if reqWebhook.MaxAttempts == 0 {
+				reqWebhook.MaxAttempts = 5
+			}
+			if reqWebhook.MaxConcurrency == 0 {
+				reqWebhook.MaxConcurrency = 1
+			}
+
+			// Query database
+			webhooks := []webhook_sql{}
+			err = db.Select(&webhooks, "select * from createWebhook($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+				reqWebhook.StreamID,
+				sql.NullString{String: reqWebhook.MessageType, Valid: reqWebhook.MessageType != ""},
+				reqWebhook.Content, reqWebhook.Url,
+				sql.NullString{String: reqWebhook.SigningSecretRef, Valid: reqWebhook.SigningSecretRef != ""},
+				reqWebhook.BatchSize, reqWebhook.BatchIntervalMs, reqWebhook.MaxAttempts, reqWebhook.MaxConcurrency)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+				return
+			}
+			refreshIngestCache()
+
+			jsonData, err := json.MarshalIndent(webhooks, "", "    ")
+			if err != nil {
+				jsonData = nil
+				wrt.WriteHeader(http.StatusInternalServerError)
+				http.Error(wrt, "Internal Server Error", http.StatusInternalServerError)
+				CheckError(err)
+			}
+			wrt.WriteHeader(http.StatusOK)
+			wrt.Write(jsonData)
+		case http.MethodGet:
+		case http.MethodPut:
+		case http.MethodDelete:
+		default:
+			wrt.WriteHeader(http.StatusMethodNotAllowed)
+			http.Error(wrt, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func deleteWebhookHandler(db *sqlx.DB) func(http.ResponseWriter, *http.Request) {
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodDelete:
+			// Read json
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+			var reqWebhook webhook_json
+			err = json.Unmarshal(body, &reqWebhook)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+
+			// Query database
+			webhooks := []webhook_sql{}
+			if reqWebhook.WebhookID != 0 {
+				err := db.Select(&webhooks, "select * from deleteWebhook($1)", reqWebhook.WebhookID)
+				if err != nil {
+					wrt.WriteHeader(http.StatusBadRequest)
+					http.Error(wrt, "Bad Request", http.StatusBadRequest)
+					CheckError(err)
+				}
+				if len(webhooks) <= 0 {
+					http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				} else {
+					jsonData, err := json.MarshalIndent(webhooks, "", "    ")
+					if err != nil {
+						jsonData = nil
+						wrt.WriteHeader(http.StatusInternalServerError)
+						http.Error(wrt, "Internal Server Error", http.StatusInternalServerError)
+						CheckError(err)
+					}
+					wrt.WriteHeader(http.StatusOK)
+					wrt.Write(jsonData)
+					refreshIngestCache()
+				}
+			} else {
+				http.Error(wrt, "`webhook_id` is required", http.StatusUnprocessableEntity)
+			}
+		case http.MethodGet:
+		case http.MethodPost:
+		case http.MethodPut:
+		default:
+			wrt.WriteHeader(http.StatusMethodNotAllowed)
+			http.Error(wrt, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func getWebhookFailuresHandler(db *sqlx.DB) func(http.ResponseWriter, *http.Request) {
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodPost:
+			// Read json
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+			var reqWebhook webhook_json
+			err = json.Unmarshal(body, &reqWebhook)
+			if err != nil {
+				wrt.WriteHeader(http.StatusBadRequest)
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				CheckError(err)
+			}
+
+			// Query database
+			failures := []webhook_failure_sql{}
+			if reqWebhook.StreamID != "" {
+				err := db.Select(&failures, "select * from getWebhookFailures($1)", reqWebhook.StreamID)
+				if err != nil {
+					wrt.WriteHeader(http.StatusBadRequest)
+					http.Error(wrt, "Bad Request", http.StatusBadRequest)
+					CheckError(err)
+				}
+				if len(failures) <= 0 {
+					wrt.WriteHeader(http.StatusNoContent)
+				} else {
+					jsonData, err := json.MarshalIndent(failures, "", "    ")
+					if err != nil {
+						jsonData = nil
+						wrt.WriteHeader(http.StatusInternalServerError)
+						http.Error(wrt, "Internal Server Error", http.StatusInternalServerError)
+						CheckError(err)
+					}
+					wrt.WriteHeader(http.StatusOK)
+					wrt.Write(jsonData)
+				}
+			} else {
+				http.Error(wrt, "`stream_id` is required", http.StatusUnprocessableEntity)
+			}
+		case http.MethodGet:
+		case http.MethodPut:
+		case http.MethodDelete:
+		default:
+			wrt.WriteHeader(http.StatusMethodNotAllowed)
+			http.Error(wrt, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+////////// HANDLER FUNCTIONS - End //////////
+
+////////// HELPER FUNCTIONS - Start //////////
+//	FUNCTION
+// 	setDBConnectionString
+//	created by Gavin
+//	on 20220109
+//	last updated 20220111
+//	by Gavin
+//	Description:	Sets the `psqlCon` global variable. Looks up environment variables
+//					and defaults if none are present.
+func setDBConnectionString() {
+	var db_host, db_port, db_user, db_password, db_dbname = db_host_def, db_port_def, db_user_def, db_password_def, db_dbname_def
+	var db_host_env, db_user_env, db_password_env, db_dbname_env = os.Getenv("RTDL_DB_HOST"), os.Getenv("RTDL_DB_USER"), os.Getenv("RTDL_DB_PASSWORD"), os.Getenv("RTDL_DB_DBNAME")
+	db_port_env, err := strconv.Atoi(os.Getenv("RTDL_DB_PORT"))
+	if err != nil {
+		db_port_env = 0
+	}
+
+	if db_host_env != "" {
+		db_host = db_host_env
+	}
+	if db_port_env != 0 {
+		db_port = db_port_env
+	}
+	if db_user_env != "" {
+		db_user = db_user_env
+	}
+	if db_password_env != "" {
+		db_password = db_password_env
+	}
+	if db_dbname_env != "" {
+		db_dbname = db_dbname_env
+	}
+	psqlCon = fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable", db_host, db_port, db_user, db_password, db_dbname)
+}
+
+////////// HELPER FUNCTIONS - Start //////////
+//	FUNCTION
+// 	refreshIngestCacher
+//	created by Gavin
+//	on 20220111
+//	last updated 20220111
+//	by Gavin
+//	Description:	Calls the `refreshCache` endpoint on the `ingest` service
+func refreshIngestCache() {
+	refreshCacheResp, err := http.Get("http://ingest:8080/refreshCache")
+	if err != nil {
+		CheckError(err)
+	}
+	if refreshCacheResp != nil {
+
+	}
+}
+
+//	FUNCTION
+// 	buildQueryString_createStream
+//	created by Gavin
+//	on 20220109
+//	last updated 20220111
+//	by Gavin
+//	Description:	Builds the query string used by the `createStream` function
+func buildQueryString_createStream(reqStream stream_json) (queryStr string) {
+	queryStr = "select * from createStream("
+	if reqStream.StreamAltID != "" {
+		queryStr = queryStr + "'" + reqStream.StreamAltID + "', "
+	} else {
+		queryStr = queryStr + "NULL, "
+	}
+	queryStr = queryStr + strconv.FormatBool(reqStream.Active) + ", "
+	if reqStream.MessageType != "" {
+		queryStr = queryStr + "'" + reqStream.MessageType + "', "
+	} else {
+		// TODO: Required field. Return error.
+		queryStr = queryStr + "NULL, "
+	}
+	if reqStream.FileStoreTypeID < 1 {
+		reqStream.FileStoreTypeID = 1
+	}
+	queryStr = queryStr + strconv.Itoa(reqStream.FileStoreTypeID) + ", "
+	if reqStream.Region != "" {
+		queryStr = queryStr + "'" + reqStream.Region + "', "
+	} else {
+		queryStr = queryStr + "NULL, "
+	}
+	if reqStream.BucketName != "" {
+		queryStr = queryStr + "'" + reqStream.BucketName + "', "
+	} else {
+		queryStr = queryStr + "NULL, "
+	}
+	if reqStream.FolderName != "" {
+		queryStr = queryStr + "'" + reqStream.FolderName + "', "
+	} else {
+		// TODO: Required field. Return error.
+		queryStr = queryStr + "NULL, "
+	}
+	if reqStream.PartitionTimeID < 1 {
+		reqStream.PartitionTimeID = 1
+	}
+	queryStr = queryStr + strconv.Itoa(reqStream.PartitionTimeID) + ", "
+	if reqStream.CompressionTypeID < 1 {
+		reqStream.CompressionTypeID = 1
+	}
+	queryStr = queryStr + strconv.Itoa(reqStream.CompressionTypeID) + ", "
+	if reqStream.AWSAcessKeyID != "" {
+		queryStr = queryStr + "'" + reqStream.AWSAcessKeyID + "', "
+	} else {
+		queryStr = queryStr + "NULL, "
+	}
+	if reqStream.AWSSecretAcessKey != "" {
+		queryStr = queryStr + "'" + reqStream.AWSSecretAcessKey + "', "
+	} else {
+		queryStr = queryStr + "NULL, "
+	}
+	if reqStream.GCPJsonCredentials != nil {
+		gcpCredsJson, _ := json.Marshal(reqStream.GCPJsonCredentials)
+		log.Println(string(gcpCredsJson))
+		//queryStr = queryStr + "'" + strings.Replace(strings.Replace(fmt.Sprintf("%v", reqStream.GCPJsonCredentials), "map[", "{", 1), "]", "}", 1) + "')"
+		queryStr = queryStr + "'" + string(gcpCredsJson) + "', "
+	} else {
+		queryStr = queryStr + "NULL, "
+	}
+
+	if reqStream.AzureStorageAccountname != "" {
+		queryStr = queryStr + "'" + reqStream.AzureStorageAccountname + "', "
 	} else {
 		queryStr = queryStr + "NULL, "
 	}
@@ -800,7 +2579,24 @@ func buildQueryString_createStream(reqStream stream_json) (queryStr string) {
 		reqStream.NamenodePort = 8020
 
 	}
-	queryStr = queryStr + strconv.Itoa(reqStream.NamenodePort) + ") "
+	queryStr = queryStr + strconv.Itoa(reqStream.NamenodePort) + ", "
+
+	queryStr = queryStr + buildQueryString_s3CompatArgs(reqStream) + ", "
+
+	queryStr = queryStr + buildQueryString_authArgs(reqStream) + ", "
+
+	queryStr = queryStr + buildQueryString_outputArgs(reqStream) + ", "
+	queryStr = queryStr + buildQueryString_parquetArgs(reqStream) + ", "
+	queryStr = queryStr + buildQueryString_compactionArgs(reqStream) + ", "
+	queryStr = queryStr + buildQueryString_retentionArgs(reqStream) + ", "
+	queryStr = queryStr + buildQueryString_maskingArgs(reqStream) + ", "
+	queryStr = queryStr + buildQueryString_transformationArgs(reqStream) + ", "
+	queryStr = queryStr + buildQueryString_dedupArgs(reqStream) + ", "
+	queryStr = queryStr + buildQueryString_sessionArgs(reqStream) + ", "
+	queryStr = queryStr + buildQueryString_enrichmentArgs(reqStream) + ", "
+	queryStr = queryStr + buildQueryString_metadataArgs(reqStream) + ", "
+	queryStr = queryStr + buildQueryString_egressArgs(reqStream) + ", "
+	queryStr = queryStr + buildQueryString_postgresArgs(reqStream) + ") "
 
 	return queryStr
 }
@@ -900,12 +2696,274 @@ func buildQueryString_updateStream(reqStream stream_json) (queryStr string) {
 		reqStream.NamenodePort = 8020
 
 	}
-	queryStr = queryStr + strconv.Itoa(reqStream.NamenodePort) + ") "
+	queryStr = queryStr + strconv.Itoa(reqStream.NamenodePort) + ", "
+
+	queryStr = queryStr + buildQueryString_s3CompatArgs(reqStream) + ", "
+
+	queryStr = queryStr + buildQueryString_authArgs(reqStream) + ", "
+
+	queryStr = queryStr + buildQueryString_outputArgs(reqStream) + ", "
+	queryStr = queryStr + buildQueryString_parquetArgs(reqStream) + ", "
+	queryStr = queryStr + buildQueryString_compactionArgs(reqStream) + ", "
+	queryStr = queryStr + buildQueryString_retentionArgs(reqStream) + ", "
+	queryStr = queryStr + buildQueryString_maskingArgs(reqStream) + ", "
+	queryStr = queryStr + buildQueryString_transformationArgs(reqStream) + ", "
+	queryStr = queryStr + buildQueryString_dedupArgs(reqStream) + ", "
+	queryStr = queryStr + buildQueryString_sessionArgs(reqStream) + ", "
+	queryStr = queryStr + buildQueryString_enrichmentArgs(reqStream) + ", "
+	queryStr = queryStr + buildQueryString_metadataArgs(reqStream) + ", "
+	queryStr = queryStr + buildQueryString_egressArgs(reqStream) + ", "
+	queryStr = queryStr + buildQueryString_postgresArgs(reqStream) + ") "
 
 	log.Println(queryStr)
 	return queryStr
 }
 
+//	FUNCTION
+// 	buildQueryString_s3CompatArgs
+//	Description:	Builds the S3-compatible storage arguments (endpoint, path-style,
+//					TLS and signature version) shared by `createStream` and `updateStream`
+func buildQueryString_s3CompatArgs(reqStream stream_json) (queryStr string) {
+	if reqStream.EndpointURL != "" {
+		queryStr = queryStr + "'" + strings.Replace(reqStream.EndpointURL, "'", "''", -1) + "', "
+	} else {
+		queryStr = queryStr + "NULL, "
+	}
+	queryStr = queryStr + strconv.FormatBool(reqStream.S3ForcePathStyle) + ", "
+	queryStr = queryStr + strconv.FormatBool(reqStream.TLSSkipVerify) + ", "
+	if reqStream.TLSCACert != "" {
+		queryStr = queryStr + "'" + strings.Replace(reqStream.TLSCACert, "'", "''", -1) + "', "
+	} else {
+		queryStr = queryStr + "NULL, "
+	}
+	if reqStream.S3SignatureVersion == "" {
+		reqStream.S3SignatureVersion = "v4" //AWS default
+	}
+	queryStr = queryStr + "'" + strings.Replace(reqStream.S3SignatureVersion, "'", "''", -1) + "'"
+
+	return queryStr
+}
+
+//	FUNCTION
+// 	buildQueryString_authArgs
+//	Description:	Builds the authentication arguments (auth mode, role ARN and secret
+//					reference) shared by `createStream` and `updateStream`
+func buildQueryString_authArgs(reqStream stream_json) (queryStr string) {
+	if reqStream.AuthMode == "" {
+		reqStream.AuthMode = "static" //keys stored on the stream
+	}
+	queryStr = queryStr + "'" + strings.Replace(reqStream.AuthMode, "'", "''", -1) + "', "
+	if reqStream.AWSRoleArn != "" {
+		queryStr = queryStr + "'" + strings.Replace(reqStream.AWSRoleArn, "'", "''", -1) + "', "
+	} else {
+		queryStr = queryStr + "NULL, "
+	}
+	if reqStream.SecretRef != "" {
+		queryStr = queryStr + "'" + strings.Replace(reqStream.SecretRef, "'", "''", -1) + "'"
+	} else {
+		queryStr = queryStr + "NULL"
+	}
+
+	return queryStr
+}
+
+//	FUNCTION
+// 	buildQueryString_outputArgs
+//	Description:	Builds the output file arguments (file naming scheme, table
+//					format and file format) shared by `createStream` and `updateStream`
+func buildQueryString_outputArgs(reqStream stream_json) (queryStr string) {
+	if reqStream.FileNaming == "" {
+		reqStream.FileNaming = "timestamp" //time-sortable names with instance id
+	}
+	queryStr = queryStr + "'" + strings.Replace(reqStream.FileNaming, "'", "''", -1) + "', "
+	if reqStream.TableFormat == "" {
+		reqStream.TableFormat = "none" //loose Parquet files
+	}
+	queryStr = queryStr + "'" + strings.Replace(reqStream.TableFormat, "'", "''", -1) + "', "
+	if reqStream.FileFormatID < 1 {
+		reqStream.FileFormatID = 1 //Parquet
+	}
+	queryStr = queryStr + strconv.Itoa(reqStream.FileFormatID)
+
+	return queryStr
+}
+
+//	FUNCTION
+// 	buildQueryString_parquetArgs
+//	Description:	Builds the Parquet writer tuning arguments (row group size, page
+//					size, dictionary encoding and sort columns) shared by `createStream`
+//					and `updateStream`; unset sizes fall back to the writer defaults
+func buildQueryString_parquetArgs(reqStream stream_json) (queryStr string) {
+	if reqStream.ParquetRowGroupSize > 0 {
+		queryStr = queryStr + strconv.FormatInt(reqStream.ParquetRowGroupSize, 10) + ", "
+	} else {
+		queryStr = queryStr + "NULL, "
+	}
+	if reqStream.ParquetPageSize > 0 {
+		queryStr = queryStr + strconv.Itoa(reqStream.ParquetPageSize) + ", "
+	} else {
+		queryStr = queryStr + "NULL, "
+	}
+	queryStr = queryStr + strconv.FormatBool(reqStream.ParquetDictionary) + ", "
+	if reqStream.ParquetSortColumns != "" {
+		queryStr = queryStr + "'" + strings.Replace(reqStream.ParquetSortColumns, "'", "''", -1) + "'"
+	} else {
+		queryStr = queryStr + "NULL"
+	}
+
+	return queryStr
+}
+
+//	FUNCTION
+// 	buildQueryString_compactionArgs
+//	Description:	Builds the compaction policy arguments (enabled, minimum file count
+//					and target file size) shared by `createStream` and `updateStream`
+func buildQueryString_compactionArgs(reqStream stream_json) (queryStr string) {
+	queryStr = queryStr + strconv.FormatBool(reqStream.CompactionEnabled) + ", "
+	if reqStream.CompactionMinFiles < 2 {
+		reqStream.CompactionMinFiles = 10 //partitions with fewer files are left alone
+	}
+	queryStr = queryStr + strconv.Itoa(reqStream.CompactionMinFiles) + ", "
+	if reqStream.CompactionTargetSize <= 0 {
+		reqStream.CompactionTargetSize = 128 * 1024 * 1024 //128M
+	}
+	queryStr = queryStr + strconv.FormatInt(reqStream.CompactionTargetSize, 10)
+
+	return queryStr
+}
+
+//	FUNCTION
+// 	buildQueryString_retentionArgs
+//	Description:	Builds the retention policy arguments (retention in days and dry run)
+//					shared by `createStream` and `updateStream`; without a retention
+//					partitions are kept forever
+func buildQueryString_retentionArgs(reqStream stream_json) (queryStr string) {
+	if reqStream.RetentionDays > 0 {
+		queryStr = queryStr + strconv.Itoa(reqStream.RetentionDays) + ", "
+	} else {
+		queryStr = queryStr + "NULL, "
+	}
+	queryStr = queryStr + strconv.FormatBool(reqStream.RetentionDryRun)
+
+	return queryStr
+}
+
+//	FUNCTION
+// 	buildQueryString_maskingArgs
+//	Description:	Builds the masking arguments (secret reference of the HMAC salt and
+//					vault key) shared by `createStream` and `updateStream`
+func buildQueryString_maskingArgs(reqStream stream_json) (queryStr string) {
+	if reqStream.MaskingKeyRef != "" {
+		queryStr = queryStr + "'" + strings.Replace(reqStream.MaskingKeyRef, "'", "''", -1) + "'"
+	} else {
+		queryStr = queryStr + "NULL"
+	}
+
+	return queryStr
+}
+
+//	FUNCTION
+// 	buildQueryString_transformationArgs
+//	Description:	Builds the transformation steps argument (a JSON array, stored as text)
+//					shared by `createStream` and `updateStream`
+func buildQueryString_transformationArgs(reqStream stream_json) (queryStr string) {
+	if len(reqStream.Transformations) > 0 && string(reqStream.Transformations) != "null" {
+		queryStr = queryStr + "'" + strings.Replace(string(reqStream.Transformations), "'", "''", -1) + "'"
+	} else {
+		queryStr = queryStr + "NULL"
+	}
+
+	return queryStr
+}
+
+//	FUNCTION
+// 	buildQueryString_dedupArgs
+//	Description:	Builds the deduplication arguments (payload field holding the message id
+//					and window in seconds) shared by `createStream` and `updateStream`
+func buildQueryString_dedupArgs(reqStream stream_json) (queryStr string) {
+	if reqStream.DedupKey != "" {
+		queryStr = queryStr + "'" + strings.Replace(reqStream.DedupKey, "'", "''", -1) + "', "
+	} else {
+		queryStr = queryStr + "NULL, "
+	}
+	if reqStream.DedupTTLSeconds > 0 {
+		queryStr = queryStr + strconv.Itoa(reqStream.DedupTTLSeconds)
+	} else {
+		queryStr = queryStr + "NULL"
+	}
+
+	return queryStr
+}
+
+//	FUNCTION
+// 	buildQueryString_sessionArgs
+//	Description:	Builds the sessionization arguments (enabled and inactivity timeout in
+//					seconds) shared by `createStream` and `updateStream`
+func buildQueryString_sessionArgs(reqStream stream_json) (queryStr string) {
+	queryStr = queryStr + strconv.FormatBool(reqStream.SessionizationEnabled) + ", "
+	if reqStream.SessionTimeoutSeconds > 0 {
+		queryStr = queryStr + strconv.Itoa(reqStream.SessionTimeoutSeconds)
+	} else {
+		queryStr = queryStr + "NULL"
+	}
+
+	return queryStr
+}
+
+//	FUNCTION
+// 	buildQueryString_enrichmentArgs
+//	Description:	Builds the enrichment argument (GeoIP and user-agent lookup enabled)
+//					shared by `createStream` and `updateStream`
+func buildQueryString_enrichmentArgs(reqStream stream_json) (queryStr string) {
+	queryStr = queryStr + strconv.FormatBool(reqStream.EnrichmentEnabled)
+
+	return queryStr
+}
+
+//	FUNCTION
+// 	buildQueryString_metadataArgs
+//	Description:	Builds the metadata columns argument (`_rtdl_*` system columns added to
+//					every row) shared by `createStream` and `updateStream`
+func buildQueryString_metadataArgs(reqStream stream_json) (queryStr string) {
+	queryStr = queryStr + strconv.FormatBool(reqStream.MetadataColumnsEnabled)
+
+	return queryStr
+}
+
+//	FUNCTION
+// 	buildQueryString_egressArgs
+//	Description:	Builds the egress targets argument (a JSON array of topics and what they
+//					receive, stored as text) shared by `createStream` and `updateStream`
+func buildQueryString_egressArgs(reqStream stream_json) (queryStr string) {
+	if len(reqStream.EgressTargets) > 0 && string(reqStream.EgressTargets) != "null" {
+		queryStr = queryStr + "'" + strings.Replace(string(reqStream.EgressTargets), "'", "''", -1) + "'"
+	} else {
+		queryStr = queryStr + "NULL"
+	}
+
+	return queryStr
+}
+
+//	FUNCTION
+// 	buildQueryString_postgresArgs
+//	Description:	Builds the Postgres table sink arguments (enabled, connection URL and
+//					schema of the tables) shared by `createStream` and `updateStream`
+func buildQueryString_postgresArgs(reqStream stream_json) (queryStr string) {
+	queryStr = queryStr + strconv.FormatBool(reqStream.PostgresEnabled) + ", "
+	if reqStream.PostgresURL != "" {
+		queryStr = queryStr + "'" + strings.Replace(reqStream.PostgresURL, "'", "''", -1) + "', "
+	} else {
+		queryStr = queryStr + "NULL, "
+	}
+	if reqStream.PostgresSchema != "" {
+		queryStr = queryStr + "'" + strings.Replace(reqStream.PostgresSchema, "'", "''", -1) + "'"
+	} else {
+		queryStr = queryStr + "NULL"
+	}
+
+	return queryStr
+}
+
 func CheckError(err error) {
 	if err != nil {
 		log.Println(err)
@@ -52,6 +52,13 @@ CREATE TABLE IF NOT EXISTS compression_types (
   PRIMARY KEY (compression_type_id)
 );
 
+-- create `file_formats` table
+CREATE TABLE IF NOT EXISTS file_formats (
+  file_format_id SERIAL,
+  file_format_name VARCHAR,
+  PRIMARY KEY (file_format_id)
+);
+
 -- create `streams` table
 CREATE TABLE IF NOT EXISTS streams (
   stream_id uuid DEFAULT gen_random_uuid(),
@@ -71,45 +78,309 @@ CREATE TABLE IF NOT EXISTS streams (
   azure_storage_access_key VARCHAR,
   namenode_host VARCHAR,
   namenode_port INTEGER,
+  endpoint_url VARCHAR,
+  s3_force_path_style BOOLEAN DEFAULT FALSE,
+  tls_skip_verify BOOLEAN DEFAULT FALSE,
+  tls_ca_cert VARCHAR,
+  s3_signature_version VARCHAR DEFAULT 'v4',
+  auth_mode VARCHAR DEFAULT 'static',
+  aws_role_arn VARCHAR,
+  secret_ref VARCHAR,
+  file_naming VARCHAR DEFAULT 'timestamp',
+  table_format VARCHAR DEFAULT 'none',
+  file_format_id INTEGER DEFAULT 1,
+  parquet_row_group_size BIGINT,
+  parquet_page_size INTEGER,
+  parquet_dictionary BOOLEAN DEFAULT FALSE,
+  parquet_sort_columns VARCHAR,
+  compaction_enabled BOOLEAN DEFAULT FALSE,
+  compaction_min_files INTEGER DEFAULT 10,
+  compaction_target_size BIGINT DEFAULT 134217728,
+  retention_days INTEGER,
+  retention_dry_run BOOLEAN DEFAULT FALSE,
+  masking_key_ref VARCHAR,
+  transformations VARCHAR,
+  dedup_key VARCHAR,
+  dedup_ttl_seconds INTEGER,
+  sessionization_enabled BOOLEAN DEFAULT FALSE,
+  session_timeout_seconds INTEGER,
+  enrichment_enabled BOOLEAN DEFAULT FALSE,
+  metadata_columns_enabled BOOLEAN DEFAULT FALSE,
+  egress_targets VARCHAR,
+  postgres_enabled BOOLEAN DEFAULT FALSE,
+  postgres_url VARCHAR,
+  postgres_schema VARCHAR,
   created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
   updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
   PRIMARY KEY (stream_id),
   FOREIGN KEY(file_store_type_id) REFERENCES file_store_types(file_store_type_id),
   FOREIGN KEY(partition_time_id) REFERENCES partition_times(partition_time_id),
-  FOREIGN KEY(compression_type_id) REFERENCES compression_types(compression_type_id)
+  FOREIGN KEY(compression_type_id) REFERENCES compression_types(compression_type_id),
+  FOREIGN KEY(file_format_id) REFERENCES file_formats(file_format_id)
 );
 
 -- create trigger to automate setting `updated_at` field when `streams` records are updated
+DROP TRIGGER IF EXISTS set_timestamp ON streams;
 CREATE TRIGGER set_timestamp
 BEFORE UPDATE ON streams
 FOR EACH ROW
 EXECUTE PROCEDURE triggerSetTS();
 
+-- create `compaction_runs` table, one row per compacted partition
+CREATE TABLE IF NOT EXISTS compaction_runs (
+  compaction_run_id SERIAL,
+  stream_id uuid NOT NULL,
+  partition_path VARCHAR NOT NULL,
+  trigger_type VARCHAR NOT NULL,
+  status VARCHAR NOT NULL,
+  files_before INTEGER,
+  files_after INTEGER,
+  bytes_before BIGINT,
+  bytes_after BIGINT,
+  error_message VARCHAR,
+  started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  finished_at TIMESTAMPTZ,
+  PRIMARY KEY (compaction_run_id),
+  FOREIGN KEY(stream_id) REFERENCES streams(stream_id) ON DELETE CASCADE
+);
+
+-- create `retention_runs` table, one row per expired partition found by the retention job
+CREATE TABLE IF NOT EXISTS retention_runs (
+  retention_run_id SERIAL,
+  stream_id uuid NOT NULL,
+  partition_path VARCHAR NOT NULL,
+  partition_end TIMESTAMPTZ,
+  dry_run BOOLEAN NOT NULL DEFAULT FALSE,
+  status VARCHAR NOT NULL,
+  files INTEGER,
+  bytes BIGINT,
+  error_message VARCHAR,
+  executed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (retention_run_id),
+  FOREIGN KEY(stream_id) REFERENCES streams(stream_id) ON DELETE CASCADE
+);
+
+-- a dry run reports an expired partition once, later passes update that row
+CREATE UNIQUE INDEX IF NOT EXISTS retention_runs_dry_run_partition ON retention_runs (stream_id, partition_path) WHERE dry_run;
+
+-- create `erasure_jobs` table, one row per subject erasure request
+-- subject values are not kept, only how many were requested
+CREATE TABLE IF NOT EXISTS erasure_jobs (
+  erasure_job_id SERIAL,
+  stream_id uuid NOT NULL,
+  subject_field VARCHAR NOT NULL,
+  subject_count INTEGER NOT NULL,
+  status VARCHAR NOT NULL DEFAULT 'requested',
+  partitions_total INTEGER,
+  partitions_done INTEGER NOT NULL DEFAULT 0,
+  partitions_failed INTEGER NOT NULL DEFAULT 0,
+  files_scanned INTEGER NOT NULL DEFAULT 0,
+  files_rewritten INTEGER NOT NULL DEFAULT 0,
+  files_deleted INTEGER NOT NULL DEFAULT 0,
+  rows_deleted BIGINT NOT NULL DEFAULT 0,
+  error_message VARCHAR,
+  requested_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  started_at TIMESTAMPTZ,
+  finished_at TIMESTAMPTZ,
+  PRIMARY KEY (erasure_job_id),
+  FOREIGN KEY(stream_id) REFERENCES streams(stream_id) ON DELETE CASCADE
+);
+
+-- create `erasure_job_partitions` table, the outcome of an erasure job per scanned partition
+CREATE TABLE IF NOT EXISTS erasure_job_partitions (
+  erasure_job_partition_id SERIAL,
+  erasure_job_id INTEGER NOT NULL,
+  partition_path VARCHAR NOT NULL,
+  status VARCHAR NOT NULL,
+  files_scanned INTEGER,
+  files_rewritten INTEGER,
+  files_deleted INTEGER,
+  rows_deleted BIGINT,
+  error_message VARCHAR,
+  finished_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (erasure_job_partition_id),
+  FOREIGN KEY(erasure_job_id) REFERENCES erasure_jobs(erasure_job_id) ON DELETE CASCADE
+);
+
+-- create `masking_rules` table, PII masking applied to the payloads of a stream before they are written
+CREATE TABLE IF NOT EXISTS masking_rules (
+  masking_rule_id SERIAL,
+  stream_id uuid NOT NULL,
+  json_path VARCHAR NOT NULL,
+  masking_action VARCHAR NOT NULL CHECK (masking_action IN ('drop', 'hash', 'truncate_ip', 'redact', 'tokenize')),
+  pattern VARCHAR,
+  replacement VARCHAR,
+  rule_order INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (masking_rule_id),
+  FOREIGN KEY(stream_id) REFERENCES streams(stream_id) ON DELETE CASCADE
+);
+
+-- create `filter_rules` table, events of a stream dropped or sampled before they are written
+-- a rule without `json_path` matches every event of its message type (all message types when NULL)
+CREATE TABLE IF NOT EXISTS filter_rules (
+  filter_rule_id SERIAL,
+  stream_id uuid NOT NULL,
+  message_type VARCHAR,
+  json_path VARCHAR,
+  filter_operator VARCHAR CHECK (filter_operator IN ('equals', 'in', 'regex', 'exists', 'not_exists', 'gt', 'gte', 'lt', 'lte')),
+  filter_value VARCHAR,
+  filter_action VARCHAR NOT NULL CHECK (filter_action IN ('drop', 'keep')),
+  sample_rate DOUBLE PRECISION NOT NULL DEFAULT 1 CHECK (sample_rate >= 0 AND sample_rate <= 1),
+  sample_key VARCHAR,
+  rule_order INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (filter_rule_id),
+  FOREIGN KEY(stream_id) REFERENCES streams(stream_id) ON DELETE CASCADE,
+  CHECK ((json_path IS NULL) = (filter_operator IS NULL))
+);
+
+-- create `filter_stats` table, running totals of the events each filter rule matched, dropped and sampled out
+CREATE TABLE IF NOT EXISTS filter_stats (
+  filter_rule_id INTEGER NOT NULL,
+  stream_id uuid NOT NULL,
+  message_type VARCHAR NOT NULL,
+  events_matched BIGINT NOT NULL DEFAULT 0,
+  events_dropped BIGINT NOT NULL DEFAULT 0,
+  events_sampled_out BIGINT NOT NULL DEFAULT 0,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (filter_rule_id, message_type),
+  FOREIGN KEY(filter_rule_id) REFERENCES filter_rules(filter_rule_id) ON DELETE CASCADE,
+  FOREIGN KEY(stream_id) REFERENCES streams(stream_id) ON DELETE CASCADE
+);
+
+-- create `dedup_stats` table, running total of the duplicate messages dropped per stream
+CREATE TABLE IF NOT EXISTS dedup_stats (
+  stream_id uuid NOT NULL,
+  duplicates_dropped BIGINT NOT NULL DEFAULT 0,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (stream_id),
+  FOREIGN KEY(stream_id) REFERENCES streams(stream_id) ON DELETE CASCADE
+);
+
+-- create `rollup_rules` table, windowed aggregates of a stream written as the `rollup_name` dataset
+-- `group_by` is a JSON array of field paths, `aggregations` a JSON array of {"function", "field", "as"}
+CREATE TABLE IF NOT EXISTS rollup_rules (
+  rollup_rule_id SERIAL,
+  stream_id uuid NOT NULL,
+  rollup_name VARCHAR NOT NULL,
+  message_type VARCHAR,
+  group_by VARCHAR,
+  aggregations VARCHAR NOT NULL,
+  window_seconds INTEGER NOT NULL CHECK (window_seconds > 0),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (rollup_rule_id),
+  UNIQUE (stream_id, rollup_name),
+  FOREIGN KEY(stream_id) REFERENCES streams(stream_id) ON DELETE CASCADE
+);
+
+-- create `webhooks` table, HTTP endpoints the written events of a stream are posted to
+-- `content` is `events` for the written events or `partitions` for the partition closed events of the stream
+-- `signing_secret_ref` names a secret like a stream's `secret_ref`, requests are HMAC signed with it when set
+CREATE TABLE IF NOT EXISTS webhooks (
+  webhook_id SERIAL,
+  stream_id uuid NOT NULL,
+  message_type VARCHAR,
+  content VARCHAR NOT NULL DEFAULT 'events' CHECK (content IN ('events', 'partitions')),
+  url VARCHAR NOT NULL,
+  signing_secret_ref VARCHAR,
+  batch_size INTEGER NOT NULL DEFAULT 1 CHECK (batch_size > 0),
+  batch_interval_ms INTEGER NOT NULL DEFAULT 1000 CHECK (batch_interval_ms > 0),
+  max_attempts INTEGER NOT NULL DEFAULT 5 CHECK (max_attempts > 0),
+  max_concurrency INTEGER NOT NULL DEFAULT 1 CHECK (max_concurrency > 0),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (webhook_id),
+  FOREIGN KEY(stream_id) REFERENCES streams(stream_id) ON DELETE CASCADE
+);
+
+-- create `webhook_failures` table, batches a webhook could not deliver within its `max_attempts`
+CREATE TABLE IF NOT EXISTS webhook_failures (
+  webhook_failure_id SERIAL,
+  webhook_id INTEGER NOT NULL,
+  stream_id uuid NOT NULL,
+  events VARCHAR NOT NULL,
+  event_count INTEGER NOT NULL,
+  attempts INTEGER NOT NULL,
+  last_status INTEGER,
+  last_error VARCHAR,
+  failed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (webhook_failure_id),
+  FOREIGN KEY(webhook_id) REFERENCES webhooks(webhook_id) ON DELETE CASCADE
+);
+
 -- populate master data - start
+-- rows missing from an existing install are added in the order of a fresh one, so the ids match
 INSERT INTO file_store_types (file_store_type_name)
-VALUES
-    ('Local'),
-    ('AWS'),
-    ('GCP'),
-	('Azure'),
-    ('HDFS');
+SELECT seed.name
+FROM unnest(ARRAY['Local', 'AWS', 'GCP', 'Azure', 'HDFS']) WITH ORDINALITY AS seed(name, position)
+WHERE NOT EXISTS (SELECT 1 FROM file_store_types t WHERE t.file_store_type_name = seed.name)
+ORDER BY seed.position;
 
 INSERT INTO partition_times (partition_time_name)
-VALUES
-    ('Hourly'),
-    ('Daily'),
-    ('Weekly'),
-    ('Monthly'),
-    ('Quarterly');
+SELECT seed.name
+FROM unnest(ARRAY['Hourly', 'Daily', 'Weekly', 'Monthly', 'Quarterly']) WITH ORDINALITY AS seed(name, position)
+WHERE NOT EXISTS (SELECT 1 FROM partition_times t WHERE t.partition_time_name = seed.name)
+ORDER BY seed.position;
 
 INSERT INTO compression_types (compression_type_name)
-VALUES
-    ('snappy'),
-    ('gzip'),
-    ('lzo');
+SELECT seed.name
+FROM unnest(ARRAY['snappy', 'gzip', 'lzo', 'zstd', 'lz4', 'brotli', 'uncompressed']) WITH ORDINALITY AS seed(name, position)
+WHERE NOT EXISTS (SELECT 1 FROM compression_types t WHERE t.compression_type_name = seed.name)
+ORDER BY seed.position;
+
+INSERT INTO file_formats (file_format_name)
+SELECT seed.name
+FROM unnest(ARRAY['parquet', 'avro', 'orc', 'jsonl', 'csv']) WITH ORDINALITY AS seed(name, position)
+WHERE NOT EXISTS (SELECT 1 FROM file_formats t WHERE t.file_format_name = seed.name)
+ORDER BY seed.position;
 -- populate master data - end
 
+-- columns added since the first release, for installs whose `streams` table predates them
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS endpoint_url VARCHAR;
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS s3_force_path_style BOOLEAN DEFAULT FALSE;
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS tls_skip_verify BOOLEAN DEFAULT FALSE;
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS tls_ca_cert VARCHAR;
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS s3_signature_version VARCHAR DEFAULT 'v4';
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS auth_mode VARCHAR DEFAULT 'static';
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS aws_role_arn VARCHAR;
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS secret_ref VARCHAR;
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS file_naming VARCHAR DEFAULT 'timestamp';
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS table_format VARCHAR DEFAULT 'none';
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS file_format_id INTEGER DEFAULT 1 REFERENCES file_formats(file_format_id);
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS parquet_row_group_size BIGINT;
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS parquet_page_size INTEGER;
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS parquet_dictionary BOOLEAN DEFAULT FALSE;
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS parquet_sort_columns VARCHAR;
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS compaction_enabled BOOLEAN DEFAULT FALSE;
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS compaction_min_files INTEGER DEFAULT 10;
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS compaction_target_size BIGINT DEFAULT 134217728;
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS retention_days INTEGER;
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS retention_dry_run BOOLEAN DEFAULT FALSE;
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS masking_key_ref VARCHAR;
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS transformations VARCHAR;
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS dedup_key VARCHAR;
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS dedup_ttl_seconds INTEGER;
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS sessionization_enabled BOOLEAN DEFAULT FALSE;
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS session_timeout_seconds INTEGER;
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS enrichment_enabled BOOLEAN DEFAULT FALSE;
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS metadata_columns_enabled BOOLEAN DEFAULT FALSE;
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS egress_targets VARCHAR;
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS postgres_enabled BOOLEAN DEFAULT FALSE;
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS postgres_url VARCHAR;
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS postgres_schema VARCHAR;
+
 -- create API handler functions - start
+-- functions whose columns or arguments changed since the first release, recreated below
+-- CREATE OR REPLACE cannot change the columns a function returns
+DROP FUNCTION IF EXISTS getStream(VARCHAR);
+DROP FUNCTION IF EXISTS getAllStreams();
+DROP FUNCTION IF EXISTS getAllActiveStreams();
+DROP FUNCTION IF EXISTS createStream(VARCHAR, BOOLEAN, VARCHAR, INTEGER, VARCHAR, VARCHAR, VARCHAR, INTEGER, INTEGER, VARCHAR, VARCHAR, VARCHAR, VARCHAR, VARCHAR, VARCHAR, INTEGER);
+DROP FUNCTION IF EXISTS updateStream(VARCHAR, VARCHAR, BOOLEAN, VARCHAR, INTEGER, VARCHAR, VARCHAR, VARCHAR, INTEGER, INTEGER, VARCHAR, VARCHAR, VARCHAR, VARCHAR, VARCHAR, VARCHAR, INTEGER);
+DROP FUNCTION IF EXISTS deleteStream(VARCHAR);
+DROP FUNCTION IF EXISTS activateStream(VARCHAR);
+DROP FUNCTION IF EXISTS deactivateStream(VARCHAR);
+
 CREATE OR REPLACE FUNCTION getStream(stream_id_arg VARCHAR)
     RETURNS TABLE (
         stream_id uuid,
@@ -128,12 +399,44 @@ CREATE OR REPLACE FUNCTION getStream(stream_id_arg VARCHAR)
 		azure_storage_account_name VARCHAR,
 		azure_storage_access_key VARCHAR,
         namenode_host VARCHAR,
-        namenode_port INTEGER		
+        namenode_port INTEGER,
+        endpoint_url VARCHAR,
+        s3_force_path_style BOOLEAN,
+        tls_skip_verify BOOLEAN,
+        tls_ca_cert VARCHAR,
+        s3_signature_version VARCHAR,
+        auth_mode VARCHAR,
+        aws_role_arn VARCHAR,
+        secret_ref VARCHAR,
+        file_naming VARCHAR,
+        table_format VARCHAR,
+        file_format_id INTEGER,
+        parquet_row_group_size BIGINT,
+        parquet_page_size INTEGER,
+        parquet_dictionary BOOLEAN,
+        parquet_sort_columns VARCHAR,
+        compaction_enabled BOOLEAN,
+        compaction_min_files INTEGER,
+        compaction_target_size BIGINT,
+        retention_days INTEGER,
+        retention_dry_run BOOLEAN,
+        masking_key_ref VARCHAR,
+        transformations VARCHAR,
+        dedup_key VARCHAR,
+        dedup_ttl_seconds INTEGER,
+        sessionization_enabled BOOLEAN,
+        session_timeout_seconds INTEGER,
+        enrichment_enabled BOOLEAN,
+        metadata_columns_enabled BOOLEAN,
+        egress_targets VARCHAR,
+        postgres_enabled BOOLEAN,
+        postgres_url VARCHAR,
+        postgres_schema VARCHAR
     )
 AS $$
 BEGIN
     RETURN QUERY
-        SELECT s.stream_id, s.stream_alt_id, s.active, s.message_type, s.file_store_type_id, s.region, s.bucket_name, s.folder_name, s.partition_time_id, s.compression_type_id, s.aws_access_key_id, s.aws_secret_access_key, s.gcp_json_credentials, s.azure_storage_account_name, s.azure_storage_access_key,s.namenode_host,s.namenode_port
+        SELECT s.stream_id, s.stream_alt_id, s.active, s.message_type, s.file_store_type_id, s.region, s.bucket_name, s.folder_name, s.partition_time_id, s.compression_type_id, s.aws_access_key_id, s.aws_secret_access_key, s.gcp_json_credentials, s.azure_storage_account_name, s.azure_storage_access_key,s.namenode_host,s.namenode_port, s.endpoint_url, s.s3_force_path_style, s.tls_skip_verify, s.tls_ca_cert, s.s3_signature_version, s.auth_mode, s.aws_role_arn, s.secret_ref, s.file_naming, s.table_format, s.file_format_id, s.parquet_row_group_size, s.parquet_page_size, s.parquet_dictionary, s.parquet_sort_columns, s.compaction_enabled, s.compaction_min_files, s.compaction_target_size, s.retention_days, s.retention_dry_run, s.masking_key_ref, s.transformations, s.dedup_key, s.dedup_ttl_seconds, s.sessionization_enabled, s.session_timeout_seconds, s.enrichment_enabled, s.metadata_columns_enabled, s.egress_targets, s.postgres_enabled, s.postgres_url, s.postgres_schema
         FROM streams s
         WHERE s.stream_id = (stream_id_arg)::uuid
         ORDER BY s.stream_id ASC;
@@ -158,12 +461,44 @@ CREATE OR REPLACE FUNCTION getAllStreams()
 		azure_storage_account_name VARCHAR,
 		azure_storage_access_key VARCHAR,
         namenode_host VARCHAR,
-        namenode_port INTEGER
+        namenode_port INTEGER,
+        endpoint_url VARCHAR,
+        s3_force_path_style BOOLEAN,
+        tls_skip_verify BOOLEAN,
+        tls_ca_cert VARCHAR,
+        s3_signature_version VARCHAR,
+        auth_mode VARCHAR,
+        aws_role_arn VARCHAR,
+        secret_ref VARCHAR,
+        file_naming VARCHAR,
+        table_format VARCHAR,
+        file_format_id INTEGER,
+        parquet_row_group_size BIGINT,
+        parquet_page_size INTEGER,
+        parquet_dictionary BOOLEAN,
+        parquet_sort_columns VARCHAR,
+        compaction_enabled BOOLEAN,
+        compaction_min_files INTEGER,
+        compaction_target_size BIGINT,
+        retention_days INTEGER,
+        retention_dry_run BOOLEAN,
+        masking_key_ref VARCHAR,
+        transformations VARCHAR,
+        dedup_key VARCHAR,
+        dedup_ttl_seconds INTEGER,
+        sessionization_enabled BOOLEAN,
+        session_timeout_seconds INTEGER,
+        enrichment_enabled BOOLEAN,
+        metadata_columns_enabled BOOLEAN,
+        egress_targets VARCHAR,
+        postgres_enabled BOOLEAN,
+        postgres_url VARCHAR,
+        postgres_schema VARCHAR
     )
 AS $$
 BEGIN
     RETURN QUERY
-        SELECT s.stream_id, s.stream_alt_id, s.active, s.message_type, s.file_store_type_id, s.region, s.bucket_name, s.folder_name, s.partition_time_id, s.compression_type_id, s.aws_access_key_id, s.aws_secret_access_key, s.gcp_json_credentials, s.azure_storage_account_name, s.azure_storage_access_key, s.namenode_host,s.namenode_port
+        SELECT s.stream_id, s.stream_alt_id, s.active, s.message_type, s.file_store_type_id, s.region, s.bucket_name, s.folder_name, s.partition_time_id, s.compression_type_id, s.aws_access_key_id, s.aws_secret_access_key, s.gcp_json_credentials, s.azure_storage_account_name, s.azure_storage_access_key, s.namenode_host,s.namenode_port, s.endpoint_url, s.s3_force_path_style, s.tls_skip_verify, s.tls_ca_cert, s.s3_signature_version, s.auth_mode, s.aws_role_arn, s.secret_ref, s.file_naming, s.table_format, s.file_format_id, s.parquet_row_group_size, s.parquet_page_size, s.parquet_dictionary, s.parquet_sort_columns, s.compaction_enabled, s.compaction_min_files, s.compaction_target_size, s.retention_days, s.retention_dry_run, s.masking_key_ref, s.transformations, s.dedup_key, s.dedup_ttl_seconds, s.sessionization_enabled, s.session_timeout_seconds, s.enrichment_enabled, s.metadata_columns_enabled, s.egress_targets, s.postgres_enabled, s.postgres_url, s.postgres_schema
         FROM streams s
         ORDER BY s.stream_id ASC;
 END;
@@ -187,20 +522,51 @@ CREATE OR REPLACE FUNCTION getAllActiveStreams()
 		azure_storage_account_name VARCHAR,
 		azure_storage_access_key VARCHAR,
         namenode_host VARCHAR,
-        namenode_port INTEGER
-		
+        namenode_port INTEGER,
+        endpoint_url VARCHAR,
+        s3_force_path_style BOOLEAN,
+        tls_skip_verify BOOLEAN,
+        tls_ca_cert VARCHAR,
+        s3_signature_version VARCHAR,
+        auth_mode VARCHAR,
+        aws_role_arn VARCHAR,
+        secret_ref VARCHAR,
+        file_naming VARCHAR,
+        table_format VARCHAR,
+        file_format_id INTEGER,
+        parquet_row_group_size BIGINT,
+        parquet_page_size INTEGER,
+        parquet_dictionary BOOLEAN,
+        parquet_sort_columns VARCHAR,
+        compaction_enabled BOOLEAN,
+        compaction_min_files INTEGER,
+        compaction_target_size BIGINT,
+        retention_days INTEGER,
+        retention_dry_run BOOLEAN,
+        masking_key_ref VARCHAR,
+        transformations VARCHAR,
+        dedup_key VARCHAR,
+        dedup_ttl_seconds INTEGER,
+        sessionization_enabled BOOLEAN,
+        session_timeout_seconds INTEGER,
+        enrichment_enabled BOOLEAN,
+        metadata_columns_enabled BOOLEAN,
+        egress_targets VARCHAR,
+        postgres_enabled BOOLEAN,
+        postgres_url VARCHAR,
+        postgres_schema VARCHAR
     )
 AS $$
 BEGIN
     RETURN QUERY
-        SELECT s.stream_id, s.stream_alt_id, s.active, s.message_type, s.file_store_type_id, s.region, s.bucket_name, s.folder_name, s.partition_time_id, s.compression_type_id, s.aws_access_key_id, s.aws_secret_access_key, s.gcp_json_credentials, s.azure_storage_account_name, s.azure_storage_access_key, s.namenode_host, s.namenode_port
+        SELECT s.stream_id, s.stream_alt_id, s.active, s.message_type, s.file_store_type_id, s.region, s.bucket_name, s.folder_name, s.partition_time_id, s.compression_type_id, s.aws_access_key_id, s.aws_secret_access_key, s.gcp_json_credentials, s.azure_storage_account_name, s.azure_storage_access_key, s.namenode_host, s.namenode_port, s.endpoint_url, s.s3_force_path_style, s.tls_skip_verify, s.tls_ca_cert, s.s3_signature_version, s.auth_mode, s.aws_role_arn, s.secret_ref, s.file_naming, s.table_format, s.file_format_id, s.parquet_row_group_size, s.parquet_page_size, s.parquet_dictionary, s.parquet_sort_columns, s.compaction_enabled, s.compaction_min_files, s.compaction_target_size, s.retention_days, s.retention_dry_run, s.masking_key_ref, s.transformations, s.dedup_key, s.dedup_ttl_seconds, s.sessionization_enabled, s.session_timeout_seconds, s.enrichment_enabled, s.metadata_columns_enabled, s.egress_targets, s.postgres_enabled, s.postgres_url, s.postgres_schema
         FROM streams s
         WHERE s.active = TRUE
         ORDER BY s.stream_id ASC;
 END;
 $$ LANGUAGE plpgsql;
 
-CREATE OR REPLACE FUNCTION createStream(stream_alt_id_arg VARCHAR, active_arg BOOLEAN, message_type_arg VARCHAR, file_store_type_id_arg INTEGER, region_arg VARCHAR, bucket_name_arg VARCHAR, folder_name_arg VARCHAR, partition_time_id_arg INTEGER, compression_type_id_arg INTEGER, aws_access_key_id_arg VARCHAR, aws_secret_access_key_arg VARCHAR, gcp_json_credentials_arg VARCHAR, azure_storage_account_name_arg VARCHAR, azure_storage_access_key_arg VARCHAR, namenode_host_arg VARCHAR, namenode_port_arg INTEGER)
+CREATE OR REPLACE FUNCTION createStream(stream_alt_id_arg VARCHAR, active_arg BOOLEAN, message_type_arg VARCHAR, file_store_type_id_arg INTEGER, region_arg VARCHAR, bucket_name_arg VARCHAR, folder_name_arg VARCHAR, partition_time_id_arg INTEGER, compression_type_id_arg INTEGER, aws_access_key_id_arg VARCHAR, aws_secret_access_key_arg VARCHAR, gcp_json_credentials_arg VARCHAR, azure_storage_account_name_arg VARCHAR, azure_storage_access_key_arg VARCHAR, namenode_host_arg VARCHAR, namenode_port_arg INTEGER, endpoint_url_arg VARCHAR, s3_force_path_style_arg BOOLEAN, tls_skip_verify_arg BOOLEAN, tls_ca_cert_arg VARCHAR, s3_signature_version_arg VARCHAR, auth_mode_arg VARCHAR, aws_role_arn_arg VARCHAR, secret_ref_arg VARCHAR, file_naming_arg VARCHAR, table_format_arg VARCHAR, file_format_id_arg INTEGER, parquet_row_group_size_arg BIGINT, parquet_page_size_arg INTEGER, parquet_dictionary_arg BOOLEAN, parquet_sort_columns_arg VARCHAR, compaction_enabled_arg BOOLEAN, compaction_min_files_arg INTEGER, compaction_target_size_arg BIGINT, retention_days_arg INTEGER, retention_dry_run_arg BOOLEAN, masking_key_ref_arg VARCHAR, transformations_arg VARCHAR, dedup_key_arg VARCHAR, dedup_ttl_seconds_arg INTEGER, sessionization_enabled_arg BOOLEAN, session_timeout_seconds_arg INTEGER, enrichment_enabled_arg BOOLEAN, metadata_columns_enabled_arg BOOLEAN, egress_targets_arg VARCHAR, postgres_enabled_arg BOOLEAN, postgres_url_arg VARCHAR, postgres_schema_arg VARCHAR)
     RETURNS TABLE (
         stream_id uuid,
         stream_alt_id VARCHAR,
@@ -218,20 +584,51 @@ CREATE OR REPLACE FUNCTION createStream(stream_alt_id_arg VARCHAR, active_arg BO
 		azure_storage_account_name VARCHAR,
 		azure_storage_access_key VARCHAR,
         namenode_host VARCHAR,
-        namenode_port INTEGER
-		
+        namenode_port INTEGER,
+        endpoint_url VARCHAR,
+        s3_force_path_style BOOLEAN,
+        tls_skip_verify BOOLEAN,
+        tls_ca_cert VARCHAR,
+        s3_signature_version VARCHAR,
+        auth_mode VARCHAR,
+        aws_role_arn VARCHAR,
+        secret_ref VARCHAR,
+        file_naming VARCHAR,
+        table_format VARCHAR,
+        file_format_id INTEGER,
+        parquet_row_group_size BIGINT,
+        parquet_page_size INTEGER,
+        parquet_dictionary BOOLEAN,
+        parquet_sort_columns VARCHAR,
+        compaction_enabled BOOLEAN,
+        compaction_min_files INTEGER,
+        compaction_target_size BIGINT,
+        retention_days INTEGER,
+        retention_dry_run BOOLEAN,
+        masking_key_ref VARCHAR,
+        transformations VARCHAR,
+        dedup_key VARCHAR,
+        dedup_ttl_seconds INTEGER,
+        sessionization_enabled BOOLEAN,
+        session_timeout_seconds INTEGER,
+        enrichment_enabled BOOLEAN,
+        metadata_columns_enabled BOOLEAN,
+        egress_targets VARCHAR,
+        postgres_enabled BOOLEAN,
+        postgres_url VARCHAR,
+        postgres_schema VARCHAR
     )
 AS $$
 BEGIN
     RETURN QUERY
-        INSERT INTO streams  (stream_alt_id, active, message_type, file_store_type_id, region, bucket_name, folder_name, partition_time_id, compression_type_id, aws_access_key_id, aws_secret_access_key, gcp_json_credentials, azure_storage_account_name, azure_storage_access_key, namenode_host, namenode_port)
+        INSERT INTO streams  (stream_alt_id, active, message_type, file_store_type_id, region, bucket_name, folder_name, partition_time_id, compression_type_id, aws_access_key_id, aws_secret_access_key, gcp_json_credentials, azure_storage_account_name, azure_storage_access_key, namenode_host, namenode_port, endpoint_url, s3_force_path_style, tls_skip_verify, tls_ca_cert, s3_signature_version, auth_mode, aws_role_arn, secret_ref, file_naming, table_format, file_format_id, parquet_row_group_size, parquet_page_size, parquet_dictionary, parquet_sort_columns, compaction_enabled, compaction_min_files, compaction_target_size, retention_days, retention_dry_run, masking_key_ref, transformations, dedup_key, dedup_ttl_seconds, sessionization_enabled, session_timeout_seconds, enrichment_enabled, metadata_columns_enabled, egress_targets, postgres_enabled, postgres_url, postgres_schema)
         VALUES
-            (stream_alt_id_arg, active_arg, message_type_arg, file_store_type_id_arg, region_arg, bucket_name_arg, folder_name_arg, partition_time_id_arg, compression_type_id_arg, aws_access_key_id_arg, aws_secret_access_key_arg, gcp_json_credentials_arg, azure_storage_account_name_arg, azure_storage_access_key_arg, namenode_host_arg, namenode_port_arg)
-        RETURNING streams.stream_id, streams.stream_alt_id, streams.active, streams.message_type, streams.file_store_type_id, streams.region, streams.bucket_name, streams.folder_name, streams.partition_time_id, streams.compression_type_id, streams.aws_access_key_id, streams.aws_secret_access_key, streams.gcp_json_credentials, streams.azure_storage_account_name, streams.azure_storage_access_key, streams.namenode_host, streams.namenode_port;
+            (stream_alt_id_arg, active_arg, message_type_arg, file_store_type_id_arg, region_arg, bucket_name_arg, folder_name_arg, partition_time_id_arg, compression_type_id_arg, aws_access_key_id_arg, aws_secret_access_key_arg, gcp_json_credentials_arg, azure_storage_account_name_arg, azure_storage_access_key_arg, namenode_host_arg, namenode_port_arg, endpoint_url_arg, s3_force_path_style_arg, tls_skip_verify_arg, tls_ca_cert_arg, s3_signature_version_arg, auth_mode_arg, aws_role_arn_arg, secret_ref_arg, file_naming_arg, table_format_arg, file_format_id_arg, parquet_row_group_size_arg, parquet_page_size_arg, parquet_dictionary_arg, parquet_sort_columns_arg, compaction_enabled_arg, compaction_min_files_arg, compaction_target_size_arg, retention_days_arg, retention_dry_run_arg, masking_key_ref_arg, transformations_arg, dedup_key_arg, dedup_ttl_seconds_arg, sessionization_enabled_arg, session_timeout_seconds_arg, enrichment_enabled_arg, metadata_columns_enabled_arg, egress_targets_arg, postgres_enabled_arg, postgres_url_arg, postgres_schema_arg)
+        RETURNING streams.stream_id, streams.stream_alt_id, streams.active, streams.message_type, streams.file_store_type_id, streams.region, streams.bucket_name, streams.folder_name, streams.partition_time_id, streams.compression_type_id, streams.aws_access_key_id, streams.aws_secret_access_key, streams.gcp_json_credentials, streams.azure_storage_account_name, streams.azure_storage_access_key, streams.namenode_host, streams.namenode_port, streams.endpoint_url, streams.s3_force_path_style, streams.tls_skip_verify, streams.tls_ca_cert, streams.s3_signature_version, streams.auth_mode, streams.aws_role_arn, streams.secret_ref, streams.file_naming, streams.table_format, streams.file_format_id, streams.parquet_row_group_size, streams.parquet_page_size, streams.parquet_dictionary, streams.parquet_sort_columns, streams.compaction_enabled, streams.compaction_min_files, streams.compaction_target_size, streams.retention_days, streams.retention_dry_run, streams.masking_key_ref, streams.transformations, streams.dedup_key, streams.dedup_ttl_seconds, streams.sessionization_enabled, streams.session_timeout_seconds, streams.enrichment_enabled, streams.metadata_columns_enabled, streams.egress_targets, streams.postgres_enabled, streams.postgres_url, streams.postgres_schema;
 END;
 $$ LANGUAGE plpgsql;
 
-CREATE OR REPLACE FUNCTION updateStream(stream_id_arg VARCHAR, stream_alt_id_arg VARCHAR, active_arg BOOLEAN, message_type_arg VARCHAR, file_store_type_id_arg INTEGER, region_arg VARCHAR, bucket_name_arg VARCHAR, folder_name_arg VARCHAR, partition_time_id_arg INTEGER, compression_type_id_arg INTEGER, aws_access_key_id_arg VARCHAR, aws_secret_access_key_arg VARCHAR, gcp_json_credentials_arg VARCHAR, azure_storage_account_name_arg VARCHAR, azure_storage_access_key_arg VARCHAR, namenode_host_arg VARCHAR, namenode_port_arg INTEGER)
+CREATE OR REPLACE FUNCTION updateStream(stream_id_arg VARCHAR, stream_alt_id_arg VARCHAR, active_arg BOOLEAN, message_type_arg VARCHAR, file_store_type_id_arg INTEGER, region_arg VARCHAR, bucket_name_arg VARCHAR, folder_name_arg VARCHAR, partition_time_id_arg INTEGER, compression_type_id_arg INTEGER, aws_access_key_id_arg VARCHAR, aws_secret_access_key_arg VARCHAR, gcp_json_credentials_arg VARCHAR, azure_storage_account_name_arg VARCHAR, azure_storage_access_key_arg VARCHAR, namenode_host_arg VARCHAR, namenode_port_arg INTEGER, endpoint_url_arg VARCHAR, s3_force_path_style_arg BOOLEAN, tls_skip_verify_arg BOOLEAN, tls_ca_cert_arg VARCHAR, s3_signature_version_arg VARCHAR, auth_mode_arg VARCHAR, aws_role_arn_arg VARCHAR, secret_ref_arg VARCHAR, file_naming_arg VARCHAR, table_format_arg VARCHAR, file_format_id_arg INTEGER, parquet_row_group_size_arg BIGINT, parquet_page_size_arg INTEGER, parquet_dictionary_arg BOOLEAN, parquet_sort_columns_arg VARCHAR, compaction_enabled_arg BOOLEAN, compaction_min_files_arg INTEGER, compaction_target_size_arg BIGINT, retention_days_arg INTEGER, retention_dry_run_arg BOOLEAN, masking_key_ref_arg VARCHAR, transformations_arg VARCHAR, dedup_key_arg VARCHAR, dedup_ttl_seconds_arg INTEGER, sessionization_enabled_arg BOOLEAN, session_timeout_seconds_arg INTEGER, enrichment_enabled_arg BOOLEAN, metadata_columns_enabled_arg BOOLEAN, egress_targets_arg VARCHAR, postgres_enabled_arg BOOLEAN, postgres_url_arg VARCHAR, postgres_schema_arg VARCHAR)
     RETURNS TABLE (
         stream_id uuid,
         stream_alt_id VARCHAR,
@@ -249,7 +646,39 @@ CREATE OR REPLACE FUNCTION updateStream(stream_id_arg VARCHAR, stream_alt_id_arg
 		azure_storage_account_name VARCHAR,
 		azure_storage_access_key VARCHAR,
         namenode_host VARCHAR,
-        namenode_port INTEGER
+        namenode_port INTEGER,
+        endpoint_url VARCHAR,
+        s3_force_path_style BOOLEAN,
+        tls_skip_verify BOOLEAN,
+        tls_ca_cert VARCHAR,
+        s3_signature_version VARCHAR,
+        auth_mode VARCHAR,
+        aws_role_arn VARCHAR,
+        secret_ref VARCHAR,
+        file_naming VARCHAR,
+        table_format VARCHAR,
+        file_format_id INTEGER,
+        parquet_row_group_size BIGINT,
+        parquet_page_size INTEGER,
+        parquet_dictionary BOOLEAN,
+        parquet_sort_columns VARCHAR,
+        compaction_enabled BOOLEAN,
+        compaction_min_files INTEGER,
+        compaction_target_size BIGINT,
+        retention_days INTEGER,
+        retention_dry_run BOOLEAN,
+        masking_key_ref VARCHAR,
+        transformations VARCHAR,
+        dedup_key VARCHAR,
+        dedup_ttl_seconds INTEGER,
+        sessionization_enabled BOOLEAN,
+        session_timeout_seconds INTEGER,
+        enrichment_enabled BOOLEAN,
+        metadata_columns_enabled BOOLEAN,
+        egress_targets VARCHAR,
+        postgres_enabled BOOLEAN,
+        postgres_url VARCHAR,
+        postgres_schema VARCHAR
     )
 AS $$
 BEGIN
@@ -270,11 +699,41 @@ BEGIN
 			azure_storage_account_name = azure_storage_account_name_arg,
 			azure_storage_access_key = azure_storage_access_key_arg,
             namenode_host = namenode_host_arg,
-            namenode_port = namenode_port_arg
-			
-			
+            namenode_port = namenode_port_arg,
+            endpoint_url = endpoint_url_arg,
+            s3_force_path_style = s3_force_path_style_arg,
+            tls_skip_verify = tls_skip_verify_arg,
+            tls_ca_cert = tls_ca_cert_arg,
+            s3_signature_version = s3_signature_version_arg,
+            auth_mode = auth_mode_arg,
+            aws_role_arn = aws_role_arn_arg,
+            secret_ref = secret_ref_arg,
+            file_naming = file_naming_arg,
+            table_format = table_format_arg,
+            file_format_id = file_format_id_arg,
+            parquet_row_group_size = parquet_row_group_size_arg,
+            parquet_page_size = parquet_page_size_arg,
+            parquet_dictionary = parquet_dictionary_arg,
+            parquet_sort_columns = parquet_sort_columns_arg,
+            compaction_enabled = compaction_enabled_arg,
+            compaction_min_files = compaction_min_files_arg,
+            compaction_target_size = compaction_target_size_arg,
+            retention_days = retention_days_arg,
+            retention_dry_run = retention_dry_run_arg,
+            masking_key_ref = masking_key_ref_arg,
+            transformations = transformations_arg,
+            dedup_key = dedup_key_arg,
+            dedup_ttl_seconds = dedup_ttl_seconds_arg,
+            sessionization_enabled = sessionization_enabled_arg,
+            session_timeout_seconds = session_timeout_seconds_arg,
+            enrichment_enabled = enrichment_enabled_arg,
+            metadata_columns_enabled = metadata_columns_enabled_arg,
+            egress_targets = egress_targets_arg,
+            postgres_enabled = postgres_enabled_arg,
+            postgres_url = postgres_url_arg,
+            postgres_schema = postgres_schema_arg
         WHERE streams.stream_id = (stream_id_arg)::uuid
-        RETURNING streams.stream_id, streams.stream_alt_id, streams.active, streams.message_type, streams.file_store_type_id, streams.region, streams.bucket_name, streams.folder_name, streams.partition_time_id, streams.compression_type_id, streams.aws_access_key_id, streams.aws_secret_access_key, streams.gcp_json_credentials, streams.azure_storage_account_name, streams.azure_storage_access_key, streams.namenode_host, streams.namenode_port;
+        RETURNING streams.stream_id, streams.stream_alt_id, streams.active, streams.message_type, streams.file_store_type_id, streams.region, streams.bucket_name, streams.folder_name, streams.partition_time_id, streams.compression_type_id, streams.aws_access_key_id, streams.aws_secret_access_key, streams.gcp_json_credentials, streams.azure_storage_account_name, streams.azure_storage_access_key, streams.namenode_host, streams.namenode_port, streams.endpoint_url, streams.s3_force_path_style, streams.tls_skip_verify, streams.tls_ca_cert, streams.s3_signature_version, streams.auth_mode, streams.aws_role_arn, streams.secret_ref, streams.file_naming, streams.table_format, streams.file_format_id, streams.parquet_row_group_size, streams.parquet_page_size, streams.parquet_dictionary, streams.parquet_sort_columns, streams.compaction_enabled, streams.compaction_min_files, streams.compaction_target_size, streams.retention_days, streams.retention_dry_run, streams.masking_key_ref, streams.transformations, streams.dedup_key, streams.dedup_ttl_seconds, streams.sessionization_enabled, streams.session_timeout_seconds, streams.enrichment_enabled, streams.metadata_columns_enabled, streams.egress_targets, streams.postgres_enabled, streams.postgres_url, streams.postgres_schema;
 END;
 $$ LANGUAGE plpgsql;
 
@@ -296,15 +755,46 @@ CREATE OR REPLACE FUNCTION deleteStream(stream_id_arg VARCHAR)
 		azure_storage_account_name VARCHAR,
 		azure_storage_access_key VARCHAR,
         namenode_host VARCHAR,
-        namenode_port INTEGER
-
+        namenode_port INTEGER,
+        endpoint_url VARCHAR,
+        s3_force_path_style BOOLEAN,
+        tls_skip_verify BOOLEAN,
+        tls_ca_cert VARCHAR,
+        s3_signature_version VARCHAR,
+        auth_mode VARCHAR,
+        aws_role_arn VARCHAR,
+        secret_ref VARCHAR,
+        file_naming VARCHAR,
+        table_format VARCHAR,
+        file_format_id INTEGER,
+        parquet_row_group_size BIGINT,
+        parquet_page_size INTEGER,
+        parquet_dictionary BOOLEAN,
+        parquet_sort_columns VARCHAR,
+        compaction_enabled BOOLEAN,
+        compaction_min_files INTEGER,
+        compaction_target_size BIGINT,
+        retention_days INTEGER,
+        retention_dry_run BOOLEAN,
+        masking_key_ref VARCHAR,
+        transformations VARCHAR,
+        dedup_key VARCHAR,
+        dedup_ttl_seconds INTEGER,
+        sessionization_enabled BOOLEAN,
+        session_timeout_seconds INTEGER,
+        enrichment_enabled BOOLEAN,
+        metadata_columns_enabled BOOLEAN,
+        egress_targets VARCHAR,
+        postgres_enabled BOOLEAN,
+        postgres_url VARCHAR,
+        postgres_schema VARCHAR
     )
 AS $$
 BEGIN
     RETURN QUERY
         DELETE FROM streams
         WHERE streams.stream_id = (stream_id_arg)::uuid
-        RETURNING streams.stream_id, streams.stream_alt_id, streams.active, streams.message_type, streams.file_store_type_id, streams.region, streams.bucket_name, streams.folder_name, streams.partition_time_id, streams.compression_type_id, streams.aws_access_key_id, streams.aws_secret_access_key, streams.gcp_json_credentials, streams.azure_storage_account_name, streams.azure_storage_access_key, streams.namenode_host, streams.namenode_port;
+        RETURNING streams.stream_id, streams.stream_alt_id, streams.active, streams.message_type, streams.file_store_type_id, streams.region, streams.bucket_name, streams.folder_name, streams.partition_time_id, streams.compression_type_id, streams.aws_access_key_id, streams.aws_secret_access_key, streams.gcp_json_credentials, streams.azure_storage_account_name, streams.azure_storage_access_key, streams.namenode_host, streams.namenode_port, streams.endpoint_url, streams.s3_force_path_style, streams.tls_skip_verify, streams.tls_ca_cert, streams.s3_signature_version, streams.auth_mode, streams.aws_role_arn, streams.secret_ref, streams.file_naming, streams.table_format, streams.file_format_id, streams.parquet_row_group_size, streams.parquet_page_size, streams.parquet_dictionary, streams.parquet_sort_columns, streams.compaction_enabled, streams.compaction_min_files, streams.compaction_target_size, streams.retention_days, streams.retention_dry_run, streams.masking_key_ref, streams.transformations, streams.dedup_key, streams.dedup_ttl_seconds, streams.sessionization_enabled, streams.session_timeout_seconds, streams.enrichment_enabled, streams.metadata_columns_enabled, streams.egress_targets, streams.postgres_enabled, streams.postgres_url, streams.postgres_schema;
 END;
 $$ LANGUAGE plpgsql;
 
@@ -326,8 +816,39 @@ CREATE OR REPLACE FUNCTION activateStream(stream_id_arg VARCHAR)
 		azure_storage_account_name VARCHAR,
 		azure_storage_access_key VARCHAR,
         namenode_host VARCHAR,
-        namenode_port INTEGER
-		
+        namenode_port INTEGER,
+        endpoint_url VARCHAR,
+        s3_force_path_style BOOLEAN,
+        tls_skip_verify BOOLEAN,
+        tls_ca_cert VARCHAR,
+        s3_signature_version VARCHAR,
+        auth_mode VARCHAR,
+        aws_role_arn VARCHAR,
+        secret_ref VARCHAR,
+        file_naming VARCHAR,
+        table_format VARCHAR,
+        file_format_id INTEGER,
+        parquet_row_group_size BIGINT,
+        parquet_page_size INTEGER,
+        parquet_dictionary BOOLEAN,
+        parquet_sort_columns VARCHAR,
+        compaction_enabled BOOLEAN,
+        compaction_min_files INTEGER,
+        compaction_target_size BIGINT,
+        retention_days INTEGER,
+        retention_dry_run BOOLEAN,
+        masking_key_ref VARCHAR,
+        transformations VARCHAR,
+        dedup_key VARCHAR,
+        dedup_ttl_seconds INTEGER,
+        sessionization_enabled BOOLEAN,
+        session_timeout_seconds INTEGER,
+        enrichment_enabled BOOLEAN,
+        metadata_columns_enabled BOOLEAN,
+        egress_targets VARCHAR,
+        postgres_enabled BOOLEAN,
+        postgres_url VARCHAR,
+        postgres_schema VARCHAR
     )
 AS $$
 BEGIN
@@ -335,7 +856,7 @@ BEGIN
         UPDATE streams
         SET active = TRUE
         WHERE streams.stream_id = (stream_id_arg)::uuid
-        RETURNING streams.stream_id, streams.stream_alt_id, streams.active, streams.message_type, streams.file_store_type_id, streams.region, streams.bucket_name, streams.folder_name, streams.partition_time_id, streams.compression_type_id, streams.aws_access_key_id, streams.aws_secret_access_key, streams.gcp_json_credentials, streams.azure_storage_account_name, streams.azure_storage_access_key, streams.namenode_host, streams.namenode_port;
+        RETURNING streams.stream_id, streams.stream_alt_id, streams.active, streams.message_type, streams.file_store_type_id, streams.region, streams.bucket_name, streams.folder_name, streams.partition_time_id, streams.compression_type_id, streams.aws_access_key_id, streams.aws_secret_access_key, streams.gcp_json_credentials, streams.azure_storage_account_name, streams.azure_storage_access_key, streams.namenode_host, streams.namenode_port, streams.endpoint_url, streams.s3_force_path_style, streams.tls_skip_verify, streams.tls_ca_cert, streams.s3_signature_version, streams.auth_mode, streams.aws_role_arn, streams.secret_ref, streams.file_naming, streams.table_format, streams.file_format_id, streams.parquet_row_group_size, streams.parquet_page_size, streams.parquet_dictionary, streams.parquet_sort_columns, streams.compaction_enabled, streams.compaction_min_files, streams.compaction_target_size, streams.retention_days, streams.retention_dry_run, streams.masking_key_ref, streams.transformations, streams.dedup_key, streams.dedup_ttl_seconds, streams.sessionization_enabled, streams.session_timeout_seconds, streams.enrichment_enabled, streams.metadata_columns_enabled, streams.egress_targets, streams.postgres_enabled, streams.postgres_url, streams.postgres_schema;
 END;
 $$ LANGUAGE plpgsql;
 
@@ -357,7 +878,39 @@ CREATE OR REPLACE FUNCTION deactivateStream(stream_id_arg VARCHAR)
 		azure_storage_account_name VARCHAR,
 		azure_storage_access_key VARCHAR,
         namenode_host VARCHAR,
-        namenode_port INTEGER
+        namenode_port INTEGER,
+        endpoint_url VARCHAR,
+        s3_force_path_style BOOLEAN,
+        tls_skip_verify BOOLEAN,
+        tls_ca_cert VARCHAR,
+        s3_signature_version VARCHAR,
+        auth_mode VARCHAR,
+        aws_role_arn VARCHAR,
+        secret_ref VARCHAR,
+        file_naming VARCHAR,
+        table_format VARCHAR,
+        file_format_id INTEGER,
+        parquet_row_group_size BIGINT,
+        parquet_page_size INTEGER,
+        parquet_dictionary BOOLEAN,
+        parquet_sort_columns VARCHAR,
+        compaction_enabled BOOLEAN,
+        compaction_min_files INTEGER,
+        compaction_target_size BIGINT,
+        retention_days INTEGER,
+        retention_dry_run BOOLEAN,
+        masking_key_ref VARCHAR,
+        transformations VARCHAR,
+        dedup_key VARCHAR,
+        dedup_ttl_seconds INTEGER,
+        sessionization_enabled BOOLEAN,
+        session_timeout_seconds INTEGER,
+        enrichment_enabled BOOLEAN,
+        metadata_columns_enabled BOOLEAN,
+        egress_targets VARCHAR,
+        postgres_enabled BOOLEAN,
+        postgres_url VARCHAR,
+        postgres_schema VARCHAR
     )
 AS $$
 BEGIN
@@ -365,7 +918,7 @@ BEGIN
         UPDATE streams
         SET active = FALSE
         WHERE streams.stream_id = (stream_id_arg)::uuid
-        RETURNING streams.stream_id, streams.stream_alt_id, streams.active, streams.message_type, streams.file_store_type_id, streams.region, streams.bucket_name, streams.folder_name, streams.partition_time_id, streams.compression_type_id, streams.aws_access_key_id, streams.aws_secret_access_key, streams.gcp_json_credentials, streams.azure_storage_account_name, streams.azure_storage_access_key, streams.namenode_host, streams.namenode_port;
+        RETURNING streams.stream_id, streams.stream_alt_id, streams.active, streams.message_type, streams.file_store_type_id, streams.region, streams.bucket_name, streams.folder_name, streams.partition_time_id, streams.compression_type_id, streams.aws_access_key_id, streams.aws_secret_access_key, streams.gcp_json_credentials, streams.azure_storage_account_name, streams.azure_storage_access_key, streams.namenode_host, streams.namenode_port, streams.endpoint_url, streams.s3_force_path_style, streams.tls_skip_verify, streams.tls_ca_cert, streams.s3_signature_version, streams.auth_mode, streams.aws_role_arn, streams.secret_ref, streams.file_naming, streams.table_format, streams.file_format_id, streams.parquet_row_group_size, streams.parquet_page_size, streams.parquet_dictionary, streams.parquet_sort_columns, streams.compaction_enabled, streams.compaction_min_files, streams.compaction_target_size, streams.retention_days, streams.retention_dry_run, streams.masking_key_ref, streams.transformations, streams.dedup_key, streams.dedup_ttl_seconds, streams.sessionization_enabled, streams.session_timeout_seconds, streams.enrichment_enabled, streams.metadata_columns_enabled, streams.egress_targets, streams.postgres_enabled, streams.postgres_url, streams.postgres_schema;
 END;
 $$ LANGUAGE plpgsql;
 
@@ -410,6 +963,483 @@ BEGIN
         ORDER BY ct.compression_type_id ASC;
 END;
 $$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION getAllFileFormats()
+    RETURNS TABLE (
+        file_format_id INTEGER,
+        file_format_name VARCHAR
+    )
+AS $$
+BEGIN
+    RETURN QUERY
+        SELECT ff.file_format_id, ff.file_format_name
+        FROM file_formats ff
+        ORDER BY ff.file_format_id ASC;
+END;
+$$ LANGUAGE plpgsql;
+CREATE OR REPLACE FUNCTION getCompactionRuns(stream_id_arg VARCHAR)
+    RETURNS TABLE (
+        compaction_run_id INTEGER,
+        stream_id uuid,
+        partition_path VARCHAR,
+        trigger_type VARCHAR,
+        status VARCHAR,
+        files_before INTEGER,
+        files_after INTEGER,
+        bytes_before BIGINT,
+        bytes_after BIGINT,
+        error_message VARCHAR,
+        started_at TIMESTAMPTZ,
+        finished_at TIMESTAMPTZ
+    )
+AS $$
+BEGIN
+    RETURN QUERY
+        SELECT cr.compaction_run_id, cr.stream_id, cr.partition_path, cr.trigger_type, cr.status, cr.files_before, cr.files_after, cr.bytes_before, cr.bytes_after, cr.error_message, cr.started_at, cr.finished_at
+        FROM compaction_runs cr
+        WHERE cr.stream_id = (stream_id_arg)::uuid
+        ORDER BY cr.compaction_run_id DESC;
+END;
+$$ LANGUAGE plpgsql;
+CREATE OR REPLACE FUNCTION getRetentionRuns(stream_id_arg VARCHAR)
+    RETURNS TABLE (
+        retention_run_id INTEGER,
+        stream_id uuid,
+        partition_path VARCHAR,
+        partition_end TIMESTAMPTZ,
+        dry_run BOOLEAN,
+        status VARCHAR,
+        files INTEGER,
+        bytes BIGINT,
+        error_message VARCHAR,
+        executed_at TIMESTAMPTZ
+    )
+AS $$
+BEGIN
+    RETURN QUERY
+        SELECT rr.retention_run_id, rr.stream_id, rr.partition_path, rr.partition_end, rr.dry_run, rr.status, rr.files, rr.bytes, rr.error_message, rr.executed_at
+        FROM retention_runs rr
+        WHERE rr.stream_id = (stream_id_arg)::uuid
+        ORDER BY rr.retention_run_id DESC;
+END;
+$$ LANGUAGE plpgsql;
+CREATE OR REPLACE FUNCTION createErasureJob(stream_id_arg VARCHAR, subject_field_arg VARCHAR, subject_count_arg INTEGER)
+    RETURNS TABLE (
+        erasure_job_id INTEGER,
+        stream_id uuid,
+        subject_field VARCHAR,
+        subject_count INTEGER,
+        status VARCHAR,
+        partitions_total INTEGER,
+        partitions_done INTEGER,
+        partitions_failed INTEGER,
+        files_scanned INTEGER,
+        files_rewritten INTEGER,
+        files_deleted INTEGER,
+        rows_deleted BIGINT,
+        error_message VARCHAR,
+        requested_at TIMESTAMPTZ,
+        started_at TIMESTAMPTZ,
+        finished_at TIMESTAMPTZ
+    )
+AS $$
+BEGIN
+    RETURN QUERY
+        INSERT INTO erasure_jobs (stream_id, subject_field, subject_count)
+        VALUES
+            ((stream_id_arg)::uuid, subject_field_arg, subject_count_arg)
+        RETURNING erasure_jobs.erasure_job_id, erasure_jobs.stream_id, erasure_jobs.subject_field, erasure_jobs.subject_count, erasure_jobs.status, erasure_jobs.partitions_total, erasure_jobs.partitions_done, erasure_jobs.partitions_failed, erasure_jobs.files_scanned, erasure_jobs.files_rewritten, erasure_jobs.files_deleted, erasure_jobs.rows_deleted, erasure_jobs.error_message, erasure_jobs.requested_at, erasure_jobs.started_at, erasure_jobs.finished_at;
+END;
+$$ LANGUAGE plpgsql;
+CREATE OR REPLACE FUNCTION failErasureJob(erasure_job_id_arg INTEGER, error_message_arg VARCHAR)
+    RETURNS TABLE (
+        erasure_job_id INTEGER,
+        stream_id uuid,
+        subject_field VARCHAR,
+        subject_count INTEGER,
+        status VARCHAR,
+        partitions_total INTEGER,
+        partitions_done INTEGER,
+        partitions_failed INTEGER,
+        files_scanned INTEGER,
+        files_rewritten INTEGER,
+        files_deleted INTEGER,
+        rows_deleted BIGINT,
+        error_message VARCHAR,
+        requested_at TIMESTAMPTZ,
+        started_at TIMESTAMPTZ,
+        finished_at TIMESTAMPTZ
+    )
+AS $$
+BEGIN
+    RETURN QUERY
+        UPDATE erasure_jobs
+        SET
+            status = 'failed',
+            error_message = error_message_arg,
+            finished_at = NOW()
+        WHERE erasure_jobs.erasure_job_id = erasure_job_id_arg
+        RETURNING erasure_jobs.erasure_job_id, erasure_jobs.stream_id, erasure_jobs.subject_field, erasure_jobs.subject_count, erasure_jobs.status, erasure_jobs.partitions_total, erasure_jobs.partitions_done, erasure_jobs.partitions_failed, erasure_jobs.files_scanned, erasure_jobs.files_rewritten, erasure_jobs.files_deleted, erasure_jobs.rows_deleted, erasure_jobs.error_message, erasure_jobs.requested_at, erasure_jobs.started_at, erasure_jobs.finished_at;
+END;
+$$ LANGUAGE plpgsql;
+CREATE OR REPLACE FUNCTION getErasureJobs(stream_id_arg VARCHAR)
+    RETURNS TABLE (
+        erasure_job_id INTEGER,
+        stream_id uuid,
+        subject_field VARCHAR,
+        subject_count INTEGER,
+        status VARCHAR,
+        partitions_total INTEGER,
+        partitions_done INTEGER,
+        partitions_failed INTEGER,
+        files_scanned INTEGER,
+        files_rewritten INTEGER,
+        files_deleted INTEGER,
+        rows_deleted BIGINT,
+        error_message VARCHAR,
+        requested_at TIMESTAMPTZ,
+        started_at TIMESTAMPTZ,
+        finished_at TIMESTAMPTZ
+    )
+AS $$
+BEGIN
+    RETURN QUERY
+        SELECT ej.erasure_job_id, ej.stream_id, ej.subject_field, ej.subject_count, ej.status, ej.partitions_total, ej.partitions_done, ej.partitions_failed, ej.files_scanned, ej.files_rewritten, ej.files_deleted, ej.rows_deleted, ej.error_message, ej.requested_at, ej.started_at, ej.finished_at
+        FROM erasure_jobs ej
+        WHERE ej.stream_id = (stream_id_arg)::uuid
+        ORDER BY ej.erasure_job_id DESC;
+END;
+$$ LANGUAGE plpgsql;
+CREATE OR REPLACE FUNCTION getErasureJobPartitions(erasure_job_id_arg INTEGER)
+    RETURNS TABLE (
+        erasure_job_partition_id INTEGER,
+        erasure_job_id INTEGER,
+        partition_path VARCHAR,
+        status VARCHAR,
+        files_scanned INTEGER,
+        files_rewritten INTEGER,
+        files_deleted INTEGER,
+        rows_deleted BIGINT,
+        error_message VARCHAR,
+        finished_at TIMESTAMPTZ
+    )
+AS $$
+BEGIN
+    RETURN QUERY
+        SELECT ejp.erasure_job_partition_id, ejp.erasure_job_id, ejp.partition_path, ejp.status, ejp.files_scanned, ejp.files_rewritten, ejp.files_deleted, ejp.rows_deleted, ejp.error_message, ejp.finished_at
+        FROM erasure_job_partitions ejp
+        WHERE ejp.erasure_job_id = erasure_job_id_arg
+        ORDER BY ejp.partition_path ASC;
+END;
+$$ LANGUAGE plpgsql;
+CREATE OR REPLACE FUNCTION getMaskingRules(stream_id_arg VARCHAR)
+    RETURNS TABLE (
+        masking_rule_id INTEGER,
+        stream_id uuid,
+        json_path VARCHAR,
+        masking_action VARCHAR,
+        pattern VARCHAR,
+        replacement VARCHAR,
+        rule_order INTEGER,
+        created_at TIMESTAMPTZ
+    )
+AS $$
+BEGIN
+    RETURN QUERY
+        SELECT mr.masking_rule_id, mr.stream_id, mr.json_path, mr.masking_action, mr.pattern, mr.replacement, mr.rule_order, mr.created_at
+        FROM masking_rules mr
+        WHERE mr.stream_id = (stream_id_arg)::uuid
+        ORDER BY mr.rule_order ASC, mr.masking_rule_id ASC;
+END;
+$$ LANGUAGE plpgsql;
+CREATE OR REPLACE FUNCTION createMaskingRule(stream_id_arg VARCHAR, json_path_arg VARCHAR, masking_action_arg VARCHAR, pattern_arg VARCHAR, replacement_arg VARCHAR, rule_order_arg INTEGER)
+    RETURNS TABLE (
+        masking_rule_id INTEGER,
+        stream_id uuid,
+        json_path VARCHAR,
+        masking_action VARCHAR,
+        pattern VARCHAR,
+        replacement VARCHAR,
+        rule_order INTEGER,
+        created_at TIMESTAMPTZ
+    )
+AS $$
+BEGIN
+    RETURN QUERY
+        INSERT INTO masking_rules (stream_id, json_path, masking_action, pattern, replacement, rule_order)
+        VALUES
+            ((stream_id_arg)::uuid, json_path_arg, masking_action_arg, pattern_arg, replacement_arg, rule_order_arg)
+        RETURNING masking_rules.masking_rule_id, masking_rules.stream_id, masking_rules.json_path, masking_rules.masking_action, masking_rules.pattern, masking_rules.replacement, masking_rules.rule_order, masking_rules.created_at;
+END;
+$$ LANGUAGE plpgsql;
+CREATE OR REPLACE FUNCTION deleteMaskingRule(masking_rule_id_arg INTEGER)
+    RETURNS TABLE (
+        masking_rule_id INTEGER,
+        stream_id uuid,
+        json_path VARCHAR,
+        masking_action VARCHAR,
+        pattern VARCHAR,
+        replacement VARCHAR,
+        rule_order INTEGER,
+        created_at TIMESTAMPTZ
+    )
+AS $$
+BEGIN
+    RETURN QUERY
+        DELETE FROM masking_rules
+        WHERE masking_rules.masking_rule_id = masking_rule_id_arg
+        RETURNING masking_rules.masking_rule_id, masking_rules.stream_id, masking_rules.json_path, masking_rules.masking_action, masking_rules.pattern, masking_rules.replacement, masking_rules.rule_order, masking_rules.created_at;
+END;
+$$ LANGUAGE plpgsql;
+CREATE OR REPLACE FUNCTION getFilterRules(stream_id_arg VARCHAR)
+    RETURNS TABLE (
+        filter_rule_id INTEGER,
+        stream_id uuid,
+        message_type VARCHAR,
+        json_path VARCHAR,
+        filter_operator VARCHAR,
+        filter_value VARCHAR,
+        filter_action VARCHAR,
+        sample_rate DOUBLE PRECISION,
+        sample_key VARCHAR,
+        rule_order INTEGER,
+        created_at TIMESTAMPTZ
+    )
+AS $$
+BEGIN
+    RETURN QUERY
+        SELECT fr.filter_rule_id, fr.stream_id, fr.message_type, fr.json_path, fr.filter_operator, fr.filter_value, fr.filter_action, fr.sample_rate, fr.sample_key, fr.rule_order, fr.created_at
+        FROM filter_rules fr
+        WHERE fr.stream_id = (stream_id_arg)::uuid
+        ORDER BY fr.rule_order ASC, fr.filter_rule_id ASC;
+END;
+$$ LANGUAGE plpgsql;
+CREATE OR REPLACE FUNCTION createFilterRule(stream_id_arg VARCHAR, message_type_arg VARCHAR, json_path_arg VARCHAR, filter_operator_arg VARCHAR, filter_value_arg VARCHAR, filter_action_arg VARCHAR, sample_rate_arg DOUBLE PRECISION, sample_key_arg VARCHAR, rule_order_arg INTEGER)
+    RETURNS TABLE (
+        filter_rule_id INTEGER,
+        stream_id uuid,
+        message_type VARCHAR,
+        json_path VARCHAR,
+        filter_operator VARCHAR,
+        filter_value VARCHAR,
+        filter_action VARCHAR,
+        sample_rate DOUBLE PRECISION,
+        sample_key VARCHAR,
+        rule_order INTEGER,
+        created_at TIMESTAMPTZ
+    )
+AS $$
+BEGIN
+    RETURN QUERY
+        INSERT INTO filter_rules (stream_id, message_type, json_path, filter_operator, filter_value, filter_action, sample_rate, sample_key, rule_order)
+        VALUES
+            ((stream_id_arg)::uuid, message_type_arg, json_path_arg, filter_operator_arg, filter_value_arg, filter_action_arg, sample_rate_arg, sample_key_arg, rule_order_arg)
+        RETURNING filter_rules.filter_rule_id, filter_rules.stream_id, filter_rules.message_type, filter_rules.json_path, filter_rules.filter_operator, filter_rules.filter_value, filter_rules.filter_action, filter_rules.sample_rate, filter_rules.sample_key, filter_rules.rule_order, filter_rules.created_at;
+END;
+$$ LANGUAGE plpgsql;
+CREATE OR REPLACE FUNCTION deleteFilterRule(filter_rule_id_arg INTEGER)
+    RETURNS TABLE (
+        filter_rule_id INTEGER,
+        stream_id uuid,
+        message_type VARCHAR,
+        json_path VARCHAR,
+        filter_operator VARCHAR,
+        filter_value VARCHAR,
+        filter_action VARCHAR,
+        sample_rate DOUBLE PRECISION,
+        sample_key VARCHAR,
+        rule_order INTEGER,
+        created_at TIMESTAMPTZ
+    )
+AS $$
+BEGIN
+    RETURN QUERY
+        DELETE FROM filter_rules
+        WHERE filter_rules.filter_rule_id = filter_rule_id_arg
+        RETURNING filter_rules.filter_rule_id, filter_rules.stream_id, filter_rules.message_type, filter_rules.json_path, filter_rules.filter_operator, filter_rules.filter_value, filter_rules.filter_action, filter_rules.sample_rate, filter_rules.sample_key, filter_rules.rule_order, filter_rules.created_at;
+END;
+$$ LANGUAGE plpgsql;
+CREATE OR REPLACE FUNCTION getFilterStats(stream_id_arg VARCHAR)
+    RETURNS TABLE (
+        filter_rule_id INTEGER,
+        stream_id uuid,
+        message_type VARCHAR,
+        events_matched BIGINT,
+        events_dropped BIGINT,
+        events_sampled_out BIGINT,
+        updated_at TIMESTAMPTZ
+    )
+AS $$
+BEGIN
+    RETURN QUERY
+        SELECT fs.filter_rule_id, fs.stream_id, fs.message_type, fs.events_matched, fs.events_dropped, fs.events_sampled_out, fs.updated_at
+        FROM filter_stats fs
+        WHERE fs.stream_id = (stream_id_arg)::uuid
+        ORDER BY fs.filter_rule_id ASC, fs.message_type ASC;
+END;
+$$ LANGUAGE plpgsql;
+CREATE OR REPLACE FUNCTION getDedupStats(stream_id_arg VARCHAR)
+    RETURNS TABLE (
+        stream_id uuid,
+        duplicates_dropped BIGINT,
+        updated_at TIMESTAMPTZ
+    )
+AS $$
+BEGIN
+    RETURN QUERY
+        SELECT ds.stream_id, ds.duplicates_dropped, ds.updated_at
+        FROM dedup_stats ds
+        WHERE ds.stream_id = (stream_id_arg)::uuid;
+END;
+$$ LANGUAGE plpgsql;
+CREATE OR REPLACE FUNCTION getRollupRules(stream_id_arg VARCHAR)
+    RETURNS TABLE (
+        rollup_rule_id INTEGER,
+        stream_id uuid,
+        rollup_name VARCHAR,
+        message_type VARCHAR,
+        group_by VARCHAR,
+        aggregations VARCHAR,
+        window_seconds INTEGER,
+        created_at TIMESTAMPTZ
+    )
+AS $$
+BEGIN
+    RETURN QUERY
+        SELECT rr.rollup_rule_id, rr.stream_id, rr.rollup_name, rr.message_type, rr.group_by, rr.aggregations, rr.window_seconds, rr.created_at
+        FROM rollup_rules rr
+        WHERE rr.stream_id = (stream_id_arg)::uuid
+        ORDER BY rr.rollup_rule_id ASC;
+END;
+$$ LANGUAGE plpgsql;
+CREATE OR REPLACE FUNCTION createRollupRule(stream_id_arg VARCHAR, rollup_name_arg VARCHAR, message_type_arg VARCHAR, group_by_arg VARCHAR, aggregations_arg VARCHAR, window_seconds_arg INTEGER)
+    RETURNS TABLE (
+        rollup_rule_id INTEGER,
+        stream_id uuid,
+        rollup_name VARCHAR,
+        message_type VARCHAR,
+        group_by VARCHAR,
+        aggregations VARCHAR,
+        window_seconds INTEGER,
+        created_at TIMESTAMPTZ
+    )
+AS $$
+BEGIN
+    RETURN QUERY
+        INSERT INTO rollup_rules (stream_id, rollup_name, message_type, group_by, aggregations, window_seconds)
+        VALUES
+            ((stream_id_arg)::uuid, rollup_name_arg, message_type_arg, group_by_arg, aggregations_arg, window_seconds_arg)
+        RETURNING rollup_rules.rollup_rule_id, rollup_rules.stream_id, rollup_rules.rollup_name, rollup_rules.message_type, rollup_rules.group_by, rollup_rules.aggregations, rollup_rules.window_seconds, rollup_rules.created_at;
+END;
+$$ LANGUAGE plpgsql;
+CREATE OR REPLACE FUNCTION deleteRollupRule(rollup_rule_id_arg INTEGER)
+    RETURNS TABLE (
+        rollup_rule_id INTEGER,
+        stream_id uuid,
+        rollup_name VARCHAR,
+        message_type VARCHAR,
+        group_by VARCHAR,
+        aggregations VARCHAR,
+        window_seconds INTEGER,
+        created_at TIMESTAMPTZ
+    )
+AS $$
+BEGIN
+    RETURN QUERY
+        DELETE FROM rollup_rules
+        WHERE rollup_rules.rollup_rule_id = rollup_rule_id_arg
+        RETURNING rollup_rules.rollup_rule_id, rollup_rules.stream_id, rollup_rules.rollup_name, rollup_rules.message_type, rollup_rules.group_by, rollup_rules.aggregations, rollup_rules.window_seconds, rollup_rules.created_at;
+END;
+$$ LANGUAGE plpgsql;
+CREATE OR REPLACE FUNCTION getWebhooks(stream_id_arg VARCHAR)
+    RETURNS TABLE (
+        webhook_id INTEGER,
+        stream_id uuid,
+        message_type VARCHAR,
+        content VARCHAR,
+        url VARCHAR,
+        signing_secret_ref VARCHAR,
+        batch_size INTEGER,
+        batch_interval_ms INTEGER,
+        max_attempts INTEGER,
+        max_concurrency INTEGER,
+        created_at TIMESTAMPTZ
+    )
+AS $$
+BEGIN
+    RETURN QUERY
+        SELECT wh.webhook_id, wh.stream_id, wh.message_type, wh.content, wh.url, wh.signing_secret_ref, wh.batch_size, wh.batch_interval_ms, wh.max_attempts, wh.max_concurrency, wh.created_at
+        FROM webhooks wh
+        WHERE wh.stream_id = (stream_id_arg)::uuid
+        ORDER BY wh.webhook_id ASC;
+END;
+$$ LANGUAGE plpgsql;
+CREATE OR REPLACE FUNCTION createWebhook(stream_id_arg VARCHAR, message_type_arg VARCHAR, content_arg VARCHAR, url_arg VARCHAR, signing_secret_ref_arg VARCHAR, batch_size_arg INTEGER, batch_interval_ms_arg INTEGER, max_attempts_arg INTEGER, max_concurrency_arg INTEGER)
+    RETURNS TABLE (
+        webhook_id INTEGER,
+        stream_id uuid,
+        message_type VARCHAR,
+        content VARCHAR,
+        url VARCHAR,
+        signing_secret_ref VARCHAR,
+        batch_size INTEGER,
+        batch_interval_ms INTEGER,
+        max_attempts INTEGER,
+        max_concurrency INTEGER,
+        created_at TIMESTAMPTZ
+    )
+AS $$
+BEGIN
+    RETURN QUERY
+        INSERT INTO webhooks (stream_id, message_type, content, url, signing_secret_ref, batch_size, batch_interval_ms, max_attempts, max_concurrency)
+        VALUES
+            ((stream_id_arg)::uuid, message_type_arg, content_arg, url_arg, signing_secret_ref_arg, batch_size_arg, batch_interval_ms_arg, max_attempts_arg, max_concurrency_arg)
+        RETURNING webhooks.webhook_id, webhooks.stream_id, webhooks.message_type, webhooks.content, webhooks.url, webhooks.signing_secret_ref, webhooks.batch_size, webhooks.batch_interval_ms, webhooks.max_attempts, webhooks.max_concurrency, webhooks.created_at;
+END;
+$$ LANGUAGE plpgsql;
+CREATE OR REPLACE FUNCTION deleteWebhook(webhook_id_arg INTEGER)
+    RETURNS TABLE (
+        webhook_id INTEGER,
+        stream_id uuid,
+        message_type VARCHAR,
+        content VARCHAR,
+        url VARCHAR,
+        signing_secret_ref VARCHAR,
+        batch_size INTEGER,
+        batch_interval_ms INTEGER,
+        max_attempts INTEGER,
+        max_concurrency INTEGER,
+        created_at TIMESTAMPTZ
+    )
+AS $$
+BEGIN
+    RETURN QUERY
+        DELETE FROM webhooks
+        WHERE webhooks.webhook_id = webhook_id_arg
+        RETURNING webhooks.webhook_id, webhooks.stream_id, webhooks.message_type, webhooks.content, webhooks.url, webhooks.signing_secret_ref, webhooks.batch_size, webhooks.batch_interval_ms, webhooks.max_attempts, webhooks.max_concurrency, webhooks.created_at;
+END;
+$$ LANGUAGE plpgsql;
+CREATE OR REPLACE FUNCTION getWebhookFailures(stream_id_arg VARCHAR)
+    RETURNS TABLE (
+        webhook_failure_id INTEGER,
+        webhook_id INTEGER,
+        stream_id uuid,
+        events VARCHAR,
+        event_count INTEGER,
+        attempts INTEGER,
+        last_status INTEGER,
+        last_error VARCHAR,
+        failed_at TIMESTAMPTZ
+    )
+AS $$
+BEGIN
+    RETURN QUERY
+        SELECT wf.webhook_failure_id, wf.webhook_id, wf.stream_id, wf.events, wf.event_count, wf.attempts, wf.last_status, wf.last_error, wf.failed_at
+        FROM webhook_failures wf
+        WHERE wf.stream_id = (stream_id_arg)::uuid
+        ORDER BY wf.webhook_failure_id ASC;
+END;
+$$ LANGUAGE plpgsql;
 -- create API handler functions - end
 
 -- grant user rtdl all privileges in the database rtdl_db
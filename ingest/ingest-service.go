@@ -6,19 +6,148 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	kafka "github.com/segmentio/kafka-go"
 )
 
 type OutgoingMessage struct {
-	StreamId    string                 `json:"stream_id,omitempty"`
-	StreamAltId string                 `json:"stream_alt_id,omitempty"`
-	MessageType string                 `json:"message_type,omitempty"`
-	ProjectId   string                 `json:"projectId,omitempty"`
-	Payload     map[string]interface{} `json:"payload"`
+	StreamId       string                 `json:"stream_id,omitempty"`
+	StreamAltId    string                 `json:"stream_alt_id,omitempty"`
+	MessageType    string                 `json:"message_type,omitempty"`
+	MessageId      string                 `json:"message_id,omitempty"`
+	ProjectId      string                 `json:"projectId,omitempty"`
+	IngestId       string                 `json:"ingest_id,omitempty"`
+	KafkaTopic     string                 `json:"kafka_topic,omitempty"` //unset when the offset could not be read
+	KafkaPartition int                    `json:"kafka_partition"`
+	KafkaOffset    int64                  `json:"kafka_offset"`
+	ReceivedAt     string                 `json:"received_at,omitempty"`
+	ClientIp       string                 `json:"client_ip,omitempty"`
+	UserAgent      string                 `json:"user_agent,omitempty"`
+	Payload        map[string]interface{} `json:"payload"`
+}
+
+//id of this ingest instance, part of the ingest id of every message
+var producerId = generateProducerId()
+
+//sequence of the ingest ids of this instance
+var ingestSequence uint64
+
+func generateProducerId() string {
+
+	id := GetEnv("INSTANCE_ID", "")
+	if id == "" {
+		id, _ = os.Hostname()
+	}
+
+	//ends up in file names, keep it portable
+	id = strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' {
+			return r
+		}
+		return '-'
+	}, id)
+
+	return id
+}
+
+//id of a received message, unique across ingest instances and sortable by receive time
+//it travels inside the Kafka record, so a redelivered record keeps it - the ingester derives deterministic file names from it
+func generateIngestId(receivedAt time.Time) string {
+
+	sequence := atomic.AddUint64(&ingestSequence, 1)
+
+	return receivedAt.Format("20060102T150405.000000000Z") + "_" + producerId + "_" + fmt.Sprintf("%06d", sequence%1000000)
+}
+
+//partition of the ingress topic this instance writes to - every ingest instance needs a partition of its own,
+//only then is the offset stamped on a record the offset it is written at
+var producerPartition = getProducerPartition()
+
+//writes of this instance are serialised, each one stamps the offset the previous one left off at
+//the next offset is taken from the write result and only read from the leader when it is not known yet, -1
+var producerMutex sync.Mutex
+var producerNextOffset int64 = -1
+
+func getProducerPartition() int {
+
+	partition, err := strconv.Atoi(GetEnv("KAFKA_PARTITION", "0"))
+	if err != nil || partition < 0 {
+		log.Fatal("invalid KAFKA_PARTITION: ", os.Getenv("KAFKA_PARTITION"))
+	}
+	return partition
+}
+
+//Kafka key of control messages that concern no particular stream, e.g. cache refresh
+const controlMessageKey = "rtdl_control"
+
+//message types the ingester takes as control messages (configuration refresh, compaction, erasure), refused on `/ingest`
+const reservedMessageTypePrefix = "rtdl_"
+
+//the Kafka key addresses the `com.rtdl.sf/ingest` function instance a message is handled by
+//messages are keyed by stream, optionally followed by the value of a payload field to spread a busy stream over several instances
+var ingressKeyField = os.Getenv("INGRESS_KEY_FIELD")
+
+//Kafka key of an event: <stream id or alt id>[/<value of INGRESS_KEY_FIELD>]
+func getIngressKey(message *OutgoingMessage) string {
+
+	key := message.StreamId
+	if key == "" {
+		key = message.StreamAltId
+	}
+	if key == "" {
+		key = "rtdl_default" //unknown stream, dropped by the ingester
+	}
+
+	if ingressKeyField == "" {
+		return key
+	}
+
+	var value interface{} = message.Payload
+	for _, field := range strings.Split(ingressKeyField, ".") {
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return key
+		}
+		value = fields[field]
+	}
+
+	switch typedValue := value.(type) {
+	case string:
+		return key + "/" + typedValue
+	case float64, bool:
+		return key + "/" + fmt.Sprint(typedValue)
+	}
+	return key
+}
+
+//X-Forwarded-For and X-Real-IP are only believed behind a proxy that sets them, otherwise clients could claim any address
+var trustProxyHeaders = strings.EqualFold(os.Getenv("TRUST_PROXY_HEADERS"), "true")
+
+//address of the client that sent a request
+func getClientIp(req *http.Request) string {
+
+	if trustProxyHeaders {
+		if forwardedFor := req.Header.Get("X-Forwarded-For"); forwardedFor != "" { //client, proxy1, proxy2
+			return strings.TrimSpace(strings.Split(forwardedFor, ",")[0])
+		}
+		if realIp := req.Header.Get("X-Real-IP"); realIp != "" {
+			return strings.TrimSpace(realIp)
+		}
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
 }
 
 // GetEnv get key environment variable if exist otherwise return defalutValue
@@ -32,12 +161,14 @@ func GetEnv(key, defaultValue string) string {
 
 //handler function for incoming REST calls
 //based on processingType - either payload is passed on as-is to Kafka or
-//specific message, asking stateful function to reload configuration cache, is put on Kafka
+//specific message, asking stateful function to reload configuration cache, compact partitions or erase a subject, is put on Kafka
 func producerHandler(kafkaURL string, topic string, processingType string) func(http.ResponseWriter, *http.Request) {
 	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
 
 		var body []byte
 		var err error
+		var outgoingMessage *OutgoingMessage
+		key := controlMessageKey
 
 		//normal ingestion request
 		if processingType == "ingest" {
@@ -48,7 +179,13 @@ func producerHandler(kafkaURL string, topic string, processingType string) func(
 			}
 
 			log.Println("Received : ", string(body))
-			outgoingMessage := new(OutgoingMessage)
+			receivedAt := time.Now().UTC()
+
+			outgoingMessage = new(OutgoingMessage)
+			outgoingMessage.ReceivedAt = receivedAt.Format(time.RFC3339Nano)
+			outgoingMessage.IngestId = generateIngestId(receivedAt)
+			outgoingMessage.ClientIp = getClientIp(req)
+			outgoingMessage.UserAgent = req.UserAgent()
 
 			//first need to study message to check if it has stream_id or writeKey. one is necessary
 			var message map[string]interface{}
@@ -81,20 +218,86 @@ func producerHandler(kafkaURL string, topic string, processingType string) func(
 
 				outgoingMessage.MessageType = message["type"].(string)
 
+				//control messages of the ingester are only sent through the control endpoints
+				if strings.HasPrefix(strings.ToLower(outgoingMessage.MessageType), reservedMessageTypePrefix) {
+					http.Error(wrt, "message type "+outgoingMessage.MessageType+" is reserved", http.StatusUnprocessableEntity)
+					return
+				}
+
+			}
+
+			if messageId, ok := message["messageId"].(string); ok { //id retried sends keep, used for deduplication
+
+				outgoingMessage.MessageId = messageId
+
 			}
 
 			//finally put the original message inside payload
 			outgoingMessage.Payload = message
 
-			//and create json
-			body, err = json.Marshal(outgoingMessage)
+			key = getIngressKey(outgoingMessage)
 
+		} else if processingType == "compact" { //compaction request, carried to the stateful function like a cache refresh
+
+			requestBody, err := ioutil.ReadAll(req.Body)
 			if err != nil {
+				log.Println(err)
+				return
+			}
+
+			var compaction map[string]interface{}
+			if err := json.Unmarshal(requestBody, &compaction); err != nil {
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				return
+			}
+
+			streamId, _ := compaction["stream_id"].(string)
+			if streamId == "" {
+				http.Error(wrt, "`stream_id` is required", http.StatusUnprocessableEntity)
+				return
+			}
+			partition, _ := compaction["partition"].(string)
+			key = streamId
+
+			body, _ = json.Marshal(map[string]interface{}{
+				"stream_id":    streamId,
+				"message_type": "rtdl_206",
+				"payload":      map[string]interface{}{"partition": partition},
+			})
+
+		} else if processingType == "erase" { //subject erasure job created by the config service
 
+			requestBody, err := ioutil.ReadAll(req.Body)
+			if err != nil {
 				log.Println(err)
+				return
+			}
 
+			var erasure map[string]interface{}
+			if err := json.Unmarshal(requestBody, &erasure); err != nil {
+				http.Error(wrt, "Bad Request", http.StatusBadRequest)
+				return
+			}
+
+			streamId, _ := erasure["stream_id"].(string)
+			erasureJobId, _ := erasure["erasure_job_id"].(float64)
+			if streamId == "" || erasureJobId == 0 {
+				http.Error(wrt, "`stream_id` and `erasure_job_id` are required", http.StatusUnprocessableEntity)
+				return
 			}
 
+			key = streamId
+
+			body, _ = json.Marshal(map[string]interface{}{
+				"stream_id":    streamId,
+				"message_type": "rtdl_207",
+				"payload": map[string]interface{}{
+					"erasure_job_id": erasureJobId,
+					"subject_field":  erasure["subject_field"],
+					"subject_values": erasure["subject_values"],
+				},
+			})
+
 		} else { //cache refresh request
 
 			body = []byte(`{"stream_id":"","message_type":"rtdl_205","payload":{}}`)
@@ -102,24 +305,58 @@ func producerHandler(kafkaURL string, topic string, processingType string) func(
 		}
 
 		// to produce messages
-		partition := 0
-
-		conn, err := kafka.DialLeader(context.Background(), "tcp", kafkaURL, topic, partition)
+		conn, err := kafka.DialLeader(context.Background(), "tcp", kafkaURL, topic, producerPartition)
 		if err != nil {
 			log.Fatal("failed to dial leader:", err)
 		}
 
+		producerMutex.Lock()
+
+		if outgoingMessage != nil { //stamp the Kafka coordinates the message is going to get
+
+			if producerNextOffset < 0 {
+				producerNextOffset, err = conn.ReadLastOffset()
+				if err != nil {
+					log.Println("failed to read last offset:", err)
+					producerNextOffset = -1
+				}
+			}
+
+			if producerNextOffset >= 0 { //no coordinates otherwise, the ingester leaves the columns out
+				outgoingMessage.KafkaTopic = topic
+				outgoingMessage.KafkaPartition = producerPartition
+				outgoingMessage.KafkaOffset = producerNextOffset
+			}
+
+			//and create json
+			body, err = json.Marshal(outgoingMessage)
+			if err != nil {
+				producerMutex.Unlock()
+				log.Println(err)
+				return
+			}
+		}
+
 		conn.SetWriteDeadline(time.Now().Add(10 * time.Second)) //10 seconds timeout
-		_, err = conn.WriteMessages(
+		_, _, offset, _, err := conn.WriteCompressedMessagesAt(nil,
 			kafka.Message{
-				Key:   []byte("message"),
+				Key:   []byte(key),
 				Value: body,
 			},
 		)
+
 		if err != nil {
+			producerMutex.Unlock()
 			log.Fatal("failed to write messages:", err)
 		}
 
+		if outgoingMessage != nil && outgoingMessage.KafkaTopic != "" && offset != outgoingMessage.KafkaOffset {
+			log.Println("message", outgoingMessage.IngestId, "written at offset", offset, "instead of", outgoingMessage.KafkaOffset, "- another producer writes to partition", producerPartition)
+		}
+		producerNextOffset = offset + 1
+
+		producerMutex.Unlock()
+
 		if err := conn.Close(); err != nil {
 			log.Fatal("failed to close writer:", err)
 		}
@@ -141,6 +378,17 @@ func main() {
 
 	http.HandleFunc("/refreshCache", producerHandler(kafkaURL, topic, "refresh-cache"))
 
+	//compaction and erasure are requested by the config service only, on a port kept off the public listener
+	control := http.NewServeMux()
+
+	control.HandleFunc("/compact", producerHandler(kafkaURL, topic, "compact"))
+
+	control.HandleFunc("/erase", producerHandler(kafkaURL, topic, "erase"))
+
+	go func() {
+		log.Fatal(http.ListenAndServe(":"+GetEnv("CONTROL_PORT", "8081"), control))
+	}()
+
 	// Run the web server.
 	log.Fatal(http.ListenAndServe(":"+GetEnv("LISTENER_PORT", "8080"), nil))
 }
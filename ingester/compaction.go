@@ -0,0 +1,526 @@
+//small-file compaction of sealed partitions: files sharing a schema are merged into files of about the target size
+//merged files are written next to the originals, which are deleted once the rewritten manifest lists the merged files
+//only readers following the manifest never see a row twice - the partition runs without _SUCCESS while it is compacted
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/apache/flink-statefun/statefun-sdk-go/v3/pkg/statefun"
+	"github.com/jmoiron/sqlx"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go-source/writerfile"
+	"github.com/xitongsys/parquet-go/common"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/schema"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+//internal message asking the stateful function to compact the partitions of a stream
+const compactionControlMessageType = "rtdl_206"
+
+//what started a compaction run
+const (
+	compactionTriggerPolicy = "policy" //partition sealed with more files than the stream allows
+	compactionTriggerManual = "manual" //requested through the config service
+)
+
+//outcome of a compaction run
+const (
+	compactionStatusSucceeded = "succeeded"
+	compactionStatusFailed    = "failed"
+)
+
+//defaults of `compaction_min_files` and `compaction_target_size`
+const (
+	defaultCompactionMinFiles   = 10
+	defaultCompactionTargetSize = 128 * 1024 * 1024
+)
+
+//row of `compaction_runs`
+type compactionRun struct {
+	StreamId     string
+	Partition    string
+	Trigger      string
+	Status       string
+	FilesBefore  int
+	FilesAfter   int
+	BytesBefore  int64
+	BytesAfter   int64
+	ErrorMessage string
+	StartedAt    time.Time
+	FinishedAt   time.Time
+}
+
+//number of files a sealed partition needs before the policy compacts it, at least 2
+func getCompactionMinFiles(configRecord Config) int {
+
+	if !configRecord.CompactionMinFiles.Valid {
+		return defaultCompactionMinFiles
+	}
+	if configRecord.CompactionMinFiles.Int64 < 2 {
+		return 2
+	}
+	return int(configRecord.CompactionMinFiles.Int64)
+}
+
+//size in bytes merged files are aimed at
+func getCompactionTargetSize(configRecord Config) int64 {
+
+	if configRecord.CompactionTargetSize.Int64 <= 0 {
+		return defaultCompactionTargetSize
+	}
+	return configRecord.CompactionTargetSize.Int64
+}
+
+//true when the files of a stream can be merged
+//table formats are left alone - their data files are referenced from the table metadata
+func canCompact(configRecord Config) bool {
+
+	return !usesTableFormat(configRecord) && getFileFormatName(configRecord) == fileFormatParquet
+}
+
+//true when the compaction policy of the stream applies to the partition
+func needsCompaction(manifest PartitionManifest, configRecord Config) bool {
+
+	return configRecord.CompactionEnabled.Bool && canCompact(configRecord) && manifest.Sealed && len(manifest.Files) >= getCompactionMinFiles(configRecord)
+}
+
+//hand a compaction request over to the partition instances it names
+//without a partition every partition of the stream holding a manifest is compacted
+func requestCompaction(ctx statefun.Context, request IncomingMessage) error {
+
+	configRecord, found := findStreamConfig(request.StreamId)
+	if !found {
+		log.Println("No configuration found for stream", request.StreamId)
+		return nil
+	}
+
+	var partitions []string
+
+	if partition, _ := request.Payload["partition"].(string); partition != "" {
+
+		partitions = append(partitions, strings.Trim(partition, "/"))
+
+	} else {
+
+		store, err := newFileStore(getFileStoreTypeName(configRecord), configRecord)
+		if err != nil {
+			log.Println("Error opening file store", err)
+			return err
+		}
+		defer store.Close()
+
+		prefix := ""
+		if configRecord.FolderName.String != "" {
+			prefix = configRecord.FolderName.String + "/"
+		}
+
+		storedFiles, err := store.List(prefix)
+		if err != nil {
+			log.Println("Error listing partitions of stream", request.StreamId, err)
+			return err
+		}
+
+		for _, storedFile := range storedFiles {
+			if filepath.Base(storedFile.Path) == manifestFileName {
+				partitions = append(partitions, filepath.Dir(storedFile.Path))
+			}
+		}
+	}
+
+	for _, partition := range partitions {
+		ctx.Send(statefun.MessageBuilder{
+			Target:    statefun.Address{FunctionType: PartitionTypeName, Id: request.StreamId + "/" + partition},
+			Value:     PartitionMessage{Action: partitionActionCompact, Trigger: compactionTriggerManual},
+			ValueType: PartitionMessageType,
+		})
+	}
+
+	log.Println("Compaction requested for", len(partitions), "partitions of stream", request.StreamId)
+
+	return nil
+}
+
+//read the manifest of a partition from the file store
+func readPartitionManifest(configRecord Config, partition string) (PartitionManifest, error) {
+
+	var manifest PartitionManifest
+
+	store, err := newFileStore(getFileStoreTypeName(configRecord), configRecord)
+	if err != nil {
+		return manifest, err
+	}
+	defer store.Close()
+
+	data, err := store.Get(partition + "/" + manifestFileName)
+	if err != nil {
+		return manifest, err
+	}
+
+	err = json.Unmarshal(data, &manifest)
+	return manifest, err
+}
+
+//compact a partition and record the run, returns the manifest of the compacted partition
+func compactPartition(manifest PartitionManifest, configRecord Config, trigger string) (PartitionManifest, error) {
+
+	run := compactionRun{
+		StreamId:    manifest.StreamId,
+		Partition:   manifest.Partition,
+		Trigger:     trigger,
+		FilesBefore: len(manifest.Files),
+		StartedAt:   time.Now().UTC(),
+	}
+
+	compacted, err := mergePartitionFiles(manifest, configRecord, &run)
+
+	run.FinishedAt = time.Now().UTC()
+	run.Status = compactionStatusSucceeded
+	if err != nil {
+		run.Status = compactionStatusFailed
+		run.ErrorMessage = err.Error()
+	}
+
+	if recordErr := recordCompactionRun(run); recordErr != nil {
+		log.Println("Error recording compaction run of partition", manifest.Partition, recordErr)
+	}
+
+	return compacted, err
+}
+
+//merge the files of a partition, swap the manifest and delete the merged files
+func mergePartitionFiles(manifest PartitionManifest, configRecord Config, run *compactionRun) (PartitionManifest, error) {
+
+	store, err := newFileStore(getFileStoreTypeName(configRecord), configRecord)
+	if err != nil {
+		return manifest, err
+	}
+	defer store.Close()
+
+	//files sharing a schema, in manifest order
+	var schemaHashes []string
+	groups := map[string][]ManifestEntry{}
+	for _, entry := range manifest.Files {
+		if _, found := groups[entry.SchemaHash]; !found {
+			schemaHashes = append(schemaHashes, entry.SchemaHash)
+		}
+		groups[entry.SchemaHash] = append(groups[entry.SchemaHash], entry)
+	}
+
+	compacted := manifest
+	compacted.Files = nil
+
+	var mergedFiles []string   //written by this run
+	var replacedFiles []string //to be deleted once the manifest is committed
+
+	targetSize := getCompactionTargetSize(configRecord)
+
+	for _, schemaHash := range schemaHashes {
+
+		var binEntries []ManifestEntry
+		var binData [][]byte
+		var binSize int64
+
+		flushBin := func() error {
+
+			defer func() { binEntries, binData, binSize = nil, nil, 0 }()
+
+			if len(binEntries) == 1 { //nothing to merge with, the file stays
+				compacted.Files = append(compacted.Files, binEntries[0])
+				run.BytesAfter += binSize
+				return nil
+			}
+
+			fileName := generateLeafLevelFileName(getFileExtension(fileFormatParquet))
+			rows, size, err := writeMergedParquetFile(store, manifest.Partition+"/"+fileName, binData, configRecord)
+			if err != nil {
+				return err
+			}
+			mergedFiles = append(mergedFiles, fileName)
+
+			var compactedFrom []string //kept so a redelivered original is recognised once it is gone
+			for _, entry := range binEntries {
+				replacedFiles = append(replacedFiles, entry.File)
+				compactedFrom = append(compactedFrom, entry.File)
+				compactedFrom = append(compactedFrom, entry.CompactedFrom...)
+			}
+
+			compacted.Files = append(compacted.Files, ManifestEntry{
+				File:          fileName,
+				Rows:          rows,
+				SizeBytes:     size,
+				SchemaHash:    schemaHash,
+				CommittedAt:   time.Now().UTC(),
+				CompactedFrom: compactedFrom,
+			})
+			run.BytesAfter += size
+			return nil
+		}
+
+		for _, entry := range groups[schemaHash] {
+
+			data, err := store.Get(manifest.Partition + "/" + entry.File)
+			if err != nil {
+				err = errors.New("reading " + entry.File + ": " + err.Error())
+				deleteFiles(store, manifest.Partition, mergedFiles)
+				return manifest, err
+			}
+
+			entry.SizeBytes = int64(len(data))
+			run.BytesBefore += entry.SizeBytes
+
+			if len(binEntries) > 0 && binSize+entry.SizeBytes > targetSize {
+				if err = flushBin(); err != nil {
+					deleteFiles(store, manifest.Partition, mergedFiles)
+					return manifest, err
+				}
+			}
+
+			binEntries = append(binEntries, entry)
+			binData = append(binData, data)
+			binSize += entry.SizeBytes
+		}
+
+		if len(binEntries) > 0 {
+			if err = flushBin(); err != nil {
+				deleteFiles(store, manifest.Partition, mergedFiles)
+				return manifest, err
+			}
+		}
+	}
+
+	run.FilesAfter = len(compacted.Files)
+
+	if len(mergedFiles) == 0 { //every file is already as large as the target
+		return manifest, nil
+	}
+
+	//manifest first, a failure leaves the originals listed and only the merged files to remove
+	manifestData, _ := json.MarshalIndent(compacted, "", "  ")
+	if err = putFile(store, manifest.Partition+"/"+manifestFileName, manifestData); err != nil {
+		deleteFiles(store, manifest.Partition, mergedFiles)
+		return manifest, err
+	}
+
+	deleteFiles(store, manifest.Partition, replacedFiles)
+
+	log.Println("Partition", manifest.Partition, "compacted from", run.FilesBefore, "to", run.FilesAfter, "files")
+
+	return compacted, nil
+}
+
+//delete files of a partition, errors are logged - a leftover file is harmless once it is out of the manifest
+func deleteFiles(store FileStore, partition string, fileNames []string) {
+
+	for _, fileName := range fileNames {
+		if err := store.Delete(partition + "/" + fileName); err != nil {
+			log.Println("Error deleting", partition+"/"+fileName, err)
+		}
+	}
+}
+
+//write the rows of Parquet files sharing a schema into one file, returns the number of rows and the size of the file
+func writeMergedParquetFile(store FileStore, path string, files [][]byte, configRecord Config) (int64, int64, error) {
+
+	schemaHandler, rows, err := readParquetRows(files)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	size, err := writeParquetRows(store, path, schemaHandler, rows, configRecord)
+
+	return int64(len(rows)), size, err
+}
+
+//rows of Parquet files sharing a schema and the schema handler of the first file
+func readParquetRows(files [][]byte) (*schema.SchemaHandler, []interface{}, error) {
+
+	var schemaHandler *schema.SchemaHandler
+	var rows []interface{}
+
+	for _, data := range files {
+
+		pr, err := reader.NewParquetReader(buffer.NewBufferFileFromBytes(data), nil, 1)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		fileRows, err := pr.ReadByNumber(int(pr.GetNumRows()))
+		pr.ReadStop()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if schemaHandler == nil {
+			schemaHandler = pr.SchemaHandler
+		}
+		rows = append(rows, fileRows...)
+	}
+
+	return schemaHandler, rows, nil
+}
+
+//write rows read by readParquetRows into a file, tuned and sorted like the files of the stream, returns the size of the file
+func writeParquetRows(store FileStore, path string, schemaHandler *schema.SchemaHandler, rows []interface{}, configRecord Config) (int64, error) {
+
+	sortColumns := parseParquetSortColumns(configRecord.ParquetSortColumns.String)
+	if len(sortColumns) > 0 {
+		sortParquetRows(rows, schemaHandler, sortColumns)
+	}
+
+	var size int64
+
+	err := store.Upload(path, func(w io.Writer) error {
+
+		counter := &countingWriter{writer: w}
+		defer func() { size = counter.count }()
+
+		pw, err := writer.NewParquetWriter(writerfile.NewWriterFile(counter), getWritableSchema(schemaHandler), 4)
+		if err != nil {
+			return err
+		}
+
+		if configRecord.ParquetDictionary.Bool {
+			setSchemaHandlerDictionaryEncoding(pw.SchemaHandler)
+		}
+
+		if err = tuneParquetWriter(pw, configRecord); err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			if err = pw.Write(row); err != nil {
+				return err
+			}
+		}
+
+		if len(sortColumns) > 0 {
+			if err = setParquetSortingColumns(pw, getSchemaHandlerLeafPaths(pw.SchemaHandler), sortColumns); err != nil {
+				return err
+			}
+		}
+
+		return pw.WriteStop()
+	})
+
+	return size, err
+}
+
+//schema of a file being read, with the column names it was written with
+//the reader renames the schema elements to the Go field names of its rows
+func getWritableSchema(schemaHandler *schema.SchemaHandler) []*parquet.SchemaElement {
+
+	schemaElements := make([]*parquet.SchemaElement, len(schemaHandler.SchemaElements))
+
+	for i, schemaElement := range schemaHandler.SchemaElements {
+		writable := *schemaElement
+		writable.Name = schemaHandler.Infos[i].ExName
+		schemaElements[i] = &writable
+	}
+
+	return schemaElements
+}
+
+//rows read by a Parquet reader in sort column order
+func sortParquetRows(rows []interface{}, schemaHandler *schema.SchemaHandler, sortColumns []parquetSortColumn) {
+
+	fieldPaths := make([][]string, len(sortColumns))
+	for i, column := range sortColumns {
+		fieldPaths[i] = getRowFieldPath(schemaHandler, column.path)
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		for k, column := range sortColumns {
+			order := compareParquetValues(getRowValue(rows[i], fieldPaths[k]), getRowValue(rows[j], fieldPaths[k]))
+			if column.descending {
+				order = -order
+			}
+			if order != 0 {
+				return order < 0
+			}
+		}
+		return false
+	})
+}
+
+//field names of a column in the rows read by a Parquet reader, nil when the column is not in the schema
+func getRowFieldPath(schemaHandler *schema.SchemaHandler, columnPath []string) []string {
+
+	exPath := append([]string{schemaHandler.Infos[0].ExName}, columnPath...)
+
+	inPath, found := schemaHandler.ExPathToInPath[common.PathToStr(exPath)]
+	if !found {
+		return nil
+	}
+	return strings.Split(inPath, common.PAR_GO_PATH_DELIMITER)[1:] //without the root
+}
+
+//value of a field of a row read by a Parquet reader, as a payload value - nil when missing, null or not a scalar
+func getRowValue(row interface{}, fieldPath []string) interface{} {
+
+	if fieldPath == nil {
+		return nil
+	}
+
+	value := reflect.ValueOf(row)
+	for _, fieldName := range fieldPath {
+		for value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				return nil
+			}
+			value = value.Elem()
+		}
+		if value.Kind() != reflect.Struct {
+			return nil
+		}
+		value = value.FieldByName(fieldName)
+	}
+
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+
+	switch value.Kind() {
+	case reflect.Bool:
+		return value.Bool()
+	case reflect.Int32, reflect.Int64:
+		return float64(value.Int())
+	case reflect.Float32, reflect.Float64:
+		return value.Float()
+	case reflect.String:
+		return value.String()
+	}
+	return nil
+}
+
+//insert a run into `compaction_runs`
+func recordCompactionRun(run compactionRun) error {
+
+	db, err := sqlx.Open("postgres", psqlCon)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`INSERT INTO compaction_runs (stream_id, partition_path, trigger_type, status, files_before, files_after, bytes_before, bytes_after, error_message, started_at, finished_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		run.StreamId, run.Partition, run.Trigger, run.Status, run.FilesBefore, run.FilesAfter, run.BytesBefore, run.BytesAfter,
+		sql.NullString{String: run.ErrorMessage, Valid: run.ErrorMessage != ""}, run.StartedAt, run.FinishedAt)
+
+	return err
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMergePartitionFiles(t *testing.T) {
+
+	defer useLocalStore(t)()
+
+	configRecord := Config{
+		StreamId:        sql.NullString{String: "stream", Valid: true},
+		FileStoreTypeId: sql.NullInt64{Int64: 1, Valid: true},
+	}
+	store, err := newFileStore("Local", configRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	partition := "track/2022-04-15"
+	putTestParquet(t, store, partition+"/a.parquet", configRecord, `{"userId": "u-1"}`)
+	putTestParquet(t, store, partition+"/b.parquet", configRecord, `{"userId": "u-2"}`)
+	putTestParquet(t, store, partition+"/c.parquet", configRecord, `{"userId": "u-3", "plan": "pro"}`)
+	putTestParquet(t, store, partition+"/d.parquet", configRecord, `{"userId": "u-4"}`)
+	putTestParquet(t, store, "track/2022-04-16/e.parquet", configRecord, `{"userId": "u-5"}`)
+
+	manifest := PartitionManifest{StreamId: "stream", Partition: partition, Files: []ManifestEntry{
+		{File: "a.parquet", Rows: 1, SchemaHash: "user"},
+		{File: "b.parquet", Rows: 1, SchemaHash: "user"},
+		{File: "c.parquet", Rows: 1, SchemaHash: "plan"},
+		{File: "d.parquet", Rows: 1, SchemaHash: "user"},
+	}}
+	putTestFiles(t, store, map[string]string{partition + "/" + manifestFileName: newTestManifest(t, "stream", partition, manifest.Files...)})
+
+	//a file missing from the store fails the run, the partition is left as it was
+	missing := manifest
+	missing.Files = append(append([]ManifestEntry(nil), manifest.Files...), ManifestEntry{File: "f.parquet", Rows: 1, SchemaHash: "user"})
+	if _, err := mergePartitionFiles(missing, configRecord, &compactionRun{}); err == nil {
+		t.Fatal("compaction with a missing file did not fail")
+	}
+	want := []string{partition + "/" + manifestFileName, partition + "/a.parquet", partition + "/b.parquet", partition + "/c.parquet", partition + "/d.parquet", "track/2022-04-16/e.parquet"}
+	if paths := listTestFiles(t, store, ""); !reflect.DeepEqual(paths, want) {
+		t.Errorf("files after the failed compaction %v, want %v", paths, want)
+	}
+
+	//files sharing a schema are merged, the file of the other schema stays
+	compacted, err := mergePartitionFiles(manifest, configRecord, &compactionRun{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compacted.Files) != 2 || compacted.Files[1].File != "c.parquet" {
+		t.Fatalf("compacted manifest %+v, want the merged file and c.parquet", compacted.Files)
+	}
+	merged := compacted.Files[0]
+	if merged.Rows != 3 || merged.SchemaHash != "user" || !reflect.DeepEqual(merged.CompactedFrom, []string{"a.parquet", "b.parquet", "d.parquet"}) {
+		t.Errorf("merged file %+v, want 3 rows compacted from a, b and d", merged)
+	}
+
+	want = []string{partition + "/" + manifestFileName, partition + "/" + merged.File, partition + "/c.parquet", "track/2022-04-16/e.parquet"}
+	sort.Strings(want)
+	if paths := listTestFiles(t, store, ""); !reflect.DeepEqual(paths, want) {
+		t.Errorf("files after compaction %v, want %v", paths, want)
+	}
+	if values := readTestParquetValues(t, store, partition+"/"+merged.File, "userId"); !reflect.DeepEqual(values, []interface{}{"u-1", "u-2", "u-4"}) {
+		t.Errorf("rows of the merged file %v, want [u-1 u-2 u-4]", values)
+	}
+
+	data, err := store.Get(partition + "/" + manifestFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored PartitionManifest
+	if err := json.Unmarshal(data, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Files) != 2 || stored.Files[0].File != merged.File || stored.Files[1].File != "c.parquet" {
+		t.Errorf("stored manifest %+v, want the merged file and c.parquet", stored.Files)
+	}
+}
@@ -0,0 +1,328 @@
+//per-stream authentication against the cloud stores
+//streams either carry static keys or rely on the ambient credentials of the environment the ingester runs in
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	"cloud.google.com/go/storage"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/option"
+	secretmanagerpb "google.golang.org/genproto/googleapis/cloud/secretmanager/v1"
+)
+
+//supported values of `auth_mode`
+const (
+	authModeStatic          = "static"           //keys stored on the stream record (default)
+	authModeDefaultChain    = "default_chain"    //environment / shared config / instance role
+	authModeAssumeRole      = "assume_role"      //STS assume role on top of static keys or the default chain
+	authModeGCPADC          = "gcp_adc"          //GCP application default credentials
+	authModeManagedIdentity = "managed_identity" //Azure managed identity
+	authModeSecretRef       = "secret_ref"       //static keys resolved from `secret_ref` at load time
+)
+
+//scope used for Azure Storage AAD tokens
+const azureStorageResource = "https://storage.azure.com/"
+
+//cached Azure managed identity token
+var azureTokenMutex sync.Mutex
+var azureToken string
+var azureTokenExpiry time.Time
+
+//normalised auth mode of a stream
+func getAuthMode(configRecord Config) string {
+
+	authMode := strings.ToLower(strings.TrimSpace(configRecord.AuthMode.String))
+	if authMode == "" {
+		return authModeStatic
+	}
+	return authMode
+}
+
+//true when the stream uses the ambient credentials of the environment rather than stored keys
+func usesAmbientCredentials(configRecord Config) bool {
+
+	switch getAuthMode(configRecord) {
+	case authModeDefaultChain, authModeGCPADC, authModeManagedIdentity:
+		return true
+	case authModeAssumeRole:
+		return configRecord.AWSAcessKeyID.String == ""
+	}
+	return false
+}
+
+//AWS credentials for the stream's auth mode - nil means the SDK default chain
+func getAWSCredentials(configRecord Config) (*credentials.Credentials, error) {
+
+	awsAccessKeyId := strings.TrimSpace(configRecord.AWSAcessKeyID.String)
+	awsSecretAccessKey := strings.TrimSpace(configRecord.AWSSecretAcessKey.String)
+
+	switch getAuthMode(configRecord) {
+
+	case authModeStatic, authModeSecretRef:
+		return credentials.NewStaticCredentials(awsAccessKeyId, awsSecretAccessKey, ""), nil
+
+	case authModeDefaultChain:
+		return nil, nil
+
+	case authModeAssumeRole:
+		roleArn := strings.TrimSpace(configRecord.AWSRoleArn.String)
+		if roleArn == "" {
+			return nil, errors.New("AWS role ARN cannot be null or empty for auth mode assume_role")
+		}
+
+		var baseCredentials *credentials.Credentials
+		if awsAccessKeyId != "" { //assume the role from static keys, otherwise from the default chain
+			baseCredentials = credentials.NewStaticCredentials(awsAccessKeyId, awsSecretAccessKey, "")
+		}
+
+		baseConfig, err := newBaseAWSConfig(configRecord, baseCredentials)
+		if err != nil {
+			return nil, err
+		}
+
+		baseSession, err := session.NewSession(baseConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		return stscreds.NewCredentials(baseSession, roleArn, func(provider *stscreds.AssumeRoleProvider) {
+			provider.RoleSessionName = "rtdl-" + configRecord.StreamId.String
+		}), nil
+	}
+
+	return nil, errors.New("unsupported auth mode " + configRecord.AuthMode.String + " for AWS")
+}
+
+//create GCS client for the stream's auth mode
+func newGCSClient(ctx context.Context, configRecord Config) (*storage.Client, error) {
+
+	switch getAuthMode(configRecord) {
+
+	case authModeStatic, authModeSecretRef:
+		//replace all \n	with \\n to preserve them
+		jsonCreds := strings.Replace(configRecord.GCPJsonCredentials.String, "\n", "\\n", -1)
+
+		creds, err := google.CredentialsFromJSON(ctx, []byte(jsonCreds), secretmanager.DefaultAuthScopes()...)
+		if err != nil {
+			log.Println("Error creating GCP credentials", err)
+			return nil, err
+		}
+		return storage.NewClient(ctx, option.WithCredentials(creds))
+
+	case authModeDefaultChain, authModeGCPADC:
+		creds, err := google.FindDefaultCredentials(ctx, storage.ScopeReadWrite)
+		if err != nil {
+			log.Println("Error finding GCP application default credentials", err)
+			return nil, err
+		}
+		return storage.NewClient(ctx, option.WithCredentials(creds))
+	}
+
+	return nil, errors.New("unsupported auth mode " + configRecord.AuthMode.String + " for GCP")
+}
+
+//project of the application default credentials, GOOGLE_CLOUD_PROJECT takes precedence
+func getGCPDefaultProjectId() string {
+
+	if projectId := os.Getenv("GOOGLE_CLOUD_PROJECT"); projectId != "" {
+		return projectId
+	}
+
+	creds, err := google.FindDefaultCredentials(context.Background(), storage.ScopeReadWrite)
+	if err != nil {
+		log.Println("Error finding GCP application default credentials", err)
+		return ""
+	}
+	return creds.ProjectID
+}
+
+//create Azure Storage credential for the stream's auth mode
+func newAzureCredential(configRecord Config) (azblob.Credential, error) {
+
+	switch getAuthMode(configRecord) {
+
+	case authModeStatic, authModeSecretRef:
+		return azblob.NewSharedKeyCredential(configRecord.AzureStorageAccountname.String, configRecord.AzureStorageAccessKey.String)
+
+	case authModeDefaultChain, authModeManagedIdentity:
+		token, err := getAzureManagedIdentityToken()
+		if err != nil {
+			log.Println("Error retrieving Azure managed identity token", err)
+			return nil, err
+		}
+		return azblob.NewTokenCredential(token, nil), nil
+	}
+
+	return nil, errors.New("unsupported auth mode " + configRecord.AuthMode.String + " for Azure")
+}
+
+//retrieve (or reuse) a managed identity token for Azure Storage from the instance metadata service
+//AZURE_CLIENT_ID selects a user-assigned identity
+func getAzureManagedIdentityToken() (string, error) {
+
+	azureTokenMutex.Lock()
+	defer azureTokenMutex.Unlock()
+
+	if azureToken != "" && time.Now().Add(5*time.Minute).Before(azureTokenExpiry) {
+		return azureToken, nil
+	}
+
+	query := url.Values{}
+	query.Set("api-version", "2018-02-01")
+	query.Set("resource", azureStorageResource)
+	if clientId := os.Getenv("AZURE_CLIENT_ID"); clientId != "" {
+		query.Set("client_id", clientId)
+	}
+
+	request, err := http.NewRequest("GET", GetEnv("AZURE_IMDS_ENDPOINT", "http://169.254.169.254/metadata/identity/oauth2/token")+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Metadata", "true")
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	response, err := client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	body, _ := ioutil.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("managed identity endpoint returned %d: %s", response.StatusCode, string(body))
+	}
+
+	var tokenResponse struct {
+		AccessToken string `json:"access_token"`
+		ExpiresOn   string `json:"expires_on"`
+	}
+	if err = json.Unmarshal(body, &tokenResponse); err != nil {
+		return "", err
+	}
+
+	expiresOn, _ := strconv.ParseInt(tokenResponse.ExpiresOn, 10, 64)
+	azureToken = tokenResponse.AccessToken
+	azureTokenExpiry = time.Unix(expiresOn, 0)
+
+	return azureToken, nil
+}
+
+//resolve `secret_ref` into the credential fields of the configuration record
+//supported references:
+//  gcp-sm://projects/<project>/secrets/<secret>/versions/<version>
+//  env://<ENVIRONMENT_VARIABLE>
+//  file:///<path>
+//the secret has to be a JSON object keyed like the `streams` credential columns
+func resolveSecretRef(configRecord *Config) error {
+
+	secretRef := strings.TrimSpace(configRecord.SecretRef.String)
+	if secretRef == "" {
+		return errors.New("secret reference cannot be null or empty for auth mode secret_ref")
+	}
+
+	secretValue, err := readSecret(secretRef)
+	if err != nil {
+		return err
+	}
+
+	var secret map[string]interface{}
+	if err := json.Unmarshal(secretValue, &secret); err != nil {
+		return fmt.Errorf("secret %s is not a JSON object: %w", secretRef, err)
+	}
+
+	setSecretField := func(name string, field *string) {
+		switch value := secret[name].(type) {
+		case string:
+			*field = value
+		case map[string]interface{}: //GCP service account key given as an object
+			jsonValue, _ := json.Marshal(value)
+			*field = string(jsonValue)
+		}
+	}
+
+	setSecretField("aws_access_key_id", &configRecord.AWSAcessKeyID.String)
+	setSecretField("aws_secret_access_key", &configRecord.AWSSecretAcessKey.String)
+	setSecretField("gcp_json_credentials", &configRecord.GCPJsonCredentials.String)
+	setSecretField("azure_storage_account_name", &configRecord.AzureStorageAccountname.String)
+	setSecretField("azure_storage_access_key", &configRecord.AzureStorageAccessKey.String)
+
+	configRecord.AWSAcessKeyID.Valid = configRecord.AWSAcessKeyID.String != ""
+	configRecord.AWSSecretAcessKey.Valid = configRecord.AWSSecretAcessKey.String != ""
+	configRecord.GCPJsonCredentials.Valid = configRecord.GCPJsonCredentials.String != ""
+	configRecord.AzureStorageAccountname.Valid = configRecord.AzureStorageAccountname.String != ""
+	configRecord.AzureStorageAccessKey.Valid = configRecord.AzureStorageAccessKey.String != ""
+
+	return nil
+}
+
+//environment variables `env://` references may name, the ingester's own settings such as the database password stay out of reach
+const secretEnvPrefix = "RTDL_SECRET_"
+
+//raw value of a secret reference: gcp-sm://<secret version name>, env://<variable> or file://<path>
+//variables have to start with RTDL_SECRET_, files have to be in the directory SECRETS_DIR names - without it `file://` is refused
+//relative file paths are taken from SECRETS_DIR
+func readSecret(secretRef string) ([]byte, error) {
+
+	switch {
+	case strings.HasPrefix(secretRef, "gcp-sm://"):
+		ctx := context.Background()
+		client, err := secretmanager.NewClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer client.Close()
+
+		result, err := client.AccessSecretVersion(ctx, &secretmanagerpb.AccessSecretVersionRequest{
+			Name: strings.TrimPrefix(secretRef, "gcp-sm://"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return result.Payload.Data, nil
+
+	case strings.HasPrefix(secretRef, "env://"):
+		name := strings.TrimPrefix(secretRef, "env://")
+		if !strings.HasPrefix(name, secretEnvPrefix) {
+			return nil, errors.New("secret variable " + name + " does not start with " + secretEnvPrefix)
+		}
+		return []byte(os.Getenv(name)), nil
+
+	case strings.HasPrefix(secretRef, "file://"):
+		secretsDir := GetEnv("SECRETS_DIR", "")
+		if secretsDir == "" {
+			return nil, errors.New("file secret references need SECRETS_DIR")
+		}
+		secretsDir = filepath.Clean(secretsDir)
+
+		path := filepath.Clean(strings.TrimPrefix(secretRef, "file://"))
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(secretsDir, path)
+		}
+		if relative, err := filepath.Rel(secretsDir, path); err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+			return nil, errors.New("secret file " + path + " is not in " + secretsDir)
+		}
+		return ioutil.ReadFile(path)
+	}
+
+	return nil, errors.New("unsupported secret reference " + secretRef)
+}
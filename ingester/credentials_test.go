@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadSecret(t *testing.T) {
+
+	dir := t.TempDir()
+	secretsDir := filepath.Join(dir, "secrets")
+	if err := os.Mkdir(secretsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for path, content := range map[string]string{filepath.Join(secretsDir, "key"): "secret", filepath.Join(dir, "outside"): "outside"} {
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer os.Unsetenv("RTDL_SECRET_TEST_KEY")
+	defer os.Unsetenv("RTDL_TEST_OTHER")
+	defer func(value string) { os.Setenv("SECRETS_DIR", value) }(os.Getenv("SECRETS_DIR"))
+	os.Setenv("RTDL_SECRET_TEST_KEY", "secret")
+	os.Setenv("RTDL_TEST_OTHER", "other")
+
+	//without a secrets directory no file can be read
+	os.Unsetenv("SECRETS_DIR")
+	if _, err := readSecret("file://" + filepath.Join(secretsDir, "key")); err == nil {
+		t.Errorf("file secret read without SECRETS_DIR, want an error")
+	}
+
+	os.Setenv("SECRETS_DIR", secretsDir)
+
+	tests := []struct {
+		secretRef string
+		want      string
+		ok        bool
+	}{
+		{"env://RTDL_SECRET_TEST_KEY", "secret", true},
+		{"env://RTDL_TEST_OTHER", "", false},
+		{"env://PGPASSWORD", "", false},
+		{"file://key", "secret", true},
+		{"file://" + filepath.Join(secretsDir, "key"), "secret", true},
+		{"file://../outside", "", false},
+		{"file://" + filepath.Join(dir, "outside"), "", false},
+		{"file://" + secretsDir + "/../outside", "", false},
+		{"vault://key", "", false},
+	}
+
+	for _, test := range tests {
+		got, err := readSecret(test.secretRef)
+		if string(got) != test.want || (err == nil) != test.ok {
+			t.Errorf("readSecret(%s) = %q, %v, want %q and ok %t", test.secretRef, got, err, test.want, test.ok)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+//deduplication: messages of deduplicated streams pass the dedup function of their id before they are ingested
+//the id is the `messageId` the SDKs send, or the payload field named by `dedup_key` - redelivered and retried messages are dropped
+//one `com.rtdl.sf/dedup` instance per stream and id, its state expires by itself once the window is over
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/apache/flink-statefun/statefun-sdk-go/v3/pkg/statefun"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	DedupTypeName  = statefun.TypeNameFrom("com.rtdl.sf/dedup")
+	DedupStateType = statefun.MakeJsonType(statefun.TypeNameFrom("com.rtdl.sf/DedupState"))
+)
+
+//longest window an id is remembered for, `dedup_ttl_seconds` beyond it are cut down to it
+var dedupMaxTTL = getEnvDuration("DEDUP_MAX_TTL", 7*24*time.Hour)
+
+//when the id of a dedup function instance was first seen
+var DedupState = statefun.ValueSpec{
+	Name:       "dedup",
+	ValueType:  DedupStateType,
+	Expiration: statefun.ExpireAfterWrite(dedupMaxTTL),
+}
+
+//how often the duplicate counters are added to `dedup_stats`
+var dedupStatsInterval = getEnvDuration("DEDUP_STATS_INTERVAL", time.Minute)
+
+type DedupStateValue struct {
+	SeenAt time.Time `json:"seen_at"`
+}
+
+//duplicates dropped per stream since the last flush
+var dedupCountersMutex sync.Mutex
+var dedupCounters = map[string]int64{}
+
+//deduplication window of a stream, zero when the stream is not deduplicated
+func getDedupTTL(configRecord Config) time.Duration {
+
+	if configRecord.DedupTTLSeconds.Int64 <= 0 {
+		return 0
+	}
+	ttl := time.Duration(configRecord.DedupTTLSeconds.Int64) * time.Second
+	if ttl > dedupMaxTTL {
+		return dedupMaxTTL
+	}
+	return ttl
+}
+
+//id a message is deduplicated on, empty when it has none
+func getDedupKey(request IncomingMessage, configRecord Config) string {
+
+	if configRecord.DedupKey.String == "" {
+		return request.MessageId
+	}
+
+	key, err := castValue(getPayloadValue(request.Payload, parsePayloadPath(configRecord.DedupKey.String)), castTypeString)
+	if err != nil || key == nil {
+		return ""
+	}
+	return key.(string)
+}
+
+//ids are addressed hashed, function ids stay short and hold no payload values
+func hashDedupKey(key string) string {
+
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+//hand a message of a deduplicated stream over to the dedup function of its id, false when it is not deduplicated
+//the dedup function sends the message back to the calling ingest instance unless it is a duplicate
+func sendToDedup(ctx statefun.Context, request IncomingMessage, configRecord Config) bool {
+
+	if request.DedupChecked || getDedupTTL(configRecord) == 0 {
+		return false
+	}
+
+	key := getDedupKey(request, configRecord)
+	if key == "" {
+		return false
+	}
+
+	request.DedupChecked = true
+
+	ctx.Send(statefun.MessageBuilder{
+		Target:    statefun.Address{FunctionType: DedupTypeName, Id: configRecord.StreamId.String + "/" + hashDedupKey(key)},
+		Value:     request,
+		ValueType: IncomingMessageType,
+	})
+	return true
+}
+
+//dedup stateful function: passes the first message of an id on, drops the ones following within the window of the stream
+func Dedup(ctx statefun.Context, message statefun.Message) error {
+
+	var request IncomingMessage
+	if err := message.As(IncomingMessageType, &request); err != nil {
+		return err
+	}
+
+	configRecord, found := findRequestConfig(request)
+	if !found {
+		log.Println("No configuration found for stream", request.StreamId)
+		return nil
+	}
+
+	now := time.Now().UTC()
+
+	var state DedupStateValue
+	if ctx.Storage().Get(DedupState, &state) && now.Sub(state.SeenAt) < getDedupTTL(configRecord) {
+		countDuplicate(configRecord.StreamId.String)
+		return nil
+	}
+
+	ctx.Storage().Set(DedupState, DedupStateValue{SeenAt: now})
+
+	if ctx.Caller() == nil { //always sent by an ingest instance
+		return nil
+	}
+
+	ctx.Send(statefun.MessageBuilder{
+		Target:    *ctx.Caller(),
+		Value:     request,
+		ValueType: IncomingMessageType,
+	})
+
+	return nil
+}
+
+func countDuplicate(streamId string) {
+
+	dedupCountersMutex.Lock()
+	dedupCounters[streamId]++
+	dedupCountersMutex.Unlock()
+}
+
+//add the duplicates counted since the last flush to `dedup_stats`, counts that could not be written are kept for the next flush
+func flushDedupCounters() {
+
+	dedupCountersMutex.Lock()
+	counters := dedupCounters
+	dedupCounters = map[string]int64{}
+	dedupCountersMutex.Unlock()
+
+	if len(counters) == 0 {
+		return
+	}
+
+	requeue := func(streamId string, duplicates int64) {
+		dedupCountersMutex.Lock()
+		dedupCounters[streamId] += duplicates
+		dedupCountersMutex.Unlock()
+	}
+
+	db, err := sqlx.Open("postgres", psqlCon)
+	if err != nil {
+		log.Println("Failed to open a DB connection: ", err)
+		for streamId, duplicates := range counters {
+			requeue(streamId, duplicates)
+		}
+		return
+	}
+	defer db.Close()
+
+	for streamId, duplicates := range counters {
+
+		_, err := db.Exec(`INSERT INTO dedup_stats (stream_id, duplicates_dropped)
+			VALUES ($1, $2)
+			ON CONFLICT (stream_id) DO UPDATE SET
+				duplicates_dropped = dedup_stats.duplicates_dropped + EXCLUDED.duplicates_dropped,
+				updated_at = NOW()`,
+			streamId, duplicates)
+		if err == nil {
+			continue
+		}
+
+		log.Println("Error recording duplicates of stream", streamId, err)
+		if _, found := findStreamConfig(streamId); found {
+			requeue(streamId, duplicates)
+		}
+	}
+}
+
+//background job writing the duplicate counters, one flush per interval
+func runDedupStats() {
+
+	ticker := time.NewTicker(dedupStatsInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		flushDedupCounters()
+	}
+}
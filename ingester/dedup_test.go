@@ -0,0 +1,176 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/flink-statefun/statefun-sdk-go/v3/pkg/statefun"
+)
+
+//deduplicated stream, ids kept for an hour
+func newDedupConfig(dedupKey string) Config {
+
+	return Config{
+		StreamId:        sql.NullString{String: "stream", Valid: true},
+		DedupKey:        sql.NullString{String: dedupKey, Valid: dedupKey != ""},
+		DedupTTLSeconds: sql.NullInt64{Int64: 3600, Valid: true},
+	}
+}
+
+func TestGetDedupKey(t *testing.T) {
+
+	request := IncomingMessage{MessageId: "m-1", Payload: decodePayload(t, `{"order": {"id": 42}, "ref": "r-1"}`)}
+
+	tests := []struct {
+		dedupKey string
+		want     string
+	}{
+		{"", "m-1"},
+		{"ref", "r-1"},
+		{"order.id", "42"},
+		{"missing", ""},
+	}
+
+	for _, test := range tests {
+		if got := getDedupKey(request, newDedupConfig(test.dedupKey)); got != test.want {
+			t.Errorf("getDedupKey with dedup_key %q = %q, want %q", test.dedupKey, got, test.want)
+		}
+	}
+}
+
+func TestGetDedupTTL(t *testing.T) {
+
+	tests := []struct {
+		seconds int64
+		want    time.Duration
+	}{
+		{0, 0},
+		{-5, 0},
+		{60, time.Minute},
+		{int64(dedupMaxTTL/time.Second) + 1, dedupMaxTTL},
+	}
+
+	for _, test := range tests {
+		if got := getDedupTTL(Config{DedupTTLSeconds: sql.NullInt64{Int64: test.seconds, Valid: true}}); got != test.want {
+			t.Errorf("getDedupTTL(%d seconds) = %v, want %v", test.seconds, got, test.want)
+		}
+	}
+}
+
+func TestHashDedupKey(t *testing.T) {
+
+	hashed := hashDedupKey("m-1")
+	if len(hashed) != 64 || strings.Contains(hashed, "m-1") {
+		t.Errorf("hashDedupKey(m-1) = %q, want a hex SHA-256", hashed)
+	}
+	if hashDedupKey("m-1") != hashed || hashDedupKey("m-2") == hashed {
+		t.Errorf("hashDedupKey is not deterministic or not distinct")
+	}
+}
+
+func TestSendToDedup(t *testing.T) {
+
+	ingest := statefun.Address{FunctionType: IngestTypeName, Id: "stream"}
+	configRecord := newDedupConfig("")
+
+	ctx := newTestContext(ingest, nil)
+	request := IncomingMessage{StreamId: "stream", MessageId: "m-1", Payload: map[string]interface{}{}}
+
+	if !sendToDedup(ctx, request, configRecord) {
+		t.Fatal("message of a deduplicated stream not sent to the dedup function")
+	}
+	if len(ctx.sent) != 1 {
+		t.Fatalf("%d messages sent, want 1", len(ctx.sent))
+	}
+	if want := (statefun.Address{FunctionType: DedupTypeName, Id: "stream/" + hashDedupKey("m-1")}); ctx.sent[0].Target != want {
+		t.Errorf("sent to %v, want %v", ctx.sent[0].Target, want)
+	}
+	var sent IncomingMessage
+	readTestMessage(t, ctx.sent[0], &sent)
+	if !sent.DedupChecked || sent.MessageId != "m-1" {
+		t.Errorf("sent %+v, want the message marked as checked", sent)
+	}
+
+	ctx.reset()
+	for _, test := range []struct {
+		name         string
+		request      IncomingMessage
+		configRecord Config
+	}{
+		{"checked already", sent, configRecord},
+		{"no id", IncomingMessage{StreamId: "stream", Payload: map[string]interface{}{}}, configRecord},
+		{"not deduplicated", request, Config{StreamId: configRecord.StreamId}},
+	} {
+		if sendToDedup(ctx, test.request, test.configRecord) || len(ctx.sent) > 0 {
+			t.Errorf("%s: message sent to the dedup function", test.name)
+		}
+	}
+}
+
+func TestDedup(t *testing.T) {
+
+	defer func(streams []Config) { configs = streams }(configs)
+	defer func(counters map[string]int64) { dedupCounters = counters }(dedupCounters)
+
+	configs = []Config{newDedupConfig("")}
+	dedupCounters = map[string]int64{}
+
+	ingest := statefun.Address{FunctionType: IngestTypeName, Id: "stream"}
+	ctx := newTestContext(statefun.Address{FunctionType: DedupTypeName, Id: "stream/" + hashDedupKey("m-1")}, &ingest)
+
+	request := IncomingMessage{StreamId: "stream", MessageId: "m-1", DedupChecked: true, Payload: map[string]interface{}{"n": 1.0}}
+
+	//first message of the id goes back to the ingest instance
+	if err := Dedup(ctx, newTestMessage(t, request, IncomingMessageType)); err != nil {
+		t.Fatal(err)
+	}
+	if len(ctx.sent) != 1 || ctx.sent[0].Target != ingest {
+		t.Fatalf("first message sent to %v, want it back at %v", ctx.sent, ingest)
+	}
+	var returned IncomingMessage
+	readTestMessage(t, ctx.sent[0], &returned)
+	if !returned.DedupChecked || returned.Payload["n"] != 1.0 {
+		t.Errorf("returned %+v, want the checked message", returned)
+	}
+
+	//retries within the window are dropped and counted
+	for i := 0; i < 2; i++ {
+		ctx.reset()
+		if err := Dedup(ctx, newTestMessage(t, request, IncomingMessageType)); err != nil {
+			t.Fatal(err)
+		}
+		if len(ctx.sent) != 0 {
+			t.Errorf("retry %d passed on", i+1)
+		}
+	}
+	if dedupCounters["stream"] != 2 {
+		t.Errorf("%d duplicates counted, want 2", dedupCounters["stream"])
+	}
+
+	//once the window of the stream is over the id is new again
+	ctx.reset()
+	ctx.storage.Set(DedupState, DedupStateValue{SeenAt: time.Now().UTC().Add(-2 * time.Hour)})
+	if err := Dedup(ctx, newTestMessage(t, request, IncomingMessageType)); err != nil {
+		t.Fatal(err)
+	}
+	if len(ctx.sent) != 1 {
+		t.Errorf("message after the window dropped")
+	}
+	var state DedupStateValue
+	ctx.storage.Get(DedupState, &state)
+	if time.Since(state.SeenAt) > time.Minute {
+		t.Errorf("seen at %v, want the window to start again", state.SeenAt)
+	}
+
+	//messages of removed streams are dropped
+	configs = nil
+	ctx = newTestContext(ctx.self, &ingest)
+	if err := Dedup(ctx, newTestMessage(t, request, IncomingMessageType)); err != nil {
+		t.Fatal(err)
+	}
+	if len(ctx.sent) != 0 {
+		t.Errorf("message of a removed stream passed on")
+	}
+}
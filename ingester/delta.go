@@ -0,0 +1,614 @@
+//Delta Lake transaction log next to the Parquet files of a message type:
+//  <table>/_delta_log/<version>.json                 one commit per batch of files (add actions with stats)
+//  <table>/_delta_log/<version>.checkpoint.parquet   full table state every deltaCheckpointInterval commits
+//  <table>/_delta_log/_last_checkpoint               version of the latest checkpoint
+//data files are added with paths relative to the table root and without partition columns
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go-source/writerfile"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+const (
+	deltaLogFolder          = "_delta_log"
+	deltaLastCheckpoint     = "_last_checkpoint"
+	deltaCheckpointInterval = 10 //same as the Delta default `delta.checkpointInterval`
+	deltaStatsStringLength  = 32 //string stats are truncated, longer values only get a min value
+)
+
+type deltaProtocol struct {
+	MinReaderVersion int32 `json:"minReaderVersion" parquet:"name=minReaderVersion, type=INT32"`
+	MinWriterVersion int32 `json:"minWriterVersion" parquet:"name=minWriterVersion, type=INT32"`
+}
+
+type deltaFormat struct {
+	Provider string            `json:"provider" parquet:"name=provider, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Options  map[string]string `json:"options" parquet:"name=options, type=MAP, convertedtype=MAP, keytype=BYTE_ARRAY, keyconvertedtype=UTF8, valuetype=BYTE_ARRAY, valueconvertedtype=UTF8"`
+}
+
+type deltaMetaData struct {
+	Id               string            `json:"id" parquet:"name=id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Format           deltaFormat       `json:"format" parquet:"name=format"`
+	SchemaString     string            `json:"schemaString" parquet:"name=schemaString, type=BYTE_ARRAY, convertedtype=UTF8"`
+	PartitionColumns []string          `json:"partitionColumns" parquet:"name=partitionColumns, type=LIST, valuetype=BYTE_ARRAY, valueconvertedtype=UTF8"`
+	Configuration    map[string]string `json:"configuration" parquet:"name=configuration, type=MAP, convertedtype=MAP, keytype=BYTE_ARRAY, keyconvertedtype=UTF8, valuetype=BYTE_ARRAY, valueconvertedtype=UTF8"`
+	CreatedTime      int64             `json:"createdTime" parquet:"name=createdTime, type=INT64"`
+}
+
+type deltaAdd struct {
+	Path             string            `json:"path" parquet:"name=path, type=BYTE_ARRAY, convertedtype=UTF8"`
+	PartitionValues  map[string]string `json:"partitionValues" parquet:"name=partitionValues, type=MAP, convertedtype=MAP, keytype=BYTE_ARRAY, keyconvertedtype=UTF8, valuetype=BYTE_ARRAY, valueconvertedtype=UTF8"`
+	Size             int64             `json:"size" parquet:"name=size, type=INT64"`
+	ModificationTime int64             `json:"modificationTime" parquet:"name=modificationTime, type=INT64"`
+	DataChange       bool              `json:"dataChange" parquet:"name=dataChange, type=BOOLEAN"`
+	Stats            string            `json:"stats,omitempty" parquet:"name=stats, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+type deltaRemove struct {
+	Path              string `json:"path" parquet:"name=path, type=BYTE_ARRAY, convertedtype=UTF8"`
+	DeletionTimestamp int64  `json:"deletionTimestamp" parquet:"name=deletionTimestamp, type=INT64"`
+	DataChange        bool   `json:"dataChange" parquet:"name=dataChange, type=BOOLEAN"`
+}
+
+//one line of a commit file
+type deltaAction struct {
+	Protocol   *deltaProtocol         `json:"protocol,omitempty"`
+	MetaData   *deltaMetaData         `json:"metaData,omitempty"`
+	Add        *deltaAdd              `json:"add,omitempty"`
+	Remove     *deltaRemove           `json:"remove,omitempty"`
+	CommitInfo map[string]interface{} `json:"commitInfo,omitempty"`
+}
+
+//one row of a checkpoint
+type deltaCheckpointRow struct {
+	Protocol *deltaProtocol `parquet:"name=protocol, repetitiontype=OPTIONAL"`
+	MetaData *deltaMetaData `parquet:"name=metaData, repetitiontype=OPTIONAL"`
+	Add      *deltaAdd      `parquet:"name=add, repetitiontype=OPTIONAL"`
+	Remove   *deltaRemove   `parquet:"name=remove, repetitiontype=OPTIONAL"`
+}
+
+type deltaLastCheckpointInfo struct {
+	Version int64 `json:"version"`
+	Size    int64 `json:"size"`
+}
+
+//table state rebuilt from the checkpoint and the commits after it
+type deltaTableSnapshot struct {
+	Version  int64 //-1 when the table does not exist yet
+	Protocol *deltaProtocol
+	MetaData *deltaMetaData
+	Files    map[string]*deltaAdd //live files by path
+	Removed  map[string]*deltaRemove
+}
+
+//Spark SQL type of a Delta schema string
+type sparkType struct {
+	Primitive   string
+	Fields      []sparkField
+	ElementType *sparkType
+	IsStruct    bool
+}
+
+type sparkField struct {
+	Name     string                 `json:"name"`
+	Type     sparkType              `json:"type"`
+	Nullable bool                   `json:"nullable"`
+	Metadata map[string]interface{} `json:"metadata"`
+}
+
+func (t sparkType) MarshalJSON() ([]byte, error) {
+
+	switch {
+	case t.IsStruct:
+		return json.Marshal(struct {
+			Type   string       `json:"type"`
+			Fields []sparkField `json:"fields"`
+		}{"struct", t.Fields})
+	case t.ElementType != nil:
+		return json.Marshal(struct {
+			Type         string    `json:"type"`
+			ElementType  sparkType `json:"elementType"`
+			ContainsNull bool      `json:"containsNull"`
+		}{"array", *t.ElementType, true})
+	}
+	return json.Marshal(t.Primitive)
+}
+
+func (t *sparkType) UnmarshalJSON(data []byte) error {
+
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &t.Primitive)
+	}
+
+	var complexType struct {
+		Type        string       `json:"type"`
+		Fields      []sparkField `json:"fields"`
+		ElementType *sparkType   `json:"elementType"`
+	}
+	if err := json.Unmarshal(data, &complexType); err != nil {
+		return err
+	}
+
+	switch complexType.Type {
+	case "struct":
+		t.IsStruct = true
+		t.Fields = complexType.Fields
+		return nil
+	case "array":
+		t.ElementType = complexType.ElementType
+		return nil
+	}
+	return fmt.Errorf("unsupported Delta column type %s", complexType.Type)
+}
+
+//Spark names of the primitives that differ from Iceberg
+var sparkPrimitiveNames = map[string]string{"int": "integer"}
+
+//Spark type of an Iceberg type, used to share the schema merge logic with Iceberg tables
+func getSparkType(fieldType icebergType) sparkType {
+
+	switch {
+	case fieldType.Struct != nil:
+		return sparkType{IsStruct: true, Fields: getSparkFields(fieldType.Struct.Fields)}
+	case fieldType.List != nil:
+		element := getSparkType(fieldType.List.Element)
+		return sparkType{ElementType: &element}
+	}
+
+	if name, found := sparkPrimitiveNames[fieldType.Primitive]; found {
+		return sparkType{Primitive: name}
+	}
+	return sparkType{Primitive: fieldType.Primitive}
+}
+
+func getSparkFields(fields []icebergField) []sparkField {
+
+	sparkFields := []sparkField{}
+	for _, field := range fields {
+		sparkFields = append(sparkFields, sparkField{Name: field.Name, Type: getSparkType(field.Type), Nullable: true, Metadata: map[string]interface{}{}})
+	}
+	return sparkFields
+}
+
+//Iceberg type of a Spark type, ids are assigned in order
+func getIcebergTypeFromSpark(fieldType sparkType, lastColumnId *int) icebergType {
+
+	switch {
+	case fieldType.IsStruct:
+		return icebergType{Struct: &icebergStruct{Fields: getIcebergFieldsFromSpark(fieldType.Fields, lastColumnId)}}
+	case fieldType.ElementType != nil:
+		*lastColumnId++
+		list := &icebergList{ElementId: *lastColumnId}
+		list.Element = getIcebergTypeFromSpark(*fieldType.ElementType, lastColumnId)
+		return icebergType{List: list}
+	}
+
+	for icebergName, sparkName := range sparkPrimitiveNames {
+		if sparkName == fieldType.Primitive {
+			return icebergType{Primitive: icebergName}
+		}
+	}
+	return icebergType{Primitive: fieldType.Primitive}
+}
+
+func getIcebergFieldsFromSpark(fields []sparkField, lastColumnId *int) []icebergField {
+
+	var icebergFields []icebergField
+	for _, field := range fields {
+		*lastColumnId++
+		icebergField := icebergField{Id: *lastColumnId, Name: field.Name}
+		icebergField.Type = getIcebergTypeFromSpark(field.Type, lastColumnId)
+		icebergFields = append(icebergFields, icebergField)
+	}
+	return icebergFields
+}
+
+//per-file statistics of the add action - for a single record min and max are the values themselves
+func generateDeltaStats(records []map[string]interface{}) string {
+
+	minValues, maxValues, nullCount := generateDeltaColumnStats(records[0])
+
+	for i, record := range records[1:] {
+		recordMin, recordMax, recordNulls := generateDeltaColumnStats(record)
+		mergeDeltaColumnStats(minValues, maxValues, nullCount, int64(i+1), recordMin, recordMax, recordNulls)
+	}
+	removeDeltaConflicts(nullCount)
+
+	stats, _ := json.Marshal(map[string]interface{}{
+		"numRecords": len(records),
+		"minValues":  minValues,
+		"maxValues":  maxValues,
+		"nullCount":  nullCount,
+	})
+	return string(stats)
+}
+
+func generateDeltaColumnStats(payload map[string]interface{}) (map[string]interface{}, map[string]interface{}, map[string]interface{}) {
+
+	minValues := map[string]interface{}{}
+	maxValues := map[string]interface{}{}
+	nullCount := map[string]interface{}{}
+
+	for key, value := range payload {
+
+		switch typedValue := value.(type) {
+		case nil:
+			continue //not part of the schema
+		case map[string]interface{}:
+			if len(typedValue) == 0 {
+				continue
+			}
+			nestedMin, nestedMax, nestedNulls := generateDeltaColumnStats(typedValue)
+			minValues[key], maxValues[key], nullCount[key] = nestedMin, nestedMax, nestedNulls
+		case []interface{}: //no stats on arrays
+			continue
+		case string:
+			if len(typedValue) <= deltaStatsStringLength {
+				minValues[key], maxValues[key] = typedValue, typedValue
+			} else {
+				minValues[key] = typedValue[:deltaStatsStringLength] //a prefix is always a valid lower bound
+			}
+			nullCount[key] = 0
+		case float64:
+			minValues[key], maxValues[key] = typedValue, typedValue
+			nullCount[key] = 0
+		case bool: //Delta keeps no min/max for booleans
+			nullCount[key] = 0
+		}
+	}
+
+	return minValues, maxValues, nullCount
+}
+
+//fold the column statistics of a record into those of the `rows` records before it
+//columns only some records have count the others as nulls, columns whose type differs between records get no statistics
+func mergeDeltaColumnStats(minValues, maxValues, nullCount map[string]interface{}, rows int64, recordMin, recordMax, recordNulls map[string]interface{}) {
+
+	for key, nulls := range nullCount {
+
+		if nulls == nil { //type differed before
+			continue
+		}
+
+		recordKeyNulls, found := recordNulls[key]
+		if !found { //null in the record
+			nullCount[key] = addDeltaNulls(nulls, 1)
+			continue
+		}
+
+		nested, isNested := nulls.(map[string]interface{})
+		recordNested, recordIsNested := recordKeyNulls.(map[string]interface{})
+
+		switch {
+		case isNested && recordIsNested:
+			mergeDeltaColumnStats(minValues[key].(map[string]interface{}), maxValues[key].(map[string]interface{}), nested, rows,
+				recordMin[key].(map[string]interface{}), recordMax[key].(map[string]interface{}), recordNested)
+		case isNested || recordIsNested: //kept as nil until all records are merged, see removeDeltaConflicts
+			delete(minValues, key)
+			delete(maxValues, key)
+			nullCount[key] = nil
+		default:
+			mergeDeltaBound(minValues, key, recordMin[key], -1)
+			mergeDeltaBound(maxValues, key, recordMax[key], 1)
+		}
+	}
+
+	for key, recordKeyNulls := range recordNulls {
+
+		if _, found := nullCount[key]; found {
+			continue
+		}
+
+		nullCount[key] = addDeltaNulls(recordKeyNulls, rows) //null in the records before
+		if value, found := recordMin[key]; found {
+			minValues[key] = value
+		}
+		if value, found := recordMax[key]; found {
+			maxValues[key] = value
+		}
+	}
+}
+
+//drop the null counts of columns whose type differed between records
+func removeDeltaConflicts(nullCount map[string]interface{}) {
+
+	for key, nulls := range nullCount {
+		switch typedNulls := nulls.(type) {
+		case nil:
+			delete(nullCount, key)
+		case map[string]interface{}:
+			removeDeltaConflicts(typedNulls)
+		}
+	}
+}
+
+//keep the lower (order -1) or higher (order 1) of two bounds of a column, no bound once a record has none or the types differ
+func mergeDeltaBound(bounds map[string]interface{}, key string, value interface{}, order int) {
+
+	current, found := bounds[key]
+	if !found {
+		return
+	}
+	if value == nil || describeValue(value) != describeValue(current) {
+		delete(bounds, key)
+		return
+	}
+	if compareExpressionValues(value, current)*order > 0 {
+		bounds[key] = value
+	}
+}
+
+//null counts of a column, or of every column of a nested one, raised by `count`
+func addDeltaNulls(nulls interface{}, count int64) interface{} {
+
+	switch typedNulls := nulls.(type) {
+	case map[string]interface{}:
+		raised := map[string]interface{}{}
+		for key, value := range typedNulls {
+			raised[key] = addDeltaNulls(value, count)
+		}
+		return raised
+	case int:
+		return int64(typedNulls) + count
+	case int64:
+		return typedNulls + count
+	}
+	return nulls
+}
+
+//path of a log file
+func deltaLogPath(table string, version int64, suffix string) string {
+
+	return fmt.Sprintf("%s/%s/%020d%s", table, deltaLogFolder, version, suffix)
+}
+
+//apply an action to the table state
+func (snapshot *deltaTableSnapshot) apply(protocol *deltaProtocol, metaData *deltaMetaData, add *deltaAdd, remove *deltaRemove) {
+
+	if protocol != nil {
+		snapshot.Protocol = protocol
+	}
+	if metaData != nil {
+		snapshot.MetaData = metaData
+	}
+	if add != nil {
+		snapshot.Files[add.Path] = add
+		delete(snapshot.Removed, add.Path)
+	}
+	if remove != nil {
+		delete(snapshot.Files, remove.Path)
+		snapshot.Removed[remove.Path] = remove
+	}
+}
+
+//read the state of a Delta table from its latest checkpoint and the commits after it
+func readDeltaSnapshot(store FileStore, table string) (*deltaTableSnapshot, error) {
+
+	snapshot := &deltaTableSnapshot{Version: -1, Files: map[string]*deltaAdd{}, Removed: map[string]*deltaRemove{}}
+
+	lastCheckpointData, err := store.Get(table + "/" + deltaLogFolder + "/" + deltaLastCheckpoint)
+	if err != nil && err != errFileNotFound {
+		return nil, err
+	}
+
+	if err == nil {
+		var lastCheckpoint deltaLastCheckpointInfo
+		if err = json.Unmarshal(lastCheckpointData, &lastCheckpoint); err != nil {
+			return nil, err
+		}
+
+		checkpointData, err := store.Get(deltaLogPath(table, lastCheckpoint.Version, ".checkpoint.parquet"))
+		if err != nil {
+			return nil, err
+		}
+
+		parquetReader, err := reader.NewParquetReader(buffer.NewBufferFileFromBytes(checkpointData), new(deltaCheckpointRow), 1)
+		if err != nil {
+			return nil, err
+		}
+
+		rows := make([]deltaCheckpointRow, parquetReader.GetNumRows())
+		err = parquetReader.Read(&rows)
+		parquetReader.ReadStop()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, row := range rows {
+			snapshot.apply(row.Protocol, row.MetaData, row.Add, row.Remove)
+		}
+		snapshot.Version = lastCheckpoint.Version
+	}
+
+	//commits after the checkpoint - the log is contiguous, the first missing version ends it
+	for {
+		commitData, err := store.Get(deltaLogPath(table, snapshot.Version+1, ".json"))
+		if err == errFileNotFound {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, line := range bytes.Split(commitData, []byte("\n")) {
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			var action deltaAction
+			if err = json.Unmarshal(line, &action); err != nil {
+				return nil, err
+			}
+			snapshot.apply(action.Protocol, action.MetaData, action.Add, action.Remove)
+		}
+		snapshot.Version++
+	}
+
+	return snapshot, nil
+}
+
+//write a checkpoint of the table state
+func writeDeltaCheckpoint(store FileStore, table string, snapshot *deltaTableSnapshot) error {
+
+	rows := []deltaCheckpointRow{{Protocol: snapshot.Protocol}, {MetaData: snapshot.MetaData}}
+	for _, add := range snapshot.Files {
+		add := *add
+		add.DataChange = false //checkpoints carry state, not changes
+		rows = append(rows, deltaCheckpointRow{Add: &add})
+	}
+	for _, remove := range snapshot.Removed {
+		remove := *remove
+		remove.DataChange = false
+		rows = append(rows, deltaCheckpointRow{Remove: &remove})
+	}
+
+	err := store.Upload(deltaLogPath(table, snapshot.Version, ".checkpoint.parquet"), func(w io.Writer) error {
+		parquetWriter, err := writer.NewParquetWriter(writerfile.NewWriterFile(w), new(deltaCheckpointRow), 1)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err = parquetWriter.Write(row); err != nil {
+				return err
+			}
+		}
+		return parquetWriter.WriteStop()
+	})
+	if err != nil {
+		return err
+	}
+
+	lastCheckpoint, _ := json.Marshal(deltaLastCheckpointInfo{Version: snapshot.Version, Size: int64(len(rows))})
+	return putFile(store, table+"/"+deltaLogFolder+"/"+deltaLastCheckpoint, lastCheckpoint)
+}
+
+//append data files to a Delta table as a new commit, creating the table on first commit
+//callers have to make sure there is a single committer per table
+func commitDeltaTable(store FileStore, table string, dataFiles []TableDataFile, schemas map[string]string) error {
+
+	nowMs := time.Now().UnixNano() / int64(time.Millisecond)
+
+	snapshot, err := readDeltaSnapshot(store, table)
+	if err != nil {
+		return err
+	}
+
+	var actions []deltaAction
+
+	if snapshot.Protocol == nil { //new table
+		actions = append(actions, deltaAction{Protocol: &deltaProtocol{MinReaderVersion: 1, MinWriterVersion: 2}})
+	}
+
+	//schema evolution - columns of every new file are merged into the table schema
+	var currentFields []sparkField
+	if snapshot.MetaData != nil {
+		var currentSchema sparkType
+		if err = json.Unmarshal([]byte(snapshot.MetaData.SchemaString), &currentSchema); err != nil {
+			return err
+		}
+		currentFields = currentSchema.Fields
+	}
+
+	lastColumnId := 0
+	fields := getIcebergFieldsFromSpark(currentFields, &lastColumnId)
+	rejectedSchemas := map[string]bool{}
+
+	for schemaHash, schema := range schemas {
+		var root parquetSchemaNode
+		err = json.Unmarshal([]byte(schema), &root)
+		if err == nil {
+			var mergedFields []icebergField
+			if mergedFields, err = mergeIcebergFields(fields, root.Fields, &lastColumnId); err == nil {
+				fields = mergedFields
+			}
+		}
+		if err != nil { //files that do not fit the table stay out of it instead of blocking every later commit
+			log.Println("Files with schema", schemaHash, "cannot be committed to table", table, err)
+			rejectedSchemas[schemaHash] = true
+		}
+	}
+
+	schemaString, _ := json.Marshal(sparkType{IsStruct: true, Fields: getSparkFields(fields)})
+
+	if snapshot.MetaData == nil || !reflect.DeepEqual(currentFields, getSparkFields(fields)) {
+		metaData := &deltaMetaData{
+			Id:               uuid.New().String(),
+			Format:           deltaFormat{Provider: "parquet", Options: map[string]string{}},
+			SchemaString:     string(schemaString),
+			PartitionColumns: []string{},
+			Configuration:    map[string]string{},
+			CreatedTime:      nowMs,
+		}
+		if snapshot.MetaData != nil { //same table, new schema
+			metaData.Id = snapshot.MetaData.Id
+			metaData.CreatedTime = snapshot.MetaData.CreatedTime
+			metaData.Configuration = snapshot.MetaData.Configuration
+		}
+		actions = append(actions, deltaAction{MetaData: metaData})
+	}
+
+	added := 0
+	for _, dataFile := range dataFiles {
+		if rejectedSchemas[dataFile.SchemaHash] {
+			continue
+		}
+		actions = append(actions, deltaAction{Add: &deltaAdd{
+			Path:             strings.TrimPrefix(dataFile.Path, table+"/"),
+			PartitionValues:  map[string]string{},
+			Size:             dataFile.SizeBytes,
+			ModificationTime: nowMs,
+			DataChange:       true,
+			Stats:            dataFile.Stats,
+		}})
+		added++
+	}
+	if added == 0 {
+		return nil
+	}
+
+	actions = append(actions, deltaAction{CommitInfo: map[string]interface{}{
+		"timestamp":           nowMs,
+		"operation":           "WRITE",
+		"operationParameters": map[string]string{"mode": "Append"},
+		"isBlindAppend":       true,
+		"engineInfo":          "rtdl",
+	}})
+
+	var commit bytes.Buffer
+	for _, action := range actions {
+		line, err := json.Marshal(action)
+		if err != nil {
+			return err
+		}
+		commit.Write(line)
+		commit.WriteString("\n")
+		snapshot.apply(action.Protocol, action.MetaData, action.Add, action.Remove)
+	}
+
+	snapshot.Version++
+	if err = putFile(store, deltaLogPath(table, snapshot.Version, ".json"), commit.Bytes()); err != nil {
+		return err
+	}
+
+	if snapshot.Version > 0 && snapshot.Version%deltaCheckpointInterval == 0 {
+		if err = writeDeltaCheckpoint(store, table, snapshot); err != nil { //commit stands, readers replay the log instead
+			log.Println("Error writing Delta checkpoint", strconv.FormatInt(snapshot.Version, 10), "of table", table, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGenerateDeltaStats(t *testing.T) {
+
+	long := strings.Repeat("x", deltaStatsStringLength+1)
+
+	tests := []struct {
+		name    string
+		records []string
+		want    string
+	}{
+		{
+			name:    "one record",
+			records: []string{`{"n": 2, "s": "b", "ok": true, "user": {"id": "u-1"}}`},
+			want:    `{"numRecords": 1, "minValues": {"n": 2, "s": "b", "user": {"id": "u-1"}}, "maxValues": {"n": 2, "s": "b", "user": {"id": "u-1"}}, "nullCount": {"n": 0, "s": 0, "ok": 0, "user": {"id": 0}}}`,
+		},
+		{
+			name:    "bounds over the records",
+			records: []string{`{"n": 2, "s": "b", "user": {"id": "u-2"}}`, `{"n": 1, "s": "c", "user": {"id": "u-1"}}`, `{"n": 3, "s": "a", "user": {"id": "u-3"}}`},
+			want:    `{"numRecords": 3, "minValues": {"n": 1, "s": "a", "user": {"id": "u-1"}}, "maxValues": {"n": 3, "s": "c", "user": {"id": "u-3"}}, "nullCount": {"n": 0, "s": 0, "user": {"id": 0}}}`,
+		},
+		{
+			name:    "columns some records lack",
+			records: []string{`{"n": 2}`, `{"s": "a", "user": {"id": "u-1", "plan": "pro"}}`, `{"n": 5}`},
+			want:    `{"numRecords": 3, "minValues": {"n": 2, "s": "a", "user": {"id": "u-1", "plan": "pro"}}, "maxValues": {"n": 5, "s": "a", "user": {"id": "u-1", "plan": "pro"}}, "nullCount": {"n": 1, "s": 2, "user": {"id": 2, "plan": 2}}}`,
+		},
+		{
+			name:    "no bounds once a record has none or the type differs",
+			records: []string{`{"s": "a", "v": 1, "o": {"a": 1}}`, `{"s": "` + long + `", "v": "1", "o": 1}`, `{"s": "b", "v": 2, "o": {"a": 2}}`},
+			want:    `{"numRecords": 3, "minValues": {"s": "a"}, "maxValues": {}, "nullCount": {"s": 0, "v": 0}}`,
+		},
+	}
+
+	for _, test := range tests {
+
+		records := make([]map[string]interface{}, len(test.records))
+		for i, record := range test.records {
+			records[i] = decodePayload(t, record)
+		}
+
+		var got, want interface{}
+		json.Unmarshal([]byte(generateDeltaStats(records)), &got)
+		json.Unmarshal([]byte(test.want), &want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: stats %s, want %s", test.name, generateDeltaStats(records), test.want)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+//egress fan-out: each stream lists the Kafka topics its events go to (`egress_targets` on the stream record) and what each topic gets -
+//the event as received, the event as written, or a write receipt once a file has landed - events that fail to write are sent to none of them
+//streams that leave `egress_targets` unset send the written event to the `egress` topic, an empty list sends nothing
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/flink-statefun/statefun-sdk-go/v3/pkg/statefun"
+)
+
+//supported values of a target's `content`
+const (
+	egressContentRaw         = "raw"         //payload as received - masked all the same, PII does not leave rtdl
+	egressContentTransformed = "transformed" //payload as written, after enrichment, masking and transformations
+	egressContentReceipt     = "receipt"     //one message per written file, successful writes only
+)
+
+//egress target as stored on the stream record, e.g.
+//[{"topic": "events-clean", "content": "transformed"}, {"topic": "landed", "content": "receipt"}]
+type EgressTarget struct {
+	Topic   string `json:"topic"`
+	Content string `json:"content"`
+}
+
+//targets of streams that leave `egress_targets` unset, as before egress was configurable
+var defaultEgressTargets = []EgressTarget{{Topic: "egress", Content: egressContentTransformed}}
+
+//egress targets per stream id
+var streamEgressTargets map[string][]EgressTarget
+
+//receipt of a file written for a stream
+type WriteReceipt struct {
+	StreamId      string    `json:"stream_id"`
+	MessageType   string    `json:"message_type,omitempty"`
+	Path          string    `json:"path"`      //relative to the store root
+	Partition     string    `json:"partition"` //folder of the partition relative to the store root
+	Rows          int64     `json:"rows"`
+	SizeBytes     int64     `json:"size_bytes"`
+	SchemaHash    string    `json:"schema_hash"`
+	Table         string    `json:"table,omitempty"`
+	CommittedAt   time.Time `json:"committed_at"`
+	PostgresTable string    `json:"postgres_table,omitempty"` //rows are queryable there once the receipt is sent
+}
+
+//parse and check the `egress_targets` of a stream
+func compileEgressTargets(egressTargets string) ([]EgressTarget, error) {
+
+	var targets []EgressTarget
+	if err := json.Unmarshal([]byte(egressTargets), &targets); err != nil {
+		return nil, errors.New("egress targets have to be a JSON array of targets: " + err.Error())
+	}
+
+	for i, target := range targets {
+
+		targetName := "target " + strconv.Itoa(i+1)
+
+		if strings.TrimSpace(target.Topic) == "" {
+			return nil, errors.New(targetName + ": `topic` is required")
+		}
+		switch target.Content {
+		case egressContentRaw, egressContentTransformed, egressContentReceipt:
+		default:
+			return nil, errors.New(targetName + ": `content` has to be one of raw, transformed, receipt")
+		}
+	}
+
+	return targets, nil
+}
+
+//egress targets of every stream, streams whose targets do not parse send nothing rather than to topics nobody asked for
+func loadEgressTargets(streams []Config) map[string][]EgressTarget {
+
+	targets := map[string][]EgressTarget{}
+
+	for _, configRecord := range streams {
+
+		if strings.TrimSpace(configRecord.EgressTargets.String) == "" {
+			targets[configRecord.StreamId.String] = defaultEgressTargets
+			continue
+		}
+
+		streamTargets, err := compileEgressTargets(configRecord.EgressTargets.String)
+		if err != nil {
+			log.Println("Invalid egress targets of stream", configRecord.StreamId.String, err)
+			streamTargets = nil
+		}
+		targets[configRecord.StreamId.String] = streamTargets
+	}
+
+	return targets
+}
+
+//egress targets of a stream, the defaults for messages no stream matched
+func getEgressTargets(streamId string) []EgressTarget {
+
+	targets, found := streamEgressTargets[streamId]
+	if !found {
+		return defaultEgressTargets
+	}
+	return targets
+}
+
+//true when a stream sends events as received, their payload then has to be kept aside before it is changed
+func hasRawEgress(streamId string) bool {
+
+	for _, target := range getEgressTargets(streamId) {
+		if target.Content == egressContentRaw {
+			return true
+		}
+	}
+	return false
+}
+
+//copy of a payload that later changes to it do not reach
+func copyPayload(payload map[string]interface{}) map[string]interface{} {
+
+	encoded, _ := json.Marshal(payload)
+
+	var copied map[string]interface{}
+	json.Unmarshal(encoded, &copied)
+	return copied
+}
+
+//send an event to the raw and transformed egress targets of its stream, once it has been written
+//events are keyed like the ingress, so consumers see the events of an ingest instance in order
+func sendEventEgress(ctx statefun.Context, streamId string, rawPayload map[string]interface{}, payload map[string]interface{}) {
+
+	for _, target := range getEgressTargets(streamId) {
+
+		var value []byte
+		switch target.Content {
+		case egressContentRaw:
+			value, _ = json.Marshal(rawPayload)
+		case egressContentTransformed:
+			value, _ = json.Marshal(payload) //convert generic payload structure to JSON string
+		default:
+			continue
+		}
+
+		ctx.SendEgress(statefun.KafkaEgressBuilder{
+			Target: KafkaEgressTypeName,
+			Topic:  target.Topic,
+			Key:    ctx.Self().Id,
+			Value:  value,
+		})
+	}
+}
+
+//send the receipt of a written file to the receipt egress targets of its stream
+func sendWriteReceipts(ctx statefun.Context, committedFile *CommittedFile) {
+
+	var receipt []byte
+
+	for _, target := range getEgressTargets(committedFile.StreamId) {
+
+		if target.Content != egressContentReceipt {
+			continue
+		}
+
+		if receipt == nil {
+			receipt, _ = json.Marshal(WriteReceipt{
+				StreamId:      committedFile.StreamId,
+				MessageType:   committedFile.MessageType,
+				Path:          committedFile.Partition + "/" + committedFile.File,
+				Partition:     committedFile.Partition,
+				Rows:          committedFile.Rows,
+				SizeBytes:     committedFile.SizeBytes,
+				SchemaHash:    committedFile.SchemaHash,
+				Table:         committedFile.Table,
+				CommittedAt:   committedFile.CommittedAt,
+				PostgresTable: committedFile.PostgresTable,
+			})
+		}
+
+		ctx.SendEgress(statefun.KafkaEgressBuilder{
+			Target: KafkaEgressTypeName,
+			Topic:  target.Topic,
+			Key:    committedFile.StreamId,
+			Value:  receipt,
+		})
+	}
+}
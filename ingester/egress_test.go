@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/apache/flink-statefun/statefun-sdk-go/v3/pkg/statefun"
+)
+
+func TestIngestEgressAfterWrite(t *testing.T) {
+
+	defer func(streams []Config) { configs = streams }(configs)
+	defer useLocalStore(t)()
+
+	configs = []Config{newLocalTableConfig("stream")}
+	ctx := newTestContext(statefun.Address{FunctionType: IngestTypeName, Id: "ingest"}, nil)
+	message := newTestMessage(t, IncomingMessage{StreamId: "stream", Payload: decodePayload(t, `{"userId": "u-1"}`)}, IncomingMessageType)
+
+	//a regular file where the local store wants its folder, the write fails
+	if err := ioutil.WriteFile("datastore", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Ingest(ctx, message); err != nil {
+		t.Fatal(err)
+	}
+	if len(ctx.egress) != 0 {
+		t.Errorf("event that failed to write sent to %d egress targets, want none", len(ctx.egress))
+	}
+
+	os.Remove("datastore")
+	ctx.reset()
+	if err := Ingest(ctx, message); err != nil {
+		t.Fatal(err)
+	}
+	if len(ctx.egress) != 1 {
+		t.Errorf("written event sent to %d egress targets, want the default target", len(ctx.egress))
+	}
+}
@@ -0,0 +1,248 @@
+//enrichment: streams with `enrichment_enabled` get a `geo` object looked up from the client IP and a `user_agent` object parsed from the user agent
+//GeoIP lookups use the MaxMind DB file at `GEOIP_DATABASE_PATH`, which is reloaded whenever the file changes
+
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//GeoIP database, a City or Country database in MaxMind DB format
+var geoIPDatabasePath = GetEnv("GEOIP_DATABASE_PATH", "/geoip/GeoLite2-City.mmdb")
+
+//how often the database file is checked for changes
+var geoIPReloadInterval = getEnvDuration("GEOIP_RELOAD_INTERVAL", time.Minute)
+
+//currently loaded GeoIP database, a *geoIPDatabaseFile - nil until a file could be read
+var geoIPDatabase atomic.Value
+
+//a loaded GeoIP database with the modification time of its file, replaced as a whole on reload
+type geoIPDatabaseFile struct {
+	reader  *mmdbReader
+	modTime time.Time
+}
+
+//payload fields holding the client IP and user agent, Segment's `context` first
+var (
+	ipPaths        = []string{"context.ip", "ip"}
+	userAgentPaths = []string{"context.userAgent", "userAgent"}
+)
+
+//user agent detection, in order - the first match wins, so more specific agents come first
+var browserPatterns = []struct {
+	name    string
+	pattern *regexp.Regexp
+}{
+	{"Edge", regexp.MustCompile(`(?:Edge|Edg|EdgA|EdgiOS)/([\d.]+)`)},
+	{"Opera", regexp.MustCompile(`(?:OPR|Opera)/([\d.]+)`)},
+	{"Samsung Internet", regexp.MustCompile(`SamsungBrowser/([\d.]+)`)},
+	{"Firefox", regexp.MustCompile(`(?:Firefox|FxiOS)/([\d.]+)`)},
+	{"Chrome", regexp.MustCompile(`(?:Chrome|CriOS|Chromium)/([\d.]+)`)},
+	{"Safari", regexp.MustCompile(`Version/([\d.]+).*Safari/`)},
+	{"Internet Explorer", regexp.MustCompile(`(?:MSIE |Trident/.*rv:)([\d.]+)`)},
+}
+
+var osPatterns = []struct {
+	name    string
+	pattern *regexp.Regexp
+}{
+	{"Windows", regexp.MustCompile(`Windows NT ([\d.]+)`)},
+	{"iOS", regexp.MustCompile(`(?:iPhone|CPU) OS ([\d_]+)`)},
+	{"macOS", regexp.MustCompile(`Mac OS X ([\d_.]+)`)},
+	{"Android", regexp.MustCompile(`Android ([\d.]+)`)},
+	{"Chrome OS", regexp.MustCompile(`CrOS \S+ ([\d.]+)`)},
+	{"Linux", regexp.MustCompile(`Linux()`)},
+}
+
+//marketing names of Windows NT versions
+var windowsVersions = map[string]string{
+	"10.0": "10",
+	"6.3":  "8.1",
+	"6.2":  "8",
+	"6.1":  "7",
+	"6.0":  "Vista",
+	"5.1":  "XP",
+}
+
+var botPattern = regexp.MustCompile(`(?i)bot|crawler|spider|slurp|headless`)
+
+//true when the events of a stream are enriched
+func isEnriched(configRecord Config) bool {
+	return configRecord.EnrichmentEnabled.Bool
+}
+
+//load the GeoIP database when the file has changed since the last load, the loaded database is kept when the new file cannot be read
+func loadGeoIPDatabase() {
+
+	info, err := os.Stat(geoIPDatabasePath)
+	if err != nil {
+		if geoIPDatabase.Load() == nil {
+			log.Println("GeoIP database not available, events are enriched without geo fields: ", err)
+		}
+		return
+	}
+	if loaded, _ := geoIPDatabase.Load().(*geoIPDatabaseFile); loaded != nil && info.ModTime().Equal(loaded.modTime) {
+		return
+	}
+
+	reader, err := openMMDB(geoIPDatabasePath)
+	if err != nil {
+		log.Println("Failed to load GeoIP database "+geoIPDatabasePath+": ", err)
+		return
+	}
+
+	geoIPDatabase.Store(&geoIPDatabaseFile{reader: reader, modTime: info.ModTime()})
+	log.Println("GeoIP database loaded:", reader.databaseType)
+}
+
+//background job picking up new GeoIP database files
+func runGeoIPReload() {
+
+	loadGeoIPDatabase()
+
+	ticker := time.NewTicker(geoIPReloadInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		loadGeoIPDatabase()
+	}
+}
+
+//English name of a MaxMind record entry
+func getMMDBName(record map[string]interface{}, key string) string {
+
+	name, _ := getPayloadValue(record, []string{key, "names", "en"}).(string)
+	return name
+}
+
+//geo fields of an IP address, nil when the address is unknown or no database is loaded
+func lookupGeoIP(address string) map[string]interface{} {
+
+	loaded, _ := geoIPDatabase.Load().(*geoIPDatabaseFile)
+	ip := net.ParseIP(strings.TrimSpace(address))
+	if loaded == nil || ip == nil {
+		return nil
+	}
+
+	value, err := loaded.reader.lookup(ip)
+	if err != nil {
+		log.Println("Error looking up IP address in GeoIP database", err)
+		return nil
+	}
+	record, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	geo := map[string]interface{}{}
+
+	if countryCode, ok := getPayloadValue(record, []string{"country", "iso_code"}).(string); ok {
+		geo["country_code"] = countryCode
+	}
+	if country := getMMDBName(record, "country"); country != "" {
+		geo["country"] = country
+	}
+	if subdivisions, ok := record["subdivisions"].([]interface{}); ok && len(subdivisions) > 0 {
+		if subdivision, ok := subdivisions[0].(map[string]interface{}); ok {
+			if region, ok := getPayloadValue(subdivision, []string{"names", "en"}).(string); ok {
+				geo["region"] = region
+			}
+		}
+	}
+	if city := getMMDBName(record, "city"); city != "" {
+		geo["city"] = city
+	}
+	if postalCode, ok := getPayloadValue(record, []string{"postal", "code"}).(string); ok {
+		geo["postal_code"] = postalCode
+	}
+	if latitude, ok := getPayloadValue(record, []string{"location", "latitude"}).(float64); ok {
+		geo["latitude"] = latitude
+	}
+	if longitude, ok := getPayloadValue(record, []string{"location", "longitude"}).(float64); ok {
+		geo["longitude"] = longitude
+	}
+	if timeZone, ok := getPayloadValue(record, []string{"location", "time_zone"}).(string); ok {
+		geo["time_zone"] = timeZone
+	}
+
+	if len(geo) == 0 {
+		return nil
+	}
+	return geo
+}
+
+//browser, operating system and device type of a user agent
+func parseUserAgent(userAgent string) map[string]interface{} {
+
+	parsed := map[string]interface{}{
+		"browser":     "Other",
+		"os":          "Other",
+		"device_type": "desktop",
+	}
+
+	for _, browser := range browserPatterns {
+		if match := browser.pattern.FindStringSubmatch(userAgent); match != nil {
+			parsed["browser"] = browser.name
+			parsed["browser_version"] = match[1]
+			break
+		}
+	}
+
+	for _, system := range osPatterns {
+		if match := system.pattern.FindStringSubmatch(userAgent); match != nil {
+			parsed["os"] = system.name
+			version := strings.ReplaceAll(match[1], "_", ".")
+			if system.name == "Windows" && windowsVersions[version] != "" {
+				version = windowsVersions[version]
+			}
+			if version != "" {
+				parsed["os_version"] = version
+			}
+			break
+		}
+	}
+
+	switch {
+	case botPattern.MatchString(userAgent):
+		parsed["device_type"] = "bot"
+	case strings.Contains(userAgent, "iPad") || strings.Contains(userAgent, "Tablet") || (strings.Contains(userAgent, "Android") && !strings.Contains(userAgent, "Mobile")):
+		parsed["device_type"] = "tablet"
+	case strings.Contains(userAgent, "Mobi") || strings.Contains(userAgent, "iPhone") || strings.Contains(userAgent, "Android"):
+		parsed["device_type"] = "mobile"
+	}
+
+	return parsed
+}
+
+//add `geo` and `user_agent` to the payload of an event, fields already present are kept
+//the client IP and user agent seen by the ingest service stand in for payloads without them
+func enrichPayload(payload map[string]interface{}, clientIp string, clientUserAgent string) {
+
+	if _, found := payload["geo"]; !found {
+		address := getPayloadString(payload, ipPaths...)
+		if address == "" {
+			address = clientIp
+		}
+		if address != "" {
+			if geo := lookupGeoIP(address); geo != nil {
+				payload["geo"] = geo
+			}
+		}
+	}
+
+	if _, found := payload["user_agent"]; !found {
+		userAgent := getPayloadString(payload, userAgentPaths...)
+		if userAgent == "" {
+			userAgent = clientUserAgent
+		}
+		if userAgent != "" {
+			payload["user_agent"] = parseUserAgent(userAgent)
+		}
+	}
+}
@@ -0,0 +1,427 @@
+//subject erasure (GDPR/CCPA): rows whose subject field holds one of the requested values are removed from every partition of a stream
+//each partition instance rewrites its own files, so a rewrite never races a manifest flush or a compaction of the partition
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/apache/flink-statefun/statefun-sdk-go/v3/pkg/statefun"
+	"github.com/jmoiron/sqlx"
+)
+
+//internal message asking the stateful function to run an erasure job created by the config service
+const erasureControlMessageType = "rtdl_207"
+
+//status of an erasure job and of its partitions
+const (
+	erasureStatusRunning   = "running"
+	erasureStatusCompleted = "completed"
+	erasureStatusFailed    = "failed"
+)
+
+//erasure job as handed to the partition instances
+type ErasureRequest struct {
+	JobId         int64         `json:"erasure_job_id"`
+	SubjectField  string        `json:"subject_field"` //dotted path into the payload
+	SubjectValues []interface{} `json:"subject_values"`
+}
+
+//outcome of an erasure job in one partition
+type erasureResult struct {
+	FilesScanned   int
+	FilesRewritten int
+	FilesDeleted   int
+	RowsDeleted    int64
+	Errors         []string
+}
+
+//string form of a subject value, false for values that cannot identify a subject
+func formatSubjectValue(value interface{}) (string, bool) {
+
+	switch typedValue := value.(type) {
+	case string:
+		return typedValue, true
+	case float64:
+		return strconv.FormatFloat(typedValue, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(typedValue), true
+	}
+	return "", false
+}
+
+//start an erasure job: hand it over to every partition folder of the stream
+func requestErasure(ctx statefun.Context, request IncomingMessage) error {
+
+	var erasure ErasureRequest
+	payload, _ := json.Marshal(request.Payload)
+	if err := json.Unmarshal(payload, &erasure); err != nil || erasure.JobId == 0 {
+		log.Println("Invalid erasure request", err)
+		return nil
+	}
+
+	configRecord, found := findStreamConfig(request.StreamId)
+	if !found {
+		return failErasureJob(erasure.JobId, "no configuration found for stream "+request.StreamId)
+	}
+
+	if usesTableFormat(configRecord) { //rewritten data files would no longer match the table metadata
+		return failErasureJob(erasure.JobId, "table format streams have to be erased through the table format")
+	}
+
+	if getFileFormatName(configRecord) != fileFormatParquet { //only Parquet files are rewritten, every partition would fail
+		return failErasureJob(erasure.JobId, "only streams written as Parquet can be erased, stream writes "+getFileFormatName(configRecord))
+	}
+
+	if erasure.SubjectField == "" || len(erasure.SubjectValues) == 0 {
+		return failErasureJob(erasure.JobId, "subject field and values are required")
+	}
+
+	store, err := newFileStore(getFileStoreTypeName(configRecord), configRecord)
+	if err != nil {
+		log.Println("Error opening file store", err)
+		return err
+	}
+	defer store.Close()
+
+	partitions, err := findErasurePartitions(store, configRecord)
+	if err != nil {
+		log.Println("Error listing partitions of stream", request.StreamId, err)
+		return err
+	}
+
+	if hasPostgresSink(configRecord) { //rows of the subjects are in the tables of the stream's message types as well
+		rowsDeleted, err := ErasePostgresRows(getPartitionMessageTypes(partitions, configRecord), erasure, configRecord)
+		if err != nil {
+			return failErasureJob(erasure.JobId, "erasing Postgres rows: "+err.Error())
+		}
+		log.Println("Erasure job", erasure.JobId, "deleted", rowsDeleted, "rows from the Postgres tables of stream", request.StreamId)
+	}
+
+	started, err := startErasureJob(erasure.JobId, len(partitions))
+	if err != nil {
+		log.Println("Error starting erasure job", erasure.JobId, err)
+		return err
+	}
+	if !started { //redelivered request, the partitions already have the job
+		return nil
+	}
+
+	for _, partition := range partitions {
+		ctx.Send(statefun.MessageBuilder{
+			Target:    statefun.Address{FunctionType: PartitionTypeName, Id: request.StreamId + "/" + partition},
+			Value:     PartitionMessage{Action: partitionActionErase, Erasure: &erasure},
+			ValueType: PartitionMessageType,
+		})
+	}
+
+	log.Println("Erasure job", erasure.JobId, "started on", len(partitions), "partitions of stream", request.StreamId)
+
+	return nil
+}
+
+//partitions of a stream an erasure job has to go through, partitions written before manifests existed included
+//files are expected at [folder/]<message type>/<partition>/<file name>, anything else is left alone
+//only partitions that belong to the stream are taken, see belongsToStream
+func findErasurePartitions(store FileStore, configRecord Config) ([]string, error) {
+
+	prefix := ""
+	if configRecord.FolderName.String != "" {
+		prefix = configRecord.FolderName.String + "/"
+	}
+
+	storedFiles, err := store.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	partitionFiles := map[string][]StoredFile{}
+	for _, storedFile := range storedFiles {
+		parts := strings.Split(strings.TrimPrefix(storedFile.Path, prefix), "/")
+		if len(parts) != 3 {
+			continue
+		}
+		path := prefix + parts[0] + "/" + parts[1]
+		partitionFiles[path] = append(partitionFiles[path], storedFile)
+	}
+
+	partitions := make([]string, 0, len(partitionFiles))
+	for path, files := range partitionFiles {
+		if belongsToStream(store, path, files, configRecord) {
+			partitions = append(partitions, path)
+		}
+	}
+	sort.Strings(partitions)
+
+	return partitions, nil
+}
+
+//message types of the partitions of a stream, the first folder below the stream's folder
+func getPartitionMessageTypes(partitions []string, configRecord Config) []string {
+
+	prefix := ""
+	if configRecord.FolderName.String != "" {
+		prefix = configRecord.FolderName.String + "/"
+	}
+
+	var messageTypes []string
+	found := map[string]bool{}
+	for _, partition := range partitions {
+		messageType := strings.Split(strings.TrimPrefix(partition, prefix), "/")[0]
+		if !found[messageType] {
+			found[messageType] = true
+			messageTypes = append(messageTypes, messageType)
+		}
+	}
+
+	return messageTypes
+}
+
+//remove the rows of the subjects from the files of a partition and update its manifest, when it has one
+//files left without rows are deleted, all others are rewritten under their own name
+func erasePartition(manifest *PartitionManifest, partition string, configRecord Config, erasure ErasureRequest) erasureResult {
+
+	var result erasureResult
+
+	if manifest.StreamId != "" && manifest.StreamId != configRecord.StreamId.String {
+		result.Errors = append(result.Errors, "partition belongs to stream "+manifest.StreamId)
+		return result
+	}
+
+	store, err := newFileStore(getFileStoreTypeName(configRecord), configRecord)
+	if err != nil {
+		result.Errors = append(result.Errors, err.Error())
+		return result
+	}
+	defer store.Close()
+
+	storedFiles, err := store.List(partition + "/")
+	if err != nil {
+		result.Errors = append(result.Errors, err.Error())
+		return result
+	}
+
+	subjects := map[string]bool{}
+	for _, value := range erasure.SubjectValues {
+		if subject, ok := formatSubjectValue(value); ok {
+			subjects[subject] = true
+		}
+	}
+	subjectPath := strings.Split(erasure.SubjectField, ".")
+
+	rewritten := map[string]ManifestEntry{} //file name to its new manifest entry
+	var emptiedFiles []string
+
+	for _, storedFile := range storedFiles {
+
+		fileName := strings.TrimPrefix(storedFile.Path, partition+"/")
+		if strings.Contains(fileName, "/") || strings.HasPrefix(fileName, "_") || strings.HasPrefix(fileName, ".") {
+			continue //nested folders are partitions of their own, manifests, markers and staged files hold no rows
+		}
+
+		if !strings.HasSuffix(fileName, getFileExtension(fileFormatParquet)) {
+			result.Errors = append(result.Errors, "cannot erase from "+fileName+": only Parquet files can be rewritten")
+			continue
+		}
+
+		result.FilesScanned++
+
+		data, err := store.Get(storedFile.Path)
+		if err != nil {
+			result.Errors = append(result.Errors, "reading "+fileName+": "+err.Error())
+			continue
+		}
+
+		schemaHandler, rows, err := readParquetRows([][]byte{data})
+		if err != nil {
+			result.Errors = append(result.Errors, "reading "+fileName+": "+err.Error())
+			continue
+		}
+
+		fieldPath := getRowFieldPath(schemaHandler, subjectPath)
+		if fieldPath == nil {
+			continue //subject field not in the schema of the file
+		}
+
+		var keptRows []interface{}
+		for _, row := range rows {
+			if subject, ok := formatSubjectValue(getRowValue(row, fieldPath)); ok && subjects[subject] {
+				continue
+			}
+			keptRows = append(keptRows, row)
+		}
+
+		deletedRows := int64(len(rows) - len(keptRows))
+		if deletedRows == 0 {
+			continue
+		}
+
+		if len(keptRows) == 0 {
+			emptiedFiles = append(emptiedFiles, fileName)
+		} else {
+			size, err := writeParquetRows(store, storedFile.Path, schemaHandler, keptRows, configRecord)
+			if err != nil {
+				result.Errors = append(result.Errors, "rewriting "+fileName+": "+err.Error())
+				continue
+			}
+			rewritten[fileName] = ManifestEntry{Rows: int64(len(keptRows)), SizeBytes: size}
+			result.FilesRewritten++
+		}
+
+		result.RowsDeleted += deletedRows
+	}
+
+	if len(rewritten) == 0 && len(emptiedFiles) == 0 {
+		return result
+	}
+
+	if manifest.StreamId != "" { //files removed from the manifest before they are deleted, as in compaction
+
+		var files []ManifestEntry
+		for _, entry := range manifest.Files {
+			if newEntry, found := rewritten[entry.File]; found {
+				entry.Rows = newEntry.Rows
+				entry.SizeBytes = newEntry.SizeBytes
+			}
+			if !containsString(emptiedFiles, entry.File) {
+				files = append(files, entry)
+			}
+		}
+		manifest.Files = files
+
+		manifestData, _ := json.MarshalIndent(manifest, "", "  ")
+		if err := putFile(store, partition+"/"+manifestFileName, manifestData); err != nil {
+			result.Errors = append(result.Errors, "writing manifest: "+err.Error())
+			return result //emptied files stay until the job is repeated
+		}
+	}
+
+	for _, fileName := range emptiedFiles {
+		if err := store.Delete(partition + "/" + fileName); err != nil {
+			result.Errors = append(result.Errors, "deleting "+fileName+": "+err.Error())
+			continue
+		}
+		result.FilesDeleted++
+	}
+
+	//queries must not plan against rewritten or deleted files
+	messageType := strings.Split(strings.TrimPrefix(partition, configRecord.FolderName.String+"/"), "/")[0]
+	if err := refreshDremioDataset(messageType, getFileStoreTypeName(configRecord), configRecord); err != nil {
+		log.Println("Error refreshing Dremio dataset", messageType, "of stream", configRecord.StreamId.String, err)
+	}
+
+	return result
+}
+
+func containsString(values []string, value string) bool {
+
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+//mark an erasure job as running on its partitions, false when it has been started before
+//a job without partitions is complete right away
+func startErasureJob(jobId int64, partitions int) (bool, error) {
+
+	db, err := sqlx.Open("postgres", psqlCon)
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+
+	status := erasureStatusRunning
+	if partitions == 0 {
+		status = erasureStatusCompleted
+	}
+
+	result, err := db.Exec(`UPDATE erasure_jobs
+		SET status = $2, partitions_total = $3, started_at = NOW(), finished_at = CASE WHEN $3 = 0 THEN NOW() END
+		WHERE erasure_job_id = $1 AND status = 'requested'`,
+		jobId, status, partitions)
+	if err != nil {
+		return false, err
+	}
+
+	updated, err := result.RowsAffected()
+	return updated > 0, err
+}
+
+//mark an erasure job as failed before it reached any partition
+func failErasureJob(jobId int64, errorMessage string) error {
+
+	log.Println("Erasure job", jobId, "failed:", errorMessage)
+
+	db, err := sqlx.Open("postgres", psqlCon)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec("SELECT * FROM failErasureJob($1, $2)", jobId, errorMessage)
+	return err
+}
+
+//record the outcome of a partition and add it to the totals of the job
+//the job completes with its last partition, as failed when any partition failed
+func recordErasurePartition(jobId int64, partition string, result erasureResult) error {
+
+	db, err := sqlx.Open("postgres", psqlCon)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	status := erasureStatusCompleted
+	failed := 0
+	var errorMessage *string
+	if len(result.Errors) > 0 {
+		status = erasureStatusFailed
+		failed = 1
+		joined := strings.Join(result.Errors, "; ")
+		errorMessage = &joined
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO erasure_job_partitions (erasure_job_id, partition_path, status, files_scanned, files_rewritten, files_deleted, rows_deleted, error_message)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		jobId, partition, status, result.FilesScanned, result.FilesRewritten, result.FilesDeleted, result.RowsDeleted, errorMessage)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE erasure_jobs
+		SET partitions_done = partitions_done + 1,
+			partitions_failed = partitions_failed + $2,
+			files_scanned = files_scanned + $3,
+			files_rewritten = files_rewritten + $4,
+			files_deleted = files_deleted + $5,
+			rows_deleted = rows_deleted + $6,
+			status = CASE WHEN partitions_done + 1 < partitions_total THEN status WHEN partitions_failed + $2 > 0 THEN $7 ELSE $8 END,
+			finished_at = CASE WHEN partitions_done + 1 < partitions_total THEN finished_at ELSE NOW() END
+		WHERE erasure_job_id = $1`,
+		jobId, failed, result.FilesScanned, result.FilesRewritten, result.FilesDeleted, result.RowsDeleted, erasureStatusFailed, erasureStatusCompleted)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.New("committing erasure of partition " + partition + ": " + err.Error())
+	}
+
+	return nil
+}
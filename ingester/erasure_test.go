@@ -0,0 +1,200 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+//put files into a store, manifests naming a stream for the paths given one
+func putTestFiles(t *testing.T, store FileStore, files map[string]string) {
+
+	t.Helper()
+
+	for path, content := range files {
+		if err := putFile(store, path, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+//manifest of a partition as the partition function writes it
+func newTestManifest(t *testing.T, streamId string, partition string, files ...ManifestEntry) string {
+
+	t.Helper()
+
+	data, err := json.Marshal(PartitionManifest{StreamId: streamId, Partition: partition, Files: files})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+//Parquet file of JSON payloads, as the stream writes it
+func putTestParquet(t *testing.T, store FileStore, path string, configRecord Config, payloads ...string) {
+
+	t.Helper()
+
+	decoded := make([]map[string]interface{}, len(payloads))
+	for i, payload := range payloads {
+		decoded[i] = decodePayload(t, payload)
+	}
+	schema, records := generateRecordsSchema(decoded, "track")
+
+	encoded := make([][]byte, len(records))
+	for i, record := range records {
+		encoded[i], _ = json.Marshal(record)
+	}
+	if _, err := WriteFileToStore(store, path, schema, encoded, configRecord); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//values of a field in the rows of a Parquet file
+func readTestParquetValues(t *testing.T, store FileStore, path string, field string) []interface{} {
+
+	t.Helper()
+
+	data, err := store.Get(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schemaHandler, rows, err := readParquetRows([][]byte{data})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fieldPath := getRowFieldPath(schemaHandler, []string{field})
+	values := make([]interface{}, len(rows))
+	for i, row := range rows {
+		values[i] = getRowValue(row, fieldPath)
+	}
+	return values
+}
+
+//paths of the files in a store
+func listTestFiles(t *testing.T, store FileStore, prefix string) []string {
+
+	t.Helper()
+
+	storedFiles, err := store.List(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := make([]string, len(storedFiles))
+	for i, storedFile := range storedFiles {
+		paths[i] = storedFile.Path
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestFindErasurePartitions(t *testing.T) {
+
+	defer useLocalStore(t)()
+
+	configRecord := newLocalTableConfig("stream")
+	store, err := newFileStore("Local", configRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	putTestFiles(t, store, map[string]string{
+		"track/2022-04-15/a.parquet":             "",
+		"track/2022-04-15/" + manifestFileName:   newTestManifest(t, "stream", "track/2022-04-15"),
+		"page/2022-04-15/b.parquet":              "",
+		"page/2022-04-15/" + manifestFileName:    newTestManifest(t, "other", "page/2022-04-15"),
+		"track/2022-04-14/c.parquet":             "", //written before manifests, nothing tells whose it is
+		"loose.parquet":                          "",
+		"s/track/2022-04-15/d.parquet":           "",
+		"s/track/2022-04-16/e.parquet":           "",
+		"s/track/2022-04-16/" + manifestFileName: newTestManifest(t, "other", "s/track/2022-04-16"),
+		"s/track/2022-04-16/nested/f.parquet":    "",
+	})
+
+	//without a folder the store is shared, only partitions whose manifest names the stream are its own
+	partitions, err := findErasurePartitions(store, configRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"track/2022-04-15"}; !reflect.DeepEqual(partitions, want) {
+		t.Errorf("partitions without a folder %v, want %v", partitions, want)
+	}
+
+	//the folder of a stream is its own, partitions without a manifest included
+	configRecord.FolderName = sql.NullString{String: "s", Valid: true}
+	partitions, err = findErasurePartitions(store, configRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"s/track/2022-04-15"}; !reflect.DeepEqual(partitions, want) {
+		t.Errorf("partitions in the folder %v, want %v", partitions, want)
+	}
+
+	//a partition instance does not touch a partition of another stream
+	manifest := PartitionManifest{StreamId: "other"}
+	if result := erasePartition(&manifest, "page/2022-04-15", newLocalTableConfig("stream"), ErasureRequest{SubjectField: "userId", SubjectValues: []interface{}{"u-1"}}); len(result.Errors) == 0 || result.FilesScanned != 0 {
+		t.Errorf("erasure of another stream's partition gave %+v, want an error", result)
+	}
+}
+
+func TestErasePartition(t *testing.T) {
+
+	defer useLocalStore(t)()
+
+	configRecord := Config{
+		StreamId:        sql.NullString{String: "stream", Valid: true},
+		FileStoreTypeId: sql.NullInt64{Int64: 1, Valid: true},
+	}
+	store, err := newFileStore("Local", configRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	partition := "track/2022-04-15"
+	putTestParquet(t, store, partition+"/a.parquet", configRecord, `{"userId": "u-1", "page": "/"}`, `{"userId": "u-2", "page": "/"}`)
+	putTestParquet(t, store, partition+"/b.parquet", configRecord, `{"userId": "u-1", "page": "/pricing"}`)
+	putTestParquet(t, store, partition+"/c.parquet", configRecord, `{"userId": "u-3", "page": "/"}`)
+	putTestParquet(t, store, "track/2022-04-16/d.parquet", configRecord, `{"userId": "u-1", "page": "/"}`)
+
+	manifest := PartitionManifest{StreamId: "stream", Partition: partition, Files: []ManifestEntry{
+		{File: "a.parquet", Rows: 2}, {File: "b.parquet", Rows: 1}, {File: "c.parquet", Rows: 1},
+	}}
+	putTestFiles(t, store, map[string]string{partition + "/" + manifestFileName: newTestManifest(t, "stream", partition, manifest.Files...)})
+
+	result := erasePartition(&manifest, partition, configRecord, ErasureRequest{SubjectField: "userId", SubjectValues: []interface{}{"u-1"}})
+	if len(result.Errors) != 0 {
+		t.Fatal(result.Errors)
+	}
+	if result.FilesScanned != 3 || result.FilesRewritten != 1 || result.FilesDeleted != 1 || result.RowsDeleted != 2 {
+		t.Errorf("erasure result %+v, want 3 files scanned, 1 rewritten, 1 deleted and 2 rows deleted", result)
+	}
+
+	//the emptied file is gone, the partition of another day is not touched
+	want := []string{partition + "/" + manifestFileName, partition + "/a.parquet", partition + "/c.parquet", "track/2022-04-16/d.parquet"}
+	if paths := listTestFiles(t, store, ""); !reflect.DeepEqual(paths, want) {
+		t.Errorf("files after erasure %v, want %v", paths, want)
+	}
+	if values := readTestParquetValues(t, store, partition+"/a.parquet", "userId"); !reflect.DeepEqual(values, []interface{}{"u-2"}) {
+		t.Errorf("rows of the rewritten file %v, want [u-2]", values)
+	}
+	if values := readTestParquetValues(t, store, "track/2022-04-16/d.parquet", "userId"); !reflect.DeepEqual(values, []interface{}{"u-1"}) {
+		t.Errorf("rows of the other partition %v, want [u-1]", values)
+	}
+
+	data, err := store.Get(partition + "/" + manifestFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored PartitionManifest
+	if err := json.Unmarshal(data, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Files) != 2 || stored.Files[0].File != "a.parquet" || stored.Files[0].Rows != 1 || stored.Files[1].File != "c.parquet" {
+		t.Errorf("manifest after erasure %+v, want a.parquet with 1 row and c.parquet", stored.Files)
+	}
+}
@@ -0,0 +1,646 @@
+//expression language of computed fields, e.g. "price * quantity", "lower(customer.email)" or "if(amount > 100, 'large', 'small')"
+//fields are referenced by dotted path, missing fields are null and null operands give null
+
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+//parsed expression, evaluated against a payload
+type expressionNode interface {
+	eval(payload map[string]interface{}) (interface{}, error)
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+type fieldNode struct {
+	path []string
+}
+
+type unaryNode struct {
+	operator string
+	operand  expressionNode
+}
+
+type binaryNode struct {
+	operator string
+	left     expressionNode
+	right    expressionNode
+}
+
+type callNode struct {
+	name string
+	fn   expressionFunction
+	args []expressionNode
+}
+
+//built-in function with its accepted argument count, maxArgs < 0 for any number
+type expressionFunction struct {
+	minArgs int
+	maxArgs int
+	call    func(args []interface{}) (interface{}, error)
+}
+
+var expressionFunctions = map[string]expressionFunction{
+	"lower":     {1, 1, func(args []interface{}) (interface{}, error) { return mapString(args[0], strings.ToLower) }},
+	"upper":     {1, 1, func(args []interface{}) (interface{}, error) { return mapString(args[0], strings.ToUpper) }},
+	"trim":      {1, 1, func(args []interface{}) (interface{}, error) { return mapString(args[0], strings.TrimSpace) }},
+	"length":    {1, 1, expressionLength},
+	"concat":    {1, -1, expressionConcat},
+	"coalesce":  {1, -1, expressionCoalesce},
+	"substr":    {2, 3, expressionSubstr},
+	"replace":   {3, 3, expressionReplace},
+	"contains":  {2, 2, expressionContains},
+	"round":     {1, 2, expressionRound},
+	"floor":     {1, 1, func(args []interface{}) (interface{}, error) { return mapNumber(args[0], math.Floor) }},
+	"ceil":      {1, 1, func(args []interface{}) (interface{}, error) { return mapNumber(args[0], math.Ceil) }},
+	"abs":       {1, 1, func(args []interface{}) (interface{}, error) { return mapNumber(args[0], math.Abs) }},
+	"to_string": {1, 1, func(args []interface{}) (interface{}, error) { return castValue(args[0], castTypeString) }},
+	"to_number": {1, 1, func(args []interface{}) (interface{}, error) { return castValue(args[0], castTypeFloat) }},
+	"if":        {3, 3, expressionIf},
+	"now":       {0, 0, func(args []interface{}) (interface{}, error) { return time.Now().UTC().Format(time.RFC3339Nano), nil }},
+}
+
+//parse an expression
+func parseExpression(expression string) (expressionNode, error) {
+
+	tokens, err := tokenizeExpression(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &expressionParser{tokens: tokens}
+	node, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if parser.position < len(parser.tokens) {
+		return nil, errors.New("unexpected " + parser.tokens[parser.position].text + " in expression " + expression)
+	}
+	return node, nil
+}
+
+//kinds of expression tokens
+const (
+	tokenNumber = iota
+	tokenString
+	tokenIdentifier
+	tokenOperator
+)
+
+type expressionToken struct {
+	kind  int
+	text  string
+	value interface{} //numbers and strings
+}
+
+//split an expression into numbers, quoted strings, identifiers (dotted paths included) and operators
+func tokenizeExpression(expression string) ([]expressionToken, error) {
+
+	var tokens []expressionToken
+	runes := []rune(expression)
+
+	for i := 0; i < len(runes); {
+
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.' || runes[i] == 'e' || runes[i] == 'E' ||
+				((runes[i] == '+' || runes[i] == '-') && (runes[i-1] == 'e' || runes[i-1] == 'E'))) {
+				i++
+			}
+			number, err := strconv.ParseFloat(string(runes[start:i]), 64)
+			if err != nil {
+				return nil, errors.New("invalid number " + string(runes[start:i]))
+			}
+			tokens = append(tokens, expressionToken{kind: tokenNumber, text: string(runes[start:i]), value: number})
+
+		case r == '\'' || r == '"':
+			var text strings.Builder
+			i++
+			for ; i < len(runes) && runes[i] != r; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				text.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, errors.New("unterminated string in expression " + expression)
+			}
+			i++
+			tokens = append(tokens, expressionToken{kind: tokenString, text: text.String(), value: text.String()})
+
+		case unicode.IsLetter(r) || r == '_' || r == '$':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '.' || runes[i] == '$') {
+				i++
+			}
+			tokens = append(tokens, expressionToken{kind: tokenIdentifier, text: string(runes[start:i])})
+
+		default:
+			operator := string(r)
+			if i+1 < len(runes) {
+				switch string(runes[i : i+2]) {
+				case "==", "!=", "<=", ">=", "&&", "||":
+					operator = string(runes[i : i+2])
+				}
+			}
+			if !strings.Contains("+-*/%<>!(),", operator) && len(operator) == 1 {
+				return nil, errors.New("unexpected character " + operator + " in expression " + expression)
+			}
+			i += len(operator)
+			tokens = append(tokens, expressionToken{kind: tokenOperator, text: operator})
+		}
+	}
+
+	return tokens, nil
+}
+
+//recursive descent parser, lowest precedence first: || && comparison + - * / % unary
+type expressionParser struct {
+	tokens   []expressionToken
+	position int
+}
+
+//consume the next token when it is one of the operators
+func (parser *expressionParser) acceptOperator(operators ...string) (string, bool) {
+
+	if parser.position >= len(parser.tokens) || parser.tokens[parser.position].kind != tokenOperator {
+		return "", false
+	}
+	for _, operator := range operators {
+		if parser.tokens[parser.position].text == operator {
+			parser.position++
+			return operator, true
+		}
+	}
+	return "", false
+}
+
+func (parser *expressionParser) parseBinary(next func() (expressionNode, error), operators ...string) (expressionNode, error) {
+
+	left, err := next()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		operator, ok := parser.acceptOperator(operators...)
+		if !ok {
+			return left, nil
+		}
+		right, err := next()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{operator: operator, left: left, right: right}
+	}
+}
+
+func (parser *expressionParser) parseOr() (expressionNode, error) {
+	return parser.parseBinary(parser.parseAnd, "||")
+}
+
+func (parser *expressionParser) parseAnd() (expressionNode, error) {
+	return parser.parseBinary(parser.parseComparison, "&&")
+}
+
+func (parser *expressionParser) parseComparison() (expressionNode, error) {
+	return parser.parseBinary(parser.parseAdditive, "==", "!=", "<=", ">=", "<", ">")
+}
+
+func (parser *expressionParser) parseAdditive() (expressionNode, error) {
+	return parser.parseBinary(parser.parseMultiplicative, "+", "-")
+}
+
+func (parser *expressionParser) parseMultiplicative() (expressionNode, error) {
+	return parser.parseBinary(parser.parseUnary, "*", "/", "%")
+}
+
+func (parser *expressionParser) parseUnary() (expressionNode, error) {
+
+	if operator, ok := parser.acceptOperator("!", "-"); ok {
+		operand, err := parser.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryNode{operator: operator, operand: operand}, nil
+	}
+	return parser.parsePrimary()
+}
+
+func (parser *expressionParser) parsePrimary() (expressionNode, error) {
+
+	if parser.position >= len(parser.tokens) {
+		return nil, errors.New("unexpected end of expression")
+	}
+
+	token := parser.tokens[parser.position]
+	parser.position++
+
+	switch token.kind {
+
+	case tokenNumber, tokenString:
+		return literalNode{value: token.value}, nil
+
+	case tokenIdentifier:
+		switch token.text {
+		case "true":
+			return literalNode{value: true}, nil
+		case "false":
+			return literalNode{value: false}, nil
+		case "null":
+			return literalNode{value: nil}, nil
+		}
+
+		if _, ok := parser.acceptOperator("("); !ok {
+			return fieldNode{path: parsePayloadPath(token.text)}, nil
+		}
+
+		fn, found := expressionFunctions[token.text]
+		if !found {
+			return nil, errors.New("unknown function " + token.text)
+		}
+
+		var args []expressionNode
+		if _, ok := parser.acceptOperator(")"); !ok {
+			for {
+				arg, err := parser.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, arg)
+				if _, ok := parser.acceptOperator(","); ok {
+					continue
+				}
+				if _, ok := parser.acceptOperator(")"); !ok {
+					return nil, errors.New("missing ) after arguments of " + token.text)
+				}
+				break
+			}
+		}
+
+		if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+			return nil, errors.New("wrong number of arguments for " + token.text)
+		}
+		return callNode{name: token.text, fn: fn, args: args}, nil
+
+	case tokenOperator:
+		if token.text == "(" {
+			node, err := parser.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := parser.acceptOperator(")"); !ok {
+				return nil, errors.New("missing )")
+			}
+			return node, nil
+		}
+	}
+
+	return nil, errors.New("unexpected " + token.text)
+}
+
+func (node literalNode) eval(payload map[string]interface{}) (interface{}, error) {
+	return node.value, nil
+}
+
+func (node fieldNode) eval(payload map[string]interface{}) (interface{}, error) {
+	return getPayloadValue(payload, node.path), nil
+}
+
+func (node unaryNode) eval(payload map[string]interface{}) (interface{}, error) {
+
+	operand, err := node.operand.eval(payload)
+	if err != nil || operand == nil {
+		return nil, err
+	}
+
+	if node.operator == "!" {
+		return !isTruthy(operand), nil
+	}
+
+	number, ok := toNumber(operand)
+	if !ok {
+		return nil, errors.New("cannot negate " + describeValue(operand))
+	}
+	return -number, nil
+}
+
+func (node binaryNode) eval(payload map[string]interface{}) (interface{}, error) {
+
+	left, err := node.left.eval(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	switch node.operator { //short circuit
+	case "&&":
+		if !isTruthy(left) {
+			return false, nil
+		}
+		right, err := node.right.eval(payload)
+		return isTruthy(right), err
+	case "||":
+		if isTruthy(left) {
+			return true, nil
+		}
+		right, err := node.right.eval(payload)
+		return isTruthy(right), err
+	}
+
+	right, err := node.right.eval(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	switch node.operator {
+	case "==":
+		return compareExpressionValues(left, right) == 0, nil
+	case "!=":
+		return compareExpressionValues(left, right) != 0, nil
+	}
+
+	if left == nil || right == nil {
+		return nil, nil
+	}
+
+	switch node.operator {
+	case "<":
+		return compareExpressionValues(left, right) < 0, nil
+	case "<=":
+		return compareExpressionValues(left, right) <= 0, nil
+	case ">":
+		return compareExpressionValues(left, right) > 0, nil
+	case ">=":
+		return compareExpressionValues(left, right) >= 0, nil
+	}
+
+	if node.operator == "+" {
+		_, leftIsString := left.(string)
+		_, rightIsString := right.(string)
+		if leftIsString || rightIsString {
+			return formatExpressionValue(left) + formatExpressionValue(right), nil
+		}
+	}
+
+	leftNumber, leftOk := toNumber(left)
+	rightNumber, rightOk := toNumber(right)
+	if !leftOk || !rightOk {
+		return nil, errors.New("cannot apply " + node.operator + " to " + describeValue(left) + " and " + describeValue(right))
+	}
+
+	switch node.operator {
+	case "+":
+		return leftNumber + rightNumber, nil
+	case "-":
+		return leftNumber - rightNumber, nil
+	case "*":
+		return leftNumber * rightNumber, nil
+	case "/":
+		if rightNumber == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return leftNumber / rightNumber, nil
+	case "%":
+		if rightNumber == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return math.Mod(leftNumber, rightNumber), nil
+	}
+
+	return nil, errors.New("unsupported operator " + node.operator)
+}
+
+func (node callNode) eval(payload map[string]interface{}) (interface{}, error) {
+
+	args := make([]interface{}, len(node.args))
+	for i, arg := range node.args {
+		value, err := arg.eval(payload)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+
+	result, err := node.fn.call(args)
+	if err != nil {
+		return nil, errors.New(node.name + ": " + err.Error())
+	}
+	return result, nil
+}
+
+//numbers of any width as float64, as decoded JSON has them
+func toNumber(value interface{}) (float64, bool) {
+
+	switch typedValue := value.(type) {
+	case float64:
+		return typedValue, true
+	case int64:
+		return float64(typedValue), true
+	}
+	return 0, false
+}
+
+//false for null, false, zero and the empty string
+func isTruthy(value interface{}) bool {
+
+	switch typedValue := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return typedValue
+	case string:
+		return typedValue != ""
+	}
+	if number, ok := toNumber(value); ok {
+		return number != 0
+	}
+	return true
+}
+
+//order of two values as payload values are sorted, values of different types never compare equal
+func compareExpressionValues(left interface{}, right interface{}) int {
+
+	if number, ok := toNumber(left); ok {
+		left = number
+	}
+	if number, ok := toNumber(right); ok {
+		right = number
+	}
+	return compareParquetValues(left, right)
+}
+
+//text of a value in string concatenation
+func formatExpressionValue(value interface{}) string {
+
+	text, _ := castValue(value, castTypeString)
+	if typedText, ok := text.(string); ok {
+		return typedText
+	}
+	return ""
+}
+
+func describeValue(value interface{}) string {
+
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+	if _, ok := toNumber(value); ok {
+		return "number"
+	}
+	return "value"
+}
+
+func mapString(value interface{}, fn func(string) string) (interface{}, error) {
+
+	if value == nil {
+		return nil, nil
+	}
+	text, ok := value.(string)
+	if !ok {
+		return nil, errors.New("expected string, got " + describeValue(value))
+	}
+	return fn(text), nil
+}
+
+func mapNumber(value interface{}, fn func(float64) float64) (interface{}, error) {
+
+	if value == nil {
+		return nil, nil
+	}
+	number, ok := toNumber(value)
+	if !ok {
+		return nil, errors.New("expected number, got " + describeValue(value))
+	}
+	return fn(number), nil
+}
+
+func expressionLength(args []interface{}) (interface{}, error) {
+
+	switch typedValue := args[0].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return float64(len([]rune(typedValue))), nil
+	case []interface{}:
+		return float64(len(typedValue)), nil
+	case map[string]interface{}:
+		return float64(len(typedValue)), nil
+	}
+	return nil, errors.New("expected string, array or object, got " + describeValue(args[0]))
+}
+
+func expressionConcat(args []interface{}) (interface{}, error) {
+
+	var text strings.Builder
+	for _, arg := range args {
+		text.WriteString(formatExpressionValue(arg)) //nulls are skipped
+	}
+	return text.String(), nil
+}
+
+func expressionCoalesce(args []interface{}) (interface{}, error) {
+
+	for _, arg := range args {
+		if arg != nil {
+			return arg, nil
+		}
+	}
+	return nil, nil
+}
+
+//substring by rune position, start from 0
+func expressionSubstr(args []interface{}) (interface{}, error) {
+
+	if args[0] == nil {
+		return nil, nil
+	}
+	text, ok := args[0].(string)
+	start, startOk := toNumber(args[1])
+	if !ok || !startOk {
+		return nil, errors.New("expected string and start position")
+	}
+
+	runes := []rune(text)
+	from := int(math.Max(0, math.Min(start, float64(len(runes)))))
+	to := len(runes)
+	if len(args) > 2 {
+		length, ok := toNumber(args[2])
+		if !ok {
+			return nil, errors.New("expected length, got " + describeValue(args[2]))
+		}
+		to = int(math.Max(float64(from), math.Min(float64(from)+length, float64(len(runes)))))
+	}
+	return string(runes[from:to]), nil
+}
+
+func expressionReplace(args []interface{}) (interface{}, error) {
+
+	if args[0] == nil {
+		return nil, nil
+	}
+	text, ok1 := args[0].(string)
+	old, ok2 := args[1].(string)
+	replacement, ok3 := args[2].(string)
+	if !ok1 || !ok2 || !ok3 {
+		return nil, errors.New("expected strings")
+	}
+	return strings.Replace(text, old, replacement, -1), nil
+}
+
+func expressionContains(args []interface{}) (interface{}, error) {
+
+	if args[0] == nil {
+		return nil, nil
+	}
+	text, ok1 := args[0].(string)
+	part, ok2 := args[1].(string)
+	if !ok1 || !ok2 {
+		return nil, errors.New("expected strings")
+	}
+	return strings.Contains(text, part), nil
+}
+
+func expressionRound(args []interface{}) (interface{}, error) {
+
+	digits := 0.0
+	if len(args) > 1 {
+		var ok bool
+		if digits, ok = toNumber(args[1]); !ok {
+			return nil, errors.New("expected number of digits, got " + describeValue(args[1]))
+		}
+	}
+	scale := math.Pow(10, digits)
+	return mapNumber(args[0], func(number float64) float64 { return math.Round(number*scale) / scale })
+}
+
+func expressionIf(args []interface{}) (interface{}, error) {
+
+	if isTruthy(args[0]) {
+		return args[1], nil
+	}
+	return args[2], nil
+}
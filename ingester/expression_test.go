@@ -0,0 +1,180 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEvalExpression(t *testing.T) {
+
+	payload := decodePayload(t, `{
+		"price": 2.5,
+		"quantity": 4,
+		"customer": {"email": " Jane@Example.com ", "name": "Jane"},
+		"tags": ["a", "b", "c"],
+		"active": true,
+		"note": "",
+		"count": "12"
+	}`)
+
+	tests := []struct {
+		expression string
+		want       interface{}
+	}{
+		//literals and fields
+		{"42", 42.0},
+		{".5", 0.5},
+		{"'single'", "single"},
+		{`"double"`, "double"},
+		{"true", true},
+		{"null", nil},
+		{"customer.name", "Jane"},
+		{"$.customer.name", "Jane"},
+		{"customer.missing", nil},
+		{"missing.deeper", nil},
+
+		//arithmetic and precedence
+		{"price * quantity", 10.0},
+		{"1 + 2 * 3", 7.0},
+		{"(1 + 2) * 3", 9.0},
+		{"10 - 4 - 3", 3.0},
+		{"7 % 4", 3.0},
+		{"-price", -2.5},
+		{"- -1", 1.0},
+		{"price + missing", nil},
+
+		//string concatenation
+		{"'n' + 1", "n1"},
+		{"customer.name + ' (' + quantity + ')'", "Jane (4)"},
+
+		//comparison and logic
+		{"price < quantity", true},
+		{"quantity >= 4", true},
+		{"customer.name == 'Jane'", true},
+		{"customer.name != 'Jane'", false},
+		{"missing == null", true},
+		{"quantity == '4'", false},
+		{"missing < 1", nil},
+		{"active && quantity > 3", true},
+		{"note || 'default'", true},
+		{"!active", false},
+		{"!missing", nil}, //null operands give null
+		{"missing && 1 / 0", false},
+		{"active || 1 / 0", true},
+
+		//functions
+		{"lower(trim(customer.email))", "jane@example.com"},
+		{"upper(customer.name)", "JANE"},
+		{"length(tags)", 3.0},
+		{"length(customer)", 2.0},
+		{"length('héllo')", 5.0},
+		{"concat(customer.name, '-', missing, quantity)", "Jane-4"},
+		{"coalesce(missing, note, 'x')", ""},
+		{"coalesce(missing)", nil},
+		{"substr('abcdef', 2)", "cdef"},
+		{"substr('abcdef', 1, 3)", "bcd"},
+		{"substr('abc', 5, 2)", ""},
+		{"replace('a-b-c', '-', '+')", "a+b+c"},
+		{"contains(customer.email, 'Example')", true},
+		{"round(2.345, 2)", 2.35},
+		{"round(price)", 3.0},
+		{"floor(-price)", -3.0},
+		{"ceil(price)", 3.0},
+		{"abs(-3)", 3.0},
+		{"to_number(count) + 1", 13.0},
+		{"to_string(quantity)", "4"},
+		{"if(price * quantity > 5, 'large', 'small')", "large"},
+		{"lower(missing)", nil},
+	}
+
+	for _, test := range tests {
+
+		node, err := parseExpression(test.expression)
+		if err != nil {
+			t.Errorf("parseExpression(%q): %v", test.expression, err)
+			continue
+		}
+
+		got, err := node.eval(payload)
+		if err != nil {
+			t.Errorf("eval(%q): %v", test.expression, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("eval(%q) = %#v, want %#v", test.expression, got, test.want)
+		}
+	}
+}
+
+func TestParseExpressionErrors(t *testing.T) {
+
+	tests := []struct {
+		expression string
+		message    string
+	}{
+		{"", "unexpected end"},
+		{"1 +", "unexpected end"},
+		{"(1 + 2", "missing )"},
+		{"1 2", "unexpected 2"},
+		{"'unterminated", "unterminated"},
+		{"price # 2", "#"},
+		{"unknown(1)", "unknown function unknown"},
+		{"lower()", "wrong number of arguments"},
+		{"lower('a', 'b')", "wrong number of arguments"},
+		{"substr('a', 1, 2, 3)", "wrong number of arguments"},
+		{"concat('a'", "missing )"},
+		{"*", "unexpected *"},
+	}
+
+	for _, test := range tests {
+		_, err := parseExpression(test.expression)
+		if err == nil {
+			t.Errorf("parseExpression(%q) succeeded, want an error", test.expression)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.message) {
+			t.Errorf("parseExpression(%q) failed with %q, want it to mention %q", test.expression, err, test.message)
+		}
+	}
+}
+
+func TestEvalExpressionErrors(t *testing.T) {
+
+	payload := decodePayload(t, `{"name": "Jane", "tags": ["a"], "zero": 0}`)
+
+	tests := []struct {
+		expression string
+		message    string
+	}{
+		{"1 / zero", "division by zero"},
+		{"1 % 0", "division by zero"},
+		{"name * 2", "cannot apply * to string and number"},
+		{"tags - 1", "cannot apply - to array and number"},
+		{"-name", "cannot negate string"},
+		{"lower(tags)", "lower: expected string, got array"},
+		{"abs(name)", "abs: expected number, got string"},
+		{"length(1)", "length: expected string, array or object"},
+		{"to_number(name)", "to_number: cannot cast"},
+		{"round(1, name)", "round: expected number of digits"},
+		{"replace(name, 1, 'x')", "replace: expected strings"},
+	}
+
+	for _, test := range tests {
+
+		node, err := parseExpression(test.expression)
+		if err != nil {
+			t.Errorf("parseExpression(%q): %v", test.expression, err)
+			continue
+		}
+
+		_, err = node.eval(payload)
+		if err == nil {
+			t.Errorf("eval(%q) succeeded, want an error", test.expression)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.message) {
+			t.Errorf("eval(%q) failed with %q, want it to mention %q", test.expression, err, test.message)
+		}
+	}
+}
@@ -0,0 +1,383 @@
+//output file formats of a stream: Parquet (default), Avro object container files, gzip-compressed JSON Lines and CSV
+//`orc` is kept in `file_formats` so the ids do not shift, the config service refuses it
+//every format is written from the payload and the Parquet JSON schema built by GenerateSchema
+
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/linkedin/goavro/v2"
+	"github.com/xitongsys/parquet-go-source/writerfile"
+)
+
+//supported values of `file_format_name`
+const (
+	fileFormatParquet = "parquet"
+	fileFormatAvro    = "avro"
+	fileFormatJSONL   = "jsonl"
+	fileFormatCSV     = "csv"
+)
+
+//`file_format_name` of a stream, Parquet when none is configured
+//table formats always commit Parquet data files
+func getFileFormatName(configRecord Config) string {
+
+	if usesTableFormat(configRecord) {
+		return fileFormatParquet
+	}
+
+	for _, fileFormatRecord := range fileFormats {
+		if fileFormatRecord.FileFormatId == configRecord.FileFormatId.Int64 {
+			return strings.ToLower(fileFormatRecord.FileFormatName)
+		}
+	}
+
+	return fileFormatParquet
+}
+
+//`compression_type_name` of a stream, empty when uncompressed
+func getCompressionTypeName(configRecord Config) string {
+
+	for _, compressionTypeRecord := range compressionTypes {
+		if compressionTypeRecord.CompressionTypeId == configRecord.CompressionTypeId.Int64 {
+			return strings.ToLower(compressionTypeRecord.CompressionTypeName)
+		}
+	}
+
+	return ""
+}
+
+//file name extension of a file format
+func getFileExtension(fileFormat string) string {
+
+	switch fileFormat {
+	case fileFormatAvro:
+		return ".avro"
+	case fileFormatJSONL:
+		return ".jsonl.gz"
+	case fileFormatCSV:
+		return ".csv"
+	}
+	return ".parquet"
+}
+
+//format object Dremio has to promote the datasets of a stream with, empty when Dremio cannot read the files
+func getDremioFormat(configRecord Config) string {
+
+	switch getTableFormat(configRecord) {
+	case tableFormatIceberg:
+		return `{"type": "Iceberg"}`
+	case tableFormatDelta:
+		return `{"type": "Delta"}`
+	}
+
+	switch getFileFormatName(configRecord) {
+	case fileFormatJSONL:
+		return `{"type": "JSON"}`
+	case fileFormatCSV:
+		return `{"type": "Text", "fieldDelimiter": ",", "lineDelimiter": "\n", "quote": "\"", "escape": "\"", "extractHeader": true}`
+	case fileFormatAvro: //only readable through a Hive source
+		return ""
+	}
+	return `{"type": "Parquet"}`
+}
+
+//write the records in the file format of the stream
+func writeFileFormat(w io.Writer, schema string, records [][]byte, configRecord Config) error {
+
+	switch getFileFormatName(configRecord) {
+	case fileFormatAvro:
+		return writeAvroRecords(w, schema, records, configRecord)
+	case fileFormatJSONL:
+		return writeJSONLines(w, records)
+	case fileFormatCSV:
+		return writeCSVFile(w, records)
+	}
+
+	return WriteRecordsToFile(schema, writerfile.NewWriterFile(w), records, configRecord)
+}
+
+//decode the Parquet schema and the records written against it
+func decodeSchemaAndRecords(schema string, records [][]byte) (parquetSchemaNode, []map[string]interface{}, error) {
+
+	var root parquetSchemaNode
+	if err := json.Unmarshal([]byte(schema), &root); err != nil {
+		return root, nil, err
+	}
+
+	decoded := make([]map[string]interface{}, len(records))
+	for i, record := range records {
+		if err := json.Unmarshal(record, &decoded[i]); err != nil {
+			return root, nil, err
+		}
+	}
+
+	return root, decoded, nil
+}
+
+//gzip-compressed JSON Lines, one record per line
+func writeJSONLines(w io.Writer, records [][]byte) error {
+
+	gzipWriter := gzip.NewWriter(w)
+
+	for _, record := range records {
+		if _, err := gzipWriter.Write(append(record, '\n')); err != nil {
+			return err
+		}
+	}
+
+	return gzipWriter.Close()
+}
+
+//CSV with a header row, nested objects are flattened to dotted column names and arrays kept as JSON
+//the header holds the columns of every record, cells of columns a record lacks are empty
+func writeCSVFile(w io.Writer, records [][]byte) error {
+
+	rows := make([]map[string]string, len(records))
+	columns := map[string]bool{}
+
+	for i, data := range records {
+
+		var record map[string]interface{}
+		if err := json.Unmarshal(data, &record); err != nil {
+			return err
+		}
+
+		rows[i] = map[string]string{}
+		flattenCSVColumns("", record, rows[i])
+
+		for name := range rows[i] {
+			columns[name] = true
+		}
+	}
+
+	names := make([]string, 0, len(columns))
+	for name := range columns {
+		names = append(names, name)
+	}
+	sort.Strings(names) //payload keys come in random order
+
+	csvWriter := csv.NewWriter(w)
+	csvWriter.Write(names)
+
+	for _, row := range rows {
+		values := make([]string, len(names))
+		for i, name := range names {
+			values[i] = row[name]
+		}
+		csvWriter.Write(values)
+	}
+	csvWriter.Flush()
+
+	return csvWriter.Error()
+}
+
+func flattenCSVColumns(prefix string, record map[string]interface{}, columns map[string]string) {
+
+	for key, value := range record {
+
+		switch typedValue := value.(type) {
+		case nil:
+			continue //skip nulls, as in the schema
+		case map[string]interface{}:
+			flattenCSVColumns(prefix+key+".", typedValue, columns)
+		case string:
+			columns[prefix+key] = typedValue
+		case float64:
+			columns[prefix+key] = strconv.FormatFloat(typedValue, 'f', -1, 64)
+		case bool:
+			columns[prefix+key] = strconv.FormatBool(typedValue)
+		default:
+			encoded, _ := json.Marshal(typedValue)
+			columns[prefix+key] = string(encoded)
+		}
+	}
+}
+
+//Avro name for a payload key - letters, digits and underscores, not starting with a digit
+func getAvroName(name string) string {
+
+	name = strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+//Avro primitive of a Parquet physical type
+func getAvroPrimitive(parquetType string) string {
+
+	switch parquetType {
+	case "BYTE_ARRAY":
+		return "string"
+	case "INT32":
+		return "int"
+	case "INT64":
+		return "long"
+	case "FLOAT":
+		return "float"
+	case "DOUBLE":
+		return "double"
+	case "BOOLEAN":
+		return "boolean"
+	}
+	return ""
+}
+
+//Avro type of a Parquet schema node, record names are derived from the path so they are unique in the schema
+//fields and array items are nullable: values not matching the schema (e.g. later array elements) are written as null
+func getAvroType(node parquetSchemaNode, recordName string) (interface{}, error) {
+
+	parquetType := node.tagValue("type")
+
+	switch parquetType {
+	case "LIST":
+		if len(node.Fields) != 1 {
+			return nil, fmt.Errorf("list %s must have exactly one element", node.tagValue("name"))
+		}
+		items, err := getAvroType(node.Fields[0], recordName+"_element")
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"type": "array", "items": []interface{}{"null", items}}, nil
+
+	case "":
+		fields := []interface{}{}
+		for _, field := range node.Fields {
+			name := getAvroName(field.tagValue("name"))
+			fieldType, err := getAvroType(field, recordName+"_"+name)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, map[string]interface{}{"name": name, "type": []interface{}{"null", fieldType}, "default": nil})
+		}
+		return map[string]interface{}{"type": "record", "name": recordName, "fields": fields}, nil
+	}
+
+	primitive := getAvroPrimitive(parquetType)
+	if primitive == "" {
+		return nil, fmt.Errorf("unsupported Parquet type %s", parquetType)
+	}
+	return primitive, nil
+}
+
+//Avro datum of a payload value and the name of its union branch, nil when missing or not matching the schema
+func getAvroDatum(node parquetSchemaNode, recordName string, value interface{}) (interface{}, string) {
+
+	parquetType := node.tagValue("type")
+
+	switch parquetType {
+	case "LIST":
+		elements, ok := value.([]interface{})
+		if !ok {
+			return nil, ""
+		}
+		items := make([]interface{}, len(elements))
+		for i, element := range elements {
+			items[i] = getAvroUnion(node.Fields[0], recordName+"_element", element)
+		}
+		return items, "array"
+
+	case "":
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, ""
+		}
+		record := map[string]interface{}{}
+		for _, field := range node.Fields {
+			name := getAvroName(field.tagValue("name"))
+			record[name] = getAvroUnion(field, recordName+"_"+name, fields[field.tagValue("name")])
+		}
+		return record, recordName
+	}
+
+	switch typedValue := value.(type) {
+	case string:
+		if parquetType == "BYTE_ARRAY" {
+			return typedValue, "string"
+		}
+	case bool:
+		if parquetType == "BOOLEAN" {
+			return typedValue, "boolean"
+		}
+	case float64:
+		switch parquetType {
+		case "INT32":
+			return int32(typedValue), "int"
+		case "INT64":
+			return int64(typedValue), "long"
+		case "FLOAT":
+			return float32(typedValue), "float"
+		case "DOUBLE":
+			return typedValue, "double"
+		}
+	}
+	return nil, ""
+}
+
+//nullable Avro datum
+func getAvroUnion(node parquetSchemaNode, recordName string, value interface{}) interface{} {
+
+	datum, branch := getAvroDatum(node, recordName, value)
+	if datum == nil {
+		return nil
+	}
+	return goavro.Union(branch, datum)
+}
+
+//Avro object container file holding the records
+func writeAvroRecords(w io.Writer, schema string, records [][]byte, configRecord Config) error {
+
+	root, decoded, err := decodeSchemaAndRecords(schema, records)
+	if err != nil {
+		return err
+	}
+
+	recordName := getAvroName(root.tagValue("name"))
+
+	avroType, err := getAvroType(root, recordName)
+	if err != nil {
+		return err
+	}
+
+	avroSchema, _ := json.Marshal(avroType)
+
+	codec, err := goavro.NewCodec(string(avroSchema))
+	if err != nil {
+		return err
+	}
+
+	compressionName := goavro.CompressionNullLabel
+	switch getCompressionTypeName(configRecord) {
+	case "snappy":
+		compressionName = goavro.CompressionSnappyLabel
+	case "gzip":
+		compressionName = goavro.CompressionDeflateLabel
+	}
+
+	ocfWriter, err := goavro.NewOCFWriter(goavro.OCFConfig{W: w, Codec: codec, CompressionName: compressionName})
+	if err != nil {
+		return err
+	}
+
+	data := make([]interface{}, len(decoded))
+	for i, record := range decoded {
+		data[i], _ = getAvroDatum(root, recordName, record)
+	}
+
+	return ocfWriter.Append(data)
+}
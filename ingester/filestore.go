@@ -0,0 +1,672 @@
+//storage abstraction over the supported file stores
+//files are streamed straight into the destination - S3 multipart, GCS resumable, Azure block blob and HDFS streaming writes
+//uploads are atomic: object stores only expose an object once the upload completes,
+//file systems write to a hidden staging name and rename it into place
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/colinmarc/hdfs"
+	"google.golang.org/api/iterator"
+)
+
+//upload tuning - part/block size and number of parts in flight
+const (
+	uploadPartSize    = 8 * 1024 * 1024
+	uploadConcurrency = 4
+	gcsUploadTimeout  = 5 * time.Minute
+)
+
+//returned by Get for a missing file
+var errFileNotFound = errors.New("file not found")
+
+//FileStore abstracts the destination of a stream
+//paths are relative to the root of the store (datastore folder, bucket, container or HDFS root folder)
+type FileStore interface {
+	//Upload streams whatever write produces into path, aborting the upload and cleaning up on failure
+	//path only becomes visible to readers once the upload has been committed
+	Upload(path string, write func(w io.Writer) error) error
+	//Get reads a whole file, errFileNotFound when it does not exist
+	Get(path string) ([]byte, error)
+	//List returns the files below prefix, recursively
+	List(prefix string) ([]StoredFile, error)
+	//Delete removes a file, a missing file is not an error
+	Delete(path string) error
+	//URI is the absolute location of path as seen by query engines, used in table metadata
+	URI(path string) string
+	//Close releases the underlying client
+	Close() error
+}
+
+//file found by List, path is relative to the root of the store
+type StoredFile struct {
+	Path       string
+	Size       int64
+	ModifiedAt time.Time
+}
+
+//open the file store for a stream, storeType is the `file_store_type_name`
+func newFileStore(storeType string, configRecord Config) (FileStore, error) {
+
+	switch storeType {
+	case "Local":
+		return newLocalStore(configRecord)
+	case "AWS":
+		return newS3Store(configRecord)
+	case "GCP":
+		return newGCSStore(configRecord)
+	case "Azure":
+		return newAzureStore(configRecord)
+	case "HDFS":
+		return newHDFSStore(configRecord)
+	}
+
+	return nil, errors.New("unsupported file store type " + storeType)
+}
+
+//`file_store_type_name` of a stream
+func getFileStoreTypeName(configRecord Config) string {
+
+	for _, fileStoreTypeRecord := range fileStoreTypes {
+		if fileStoreTypeRecord.FileStoreTypeId == configRecord.FileStoreTypeId.Int64 {
+			return fileStoreTypeRecord.FileStoreTypeName
+		}
+	}
+	return ""
+}
+
+//path of a file relative to the store root: [folder/]<message type>/<partition>/<file name>
+func generateObjectPath(messageType string, configRecord Config, partitionTime time.Time, fileName string) string {
+
+	path := generateSubFolderName(messageType, configRecord, partitionTime) + "/" + fileName
+
+	if configRecord.FolderName.String != "" {
+		path = configRecord.FolderName.String + "/" + path
+	}
+
+	return path
+}
+
+//hidden staging name next to the final path - readers (Dremio, Spark, Hive) skip names starting with "."
+func stagingPath(path string) string {
+
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".inprogress")
+}
+
+//write a small file (manifest, marker) in one go
+func putFile(store FileStore, path string, data []byte) error {
+
+	return store.Upload(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+//counts the bytes written through it
+type countingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+func (counter *countingWriter) Write(p []byte) (int, error) {
+
+	n, err := counter.writer.Write(p)
+	counter.count += int64(n)
+	return n, err
+}
+
+//connect the output of a writer to the input of an uploader through a pipe
+//a write failure aborts the upload, an upload failure unblocks the writer
+func pipeUpload(write func(w io.Writer) error, upload func(r io.Reader) error) error {
+
+	pipeReader, pipeWriter := io.Pipe()
+	writeErr := make(chan error, 1)
+
+	go func() {
+		err := write(pipeWriter)
+		pipeWriter.CloseWithError(err) //nil closes normally and signals EOF
+		writeErr <- err
+	}()
+
+	uploadErr := upload(pipeReader)
+	pipeReader.CloseWithError(uploadErr)
+
+	if err := <-writeErr; err != nil {
+		return err
+	}
+	return uploadErr
+}
+
+//local file system, rooted at the datastore folder
+type localStore struct {
+	root string
+}
+
+func newLocalStore(configRecord Config) (*localStore, error) {
+
+	return &localStore{root: "datastore"}, nil //root will always be datastore
+}
+
+func (store *localStore) Upload(path string, write func(w io.Writer) error) error {
+
+	fullPath := store.root + "/" + path
+	stagedPath := stagingPath(fullPath)
+
+	err := os.MkdirAll(filepath.Dir(fullPath), os.ModePerm)
+	if err != nil {
+		log.Println("Can't create output directory", err)
+		return err
+	}
+
+	file, err := os.Create(stagedPath)
+	if err != nil {
+		log.Println("Can't create file", err)
+		return err
+	}
+
+	err = write(file)
+	if err == nil {
+		err = file.Sync() //data has to be durable before the rename publishes it
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err == nil { //commit - rename is atomic and replaces an existing file
+		err = os.Rename(stagedPath, fullPath)
+	}
+
+	if err != nil {
+		os.Remove(stagedPath) //do not leave partial files behind
+	}
+	return err
+}
+
+func (store *localStore) Get(path string) ([]byte, error) {
+
+	data, err := ioutil.ReadFile(store.root + "/" + path)
+	if os.IsNotExist(err) {
+		return nil, errFileNotFound
+	}
+	return data, err
+}
+
+func (store *localStore) List(prefix string) ([]StoredFile, error) {
+
+	var files []StoredFile
+
+	err := filepath.Walk(store.root+"/"+prefix, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			relativePath, _ := filepath.Rel(store.root, path)
+			files = append(files, StoredFile{Path: filepath.ToSlash(relativePath), Size: info.Size(), ModifiedAt: info.ModTime()})
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return files, err
+}
+
+func (store *localStore) Delete(path string) error {
+
+	err := os.Remove(store.root + "/" + path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+//Dremio sees the datastore folder at its own mount path
+func (store *localStore) URI(path string) string {
+
+	return "file://" + GetEnv("DREMIO_MOUNT_PATH", "/mnt/datastore") + "/" + path
+}
+
+func (store *localStore) Close() error {
+	return nil
+}
+
+//AWS S3 or S3-compatible store
+type s3Store struct {
+	client *s3.S3
+	bucket string
+}
+
+func newS3Store(configRecord Config) (*s3Store, error) {
+
+	bucketName := configRecord.BucketName.String
+	if bucketName == "" {
+		return nil, errors.New("S3 bucket name cannot be null or empty")
+	}
+
+	client, err := newS3Client(configRecord) //AWS proper or S3-compatible endpoint
+	if err != nil {
+		log.Println("Failed to create AWS Session ", err)
+		return nil, err
+	}
+
+	return &s3Store{client: client, bucket: bucketName}, nil
+}
+
+func (store *s3Store) Upload(path string, write func(w io.Writer) error) error {
+
+	//multipart upload, parts already sent are aborted when the upload fails
+	uploader := s3manager.NewUploaderWithClient(store.client, func(uploader *s3manager.Uploader) {
+		uploader.PartSize = uploadPartSize
+		uploader.Concurrency = uploadConcurrency
+		uploader.LeavePartsOnError = false
+	})
+
+	return pipeUpload(write, func(reader io.Reader) error {
+		_, err := uploader.Upload(&s3manager.UploadInput{
+			Bucket: aws.String(store.bucket),
+			Key:    aws.String(path),
+			Body:   reader,
+		})
+		return err
+	})
+}
+
+func (store *s3Store) Get(path string) ([]byte, error) {
+
+	result, err := store.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(store.bucket),
+		Key:    aws.String(path),
+	})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, errFileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer result.Body.Close()
+
+	return ioutil.ReadAll(result.Body)
+}
+
+func (store *s3Store) List(prefix string) ([]StoredFile, error) {
+
+	var files []StoredFile
+
+	err := store.client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(store.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			files = append(files, StoredFile{Path: aws.StringValue(object.Key), Size: aws.Int64Value(object.Size), ModifiedAt: aws.TimeValue(object.LastModified)})
+		}
+		return true
+	})
+
+	return files, err
+}
+
+func (store *s3Store) Delete(path string) error {
+
+	_, err := store.client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(store.bucket),
+		Key:    aws.String(path),
+	})
+	return err
+}
+
+func (store *s3Store) URI(path string) string {
+
+	return "s3://" + store.bucket + "/" + path
+}
+
+func (store *s3Store) Close() error {
+	return nil
+}
+
+//GCP Cloud Storage
+type gcsStore struct {
+	client *storage.Client
+	bucket string
+}
+
+func newGCSStore(configRecord Config) (*gcsStore, error) {
+
+	bucketName := configRecord.BucketName.String
+	if bucketName == "" {
+		return nil, errors.New("GCS bucket name cannot be null or empty")
+	}
+
+	client, err := newGCSClient(context.Background(), configRecord) //service account key or application default credentials
+	if err != nil {
+		log.Println("Error creating GCP client", err)
+		return nil, err
+	}
+
+	return &gcsStore{client: client, bucket: bucketName}, nil
+}
+
+func (store *gcsStore) Upload(path string, write func(w io.Writer) error) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), gcsUploadTimeout)
+	defer cancel()
+
+	//resumable upload, cancelling the context abandons it without creating the object
+	objectWriter := store.client.Bucket(store.bucket).Object(path).NewWriter(ctx)
+	objectWriter.ChunkSize = uploadPartSize
+
+	if err := write(objectWriter); err != nil {
+		cancel()
+		objectWriter.Close()
+		return err
+	}
+
+	return objectWriter.Close()
+}
+
+func (store *gcsStore) Get(path string) ([]byte, error) {
+
+	reader, err := store.client.Bucket(store.bucket).Object(path).NewReader(context.Background())
+	if err == storage.ErrObjectNotExist {
+		return nil, errFileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return ioutil.ReadAll(reader)
+}
+
+func (store *gcsStore) List(prefix string) ([]StoredFile, error) {
+
+	var files []StoredFile
+
+	objects := store.client.Bucket(store.bucket).Objects(context.Background(), &storage.Query{Prefix: prefix})
+	for {
+		attributes, err := objects.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, StoredFile{Path: attributes.Name, Size: attributes.Size, ModifiedAt: attributes.Updated})
+	}
+
+	return files, nil
+}
+
+func (store *gcsStore) Delete(path string) error {
+
+	err := store.client.Bucket(store.bucket).Object(path).Delete(context.Background())
+	if err == storage.ErrObjectNotExist {
+		return nil
+	}
+	return err
+}
+
+func (store *gcsStore) URI(path string) string {
+
+	return "gs://" + store.bucket + "/" + path
+}
+
+func (store *gcsStore) Close() error {
+	return store.client.Close()
+}
+
+//Azure Blob Storage
+type azureStore struct {
+	containerURL azblob.ContainerURL
+	account      string
+	container    string
+}
+
+func newAzureStore(configRecord Config) (*azureStore, error) {
+
+	// Create a request pipeline that is used to process HTTP(S) requests and responses. It requires
+	// your account credentials. In more advanced scenarios, you can configure telemetry, retry policies,
+	// logging, and other options. Also, you can configure multiple request pipelines for different scenarios.
+	azureCredential, err := newAzureCredential(configRecord) //shared key or managed identity
+	if err != nil {
+		log.Println("Error constructing Azure credential", err)
+		return nil, err
+	}
+
+	azurePipeline := azblob.NewPipeline(azureCredential, azblob.PipelineOptions{})
+
+	//Storage account blob service URL endpoint
+	azureUrl, _ := url.Parse(fmt.Sprintf("https://%s.blob.core.windows.net", configRecord.AzureStorageAccountname.String))
+
+	bucketName := configRecord.BucketName.String //maps to Container Name for Azure Storage
+	if bucketName == "" {
+		return nil, errors.New("Bucket name (maps to Azure Storage Account Name) cannot be null or empty")
+	}
+
+	// Create an ServiceURL object that wraps the service URL and a request pipeline.
+	azureServiceURL := azblob.NewServiceURL(*azureUrl, azurePipeline)
+
+	// Create a URL that references a to-be-created container in your Azure Storage account.
+	// This returns a ContainerURL object that wraps the container's URL and a request pipeline (inherited from serviceURL)
+	azureContainerURL := azureServiceURL.NewContainerURL(strings.ToLower(bucketName)) // Container names require lowercase
+
+	//check if container exists
+	ctx := context.Background()
+	azureContainerProperties, _ := azureContainerURL.GetProperties(ctx, azblob.LeaseAccessConditions{})
+
+	if azureContainerProperties == nil { //container does not exist, need to create
+		// Create the container on the service (with no metadata and no public access)
+		_, err := azureContainerURL.Create(ctx, azblob.Metadata{}, azblob.PublicAccessNone)
+		if err != nil {
+			log.Println("Error creating Azure Storage container", err)
+			return nil, err
+		}
+	}
+
+	return &azureStore{
+		containerURL: azureContainerURL,
+		account:      configRecord.AzureStorageAccountname.String,
+		container:    strings.ToLower(bucketName),
+	}, nil
+}
+
+func (store *azureStore) Upload(path string, write func(w io.Writer) error) error {
+
+	//blocks are staged as they are produced and only committed once the stream completes
+	//uncommitted blocks of a failed upload are garbage collected by the service
+	azureBlobURL := store.containerURL.NewBlockBlobURL(path)
+
+	return pipeUpload(write, func(reader io.Reader) error {
+		_, err := azblob.UploadStreamToBlockBlob(context.Background(), reader, azureBlobURL, azblob.UploadStreamToBlockBlobOptions{
+			BufferSize:      uploadPartSize,
+			MaxBuffers:      uploadConcurrency,
+			BlobHTTPHeaders: azblob.BlobHTTPHeaders{ContentType: "application/octet-stream"},
+		})
+		return err
+	})
+}
+
+func (store *azureStore) Get(path string) ([]byte, error) {
+
+	ctx := context.Background()
+	response, err := store.containerURL.NewBlockBlobURL(path).Download(ctx, 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false, azblob.ClientProvidedKeyOptions{})
+	if storageErr, ok := err.(azblob.StorageError); ok && storageErr.ServiceCode() == azblob.ServiceCodeBlobNotFound {
+		return nil, errFileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	body := response.Body(azblob.RetryReaderOptions{MaxRetryRequests: 3})
+	defer body.Close()
+
+	return ioutil.ReadAll(body)
+}
+
+func (store *azureStore) List(prefix string) ([]StoredFile, error) {
+
+	var files []StoredFile
+
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		segment, err := store.containerURL.ListBlobsFlatSegment(context.Background(), marker, azblob.ListBlobsSegmentOptions{Prefix: prefix})
+		if err != nil {
+			return nil, err
+		}
+		for _, blob := range segment.Segment.BlobItems {
+			var size int64
+			if blob.Properties.ContentLength != nil {
+				size = *blob.Properties.ContentLength
+			}
+			files = append(files, StoredFile{Path: blob.Name, Size: size, ModifiedAt: blob.Properties.LastModified})
+		}
+		marker = segment.NextMarker
+	}
+
+	return files, nil
+}
+
+func (store *azureStore) Delete(path string) error {
+
+	_, err := store.containerURL.NewBlobURL(path).Delete(context.Background(), azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
+	if storageErr, ok := err.(azblob.StorageError); ok && storageErr.ServiceCode() == azblob.ServiceCodeBlobNotFound {
+		return nil
+	}
+	return err
+}
+
+func (store *azureStore) URI(path string) string {
+
+	return "abfss://" + store.container + "@" + store.account + ".dfs.core.windows.net/" + path
+}
+
+func (store *azureStore) Close() error {
+	return nil
+}
+
+//HDFS, rooted at the bucket (root folder) of the stream
+type hdfsStore struct {
+	client   *hdfs.Client
+	root     string
+	namenode string
+}
+
+func newHDFSStore(configRecord Config) (*hdfsStore, error) {
+
+	if configRecord.BucketName.String == "" {
+		return nil, errors.New("HDFS root folder (bucket) name cannot be null or empty")
+	}
+
+	namenode := configRecord.NamenodeHost.String + ":" + strconv.Itoa(int(configRecord.NamenodePort.Int64))
+
+	client, err := hdfs.New(namenode)
+	if err != nil {
+		log.Println("Error connecting to HDFS namenode", err)
+		return nil, err
+	}
+
+	return &hdfsStore{client: client, root: "/" + configRecord.BucketName.String, namenode: namenode}, nil
+}
+
+func (store *hdfsStore) Upload(path string, write func(w io.Writer) error) error {
+
+	fullPath := store.root + "/" + path
+	stagedPath := stagingPath(fullPath)
+
+	err := store.client.MkdirAll(filepath.Dir(fullPath), os.FileMode(0777))
+	if err != nil {
+		log.Println("Error creating directory in HDFS", err)
+		return err
+	}
+
+	store.client.Remove(stagedPath) //leftover of an earlier failed attempt, Create does not overwrite
+
+	fileWriter, err := store.client.Create(stagedPath)
+	if err != nil {
+		log.Println("Error creating file in HDFS", err)
+		return err
+	}
+
+	err = write(fileWriter)
+	closeErr := fileWriter.Close() //completes the file on the namenode
+	if err == nil {
+		err = closeErr
+	}
+
+	if err == nil { //commit - HDFS rename does not replace, so a redelivered file is removed first
+		if _, statErr := store.client.Stat(fullPath); statErr == nil {
+			store.client.Remove(fullPath)
+		}
+		err = store.client.Rename(stagedPath, fullPath)
+	}
+
+	if err != nil {
+		log.Println("Error committing file in HDFS", err)
+		store.client.Remove(stagedPath) //do not leave partial files behind
+	}
+	return err
+}
+
+func (store *hdfsStore) Get(path string) ([]byte, error) {
+
+	data, err := store.client.ReadFile(store.root + "/" + path)
+	if os.IsNotExist(err) {
+		return nil, errFileNotFound
+	}
+	return data, err
+}
+
+func (store *hdfsStore) List(prefix string) ([]StoredFile, error) {
+
+	var files []StoredFile
+
+	err := store.client.Walk(store.root+"/"+prefix, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			files = append(files, StoredFile{Path: strings.TrimPrefix(path, store.root+"/"), Size: info.Size(), ModifiedAt: info.ModTime()})
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return files, err
+}
+
+func (store *hdfsStore) Delete(path string) error {
+
+	err := store.client.Remove(store.root + "/" + path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (store *hdfsStore) URI(path string) string {
+
+	return "hdfs://" + store.namenode + store.root + "/" + path
+}
+
+func (store *hdfsStore) Close() error {
+	return store.client.Close()
+}
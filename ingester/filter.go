@@ -0,0 +1,347 @@
+//event filtering: per-stream rules, managed through the config service, drop or sample events before anything is written
+//rules are checked in order and the first matching one decides, events no rule matches are kept
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"log"
+	"math"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//supported values of `filter_operator`
+const (
+	filterOperatorEquals    = "equals"
+	filterOperatorIn        = "in" //`filter_value` is a JSON array
+	filterOperatorRegex     = "regex"
+	filterOperatorExists    = "exists"
+	filterOperatorNotExists = "not_exists"
+	filterOperatorGT        = "gt"
+	filterOperatorGTE       = "gte"
+	filterOperatorLT        = "lt"
+	filterOperatorLTE       = "lte"
+)
+
+//supported values of `filter_action`
+const (
+	filterActionDrop = "drop"
+	filterActionKeep = "keep" //subject to `sample_rate`
+)
+
+//how often the filter counters are added to `filter_stats`
+var filterStatsInterval = getEnvDuration("FILTER_STATS_INTERVAL", time.Minute)
+
+//struct representation of a filter rule
+type FilterRule struct {
+	FilterRuleId   int64          `db:"filter_rule_id"`
+	StreamId       string         `db:"stream_id"`
+	MessageType    sql.NullString `db:"message_type"`
+	JsonPath       sql.NullString `db:"json_path"`
+	FilterOperator sql.NullString `db:"filter_operator"`
+	FilterValue    sql.NullString `db:"filter_value"`
+	FilterAction   string         `db:"filter_action"`
+	SampleRate     float64        `db:"sample_rate"`
+	SampleKey      sql.NullString `db:"sample_key"`
+	RuleOrder      int64          `db:"rule_order"`
+	CreatedAt      time.Time      `db:"created_at"`
+}
+
+//filter rule ready to be applied
+type compiledFilterRule struct {
+	id          int64
+	messageType string   //empty for every message type
+	path        []string //nil for every event
+	operator    string
+	value       interface{}
+	values      []interface{}
+	pattern     *regexp.Regexp
+	action      string
+	sampleRate  float64
+	sampleKey   []string //nil to sample on the whole payload
+}
+
+//filter rules per stream id, in rule order
+var filterRules map[string][]compiledFilterRule
+
+//events a rule matched, dropped and sampled out since the last flush
+type filterCounterKey struct {
+	ruleId      int64
+	streamId    string
+	messageType string
+}
+
+type filterCounter struct {
+	matched    int64
+	dropped    int64
+	sampledOut int64
+}
+
+var filterCountersMutex sync.Mutex
+var filterCounters = map[filterCounterKey]*filterCounter{}
+
+//`filter_value` as JSON, plain text when it is not valid JSON
+func parseFilterValue(filterValue string) interface{} {
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(filterValue), &value); err != nil {
+		return filterValue
+	}
+	return value
+}
+
+//check and compile a filter rule
+func compileFilterRule(rule FilterRule) (compiledFilterRule, error) {
+
+	compiled := compiledFilterRule{
+		id:          rule.FilterRuleId,
+		messageType: rule.MessageType.String,
+		path:        parsePayloadPath(rule.JsonPath.String),
+		operator:    rule.FilterOperator.String,
+		action:      rule.FilterAction,
+		sampleRate:  rule.SampleRate,
+		sampleKey:   parsePayloadPath(rule.SampleKey.String),
+	}
+
+	if compiled.action != filterActionDrop && compiled.action != filterActionKeep {
+		return compiled, errors.New("unsupported filter action " + compiled.action)
+	}
+
+	if compiled.path == nil {
+		return compiled, nil
+	}
+
+	compiled.value = parseFilterValue(rule.FilterValue.String)
+
+	switch compiled.operator {
+	case filterOperatorEquals, filterOperatorExists, filterOperatorNotExists, filterOperatorGT, filterOperatorGTE, filterOperatorLT, filterOperatorLTE:
+	case filterOperatorIn:
+		values, ok := compiled.value.([]interface{})
+		if !ok {
+			return compiled, errors.New("filter value of operator in has to be a JSON array")
+		}
+		compiled.values = values
+	case filterOperatorRegex:
+		expression, ok := compiled.value.(string)
+		if !ok {
+			return compiled, errors.New("filter value of operator regex has to be a string")
+		}
+		pattern, err := regexp.Compile(expression)
+		if err != nil {
+			return compiled, err
+		}
+		compiled.pattern = pattern
+	default:
+		return compiled, errors.New("unsupported filter operator " + compiled.operator)
+	}
+
+	return compiled, nil
+}
+
+//load the filter rules of every stream, rules that do not compile are left out so their events are kept
+func loadFilterRules(db *sqlx.DB) (map[string][]compiledFilterRule, error) {
+
+	var rules []FilterRule
+	err := db.Select(&rules, "SELECT * FROM filter_rules ORDER BY stream_id, rule_order, filter_rule_id")
+	if err != nil {
+		return nil, err
+	}
+
+	compiledRules := map[string][]compiledFilterRule{}
+
+	for _, rule := range rules {
+		compiled, err := compileFilterRule(rule)
+		if err != nil {
+			log.Println("Invalid filter rule", rule.FilterRuleId, "of stream", rule.StreamId, err)
+			continue
+		}
+		compiledRules[rule.StreamId] = append(compiledRules[rule.StreamId], compiled)
+	}
+
+	return compiledRules, nil
+}
+
+//true when the predicate of a rule holds for a payload
+func (rule compiledFilterRule) matches(payload map[string]interface{}) bool {
+
+	if rule.path == nil {
+		return true
+	}
+
+	value := getPayloadValue(payload, rule.path)
+
+	switch rule.operator {
+	case filterOperatorExists:
+		return value != nil
+	case filterOperatorNotExists:
+		return value == nil
+	}
+
+	if value == nil {
+		return false
+	}
+
+	switch rule.operator {
+	case filterOperatorEquals:
+		return compareExpressionValues(value, rule.value) == 0
+	case filterOperatorIn:
+		for _, candidate := range rule.values {
+			if compareExpressionValues(value, candidate) == 0 {
+				return true
+			}
+		}
+		return false
+	case filterOperatorRegex:
+		text, err := castValue(value, castTypeString)
+		return err == nil && rule.pattern.MatchString(text.(string))
+	}
+
+	if describeValue(value) != describeValue(rule.value) { //numbers with numbers, strings with strings
+		return false
+	}
+
+	order := compareExpressionValues(value, rule.value)
+	switch rule.operator {
+	case filterOperatorGT:
+		return order > 0
+	case filterOperatorGTE:
+		return order >= 0
+	case filterOperatorLT:
+		return order < 0
+	case filterOperatorLTE:
+		return order <= 0
+	}
+	return false
+}
+
+//position of an event in [0, 1) for sampling, the same event (or sample key value) always lands on the same position
+func getSamplePosition(payload map[string]interface{}, sampleKey []string) float64 {
+
+	var key []byte
+	if sampleKey != nil {
+		key, _ = json.Marshal(getPayloadValue(payload, sampleKey))
+	} else {
+		key, _ = json.Marshal(payload) //object keys are marshalled in order
+	}
+
+	hash := fnv.New64a()
+	hash.Write(key)
+	return float64(hash.Sum64()>>11) / math.Pow(2, 53) //53 bits convert exactly, all 64 could round up to 1
+}
+
+//false when an event of a stream has to be dropped
+func filterEvent(payload map[string]interface{}, streamId string, messageType string) bool {
+
+	for _, rule := range filterRules[streamId] {
+
+		if rule.messageType != "" && rule.messageType != messageType {
+			continue
+		}
+		if !rule.matches(payload) {
+			continue
+		}
+
+		counter := filterCounter{matched: 1}
+		keep := true
+
+		if rule.action == filterActionDrop {
+			counter.dropped = 1
+			keep = false
+		} else if rule.sampleRate < 1 && getSamplePosition(payload, rule.sampleKey) >= rule.sampleRate {
+			counter.sampledOut = 1
+			keep = false
+		}
+
+		countFilterEvent(filterCounterKey{ruleId: rule.id, streamId: streamId, messageType: messageType}, counter)
+		return keep
+	}
+
+	return true
+}
+
+func countFilterEvent(key filterCounterKey, counter filterCounter) {
+
+	filterCountersMutex.Lock()
+	defer filterCountersMutex.Unlock()
+
+	total, found := filterCounters[key]
+	if !found {
+		total = &filterCounter{}
+		filterCounters[key] = total
+	}
+	total.matched += counter.matched
+	total.dropped += counter.dropped
+	total.sampledOut += counter.sampledOut
+}
+
+//add the counters gathered since the last flush to `filter_stats`
+//counters that could not be written are kept for the next flush, unless their rule has been deleted in the meantime
+func flushFilterCounters() {
+
+	filterCountersMutex.Lock()
+	counters := filterCounters
+	filterCounters = map[filterCounterKey]*filterCounter{}
+	filterCountersMutex.Unlock()
+
+	if len(counters) == 0 {
+		return
+	}
+
+	db, err := sqlx.Open("postgres", psqlCon)
+	if err != nil {
+		log.Println("Failed to open a DB connection: ", err)
+		for key, counter := range counters {
+			countFilterEvent(key, *counter)
+		}
+		return
+	}
+	defer db.Close()
+
+	for key, counter := range counters {
+
+		_, err := db.Exec(`INSERT INTO filter_stats (filter_rule_id, stream_id, message_type, events_matched, events_dropped, events_sampled_out)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (filter_rule_id, message_type) DO UPDATE SET
+				events_matched = filter_stats.events_matched + EXCLUDED.events_matched,
+				events_dropped = filter_stats.events_dropped + EXCLUDED.events_dropped,
+				events_sampled_out = filter_stats.events_sampled_out + EXCLUDED.events_sampled_out,
+				updated_at = NOW()`,
+			key.ruleId, key.streamId, key.messageType, counter.matched, counter.dropped, counter.sampledOut)
+		if err == nil {
+			continue
+		}
+
+		log.Println("Error recording filter counters of rule", key.ruleId, err)
+		if hasFilterRule(key.streamId, key.ruleId) {
+			countFilterEvent(key, *counter)
+		}
+	}
+}
+
+func hasFilterRule(streamId string, ruleId int64) bool {
+
+	for _, rule := range filterRules[streamId] {
+		if rule.id == ruleId {
+			return true
+		}
+	}
+	return false
+}
+
+//background job writing the filter counters, one flush per interval
+func runFilterStats() {
+
+	ticker := time.NewTicker(filterStatsInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		flushFilterCounters()
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"strconv"
+	"testing"
+)
+
+//filter rule as loaded from `filter_rules`
+func newFilterRule(id int64, jsonPath string, operator string, value string, action string, sampleRate float64) FilterRule {
+
+	return FilterRule{
+		FilterRuleId:   id,
+		JsonPath:       sql.NullString{String: jsonPath, Valid: jsonPath != ""},
+		FilterOperator: sql.NullString{String: operator, Valid: operator != ""},
+		FilterValue:    sql.NullString{String: value, Valid: value != ""},
+		FilterAction:   action,
+		SampleRate:     sampleRate,
+	}
+}
+
+func TestFilterRuleMatches(t *testing.T) {
+
+	payload := decodePayload(t, `{"type": "page", "status": 404, "path": "/health/live", "user": {"plan": "free"}, "score": 0.5}`)
+
+	tests := []struct {
+		jsonPath string
+		operator string
+		value    string
+		want     bool
+	}{
+		{"", "", "", true}, //every event
+		{"type", filterOperatorEquals, `"page"`, true},
+		{"type", filterOperatorEquals, `page`, true}, //plain text when not JSON
+		{"status", filterOperatorEquals, `404`, true},
+		{"status", filterOperatorEquals, `"404"`, false},
+		{"user.plan", filterOperatorIn, `["free", "trial"]`, true},
+		{"user.plan", filterOperatorIn, `["pro"]`, false},
+		{"path", filterOperatorRegex, `"^/health"`, true},
+		{"status", filterOperatorRegex, `"^4\\d\\d$"`, true},
+		{"user.plan", filterOperatorExists, "", true},
+		{"user.email", filterOperatorExists, "", false},
+		{"user.email", filterOperatorNotExists, "", true},
+		{"status", filterOperatorGT, `400`, true},
+		{"status", filterOperatorGTE, `404`, true},
+		{"status", filterOperatorLT, `404`, false},
+		{"score", filterOperatorLTE, `0.5`, true},
+		{"status", filterOperatorGT, `"400"`, false}, //numbers only compare with numbers
+		{"missing", filterOperatorEquals, `null`, false},
+		{"missing", filterOperatorLT, `1`, false},
+	}
+
+	for _, test := range tests {
+
+		rule, err := compileFilterRule(newFilterRule(1, test.jsonPath, test.operator, test.value, filterActionDrop, 1))
+		if err != nil {
+			t.Errorf("compileFilterRule(%s %s %s): %v", test.jsonPath, test.operator, test.value, err)
+			continue
+		}
+		if got := rule.matches(payload); got != test.want {
+			t.Errorf("%s %s %s matches = %v, want %v", test.jsonPath, test.operator, test.value, got, test.want)
+		}
+	}
+}
+
+func TestCompileFilterRuleErrors(t *testing.T) {
+
+	tests := []FilterRule{
+		newFilterRule(1, "type", filterOperatorEquals, `"page"`, "archive", 1),
+		newFilterRule(1, "type", "like", `"page"`, filterActionDrop, 1),
+		newFilterRule(1, "type", filterOperatorIn, `"page"`, filterActionDrop, 1),
+		newFilterRule(1, "type", filterOperatorRegex, `42`, filterActionDrop, 1),
+		newFilterRule(1, "type", filterOperatorRegex, `"(["`, filterActionDrop, 1),
+	}
+
+	for _, rule := range tests {
+		if _, err := compileFilterRule(rule); err == nil {
+			t.Errorf("compileFilterRule(%s %s %s) succeeded, want an error", rule.FilterAction, rule.FilterOperator.String, rule.FilterValue.String)
+		}
+	}
+}
+
+func TestFilterEvent(t *testing.T) {
+
+	defer func(rules map[string][]compiledFilterRule) { filterRules = rules }(filterRules)
+	defer func(counters map[filterCounterKey]*filterCounter) { filterCounters = counters }(filterCounters)
+
+	var rules []compiledFilterRule
+	for _, rule := range []FilterRule{
+		newFilterRule(1, "path", filterOperatorRegex, `"^/health"`, filterActionDrop, 1),
+		newFilterRule(2, "user.plan", filterOperatorEquals, `"pro"`, filterActionKeep, 1), //decides before the drop below
+		newFilterRule(3, "", "", "", filterActionDrop, 1),
+	} {
+		compiled, err := compileFilterRule(rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, compiled)
+	}
+	pageViews, _ := compileFilterRule(newFilterRule(4, "path", filterOperatorExists, "", filterActionDrop, 1))
+	pageViews.messageType = "page"
+
+	filterRules = map[string][]compiledFilterRule{"stream": append([]compiledFilterRule{pageViews}, rules...)}
+	filterCounters = map[filterCounterKey]*filterCounter{}
+
+	tests := []struct {
+		payload     string
+		messageType string
+		want        bool
+	}{
+		{`{"path": "/health/live"}`, "track", false},
+		{`{"path": "/checkout", "user": {"plan": "pro"}}`, "track", true},
+		{`{"path": "/checkout", "user": {"plan": "free"}}`, "track", false},
+		{`{"path": "/checkout", "user": {"plan": "pro"}}`, "page", false}, //rule of the message type comes first
+	}
+
+	for _, test := range tests {
+		if got := filterEvent(decodePayload(t, test.payload), "stream", test.messageType); got != test.want {
+			t.Errorf("filterEvent(%s, %s) = %v, want %v", test.payload, test.messageType, got, test.want)
+		}
+	}
+
+	if got := filterEvent(decodePayload(t, `{}`), "other stream", "track"); !got {
+		t.Errorf("event of a stream without rules dropped")
+	}
+
+	wantCounters := map[filterCounterKey]filterCounter{
+		{ruleId: 1, streamId: "stream", messageType: "track"}: {matched: 1, dropped: 1},
+		{ruleId: 2, streamId: "stream", messageType: "track"}: {matched: 1},
+		{ruleId: 3, streamId: "stream", messageType: "track"}: {matched: 1, dropped: 1},
+		{ruleId: 4, streamId: "stream", messageType: "page"}:  {matched: 1, dropped: 1},
+	}
+	if len(filterCounters) != len(wantCounters) {
+		t.Errorf("%d counters, want %d", len(filterCounters), len(wantCounters))
+	}
+	for key, want := range wantCounters {
+		if got, found := filterCounters[key]; !found || *got != want {
+			t.Errorf("counter of rule %d = %+v, want %+v", key.ruleId, got, want)
+		}
+	}
+}
+
+func TestFilterEventSampling(t *testing.T) {
+
+	defer func(rules map[string][]compiledFilterRule) { filterRules = rules }(filterRules)
+	defer func(counters map[filterCounterKey]*filterCounter) { filterCounters = counters }(filterCounters)
+
+	sampled, err := compileFilterRule(newFilterRule(1, "", "", "", filterActionKeep, 0.25))
+	if err != nil {
+		t.Fatal(err)
+	}
+	filterRules = map[string][]compiledFilterRule{"stream": {sampled}}
+	filterCounters = map[filterCounterKey]*filterCounter{}
+
+	events := 20000
+	kept := 0
+	for i := 0; i < events; i++ {
+		payload := decodePayload(t, `{"id": `+strconv.Itoa(i)+`}`)
+		keep := filterEvent(payload, "stream", "track")
+		if keep {
+			kept++
+		}
+		if filterEvent(payload, "stream", "track") != keep { //the same event is always sampled the same way
+			t.Fatalf("event %d sampled differently twice", i)
+		}
+	}
+
+	if rate := float64(kept) / float64(events); math.Abs(rate-0.25) > 0.02 {
+		t.Errorf("kept %.3f of the events, want about 0.25", rate)
+	}
+
+	counter := filterCounters[filterCounterKey{ruleId: 1, streamId: "stream", messageType: "track"}]
+	if counter.matched != int64(2*events) || counter.sampledOut != int64(2*(events-kept)) || counter.dropped != 0 {
+		t.Errorf("counter %+v, want %d matched and %d sampled out", *counter, 2*events, 2*(events-kept))
+	}
+}
+
+func TestGetSamplePosition(t *testing.T) {
+
+	sampleKey := parsePayloadPath("user.id")
+
+	first := getSamplePosition(decodePayload(t, `{"user": {"id": 7}, "page": "/a"}`), sampleKey)
+	second := getSamplePosition(decodePayload(t, `{"user": {"id": 7}, "page": "/b"}`), sampleKey)
+	if first != second {
+		t.Errorf("events of the same sample key at %v and %v", first, second)
+	}
+
+	whole := getSamplePosition(decodePayload(t, `{"user": {"id": 7}, "page": "/a"}`), nil)
+	reordered := getSamplePosition(decodePayload(t, `{"page": "/a", "user": {"id": 7}}`), nil)
+	if whole != reordered {
+		t.Errorf("same event at %v and %v depending on key order", whole, reordered)
+	}
+
+	for i := 0; i < 1000; i++ {
+		if position := getSamplePosition(decodePayload(t, `{"id": `+strconv.Itoa(i)+`}`), nil); position < 0 || position >= 1 {
+			t.Fatalf("sample position %v out of [0, 1)", position)
+		}
+	}
+}
@@ -0,0 +1,688 @@
+//Apache Iceberg (format version 1) commits using the Hadoop catalog layout:
+//  <table>/metadata/v<N>.metadata.json  table metadata, one file per commit
+//  <table>/metadata/version-hint.text   current metadata version
+//  <table>/metadata/snap-*.avro         manifest list of a snapshot
+//  <table>/metadata/*-m0.avro           manifest of the files added by a snapshot
+//data files stay in the partition folders they were written to; the table itself is unpartitioned
+//and columns are resolved by name through `schema.name-mapping.default` as the Parquet files carry no field ids
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/linkedin/goavro/v2"
+)
+
+const (
+	icebergMetadataFolder  = "metadata"
+	icebergVersionHintFile = "version-hint.text"
+	icebergBlockSize       = 64 * 1024 * 1024 //v1 manifests require a block size per data file
+	icebergLastPartitionId = 999              //no partition fields have been assigned
+)
+
+//manifest entry schema (v1) - field ids are part of the spec
+const icebergManifestEntrySchema = `{
+	"type": "record", "name": "manifest_entry", "fields": [
+		{"name": "status", "type": "int", "field-id": 0},
+		{"name": "snapshot_id", "type": "long", "field-id": 1},
+		{"name": "data_file", "field-id": 2, "type": {
+			"type": "record", "name": "r2", "fields": [
+				{"name": "file_path", "type": "string", "field-id": 100},
+				{"name": "file_format", "type": "string", "field-id": 101},
+				{"name": "partition", "field-id": 102, "type": {"type": "record", "name": "r102", "fields": []}},
+				{"name": "record_count", "type": "long", "field-id": 103},
+				{"name": "file_size_in_bytes", "type": "long", "field-id": 104},
+				{"name": "block_size_in_bytes", "type": "long", "field-id": 105}
+			]
+		}}
+	]
+}`
+
+//manifest list schema (v1)
+const icebergManifestListSchema = `{
+	"type": "record", "name": "manifest_file", "fields": [
+		{"name": "manifest_path", "type": "string", "field-id": 500},
+		{"name": "manifest_length", "type": "long", "field-id": 501},
+		{"name": "partition_spec_id", "type": "int", "field-id": 502},
+		{"name": "added_snapshot_id", "type": ["null", "long"], "default": null, "field-id": 503},
+		{"name": "added_data_files_count", "type": ["null", "int"], "default": null, "field-id": 504},
+		{"name": "existing_data_files_count", "type": ["null", "int"], "default": null, "field-id": 505},
+		{"name": "deleted_data_files_count", "type": ["null", "int"], "default": null, "field-id": 506},
+		{"name": "added_rows_count", "type": ["null", "long"], "default": null, "field-id": 512},
+		{"name": "existing_rows_count", "type": ["null", "long"], "default": null, "field-id": 513},
+		{"name": "deleted_rows_count", "type": ["null", "long"], "default": null, "field-id": 514}
+	]
+}`
+
+//status of a manifest entry
+const icebergStatusAdded = 1
+
+//Iceberg type - a primitive name, a struct or a list
+type icebergType struct {
+	Primitive string
+	Struct    *icebergStruct
+	List      *icebergList
+}
+
+type icebergStruct struct {
+	Fields []icebergField `json:"fields"`
+}
+
+type icebergList struct {
+	ElementId       int         `json:"element-id"`
+	Element         icebergType `json:"element"`
+	ElementRequired bool        `json:"element-required"`
+}
+
+type icebergField struct {
+	Id       int         `json:"id"`
+	Name     string      `json:"name"`
+	Required bool        `json:"required"`
+	Type     icebergType `json:"type"`
+}
+
+type icebergSchema struct {
+	Type     string         `json:"type"`
+	SchemaId int            `json:"schema-id"`
+	Fields   []icebergField `json:"fields"`
+}
+
+type icebergPartitionSpec struct {
+	SpecId int           `json:"spec-id"`
+	Fields []interface{} `json:"fields"`
+}
+
+type icebergSortOrder struct {
+	OrderId int           `json:"order-id"`
+	Fields  []interface{} `json:"fields"`
+}
+
+type icebergSnapshot struct {
+	SnapshotId       int64             `json:"snapshot-id"`
+	ParentSnapshotId *int64            `json:"parent-snapshot-id,omitempty"`
+	TimestampMs      int64             `json:"timestamp-ms"`
+	Summary          map[string]string `json:"summary"`
+	ManifestList     string            `json:"manifest-list"`
+	SchemaId         int               `json:"schema-id"`
+}
+
+type icebergSnapshotLogEntry struct {
+	TimestampMs int64 `json:"timestamp-ms"`
+	SnapshotId  int64 `json:"snapshot-id"`
+}
+
+type icebergMetadataLogEntry struct {
+	TimestampMs  int64  `json:"timestamp-ms"`
+	MetadataFile string `json:"metadata-file"`
+}
+
+//table metadata (v1)
+type icebergTableMetadata struct {
+	FormatVersion      int                       `json:"format-version"`
+	TableUUID          string                    `json:"table-uuid"`
+	Location           string                    `json:"location"`
+	LastUpdatedMs      int64                     `json:"last-updated-ms"`
+	LastColumnId       int                       `json:"last-column-id"`
+	Schema             icebergSchema             `json:"schema"`
+	CurrentSchemaId    int                       `json:"current-schema-id"`
+	Schemas            []icebergSchema           `json:"schemas"`
+	PartitionSpec      []interface{}             `json:"partition-spec"`
+	DefaultSpecId      int                       `json:"default-spec-id"`
+	PartitionSpecs     []icebergPartitionSpec    `json:"partition-specs"`
+	LastPartitionId    int                       `json:"last-partition-id"`
+	DefaultSortOrderId int                       `json:"default-sort-order-id"`
+	SortOrders         []icebergSortOrder        `json:"sort-orders"`
+	Properties         map[string]string         `json:"properties"`
+	CurrentSnapshotId  int64                     `json:"current-snapshot-id"`
+	Snapshots          []icebergSnapshot         `json:"snapshots"`
+	SnapshotLog        []icebergSnapshotLogEntry `json:"snapshot-log"`
+	MetadataLog        []icebergMetadataLogEntry `json:"metadata-log"`
+}
+
+//name mapping entry, see `schema.name-mapping.default`
+type icebergNameMapping struct {
+	FieldId int                  `json:"field-id"`
+	Names   []string             `json:"names"`
+	Fields  []icebergNameMapping `json:"fields,omitempty"`
+}
+
+func (t icebergType) MarshalJSON() ([]byte, error) {
+
+	switch {
+	case t.Struct != nil:
+		return json.Marshal(struct {
+			Type string `json:"type"`
+			*icebergStruct
+		}{"struct", t.Struct})
+	case t.List != nil:
+		return json.Marshal(struct {
+			Type string `json:"type"`
+			*icebergList
+		}{"list", t.List})
+	}
+	return json.Marshal(t.Primitive)
+}
+
+func (t *icebergType) UnmarshalJSON(data []byte) error {
+
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &t.Primitive)
+	}
+
+	var kind struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &kind); err != nil {
+		return err
+	}
+
+	switch kind.Type {
+	case "struct":
+		t.Struct = &icebergStruct{}
+		return json.Unmarshal(data, t.Struct)
+	case "list":
+		t.List = &icebergList{}
+		return json.Unmarshal(data, t.List)
+	}
+	return fmt.Errorf("unsupported Iceberg type %s", kind.Type)
+}
+
+func (t icebergType) String() string {
+
+	switch {
+	case t.Struct != nil:
+		return "struct"
+	case t.List != nil:
+		return "list<" + t.List.Element.String() + ">"
+	}
+	return t.Primitive
+}
+
+//node of the Parquet JSON schema built by GenerateSchema
+type parquetSchemaNode struct {
+	Tag    string              `json:"Tag"`
+	Fields []parquetSchemaNode `json:"Fields"`
+}
+
+//value of a key in a Parquet schema tag, e.g. "name=x, type=DOUBLE"
+func (node parquetSchemaNode) tagValue(key string) string {
+
+	for _, part := range strings.Split(node.Tag, ",") {
+		pair := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(pair) == 2 && strings.EqualFold(pair[0], key) {
+			return pair[1]
+		}
+	}
+	return ""
+}
+
+//Iceberg primitive of a Parquet physical type
+func getIcebergPrimitive(parquetType string) string {
+
+	switch parquetType {
+	case "BYTE_ARRAY":
+		return "string"
+	case "INT32":
+		return "int"
+	case "INT64":
+		return "long"
+	case "INT96":
+		return "timestamp"
+	case "FLOAT":
+		return "float"
+	case "DOUBLE":
+		return "double"
+	case "BOOLEAN":
+		return "boolean"
+	}
+	return ""
+}
+
+//new Iceberg type for a Parquet schema node, ids are taken from lastColumnId
+func newIcebergType(node parquetSchemaNode, lastColumnId *int) (icebergType, error) {
+
+	parquetType := node.tagValue("type")
+
+	switch {
+	case parquetType == "LIST":
+		if len(node.Fields) != 1 {
+			return icebergType{}, fmt.Errorf("list %s must have exactly one element", node.tagValue("name"))
+		}
+		*lastColumnId++
+		list := &icebergList{ElementId: *lastColumnId}
+		element, err := newIcebergType(node.Fields[0], lastColumnId)
+		if err != nil {
+			return icebergType{}, err
+		}
+		list.Element = element
+		return icebergType{List: list}, nil
+
+	case parquetType == "":
+		fields, err := mergeIcebergFields(nil, node.Fields, lastColumnId)
+		if err != nil {
+			return icebergType{}, err
+		}
+		return icebergType{Struct: &icebergStruct{Fields: fields}}, nil
+	}
+
+	primitive := getIcebergPrimitive(parquetType)
+	if primitive == "" {
+		return icebergType{}, fmt.Errorf("unsupported Parquet type %s", parquetType)
+	}
+	return icebergType{Primitive: primitive}, nil
+}
+
+//merge a Parquet node into an existing Iceberg type - new struct fields are added, types may only be widened
+func mergeIcebergType(existing icebergType, node parquetSchemaNode, lastColumnId *int) (icebergType, error) {
+
+	incoming, err := newIcebergType(node, new(int)) //ids are irrelevant, only used for the comparison
+	if err != nil {
+		return existing, err
+	}
+
+	switch {
+	case existing.Struct != nil && incoming.Struct != nil:
+		fields, err := mergeIcebergFields(existing.Struct.Fields, node.Fields, lastColumnId)
+		if err != nil {
+			return existing, err
+		}
+		return icebergType{Struct: &icebergStruct{Fields: fields}}, nil
+
+	case existing.List != nil && incoming.List != nil:
+		element, err := mergeIcebergType(existing.List.Element, node.Fields[0], lastColumnId)
+		if err != nil {
+			return existing, err
+		}
+		list := *existing.List
+		list.Element = element
+		return icebergType{List: &list}, nil
+
+	case existing.Primitive != "" && existing.Primitive == incoming.Primitive:
+		return existing, nil
+
+	case existing.Primitive == "long" && incoming.Primitive == "int",
+		existing.Primitive == "double" && incoming.Primitive == "float":
+		return existing, nil
+
+	case existing.Primitive == "int" && incoming.Primitive == "long",
+		existing.Primitive == "float" && incoming.Primitive == "double": //allowed type promotions
+		return incoming, nil
+	}
+
+	return existing, fmt.Errorf("column %s cannot change type from %s to %s", node.tagValue("name"), existing, incoming)
+}
+
+//merge the fields of a Parquet group into Iceberg fields by name
+//columns are optional as messages of the same type do not need to carry all of them
+func mergeIcebergFields(existing []icebergField, nodes []parquetSchemaNode, lastColumnId *int) ([]icebergField, error) {
+
+	fields := append([]icebergField{}, existing...)
+
+	for _, node := range nodes {
+
+		name := node.tagValue("name")
+		found := false
+
+		for i := range fields {
+			if fields[i].Name == name {
+				merged, err := mergeIcebergType(fields[i].Type, node, lastColumnId)
+				if err != nil {
+					return nil, err
+				}
+				fields[i].Type = merged
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			*lastColumnId++
+			field := icebergField{Id: *lastColumnId, Name: name}
+			fieldType, err := newIcebergType(node, lastColumnId)
+			if err != nil {
+				return nil, err
+			}
+			field.Type = fieldType
+			fields = append(fields, field)
+		}
+	}
+
+	return fields, nil
+}
+
+//name mapping of Iceberg fields to Parquet column names
+func generateIcebergNameMapping(fields []icebergField) []icebergNameMapping {
+
+	var mappings []icebergNameMapping
+
+	for _, field := range fields {
+		mapping := icebergNameMapping{FieldId: field.Id, Names: []string{field.Name}}
+		mapping.Fields = generateIcebergTypeNameMapping(field.Type)
+		mappings = append(mappings, mapping)
+	}
+
+	return mappings
+}
+
+func generateIcebergTypeNameMapping(fieldType icebergType) []icebergNameMapping {
+
+	switch {
+	case fieldType.Struct != nil:
+		return generateIcebergNameMapping(fieldType.Struct.Fields)
+	case fieldType.List != nil:
+		return []icebergNameMapping{{
+			FieldId: fieldType.List.ElementId,
+			Names:   []string{"element"},
+			Fields:  generateIcebergTypeNameMapping(fieldType.List.Element),
+		}}
+	}
+	return nil
+}
+
+//random positive id for snapshots
+func generateIcebergSnapshotId() int64 {
+
+	var buffer [8]byte
+	rand.Read(buffer[:])
+	return int64(binary.BigEndian.Uint64(buffer[:]) & 0x7fffffffffffffff)
+}
+
+//write records as an Avro object container file
+func writeAvroFile(store FileStore, path string, schema string, metadata map[string]string, records []interface{}) (int64, error) {
+
+	codec, err := goavro.NewCodec(schema)
+	if err != nil {
+		return 0, err
+	}
+
+	avroMetadata := map[string][]byte{}
+	for key, value := range metadata {
+		avroMetadata[key] = []byte(value)
+	}
+
+	var buffer bytes.Buffer
+	writer, err := goavro.NewOCFWriter(goavro.OCFConfig{W: &buffer, Codec: codec, MetaData: avroMetadata})
+	if err != nil {
+		return 0, err
+	}
+
+	if err = writer.Append(records); err != nil {
+		return 0, err
+	}
+
+	return int64(buffer.Len()), putFile(store, path, buffer.Bytes())
+}
+
+//read the records of an Avro object container file
+func readAvroFile(store FileStore, path string) ([]interface{}, error) {
+
+	data, err := store.Get(path)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := goavro.NewOCFReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var records []interface{}
+	for reader.Scan() {
+		record, err := reader.Read()
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, reader.Err()
+}
+
+//path of a metadata location relative to the store root
+func icebergRelativePath(store FileStore, uri string) string {
+
+	return strings.TrimPrefix(uri, store.URI(""))
+}
+
+//read the current metadata of a table, nil when the table does not exist yet
+func readIcebergMetadata(store FileStore, table string) (*icebergTableMetadata, int, error) {
+
+	metadataFolder := table + "/" + icebergMetadataFolder
+
+	versionHint, err := store.Get(metadataFolder + "/" + icebergVersionHintFile)
+	if err == errFileNotFound {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	version, err := strconv.Atoi(strings.TrimSpace(string(versionHint)))
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid Iceberg version hint of %s: %w", table, err)
+	}
+
+	data, err := store.Get(metadataFolder + "/v" + strconv.Itoa(version) + ".metadata.json")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var metadata icebergTableMetadata
+	if err = json.Unmarshal(data, &metadata); err != nil {
+		return nil, 0, err
+	}
+
+	return &metadata, version, nil
+}
+
+//append data files to an Iceberg table as a new snapshot, creating the table on first commit
+//callers have to make sure there is a single committer per table
+func commitIcebergTable(store FileStore, table string, dataFiles []TableDataFile, schemas map[string]string) error {
+
+	nowMs := time.Now().UnixNano() / int64(time.Millisecond)
+	metadataFolder := table + "/" + icebergMetadataFolder
+
+	metadata, version, err := readIcebergMetadata(store, table)
+	if err != nil {
+		return err
+	}
+
+	if metadata == nil { //new table
+		metadata = &icebergTableMetadata{
+			FormatVersion:     1,
+			TableUUID:         uuid.New().String(),
+			Location:          store.URI(table),
+			Schema:            icebergSchema{Type: "struct", SchemaId: 0, Fields: []icebergField{}},
+			PartitionSpec:     []interface{}{},
+			PartitionSpecs:    []icebergPartitionSpec{{SpecId: 0, Fields: []interface{}{}}},
+			LastPartitionId:   icebergLastPartitionId,
+			SortOrders:        []icebergSortOrder{{OrderId: 0, Fields: []interface{}{}}},
+			Properties:        map[string]string{"write.format.default": "parquet"},
+			CurrentSnapshotId: -1,
+		}
+		metadata.Schemas = []icebergSchema{metadata.Schema}
+	}
+
+	//schema evolution - columns of every new file are merged into the current schema
+	fields := metadata.Schema.Fields
+	lastColumnId := metadata.LastColumnId
+
+	rejectedSchemas := map[string]bool{}
+
+	for schemaHash, schema := range schemas {
+		var root parquetSchemaNode
+		mergeColumnId := lastColumnId
+		err = json.Unmarshal([]byte(schema), &root)
+		if err == nil {
+			var mergedFields []icebergField
+			if mergedFields, err = mergeIcebergFields(fields, root.Fields, &mergeColumnId); err == nil {
+				fields = mergedFields
+				lastColumnId = mergeColumnId
+			}
+		}
+		if err != nil { //files that do not fit the table stay out of it instead of blocking every later commit
+			log.Println("Files with schema", schemaHash, "cannot be committed to table", table, err)
+			rejectedSchemas[schemaHash] = true
+		}
+	}
+
+	var acceptedFiles []TableDataFile
+	for _, dataFile := range dataFiles {
+		if !rejectedSchemas[dataFile.SchemaHash] {
+			acceptedFiles = append(acceptedFiles, dataFile)
+		}
+	}
+	if len(acceptedFiles) == 0 {
+		return nil
+	}
+	dataFiles = acceptedFiles
+
+	previousFields, _ := json.Marshal(metadata.Schema.Fields)
+	mergedFields, _ := json.Marshal(fields)
+
+	if !bytes.Equal(previousFields, mergedFields) {
+		schemaId := 0
+		for _, schema := range metadata.Schemas {
+			if schema.SchemaId >= schemaId {
+				schemaId = schema.SchemaId + 1
+			}
+		}
+		if version == 0 {
+			schemaId = 0 //first schema of a new table
+			metadata.Schemas = nil
+		}
+		metadata.Schema = icebergSchema{Type: "struct", SchemaId: schemaId, Fields: fields}
+		metadata.Schemas = append(metadata.Schemas, metadata.Schema)
+		metadata.CurrentSchemaId = schemaId
+		metadata.LastColumnId = lastColumnId
+	}
+
+	nameMapping, _ := json.Marshal(generateIcebergNameMapping(metadata.Schema.Fields))
+	if metadata.Properties == nil {
+		metadata.Properties = map[string]string{}
+	}
+	metadata.Properties["schema.name-mapping.default"] = string(nameMapping)
+
+	schemaJson, _ := json.Marshal(metadata.Schema)
+	snapshotId := generateIcebergSnapshotId()
+	commitId := uuid.New().String()
+
+	//manifest of the added files
+	var entries []interface{}
+	var addedRows int64
+	for _, dataFile := range dataFiles {
+		entries = append(entries, map[string]interface{}{
+			"status":      icebergStatusAdded,
+			"snapshot_id": snapshotId,
+			"data_file": map[string]interface{}{
+				"file_path":           store.URI(dataFile.Path),
+				"file_format":         "PARQUET",
+				"partition":           map[string]interface{}{},
+				"record_count":        dataFile.Rows,
+				"file_size_in_bytes":  dataFile.SizeBytes,
+				"block_size_in_bytes": int64(icebergBlockSize),
+			},
+		})
+		addedRows += dataFile.Rows
+	}
+
+	manifestPath := metadataFolder + "/" + commitId + "-m0.avro"
+	manifestLength, err := writeAvroFile(store, manifestPath, icebergManifestEntrySchema, map[string]string{
+		"schema":            string(schemaJson),
+		"schema-id":         strconv.Itoa(metadata.Schema.SchemaId),
+		"partition-spec":    "[]",
+		"partition-spec-id": "0",
+		"format-version":    "1",
+	}, entries)
+	if err != nil {
+		return err
+	}
+
+	//manifest list - manifests of the parent snapshot plus the new one
+	var manifests []interface{}
+	var parentSnapshotId *int64
+
+	for _, snapshot := range metadata.Snapshots {
+		if snapshot.SnapshotId == metadata.CurrentSnapshotId {
+			parentManifests, err := readAvroFile(store, icebergRelativePath(store, snapshot.ManifestList))
+			if err != nil {
+				return err
+			}
+			manifests = append(manifests, parentManifests...)
+			currentSnapshotId := snapshot.SnapshotId
+			parentSnapshotId = &currentSnapshotId
+		}
+	}
+
+	manifests = append(manifests, map[string]interface{}{
+		"manifest_path":             store.URI(manifestPath),
+		"manifest_length":           manifestLength,
+		"partition_spec_id":         0,
+		"added_snapshot_id":         goavro.Union("long", snapshotId),
+		"added_data_files_count":    goavro.Union("int", int32(len(dataFiles))),
+		"existing_data_files_count": goavro.Union("int", int32(0)),
+		"deleted_data_files_count":  goavro.Union("int", int32(0)),
+		"added_rows_count":          goavro.Union("long", addedRows),
+		"existing_rows_count":       goavro.Union("long", int64(0)),
+		"deleted_rows_count":        goavro.Union("long", int64(0)),
+	})
+
+	manifestListMetadata := map[string]string{
+		"snapshot-id":    strconv.FormatInt(snapshotId, 10),
+		"format-version": "1",
+	}
+	if parentSnapshotId != nil {
+		manifestListMetadata["parent-snapshot-id"] = strconv.FormatInt(*parentSnapshotId, 10)
+	}
+
+	manifestListPath := metadataFolder + "/snap-" + strconv.FormatInt(snapshotId, 10) + "-1-" + commitId + ".avro"
+	if _, err = writeAvroFile(store, manifestListPath, icebergManifestListSchema, manifestListMetadata, manifests); err != nil {
+		return err
+	}
+
+	//new table metadata
+	metadata.Snapshots = append(metadata.Snapshots, icebergSnapshot{
+		SnapshotId:       snapshotId,
+		ParentSnapshotId: parentSnapshotId,
+		TimestampMs:      nowMs,
+		Summary: map[string]string{
+			"operation":        "append",
+			"added-data-files": strconv.Itoa(len(dataFiles)),
+			"added-records":    strconv.FormatInt(addedRows, 10),
+		},
+		ManifestList: store.URI(manifestListPath),
+		SchemaId:     metadata.Schema.SchemaId,
+	})
+	metadata.CurrentSnapshotId = snapshotId
+	metadata.SnapshotLog = append(metadata.SnapshotLog, icebergSnapshotLogEntry{TimestampMs: nowMs, SnapshotId: snapshotId})
+	if version > 0 {
+		metadata.MetadataLog = append(metadata.MetadataLog, icebergMetadataLogEntry{
+			TimestampMs:  metadata.LastUpdatedMs,
+			MetadataFile: store.URI(metadataFolder + "/v" + strconv.Itoa(version) + ".metadata.json"),
+		})
+	}
+	metadata.LastUpdatedMs = nowMs
+
+	metadataJson, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	//metadata file first, the version hint makes the commit visible
+	version++
+	if err = putFile(store, metadataFolder+"/v"+strconv.Itoa(version)+".metadata.json", metadataJson); err != nil {
+		return err
+	}
+
+	return putFile(store, metadataFolder+"/"+icebergVersionHintFile, []byte(strconv.Itoa(version)))
+}
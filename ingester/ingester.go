@@ -5,7 +5,6 @@ package main
 
 import (
 	"bytes"
-	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -14,30 +13,20 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
-	secretmanager "cloud.google.com/go/secretmanager/apiv1"
-	"cloud.google.com/go/storage"
-	"github.com/Azure/azure-storage-blob-go/azblob"
 	"github.com/apache/flink-statefun/statefun-sdk-go/v3/pkg/statefun"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/colinmarc/hdfs"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
-	"github.com/xitongsys/parquet-go-source/local"
-	"github.com/xitongsys/parquet-go/parquet"
 	"github.com/xitongsys/parquet-go/source"
 	"github.com/xitongsys/parquet-go/writer"
-	"golang.org/x/oauth2/google"
-	"google.golang.org/api/option"
 )
 
 // default database connection settings
@@ -65,10 +54,19 @@ var dremioToken string
 // - generic payload
 
 type IncomingMessage struct {
-	StreamId    string                 `json:"stream_id,omitempty"`
-	StreamAltId string                 `json:"stream_alt_id,omitempty"`
-	MessageType string                 `json:"message_type,omitempty"`
-	Payload     map[string]interface{} `json:"payload"`
+	StreamId       string                 `json:"stream_id,omitempty"`
+	StreamAltId    string                 `json:"stream_alt_id,omitempty"`
+	MessageType    string                 `json:"message_type,omitempty"`
+	MessageId      string                 `json:"message_id,omitempty"`
+	IngestId       string                 `json:"ingest_id,omitempty"`   //stamped by the ingest service, kept by redeliveries
+	KafkaTopic     string                 `json:"kafka_topic,omitempty"` //Kafka coordinates stamped by the ingest service, unset when it could not read them
+	KafkaPartition int                    `json:"kafka_partition"`
+	KafkaOffset    int64                  `json:"kafka_offset"`
+	ReceivedAt     string                 `json:"received_at,omitempty"`
+	ClientIp       string                 `json:"client_ip,omitempty"`
+	UserAgent      string                 `json:"user_agent,omitempty"`
+	Payload        map[string]interface{} `json:"payload"`
+	DedupChecked   bool                   `json:"dedup_checked,omitempty"` //passed the dedup function, set by it
 }
 
 //struct representation of stream configuration
@@ -91,7 +89,39 @@ type Config struct {
 	AzureStorageAccountname sql.NullString `db:"azure_storage_account_name" default:""`
 	AzureStorageAccessKey   sql.NullString `db:"azure_storage_access_key" default:""`
 	NamenodeHost            sql.NullString `db:"namenode_host" default:"host.docker.internal"`
-	NamenodePort            sql.NullInt64  `db:"namenode_port" default:8020`
+	NamenodePort            sql.NullInt64  `db:"namenode_port" default:"8020"`
+	EndpointUrl             sql.NullString `db:"endpoint_url" default:""`
+	S3ForcePathStyle        sql.NullBool   `db:"s3_force_path_style"`
+	TLSSkipVerify           sql.NullBool   `db:"tls_skip_verify"`
+	TLSCACert               sql.NullString `db:"tls_ca_cert" default:""`
+	S3SignatureVersion      sql.NullString `db:"s3_signature_version" default:"v4"`
+	AuthMode                sql.NullString `db:"auth_mode" default:"static"`
+	AWSRoleArn              sql.NullString `db:"aws_role_arn" default:""`
+	SecretRef               sql.NullString `db:"secret_ref" default:""`
+	FileNaming              sql.NullString `db:"file_naming" default:"timestamp"`
+	TableFormat             sql.NullString `db:"table_format" default:"none"`
+	FileFormatId            sql.NullInt64  `db:"file_format_id"`
+	ParquetRowGroupSize     sql.NullInt64  `db:"parquet_row_group_size"`
+	ParquetPageSize         sql.NullInt64  `db:"parquet_page_size"`
+	ParquetDictionary       sql.NullBool   `db:"parquet_dictionary"`
+	ParquetSortColumns      sql.NullString `db:"parquet_sort_columns" default:""`
+	CompactionEnabled       sql.NullBool   `db:"compaction_enabled"`
+	CompactionMinFiles      sql.NullInt64  `db:"compaction_min_files"`
+	CompactionTargetSize    sql.NullInt64  `db:"compaction_target_size"`
+	RetentionDays           sql.NullInt64  `db:"retention_days"`
+	RetentionDryRun         sql.NullBool   `db:"retention_dry_run"`
+	MaskingKeyRef           sql.NullString `db:"masking_key_ref" default:""`
+	Transformations         sql.NullString `db:"transformations" default:""`
+	DedupKey                sql.NullString `db:"dedup_key" default:""`
+	DedupTTLSeconds         sql.NullInt64  `db:"dedup_ttl_seconds"`
+	SessionizationEnabled   sql.NullBool   `db:"sessionization_enabled"`
+	SessionTimeoutSeconds   sql.NullInt64  `db:"session_timeout_seconds"`
+	EnrichmentEnabled       sql.NullBool   `db:"enrichment_enabled"`
+	MetadataColumnsEnabled  sql.NullBool   `db:"metadata_columns_enabled"`
+	EgressTargets           sql.NullString `db:"egress_targets" default:""`
+	PostgresEnabled         sql.NullBool   `db:"postgres_enabled"`
+	PostgresUrl             sql.NullString `db:"postgres_url" default:""`
+	PostgresSchema          sql.NullString `db:"postgres_schema" default:""`
 	CreatedAt               time.Time      `db:"created_at"`
 	UpdatedAt               time.Time      `db:"updated_at"`
 }
@@ -121,6 +151,14 @@ type CompressionType struct {
 
 var compressionTypes []CompressionType
 
+//struct representation of output file formats
+type FileFormat struct {
+	FileFormatId   int64  `db:"file_format_id"`
+	FileFormatName string `db:"file_format_name"`
+}
+
+var fileFormats []FileFormat
+
 //name variables for stateful function
 var (
 	IngestTypeName      = statefun.TypeNameFrom("com.rtdl.sf/ingest")
@@ -130,16 +168,16 @@ var (
 
 //GCP config structure
 type GCPCredentials struct {
-	accountType             string `json:"type"`
-	projectId               string `json:"project_id"`
-	privateKeyId            string `json:"private_key_id"`
-	privateKey              string `json:"private_key"`
-	clientEmail             string `json:"client_email"`
-	clientId                string `json:"client_id"`
-	authUri                 string `json:"auth_uri"`
-	tokenUri                string `json:"token_uri"`
-	authProviderX509CertUrl string `json:"auth_provider_x509_cert_url"`
-	clientX509CertUrl       string `json:"client_x509_cert_url"`
+	AccountType             string `json:"type"`
+	ProjectId               string `json:"project_id"`
+	PrivateKeyId            string `json:"private_key_id"`
+	PrivateKey              string `json:"private_key"`
+	ClientEmail             string `json:"client_email"`
+	ClientId                string `json:"client_id"`
+	AuthUri                 string `json:"auth_uri"`
+	TokenUri                string `json:"token_uri"`
+	AuthProviderX509CertUrl string `json:"auth_provider_x509_cert_url"`
+	ClientX509CertUrl       string `json:"client_x509_cert_url"`
 }
 
 // GetEnv get key environment variable if exist otherwise return defalutValue
@@ -159,6 +197,7 @@ func LoadConfig() error {
 	var tempFileStoreTypes []FileStoreType
 	var tempPartitionTimes []PartitionTime
 	var tempCompressionTypes []CompressionType
+	var tempFileFormats []FileFormat
 
 	//directly load data from PostgreSQL
 
@@ -177,8 +216,53 @@ func LoadConfig() error {
 		return err
 	}
 
+	//credentials kept in a secret store are resolved once per load
+	for i := range tempConfigs {
+		if getAuthMode(tempConfigs[i]) == authModeSecretRef {
+			err = resolveSecretRef(&tempConfigs[i])
+			if err != nil {
+				log.Println("Failed to resolve secret reference for stream "+tempConfigs[i].StreamId.String+": ", err)
+			}
+		}
+	}
+
 	configs = tempConfigs
 
+	tempMaskingRules, tempMaskingKeySets, err := loadMaskingRules(db, tempConfigs)
+	if err != nil {
+		log.Println("Failed to load masking rules: ", err)
+		return err
+	}
+
+	maskingRules = tempMaskingRules
+	maskingKeySets = tempMaskingKeySets
+
+	streamTransformations = loadTransformations(tempConfigs)
+
+	streamEgressTargets = loadEgressTargets(tempConfigs)
+
+	tempFilterRules, err := loadFilterRules(db)
+	if err != nil {
+		log.Println("Failed to load filter rules: ", err)
+		return err
+	}
+
+	filterRules = tempFilterRules
+
+	tempRollupRules, err := loadRollupRules(db)
+	if err != nil {
+		log.Println("Failed to load rollup rules: ", err)
+		return err
+	}
+
+	rollupRules = tempRollupRules
+
+	err = loadWebhooks(db) //swaps the webhooks of every stream itself, under the lock events are queued with
+	if err != nil {
+		log.Println("Failed to load webhooks: ", err)
+		return err
+	}
+
 	fileStoreTypeSql := "SELECT * FROM file_store_types"
 	err = db.Select(&tempFileStoreTypes, fileStoreTypeSql) //populate supported file store types
 	if err != nil {
@@ -206,6 +290,15 @@ func LoadConfig() error {
 
 	compressionTypes = tempCompressionTypes
 
+	fileFormatsSql := "SELECT * from file_formats"
+	err = db.Select(&tempFileFormats, fileFormatsSql)
+	if err != nil {
+		log.Println("Failed to execute query: ", err)
+		return err
+	}
+
+	fileFormats = tempFileFormats
+
 	defer db.Close()
 	log.Println("No. of config records retrieved : " + strconv.Itoa(len(configs)))
 	return nil
@@ -420,7 +513,16 @@ func GenerateSchema(payload map[string]interface{}, messageType string, jsonSche
 		jsonSchema += `"Fields": [`
 	}
 
-	for key, value := range payload {
+	//fields in name order, the same payload shape always gives the same schema (and schema hash)
+	keys := make([]string, 0, len(payload))
+	for key := range payload {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+
+		value := payload[key]
 
 		if value == nil {
 
@@ -482,7 +584,7 @@ func GenerateSchema(payload map[string]interface{}, messageType string, jsonSche
 
 }
 
-func generateSubFolderName(messageType string, configRecord Config) string {
+func generateSubFolderName(messageType string, configRecord Config, partitionTime time.Time) string {
 
 	var subFolderName string
 
@@ -494,21 +596,21 @@ func generateSubFolderName(messageType string, configRecord Config) string {
 
 			case "Hourly":
 
-				subFolderName = messageType + "/" + time.Now().Format("2006-01-02-15")
+				subFolderName = messageType + "/" + partitionTime.Format("2006-01-02-15")
 
 			case "Daily":
-				subFolderName = messageType + "/" + time.Now().Format("2006-01-02")
+				subFolderName = messageType + "/" + partitionTime.Format("2006-01-02")
 
 			case "Weekly":
-				year, week := time.Now().ISOWeek()
+				year, week := partitionTime.ISOWeek()
 				subFolderName = messageType + "/" + strconv.Itoa(year) + "-" + strconv.Itoa(week)
 
 			case "Monthly":
-				subFolderName = messageType + "/" + time.Now().Format("2006-01")
+				subFolderName = messageType + "/" + partitionTime.Format("2006-01")
 
 			case "Quarterly":
-				quarter := int((time.Now().Month() + 2) / 3)
-				subFolderName = messageType + "/" + time.Now().Format("2006") + "-" + string(quarter)
+				quarter := int((partitionTime.Month() + 2) / 3)
+				subFolderName = messageType + "/" + partitionTime.Format("2006") + "-" + strconv.Itoa(quarter)
 			}
 
 		}
@@ -518,52 +620,99 @@ func generateSubFolderName(messageType string, configRecord Config) string {
 	return subFolderName
 }
 
+//unique id of this ingester instance, part of every generated file name
+var instanceId = generateInstanceId()
+
+//per-instance sequence, disambiguates files created within the same nanosecond
+var fileSequence uint64
+
+func generateInstanceId() string {
+
+	id := os.Getenv("RTDL_INSTANCE_ID")
+	if id == "" {
+		id, _ = os.Hostname()
+	}
+
+	//keep file names portable across stores
+	id = strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' {
+			return r
+		}
+		return '-'
+	}, id)
+
+	if id == "" {
+		id = "rtdl"
+	}
+	return id
+}
+
 //generate the leaf level file name
-func generateLeafLevelFileName() string {
+//zero-padded UTC timestamp first so names sort by time, followed by instance id and sequence
+func generateLeafLevelFileName(extension string) string {
 
-	//construct the timestamp string
-	t := time.Now()
-	year := t.Year()
-	month := t.Month()
-	day := t.Day()
-	hour := t.Hour()
-	min := t.Minute()
-	sec := t.Second()
-	nanosec := t.Nanosecond()
+	t := time.Now().UTC()
+	sequence := atomic.AddUint64(&fileSequence, 1)
 
-	return strconv.Itoa(year) + strconv.Itoa(int(month)) + strconv.Itoa(day) + "_" + strconv.Itoa(hour) + strconv.Itoa(min) + strconv.Itoa(sec) + strconv.Itoa(nanosec) + ".parquet"
+	return t.Format("20060102T150405.000000000Z") + "_" + instanceId + "_" + fmt.Sprintf("%06d", sequence%1000000) + extension
 
 }
 
+//generate a file name derived from the ingest id of the message
+//a redelivered message gets the same name and overwrites its own file instead of duplicating the data
+func generateDeterministicFileName(request IncomingMessage, extension string) string {
+
+	return request.IngestId + extension
+}
+
+//true when the stream writes deterministic file names and the message carries an ingest id
+func usesDeterministicFileNames(request IncomingMessage, configRecord Config) bool {
+
+	return strings.EqualFold(configRecord.FileNaming.String, "deterministic") && request.IngestId != ""
+}
+
 //writer-agnostic function to actually write to file
 func WriteToFile(schema string, fw source.ParquetFile, payload []byte, configRecord Config) error {
 
+	return WriteRecordsToFile(schema, fw, [][]byte{payload}, configRecord)
+}
+
+//write JSON records sharing one schema into a Parquet file, tuned by the Parquet settings of the stream
+func WriteRecordsToFile(schema string, fw source.ParquetFile, records [][]byte, configRecord Config) error {
+
+	if configRecord.ParquetDictionary.Bool {
+		schema = generateDictionarySchema(schema)
+	}
+
 	pw, err := writer.NewJSONWriter(schema, fw, 4)
 	if err != nil {
 		log.Println("Can't create json writer", err)
 		return err
 	}
 
-	//set compression
-
-	compressionType := configRecord.CompressionTypeId.Int64
-
-	if compressionType > 0 && compressionType < 4 { //supported compression type
+	//set compression and sizes
+	if err = tuneParquetWriter(&pw.ParquetWriter, configRecord); err != nil {
+		log.Println("Can't tune writer", err)
+		return err
+	}
 
-		switch compressionType {
-		case 1:
-			pw.CompressionType = parquet.CompressionCodec_SNAPPY
-		case 2:
-			pw.CompressionType = parquet.CompressionCodec_GZIP
-		case 3:
-			pw.CompressionType = parquet.CompressionCodec_LZO
+	sortColumns := parseParquetSortColumns(configRecord.ParquetSortColumns.String)
+	if len(sortColumns) > 0 {
+		records = sortParquetRecords(records, sortColumns)
+	}
 
+	for _, record := range records {
+		if err = pw.Write(record); err != nil {
+			log.Println("Write error", err)
+			return err
 		}
 	}
 
-	if err = pw.Write(payload); err != nil {
-		log.Println("Write error", err)
-		return err
+	if len(sortColumns) > 0 {
+		if err = setParquetSortingColumns(&pw.ParquetWriter, getJSONSchemaLeafPaths(schema), sortColumns); err != nil {
+			log.Println("Flush error", err)
+			return err
+		}
 	}
 
 	if err = pw.WriteStop(); err != nil {
@@ -662,8 +811,19 @@ func UpdateDremio(messageType string, sourceType string, location string, config
 
 		case "S3":
 
-			sourceStringMultiLine += `, "type": "S3", "config": {"accessKey": "` + configRecord.AWSAcessKeyID.String + `"`
-			sourceStringMultiLine += `, "accessSecret": "` + configRecord.AWSSecretAcessKey.String + `"`
+			sourceStringMultiLine += `, "type": "S3", "config": {`
+			if usesAmbientCredentials(configRecord) { //instance role / environment of the Dremio executors
+				sourceStringMultiLine += `"credentialType": "EC2_METADATA"`
+			} else {
+				sourceStringMultiLine += `"credentialType": "ACCESS_KEY", "accessKey": "` + configRecord.AWSAcessKeyID.String + `"`
+				sourceStringMultiLine += `, "accessSecret": "` + configRecord.AWSSecretAcessKey.String + `"`
+			}
+			if getAuthMode(configRecord) == authModeAssumeRole {
+				sourceStringMultiLine += `, "assumedRoleARN": "` + configRecord.AWSRoleArn.String + `"`
+			}
+			if isS3Compatible(configRecord) { //MinIO, Ceph, R2 etc.
+				sourceStringMultiLine += dremioS3CompatProperties(configRecord)
+			}
 			//sourceStringMultiLine += `, "externalBucketList": ["` + location + `"]`
 			if strings.Contains(dremioHost, "cloud") {
 				sourceStringMultiLine += `, "rootPath": "/`
@@ -678,23 +838,32 @@ func UpdateDremio(messageType string, sourceType string, location string, config
 
 		case "GCS":
 			var gcpCreds map[string]interface{}
-			//need to extract all variable values from GCP crendentials object
 
-			err := json.Unmarshal([]byte(configRecord.GCPJsonCredentials.String), &gcpCreds)
-			if err != nil {
-				log.Println("Error reading GCP credentials from configuration record", err)
-				return err
-			}
+			if usesAmbientCredentials(configRecord) { //application default credentials of the Dremio executors
+
+				projectId := getGCPDefaultProjectId()
+				sourceStringMultiLine += `, "type":"GCS", "config": {"projectId": "` + projectId + `"`
+				sourceStringMultiLine += `, "authMode": "AUTO"`
+
+			} else {
+				//need to extract all variable values from GCP crendentials object
 
-			projectId := gcpCreds["project_id"].(string)
-			clientEmail := gcpCreds["client_email"].(string)
-			clientId := gcpCreds["client_id"].(string)
-			privateKeyId := gcpCreds["private_key_id"].(string)
-			privateKey := strings.Replace(gcpCreds["private_key"].(string), "\n", "\\n", -1)
-			sourceStringMultiLine += `, "type":"GCS", "config": {"projectId": "` + projectId + `"`
-			sourceStringMultiLine += `, "authMode": "SERVICE_ACCOUNT_KEYS", "clientEmail": "` + clientEmail + `"`
-			sourceStringMultiLine += `, "clientId": "` + clientId + `", "privateKeyId": "` + privateKeyId + `"`
-			sourceStringMultiLine += `, "privateKey": "` + privateKey + `"`
+				err := json.Unmarshal([]byte(configRecord.GCPJsonCredentials.String), &gcpCreds)
+				if err != nil {
+					log.Println("Error reading GCP credentials from configuration record", err)
+					return err
+				}
+
+				projectId := gcpCreds["project_id"].(string)
+				clientEmail := gcpCreds["client_email"].(string)
+				clientId := gcpCreds["client_id"].(string)
+				privateKeyId := gcpCreds["private_key_id"].(string)
+				privateKey := strings.Replace(gcpCreds["private_key"].(string), "\n", "\\n", -1)
+				sourceStringMultiLine += `, "type":"GCS", "config": {"projectId": "` + projectId + `"`
+				sourceStringMultiLine += `, "authMode": "SERVICE_ACCOUNT_KEYS", "clientEmail": "` + clientEmail + `"`
+				sourceStringMultiLine += `, "clientId": "` + clientId + `", "privateKeyId": "` + privateKeyId + `"`
+				sourceStringMultiLine += `, "privateKey": "` + privateKey + `"`
+			}
 			sourceStringMultiLine += `, "rootPath": "/` + location + `/`
 			if configRecord.FolderName.String != "" {
 				sourceStringMultiLine += configRecord.FolderName.String + `/`
@@ -703,6 +872,11 @@ func UpdateDremio(messageType string, sourceType string, location string, config
 
 		case "Azure":
 
+			if usesAmbientCredentials(configRecord) { //Dremio has no managed identity support for Azure Storage sources
+				log.Println("Azure managed identity is not supported by Dremio sources, create source " + sourceName + " manually")
+				return nil
+			}
+
 			//sourceStringMultiLine += `, "metadataPolicy": {"datasetUpdateMode": "INLINE"} `
 			sourceStringMultiLine += `, "metadataPolicy": {"datasetUpdateMode": "INLINE", "datasetRefreshAfterMs": 60000 ` //to be made customisable
 			sourceStringMultiLine += `, "namesRefreshMs": 60000, "authTTLMs": 60000, "datasetExpireAfterMs": 60000} `      //metadata end, comment/delete two lines together
@@ -743,6 +917,12 @@ func UpdateDremio(messageType string, sourceType string, location string, config
 
 	}
 
+	dremioFormat := getDremioFormat(configRecord)
+	if dremioFormat == "" && !datasetExists {
+		log.Println("Dremio cannot promote", getFileFormatName(configRecord), "files, dataset", messageType, "has to be created manually")
+		return nil
+	}
+
 	if !datasetExists {
 
 		var encodedId string
@@ -772,7 +952,7 @@ func UpdateDremio(messageType string, sourceType string, location string, config
 				datasetDefMultiLine = `{"id": "` + encodedId + `", "entityType": "dataset", "path": ["` + sourceName + `", "` + messageType + `"]`
 			}
 
-			datasetDefMultiLine += `, "format": {"type": "Parquet"}`
+			datasetDefMultiLine += `, "format": ` + dremioFormat
 			datasetDefMultiLine += `, "type": "PHYSICAL_DATASET"`
 			datasetDefMultiLine += `}`
 			datasetDef := []byte(datasetDefMultiLine)
@@ -793,46 +973,71 @@ func UpdateDremio(messageType string, sourceType string, location string, config
 
 }
 
-//Write local Parquet
-func WriteLocalParquet(messageType string, schema string, payload []byte, configRecord Config) error {
+//stream a file for the records into the file store in the file format of the stream, returns the size of the file
+func WriteFileToStore(store FileStore, path string, schema string, records [][]byte, configRecord Config) (int64, error) {
 
-	//write
-	path := "datastore" //root will always be datastore
+	var size int64
 
-	folderName := configRecord.FolderName.String
-	if folderName != "" { //default
-		path += "/" + folderName
+	err := store.Upload(path, func(w io.Writer) error {
+		counter := &countingWriter{writer: w}
+		err := writeFileFormat(counter, schema, records, configRecord)
+		size = counter.count
+		return err
+	})
+
+	return size, err
+}
+
+//registers the stream in Dremio once a file has been written
+//table format streams are registered when the table commit exists
+func updateDremioForFile(messageType string, sourceType string, location string, configRecord Config) error {
+
+	if usesTableFormat(configRecord) {
+		return nil
 	}
 
-	path += "/" + generateSubFolderName(messageType, configRecord)
+	return UpdateDremio(messageType, sourceType, location, configRecord)
+}
+
+//delete a written file that is not committed after all, a file left behind is only logged
+func removeUncommittedFile(objectPath string, configRecord Config) {
 
-	err := os.MkdirAll(path, os.ModePerm)
+	store, err := newFileStore(getFileStoreTypeName(configRecord), configRecord)
 	if err != nil {
-		log.Println("Can't create output directory", err)
-		return err
+		log.Println("Error opening file store to remove", objectPath, err)
+		return
 	}
+	defer store.Close()
 
-	location := os.Getenv("LOCAL_FS_MOUNT_PATH") + "/" + path
-	fileName := path + "/" + generateLeafLevelFileName()
-
-	log.Println("Local path:", fileName)
+	if err := store.Delete(objectPath); err != nil {
+		log.Println("Error removing uncommitted file", objectPath, err)
+	}
+}
 
-	fw, err := local.NewLocalFileWriter(fileName)
+//Write local Parquet
+func WriteLocalParquet(messageType string, objectPath string, schema string, records [][]byte, configRecord Config) (int64, error) {
 
+	store, err := newLocalStore(configRecord)
 	if err != nil {
-		log.Println("Can't create file", err)
-		return err
+		return 0, err
 	}
 
-	err = WriteToFile(schema, fw, payload, configRecord)
+	//write
+	path := store.root + "/" + filepath.Dir(objectPath) //datastore/[folder/]<message type>/<partition>
+
+	location := os.Getenv("LOCAL_FS_MOUNT_PATH") + "/" + path
+
+	log.Println("Local path:", store.root+"/"+objectPath)
+
+	size, err := WriteFileToStore(store, objectPath, schema, records, configRecord)
 
 	if err == nil { //file write successful, update Dremio
 
-		return UpdateDremio(messageType, "Local", location, configRecord)
+		return size, updateDremioForFile(messageType, "Local", location, configRecord)
 
 	}
 
-	return err
+	return 0, err
 
 }
 
@@ -854,9 +1059,14 @@ func CreateHDFSDataset(messageType string, configRecord Config) error {
 		url = "http://" + dremioHost + ":" + dremioPort + "/apiv2/source/" + configRecord.StreamId.String + "/folder_format/" + messageType
 	}
 
+	dremioFormat := getDremioFormat(configRecord)
+	if dremioFormat == "" {
+		return nil //not promotable, logged by UpdateDremio
+	}
+
 	method := "PUT"
 
-	payload := strings.NewReader(`{"type":"Parquet"}`)
+	payload := strings.NewReader(dremioFormat)
 
 	client := &http.Client{}
 	req, err := http.NewRequest(method, url, payload)
@@ -885,443 +1095,295 @@ func CreateHDFSDataset(messageType string, configRecord Config) error {
 
 }
 
-func WriteHDFSParquet(messageType string, schema string, payload []byte, configRecord Config) error {
-
-	if configRecord.BucketName.String == "" {
-		return errors.New("HDFS root folder (bucket) name cannot be null or empty")
-	}
-	subFolderName := generateSubFolderName(messageType, configRecord)
-	leafLevelFileName := generateLeafLevelFileName()
-
-	path := "/" + configRecord.BucketName.String
-
-	if configRecord.FolderName.String != "" {
-		path = path + "/" + configRecord.FolderName.String
-	}
-
-	path = path + "/" + subFolderName
+func WriteHDFSParquet(messageType string, objectPath string, schema string, records [][]byte, configRecord Config) (int64, error) {
 
-	fw, err := local.NewLocalFileWriter(leafLevelFileName)
-	err = WriteToFile(schema, fw, payload, configRecord) //write temporary local file
+	store, err := newHDFSStore(configRecord)
 	if err != nil {
-		log.Println("Unable to write temporary local file", err)
-		return err
+		return 0, err
 	}
+	defer store.Close()
 
-	tempFile, err1 := os.Open(leafLevelFileName) //open temporary local file
-	if err1 != nil {
-		log.Println("Unable to open temporary local file", err1)
-		return err1
+	//streamed straight into HDFS, no temporary local file
+	size, err := WriteFileToStore(store, objectPath, schema, records, configRecord)
+	if err != nil {
+		log.Println("Error writing file to HDFS", err)
+		return 0, err
 	}
 
-	defer tempFile.Close()
+	log.Println("Finished writing file to HDFS")
+	return size, updateDremioForFile(messageType, "HDFS", configRecord.BucketName.String, configRecord)
 
-	// Get file size and read the file content into a buffer
-	fileInfo, _ := tempFile.Stat()
-	var size int64 = fileInfo.Size()
-	buffer := make([]byte, size)
-	tempFile.Read(buffer)
-
-	//temporary code for HDFS write test
-	client, clientError := hdfs.New(configRecord.NamenodeHost.String + ":" + strconv.Itoa(int(configRecord.NamenodePort.Int64)))
-	if clientError != nil {
-		log.Println(clientError)
-	} else {
-		_, hdfsReadError := client.ReadDir(path)
-
-		if hdfsReadError != nil { //directory does not exist
-			createErr := client.MkdirAll("/"+path, os.FileMode(0777))
-			if createErr != nil {
-				log.Println(createErr)
-			} else {
+}
 
-				log.Println("directory created in hdfs")
-			}
+func WriteAWSParquet(messageType string, objectPath string, schema string, records [][]byte, configRecord Config) (int64, error) {
 
-		} else {
-			log.Println("HDFS Directory " + path + " exists")
-		}
+	store, err := newS3Store(configRecord)
+	if err != nil {
+		return 0, err
 	}
 
-	fw2, err2 := client.Create(path + "/" + leafLevelFileName)
-	if err2 != nil {
-		log.Println("Error creating file in HDFS", err2)
-		return err2
+	//multipart upload straight from the Parquet writer
+	size, err := WriteFileToStore(store, objectPath, schema, records, configRecord)
+	if err != nil {
+		log.Println("Error uploading file to S3", err)
+		return 0, err
 	}
 
-	fw2.Close()
-
-	fw2, err2 = client.Append(path + "/" + leafLevelFileName)
-	if err2 != nil {
-		log.Println("Error opening file for writing in HDFS", err2)
-		return err2
-	}
+	log.Println("Finished uploading file to S3")
+	return size, updateDremioForFile(messageType, "S3", store.bucket, configRecord)
 
-	defer fw2.Close()
+}
 
-	_, err = fw2.Write(buffer)
+func WriteGCPParquet(messageType string, objectPath string, schema string, records [][]byte, configRecord Config) (int64, error) {
 
+	store, err := newGCSStore(configRecord)
 	if err != nil {
-		log.Println("Error writing file to HDFS", err)
+		return 0, err
 	}
+	defer store.Close()
 
-	err = fw2.Flush()
-
+	//resumable upload straight from the Parquet writer
+	size, err := WriteFileToStore(store, objectPath, schema, records, configRecord)
 	if err != nil {
-		log.Println("Error flushing file to HDFS", err)
-	}
-
-	os.Remove(leafLevelFileName) //remove the temp file
-	if err == nil {
-		log.Println("Finished writing file to HDFS")
-		err = UpdateDremio(messageType, "HDFS", configRecord.BucketName.String, configRecord)
+		log.Println("Error uploading file", err)
+		return 0, err
 	}
 
-	return err
+	log.Println("Finished uploading file to GCS")
+	return size, updateDremioForFile(messageType, "GCS", store.bucket, configRecord)
 
 }
 
-func WriteAWSParquet(messageType string, schema string, payload []byte, configRecord Config) error {
+func WriteAzureParquet(messageType string, objectPath string, schema string, records [][]byte, configRecord Config) (int64, error) {
 
-	var key string
-
-	subFolderName := generateSubFolderName(messageType, configRecord)
-	leafLevelFileName := generateLeafLevelFileName()
-
-	if configRecord.Region.String == "" {
-		return errors.New("AWS Region cannot be null or empty")
+	store, err := newAzureStore(configRecord)
+	if err != nil {
+		return 0, err
 	}
 
-	region := strings.TrimSpace(configRecord.Region.String)
-	awsAccessKeyId := strings.TrimSpace(configRecord.AWSAcessKeyID.String)
-	awsSecretAccessKey := strings.TrimSpace(configRecord.AWSSecretAcessKey.String)
-
-	//log.Println("AWS Parquet writing implementation pending")
-	bucketName := configRecord.BucketName.String
-	if bucketName == "" {
-		return errors.New("S3 bucket name cannot be null or empty")
+	//block blob upload straight from the Parquet writer
+	size, err := WriteFileToStore(store, objectPath, schema, records, configRecord)
+	if err != nil {
+		log.Println("Error writing Azure blob", err)
+		return 0, err
 	}
 
-	if configRecord.FolderName.String != "" {
+	log.Println("Finished uploading file to Azure")
+	return size, updateDremioForFile(messageType, "Azure", configRecord.BucketName.String, configRecord)
 
-		key = configRecord.FolderName.String + "/" + subFolderName + "/" + leafLevelFileName
+}
 
-	} else {
+//configuration of the stream a message belongs to, matched on stream_alt_id or stream_id
+func findRequestConfig(request IncomingMessage) (Config, bool) {
 
-		key = subFolderName + "/" + leafLevelFileName
+	for _, configRecord := range configs {
 
-	}
+		if request.StreamAltId != "" { //use stream_alt_id
 
-	fw, err := local.NewLocalFileWriter(leafLevelFileName)
-	err = WriteToFile(schema, fw, payload, configRecord) //write temporary local file
-	if err != nil {
-		log.Println("Unable to write temporary local file", err)
-		return err
-	}
+			if configRecord.StreamAltId.String == request.StreamAltId {
+				return configRecord, true
 
-	awsSession, err := session.NewSession(&aws.Config{
-		Region:      aws.String(region),
-		Credentials: credentials.NewStaticCredentials(awsAccessKeyId, awsSecretAccessKey, ""),
-	})
-	if err != nil {
-		log.Println("Failed to create AWS Session ", err)
-		return err
-	}
+			}
 
-	tempFile, err1 := os.Open(leafLevelFileName) //open temporary local file
-	if err1 != nil {
-		log.Println("Unable to open temporary local file", err1)
-		return err1
-	}
+		}
 
-	defer tempFile.Close()
-
-	// Get file size and read the file content into a buffer
-	fileInfo, _ := tempFile.Stat()
-	var size int64 = fileInfo.Size()
-	buffer := make([]byte, size)
-	tempFile.Read(buffer)
-
-	// Config settings: this is where we choose the bucket, filename, content-type etc.
-	// of the file we're uploading.
-	_, err = s3.New(awsSession).PutObject(&s3.PutObjectInput{
-		Bucket: aws.String(bucketName),
-		Key:    aws.String(key),
-		//ACL:                  aws.String("private"),
-		Body: bytes.NewReader(buffer),
-		//ContentLength:        aws.Int64(size),
-		//ContentType:          aws.String(http.DetectContentType(buffer)),
-		//ContentDisposition:   aws.String("attachment"),
-		//ServerSideEncryption: aws.String("AES256"),
-	})
+		if request.StreamId != "" {
+			if configRecord.StreamId.String == request.StreamId {
+				return configRecord, true
 
-	os.Remove(leafLevelFileName) //remove the temp file
-	log.Println("Finished uploading file to S3")
+			}
 
-	if err == nil {
+		}
 
-		return UpdateDremio(messageType, "S3", bucketName, configRecord)
 	}
 
-	return err
-
+	return Config{}, false
 }
 
-func WriteGCPParquet(messageType string, schema string, payload []byte, configRecord Config) error {
-
-	var path string
-	//var location string
-
-	subFolderName := generateSubFolderName(messageType, configRecord)
-	leafLevelFileName := generateLeafLevelFileName()
+//message type precedence order will be 1."type" within request.Payload 2."message_type" within incoming message 3. Config Record MessageType
+//a default value will also be kept
+func getMessageType(request IncomingMessage, configRecord Config) string {
 
-	//replace all \n	with \\n to preserve them
-	jsonCreds := strings.Replace(configRecord.GCPJsonCredentials.String, "\n", "\\n", -1)
-
-	//create client
-	ctx := context.Background()
-	creds, err := google.CredentialsFromJSON(ctx, []byte(jsonCreds), secretmanager.DefaultAuthScopes()...)
-	if err != nil {
-		log.Println("Error creating GCP credentials", err)
-		return err
-	}
+	var messageType string = "rtdl_default"
 
-	client, err := storage.NewClient(ctx, option.WithCredentials(creds))
-	if err != nil {
-		log.Println("Error creating GCP client", err)
-		return err
-	}
-	defer client.Close()
+	//least precendence - config record message_type
+	if configRecord.MessageType.String != "" {
 
-	bucketName := configRecord.BucketName.String
-	if bucketName == "" {
-		return errors.New("GCS bucket name cannot be null or empty")
+		messageType = configRecord.MessageType.String
 	}
 
-	if configRecord.FolderName.String != "" {
-
-		path = configRecord.FolderName.String + "/" + subFolderName + "/" + leafLevelFileName
-		//location = configRecord.FolderName.String + "/" + subFolderName
-	} else {
+	//higher precendence message_type within message
+	if request.MessageType != "" {
 
-		path = subFolderName + "/" + leafLevelFileName
-		//location = subFolderName
+		messageType = request.MessageType
 	}
 
-	fw, err := local.NewLocalFileWriter(leafLevelFileName)
-	err = WriteToFile(schema, fw, payload, configRecord) //write temporary local file
-	if err != nil {
-		log.Println("Unable to write temporary local file", err)
-		return err
-	}
+	//highest precedence - type inside main payload
+	if payloadType, found := request.Payload["type"]; found {
+		if typeString, ok := payloadType.(string); ok {
 
-	tempFile, err1 := os.Open(leafLevelFileName) //open temporary local file
-	if err1 != nil {
-		log.Println("Unable to open temporary local file", err1)
-		return err1
+			messageType = typeString
+		}
 	}
 
-	defer tempFile.Close()
+	return messageType
+}
 
-	ctx, cancel := context.WithTimeout(ctx, time.Second*50)
-	defer cancel()
+//event time of a message - the payload `timestamp`, else the time the ingest service received it, else now
+func getEventTime(request IncomingMessage) time.Time {
 
-	// Upload an object with storage.Writer.
-	writer := client.Bucket(bucketName).Object(path).NewWriter(ctx)
-	if _, err = io.Copy(writer, tempFile); err != nil {
-		log.Println("Error uploading file", err)
-		return err
+	if timestamp, err := time.Parse(time.RFC3339Nano, getPayloadString(request.Payload, "timestamp")); err == nil {
+		return timestamp.UTC()
 	}
-	if err := writer.Close(); err != nil {
-		log.Println("Error closing writer", err)
-		return err
+	if receivedAt, err := time.Parse(time.RFC3339Nano, request.ReceivedAt); err == nil {
+		return receivedAt.UTC()
 	}
-
-	os.Remove(leafLevelFileName) //remove the temp file
-
-	log.Println("Finished uploading file to GCS")
-	return UpdateDremio(messageType, "GCS", bucketName, configRecord)
-
+	return time.Now().UTC()
 }
 
-func WriteAzureParquet(messageType string, schema string, payload []byte, configRecord Config) error {
+//Parquet writing logic
+//returns the committed file, nil when the message did not match a stream
+func WriteParquet(request IncomingMessage) (*CommittedFile, error) {
 
-	var path string
-	//var location string
+	return WriteMessages([]IncomingMessage{request})
+}
 
-	subFolderName := generateSubFolderName(messageType, configRecord)
-	leafLevelFileName := generateLeafLevelFileName()
+//write messages of one stream and message type into one file, e.g. the closed sessions or the rows of a rollup window
+//stream, message type, partition and file name are those of the first message, the schema covers every payload
+//returns the committed file, nil when the messages did not match a stream
+func WriteMessages(requests []IncomingMessage) (*CommittedFile, error) {
 
-	// Create a request pipeline that is used to process HTTP(S) requests and responses. It requires
-	// your account credentials. In more advanced scenarios, you can configure telemetry, retry policies,
-	// logging, and other options. Also, you can configure multiple request pipelines for different scenarios.
-	azureCredential, err := azblob.NewSharedKeyCredential(configRecord.AzureStorageAccountname.String, configRecord.AzureStorageAccessKey.String)
-	if err != nil {
-		log.Println("Error constructing Azure credential", err)
-		return err
+	if len(requests) == 0 {
+		return nil, nil
 	}
+	request := requests[0]
 
-	azurePipeline := azblob.NewPipeline(azureCredential, azblob.PipelineOptions{})
+	//first retrieve relevant destination information from config array
+	matchingConfig, _ := findRequestConfig(request)
 
-	//Storage account blob service URL endpoint
-	azureUrl, _ := url.Parse(fmt.Sprintf("https://%s.blob.core.windows.net", configRecord.AzureStorageAccountname.String))
+	messageType := getMessageType(request, matchingConfig)
 
-	bucketName := configRecord.BucketName.String //maps to Container Name for Azure Storage
-	if bucketName == "" {
-		return errors.New("Bucket name (maps to Azure Storage Account Name) cannot be null or empty")
+	records := make([]map[string]interface{}, len(requests))
+	for i, message := range requests {
+		records[i] = message.Payload
+		if hasMetadataColumns(matchingConfig) { //schema version is that of the payload, system columns do not change it
+			payloadSchema := strings.TrimRight(GenerateSchema(message.Payload, messageType, ""), ",") + "]}"
+			records[i] = addMetadataColumns(message, matchingConfig, messageType, generateSchemaHash(payloadSchema)[:16])
+		}
 	}
 
-	if configRecord.FolderName.String != "" {
+	schema, records := generateRecordsSchema(records, messageType)
 
-		path = configRecord.FolderName.String + "/" + subFolderName + "/" + leafLevelFileName
-		//location = configRecord.FolderName.String + "/" + subFolderName
-	} else {
-
-		path = subFolderName + "/" + leafLevelFileName
-		//location = subFolderName
+	payloads := make([][]byte, len(records))
+	for i, record := range records {
+		payloads[i], _ = json.Marshal(record) //convert generic payload structure to JSON string
 	}
 
-	// Create an ServiceURL object that wraps the service URL and a request pipeline.
-	azureServiceURL := azblob.NewServiceURL(*azureUrl, azurePipeline)
+	//partition and file name - processing time by default, ingest id and receive time when deterministic
+	partitionTime := time.Now()
+	extension := getFileExtension(getFileFormatName(matchingConfig))
+	fileName := generateLeafLevelFileName(extension)
 
-	fw, err := local.NewLocalFileWriter(leafLevelFileName)
-	err = WriteToFile(schema, fw, payload, configRecord) //write temporary local file
-	if err != nil {
-		log.Println("Unable to write temporary local file", err)
-		return err
-	}
+	if usesDeterministicFileNames(request, matchingConfig) {
 
-	ctx := context.Background()
-	tempFile, err1 := os.Open(leafLevelFileName) //open temporary local file
-	if err1 != nil {
-		log.Println("Unable to open temporary local file", err1)
-		return err1
+		if receivedAt, err := time.Parse(time.RFC3339Nano, request.ReceivedAt); err == nil {
+			partitionTime = receivedAt.Local() //redeliveries have to land in the same partition
+		}
+		fileName = generateDeterministicFileName(request, extension)
 	}
 
-	defer tempFile.Close()
+	objectPath := generateObjectPath(messageType, matchingConfig, partitionTime, fileName)
 
-	// Create a URL that references a to-be-created container in your Azure Storage account.
-	// This returns a ContainerURL object that wraps the container's URL and a request pipeline (inherited from serviceURL)
-	azureContainerURL := azureServiceURL.NewContainerURL(strings.ToLower(bucketName)) // Container names require lowercase
+	committedFile := &CommittedFile{
+		StreamId:     matchingConfig.StreamId.String,
+		Partition:    filepath.Dir(objectPath),
+		PartitionEnd: generatePartitionEnd(matchingConfig, partitionTime),
+		File:         fileName,
+		Rows:         int64(len(records)),
+		SchemaHash:   generateSchemaHash(schema),
+	}
 
-	//check if container exists
-	azureContainerProperties, _ := azureContainerURL.GetProperties(ctx, azblob.LeaseAccessConditions{})
+	var size int64
+	var err error
 
-	if azureContainerProperties == nil { //container does not exist, need to create
-		// Create the container on the service (with no metadata and no public access)
-		_, err := azureContainerURL.Create(ctx, azblob.Metadata{}, azblob.PublicAccessNone)
+	//rows are inserted first and committed once the file is written, a failed write leaves neither
+	//the COPY transaction stays open while the file is uploaded, holding a connection and the row locks of the insert for that long
+	var postgresTxn *sql.Tx
+	if hasPostgresSink(matchingConfig) {
+		postgresTxn, committedFile.PostgresTable, err = WritePostgresRows(messageType, schema, records, matchingConfig)
 		if err != nil {
-			log.Println("Error creating Azure Storage container", err)
-			return err
+			return nil, err
+		}
+		defer postgresTxn.Rollback() //no-op once committed
+	}
+
+	switch getFileStoreTypeName(matchingConfig) { //similar logic for file store types
+	case "Local":
+		size, err = WriteLocalParquet(messageType, objectPath, schema, payloads, matchingConfig)
+	case "AWS":
+		size, err = WriteAWSParquet(messageType, objectPath, schema, payloads, matchingConfig)
+	case "GCP":
+		size, err = WriteGCPParquet(messageType, objectPath, schema, payloads, matchingConfig)
+	case "Azure":
+		size, err = WriteAzureParquet(messageType, objectPath, schema, payloads, matchingConfig)
+	case "HDFS":
+		size, err = WriteHDFSParquet(messageType, objectPath, schema, payloads, matchingConfig)
+		if err != nil {
+			log.Println("Error writing HDFS file")
+			return nil, err
+		}
+		//need to call HDFS dataset creation now, the file itself is committed either way
+		if !usesTableFormat(matchingConfig) {
+			if dremioErr := CreateHDFSDataset(messageType, matchingConfig); dremioErr != nil {
+				log.Println("Error creating HDFS dataset", dremioErr)
+			}
 		}
+	default:
+		return nil, nil
 	}
 
-	// Create a URL that references a to-be-created blob in your Azure Storage account's container.
-	// This returns a BlockBlobURL object that wraps the blob's URL and a request pipeline (inherited from containerURL)
-	azureBlobURL := azureContainerURL.NewBlockBlobURL(path)
-
-	_, err = azureBlobURL.Upload(ctx, tempFile, azblob.BlobHTTPHeaders{ContentType: "application/octet-stream"}, azblob.Metadata{}, azblob.BlobAccessConditions{}, azblob.DefaultAccessTier, nil, azblob.ClientProvidedKeyOptions{})
 	if err != nil {
-		log.Println("Error writing Azure blob", err)
-		return err
+		return nil, err
 	}
 
-	os.Remove(leafLevelFileName) //remove the temp file
-
-	log.Println("Finished uploading file to Azure")
-	return UpdateDremio(messageType, "Azure", bucketName, configRecord)
-
-}
-
-//Parquet writing logic
-func WriteParquet(request IncomingMessage) error {
-
-	//log.Println(GenerateSchema(request.Payload,request.MessageType, "")+"]}")
-
-	//message type precedence order will be 1."type" within request.Payload 2."message_type" within incoming message 3. Config Record MessageType
-	//a default value will also be kept
-
-	var messageType string = "rtdl_default"
-
-	payload, _ := json.Marshal(request.Payload) //convert generic payload structure to JSON string
-
-	var matchingConfig Config
-
-	//first retrieve relevant destination information from config array
-
-	for _, configRecord := range configs {
-
-		if request.StreamAltId != "" { //use stream_alt_id
-
-			if configRecord.StreamAltId.String == request.StreamAltId {
-				matchingConfig = configRecord
-				break
-
-			}
-
+	if postgresTxn != nil {
+		if err = postgresTxn.Commit(); err != nil {
+			log.Println("Error committing rows to Postgres table", committedFile.PostgresTable, err)
+			removeUncommittedFile(objectPath, matchingConfig) //not in the manifest, it would be found by nothing but a listing
+			return nil, err
 		}
+	}
 
-		if request.StreamId != "" {
-			if configRecord.StreamId.String == request.StreamId {
-				matchingConfig = configRecord
-				break
+	committedFile.SizeBytes = size
+	committedFile.CommittedAt = time.Now().UTC()
+	committedFile.MessageType = messageType
 
-			}
+	if usesTableFormat(matchingConfig) { //file still has to be committed to the table
+		committedFile.Table = generateTablePath(messageType, matchingConfig)
+		committedFile.Schema = schema
 
+		if getTableFormat(matchingConfig) == tableFormatDelta {
+			committedFile.Stats = generateDeltaStats(records)
 		}
-
 	}
 
-	//least precendence - config record message_type
-	if matchingConfig.MessageType.String != "" {
+	return committedFile, nil
+}
 
-		messageType = matchingConfig.MessageType.String
-	}
+//record a written file in the manifest of its partition and in the table it belongs to, nothing when no file was written
+func recordCommittedFile(ctx statefun.Context, committedFile *CommittedFile) {
 
-	//higher precendence message_type within message
-	if request.MessageType != "" {
-
-		messageType = request.MessageType
+	if committedFile == nil {
+		return
 	}
 
-	//highest precedence - type inside main payload
-	if payloadType, found := request.Payload["type"]; found {
-		if typeString, ok := payloadType.(string); ok {
+	sendCommittedFile(ctx, committedFile)
 
-			messageType = typeString
-		}
+	if committedFile.Table != "" {
+		sendTableFile(ctx, committedFile)
 	}
 
-	schema := strings.TrimRight(GenerateSchema(request.Payload, messageType, ""), ",") + "]}"
-
-	for _, fileStoreTypeRecord := range fileStoreTypes { //similar logic for file store types
-
-		if fileStoreTypeRecord.FileStoreTypeId == matchingConfig.FileStoreTypeId.Int64 {
-
-			switch fileStoreTypeRecord.FileStoreTypeName {
-			case "Local":
-				return WriteLocalParquet(messageType, schema, payload, matchingConfig)
-			case "AWS":
-				return WriteAWSParquet(messageType, schema, payload, matchingConfig)
-			case "GCP":
-				return WriteGCPParquet(messageType, schema, payload, matchingConfig)
-			case "Azure":
-				return WriteAzureParquet(messageType, schema, payload, matchingConfig)
-			case "HDFS":
-				err := WriteHDFSParquet(messageType, schema, payload, matchingConfig)
-				if err != nil {
-					log.Println("Error writing HDFS file")
-					return err
-				} else { //need to call HDFS dataset creation now
-
-					return CreateHDFSDataset(messageType, matchingConfig)
-				}
-
-			}
-		}
-
-	}
-	return nil
+	sendWriteReceipts(ctx, committedFile)
 }
 
 //main stateful function
@@ -1343,25 +1405,63 @@ func Ingest(ctx statefun.Context, message statefun.Message) error {
 		return nil
 	}
 
-	err := WriteParquet(request)
+	if request.MessageType == compactionControlMessageType { //compaction requested through the config service
+		return requestCompaction(ctx, request)
+	}
+
+	if request.MessageType == erasureControlMessageType { //erasure job created through the config service
+		return requestErasure(ctx, request)
+	}
+
+	//duplicates and filtered events are neither written nor passed on
+	//geo and user agent fields are added while the client IP is still there, masking can cover them as well
+	//PII is masked before the schema is generated, so neither the files nor the egress topic see it
+	//the `_rtdl_client_ip` and `_rtdl_user_agent` metadata columns are only added on write, they are masked there by the same rules
+	//transformations then work on the masked payload
+	configRecord, found := findRequestConfig(request)
+	rawPayload := request.Payload
+	if found {
+		if sendToDedup(ctx, request, configRecord) { //ingested once it comes back
+			return nil
+		}
+		if !filterEvent(request.Payload, configRecord.StreamId.String, getMessageType(request, configRecord)) {
+			return nil
+		}
+		if hasRawEgress(configRecord.StreamId.String) {
+			rawPayload = copyPayload(request.Payload)
+			maskPayload(rawPayload, configRecord.StreamId.String)
+		}
+		if isEnriched(configRecord) {
+			enrichPayload(request.Payload, request.ClientIp, request.UserAgent)
+		}
+		maskPayload(request.Payload, configRecord.StreamId.String)
+		request.Payload = transformPayload(request.Payload, configRecord.StreamId.String)
+	}
+
+	committedFile, err := WriteParquet(request)
 	if err != nil {
 
 		log.Println("error writing Parquet", err)
 
 	}
 
-	payload, _ := json.Marshal(request.Payload) //convert generic payload structure to JSON string
+	recordCommittedFile(ctx, committedFile)
 
-	//initial implementation to test out data flow
-	//not required once actual Parquet writing logic has been implemented
-	ctx.SendEgress(statefun.KafkaEgressBuilder{
-		Target: KafkaEgressTypeName,
-		Topic:  "egress",
-		Key:    "message",
-		Value:  []byte(payload),
-	})
+	if err == nil && committedFile != nil { //webhooks only hear of events that have landed
+		sendToWebhooks(configRecord.StreamId.String, committedFile.MessageType, request.Payload)
+	}
+
+	if found && isSessionized(configRecord) {
+		sendSessionEvent(ctx, request, configRecord)
+	}
 
-	log.Println("egress message written")
+	if found {
+		sendRollupEvents(ctx, request, configRecord)
+	}
+
+	if err == nil && committedFile != nil { //like receipts, egress only carries events that have landed
+		sendEventEgress(ctx, configRecord.StreamId.String, rawPayload, request.Payload)
+	}
 
 	return nil
 }
@@ -1387,14 +1487,68 @@ func main() {
 		log.Fatal("Unable to connect with Dremio ", err)
 	}
 
+	//expiry of old partitions
+	go runRetention()
+
+	//filter counters into `filter_stats`
+	go runFilterStats()
+
+	//duplicate counters into `dedup_stats`
+	go runDedupStats()
+
+	//GeoIP database, reloaded when the file changes
+	go runGeoIPReload()
+
 	builder := statefun.StatefulFunctionsBuilder()
 
-	//only the one function in the chain now
 	_ = builder.WithSpec(statefun.StatefulFunctionSpec{
 		FunctionType: IngestTypeName,
 		Function:     statefun.StatefulFunctionPointer(Ingest),
 	})
 
+	//seen message ids per stream and id
+	_ = builder.WithSpec(statefun.StatefulFunctionSpec{
+		FunctionType: DedupTypeName,
+		States:       []statefun.ValueSpec{DedupState},
+		Function:     statefun.StatefulFunctionPointer(Dedup),
+	})
+
+	//table format commits (Iceberg) per stream and message type
+	_ = builder.WithSpec(statefun.StatefulFunctionSpec{
+		FunctionType: TableTypeName,
+		States:       []statefun.ValueSpec{TableState},
+		Function:     statefun.StatefulFunctionPointer(Table),
+	})
+
+	//manifest and _SUCCESS marker per partition
+	_ = builder.WithSpec(statefun.StatefulFunctionSpec{
+		FunctionType: PartitionTypeName,
+		States:       []statefun.ValueSpec{PartitionState},
+		Function:     statefun.StatefulFunctionPointer(Partition),
+	})
+
+	//sessions per stream and user
+	_ = builder.WithSpec(statefun.StatefulFunctionSpec{
+		FunctionType: SessionTypeName,
+		States:       []statefun.ValueSpec{SessionState},
+		Function:     statefun.StatefulFunctionPointer(Session),
+	})
+
+	//closed sessions per stream, written in batches
+	_ = builder.WithSpec(statefun.StatefulFunctionSpec{
+		FunctionType: SessionWriterTypeName,
+		States:       []statefun.ValueSpec{SessionWriterState},
+		Function:     statefun.StatefulFunctionPointer(SessionWriter),
+	})
+
+	//windowed aggregates per stream and rollup rule
+	_ = builder.WithSpec(statefun.StatefulFunctionSpec{
+		FunctionType: RollupTypeName,
+		States:       []statefun.ValueSpec{RollupState},
+		Function:     statefun.StatefulFunctionPointer(Rollup),
+	})
+
 	http.Handle("/statefun", builder.AsHandler())
+	http.HandleFunc("/previewTransformations", previewTransformationsHandler)
 	_ = http.ListenAndServe(":8082", nil)
 }
@@ -0,0 +1,367 @@
+//PII masking: per-stream rules managed through the config service rewrite payload fields before schema generation
+//rules are keyed by JSON path, e.g. "$.customer.email", "contacts[*].phone" or "devices.*.ip" - arrays on the way are traversed implicitly
+
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//supported values of `masking_action`
+const (
+	maskingActionDrop       = "drop"        //remove the field
+	maskingActionHash       = "hash"        //salted HMAC-SHA256, hex encoded
+	maskingActionTruncateIP = "truncate_ip" //IPv4 to /24, IPv6 to /48
+	maskingActionRedact     = "redact"      //replace regex matches, or the whole value without a pattern
+	maskingActionTokenize   = "tokenize"    //deterministic AES-GCM token, reversible with the vault key
+)
+
+//replacement of `redact` rules without one
+const defaultMaskingReplacement = "[REDACTED]"
+
+//prefix of tokens written by `tokenize` rules
+const maskingTokenPrefix = "tok_"
+
+//struct representation of a masking rule
+type MaskingRule struct {
+	MaskingRuleId int64          `db:"masking_rule_id"`
+	StreamId      string         `db:"stream_id"`
+	JsonPath      string         `db:"json_path"`
+	MaskingAction string         `db:"masking_action"`
+	Pattern       sql.NullString `db:"pattern"`
+	Replacement   sql.NullString `db:"replacement"`
+	RuleOrder     int64          `db:"rule_order"`
+	CreatedAt     time.Time      `db:"created_at"`
+}
+
+//masking rule ready to be applied
+type compiledMaskingRule struct {
+	path        []string
+	action      string
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+//keys of a stream, read from `masking_key_ref` (or MASKING_KEY_REF) as {"hmac_salt": "...", "vault_key": "<base64, 32 bytes>"}
+type maskingKeys struct {
+	hmacSalt []byte
+	vaultKey []byte
+}
+
+//masking rules and keys per stream id, in rule order
+var maskingRules map[string][]compiledMaskingRule
+var maskingKeySets map[string]maskingKeys
+
+//split a JSON path into its segments, "*" stands for every key or element
+func parseMaskingPath(jsonPath string) []string {
+
+	jsonPath = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(jsonPath), "$"), ".")
+	jsonPath = strings.Replace(jsonPath, "[*]", ".*", -1)
+
+	var path []string
+	for _, segment := range strings.Split(jsonPath, ".") {
+		if segment != "" {
+			path = append(path, segment)
+		}
+	}
+	return path
+}
+
+//check and compile a masking rule
+func compileMaskingRule(rule MaskingRule) (compiledMaskingRule, error) {
+
+	compiled := compiledMaskingRule{
+		path:        parseMaskingPath(rule.JsonPath),
+		action:      rule.MaskingAction,
+		replacement: rule.Replacement.String,
+	}
+
+	if len(compiled.path) == 0 {
+		return compiled, errors.New("json path " + rule.JsonPath + " selects no field")
+	}
+
+	switch rule.MaskingAction {
+	case maskingActionDrop, maskingActionHash, maskingActionTruncateIP, maskingActionTokenize:
+	case maskingActionRedact:
+		if !rule.Replacement.Valid {
+			compiled.replacement = defaultMaskingReplacement
+		}
+		if rule.Pattern.String != "" {
+			pattern, err := regexp.Compile(rule.Pattern.String)
+			if err != nil {
+				return compiled, err
+			}
+			compiled.pattern = pattern
+		}
+	default:
+		return compiled, errors.New("unsupported masking action " + rule.MaskingAction)
+	}
+
+	return compiled, nil
+}
+
+//load the masking rules of every stream and the keys of the streams whose rules need them
+func loadMaskingRules(db *sqlx.DB, streams []Config) (map[string][]compiledMaskingRule, map[string]maskingKeys, error) {
+
+	var rules []MaskingRule
+	err := db.Select(&rules, "SELECT * FROM masking_rules ORDER BY stream_id, rule_order, masking_rule_id")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	compiledRules := map[string][]compiledMaskingRule{}
+	needsKeys := map[string]bool{}
+
+	for _, rule := range rules {
+		compiled, err := compileMaskingRule(rule)
+		if err != nil {
+			log.Println("Invalid masking rule", rule.MaskingRuleId, "of stream", rule.StreamId, err)
+			if len(compiled.path) == 0 {
+				continue
+			}
+			compiled.action = maskingActionDrop //a broken rule drops the field rather than letting it through
+		}
+		compiledRules[rule.StreamId] = append(compiledRules[rule.StreamId], compiled)
+		if compiled.action == maskingActionHash || compiled.action == maskingActionTokenize {
+			needsKeys[rule.StreamId] = true
+		}
+	}
+
+	keySets := map[string]maskingKeys{}
+
+	for _, configRecord := range streams {
+
+		streamId := configRecord.StreamId.String
+		if !needsKeys[streamId] {
+			continue
+		}
+
+		keyRef := strings.TrimSpace(configRecord.MaskingKeyRef.String)
+		if keyRef == "" {
+			keyRef = os.Getenv("MASKING_KEY_REF")
+		}
+		if keyRef == "" {
+			log.Println("No masking key reference for stream", streamId, "- hashed and tokenized fields are dropped")
+			continue
+		}
+
+		keys, err := readMaskingKeys(keyRef)
+		if err != nil {
+			log.Println("Failed to read masking keys of stream", streamId, err)
+			continue
+		}
+		keySets[streamId] = keys
+	}
+
+	return compiledRules, keySets, nil
+}
+
+//read the HMAC salt and vault key of a secret reference
+func readMaskingKeys(keyRef string) (maskingKeys, error) {
+
+	var keys maskingKeys
+
+	secretValue, err := readSecret(keyRef)
+	if err != nil {
+		return keys, err
+	}
+
+	var secret struct {
+		HmacSalt string `json:"hmac_salt"`
+		VaultKey string `json:"vault_key"`
+	}
+	if err := json.Unmarshal(secretValue, &secret); err != nil {
+		return keys, errors.New("secret " + keyRef + " is not a JSON object: " + err.Error())
+	}
+
+	keys.hmacSalt = []byte(secret.HmacSalt)
+
+	if secret.VaultKey != "" {
+		vaultKey, err := base64.StdEncoding.DecodeString(secret.VaultKey)
+		if err != nil || len(vaultKey) != 32 {
+			return keys, errors.New("vault key of " + keyRef + " has to be 32 base64 encoded bytes")
+		}
+		keys.vaultKey = vaultKey
+	}
+
+	return keys, nil
+}
+
+//apply the masking rules of a stream to a payload in place
+func maskPayload(payload map[string]interface{}, streamId string) {
+
+	keys := maskingKeySets[streamId]
+
+	for _, rule := range maskingRules[streamId] {
+		applyMaskingRule(payload, rule.path, rule, keys)
+	}
+}
+
+//walk a payload down a rule path and mask the values at its end
+//masked array elements that have to be dropped become null, the array keeps its length
+func applyMaskingRule(value interface{}, path []string, rule compiledMaskingRule, keys maskingKeys) {
+
+	switch typedValue := value.(type) {
+
+	case map[string]interface{}:
+		var fieldNames []string
+		if path[0] == "*" {
+			for fieldName := range typedValue {
+				fieldNames = append(fieldNames, fieldName)
+			}
+		} else if _, found := typedValue[path[0]]; found {
+			fieldNames = []string{path[0]}
+		}
+
+		for _, fieldName := range fieldNames {
+			if len(path) > 1 {
+				applyMaskingRule(typedValue[fieldName], path[1:], rule, keys)
+			} else if masked, keep := maskValue(typedValue[fieldName], rule, keys); keep {
+				typedValue[fieldName] = masked
+			} else {
+				delete(typedValue, fieldName)
+			}
+		}
+
+	case []interface{}:
+		if path[0] != "*" { //field of every element
+			for _, element := range typedValue {
+				applyMaskingRule(element, path, rule, keys)
+			}
+			return
+		}
+
+		for i := range typedValue {
+			if len(path) > 1 {
+				applyMaskingRule(typedValue[i], path[1:], rule, keys)
+			} else if masked, keep := maskValue(typedValue[i], rule, keys); keep {
+				typedValue[i] = masked
+			} else {
+				typedValue[i] = nil
+			}
+		}
+	}
+}
+
+//masked form of a value, false when the value has to be dropped
+//hashing and tokenizing without a key drop the value rather than letting it through
+func maskValue(value interface{}, rule compiledMaskingRule, keys maskingKeys) (interface{}, bool) {
+
+	switch rule.action {
+
+	case maskingActionDrop:
+		return nil, false
+
+	case maskingActionHash:
+		if value == nil {
+			return nil, true
+		}
+		if len(keys.hmacSalt) == 0 {
+			return nil, false
+		}
+		mac := hmac.New(sha256.New, keys.hmacSalt)
+		mac.Write(getMaskingPlaintext(value))
+		return hex.EncodeToString(mac.Sum(nil)), true
+
+	case maskingActionTruncateIP:
+		if address, ok := value.(string); ok {
+			if truncated := truncateIP(address); truncated != "" {
+				return truncated, true
+			}
+		}
+		return nil, true //not an address, nothing worth keeping
+
+	case maskingActionRedact:
+		if rule.pattern == nil {
+			return rule.replacement, true
+		}
+		if text, ok := value.(string); ok {
+			return rule.pattern.ReplaceAllString(text, rule.replacement), true
+		}
+		return value, true
+
+	case maskingActionTokenize:
+		if value == nil {
+			return nil, true
+		}
+		token, err := tokenizeValue(value, keys.vaultKey)
+		if err != nil {
+			return nil, false
+		}
+		return token, true
+	}
+
+	return nil, false
+}
+
+//bytes a value is hashed or tokenized from: strings as they are, anything else as JSON
+func getMaskingPlaintext(value interface{}) []byte {
+
+	if text, ok := value.(string); ok {
+		return []byte(text)
+	}
+	plaintext, _ := json.Marshal(value)
+	return plaintext
+}
+
+//network of an address: IPv4 /24, IPv6 /48 - the port of "host:port" forms is dropped
+func truncateIP(address string) string {
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			ip = net.ParseIP(host)
+		}
+	}
+	if ip == nil {
+		return ""
+	}
+
+	if ipv4 := ip.To4(); ipv4 != nil {
+		return ipv4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+//deterministic token of a value, so tokenized fields can still be joined and grouped on
+//the nonce is derived from the value: "tok_" + base64url(nonce || AES-256-GCM ciphertext of the JSON encoded value)
+func tokenizeValue(value interface{}, vaultKey []byte) (string, error) {
+
+	if len(vaultKey) != 32 {
+		return "", errors.New("no vault key")
+	}
+
+	plaintext, _ := json.Marshal(value) //keeps the type, so detokenized values come back as they were
+
+	block, err := aes.NewCipher(vaultKey)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, vaultKey)
+	mac.Write([]byte("rtdl-token-nonce"))
+	mac.Write(plaintext)
+	nonce := mac.Sum(nil)[:gcm.NonceSize()]
+
+	return maskingTokenPrefix + base64.RawURLEncoding.EncodeToString(gcm.Seal(nonce, nonce, plaintext, nil)), nil
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//payload as the ingester receives it, decoded from JSON
+func decodePayload(t *testing.T, payload string) map[string]interface{} {
+
+	t.Helper()
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal([]byte(payload), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+//masking rule as loaded from `masking_rules`
+func newMaskingRule(t *testing.T, jsonPath string, action string, pattern string) compiledMaskingRule {
+
+	t.Helper()
+
+	rule := MaskingRule{JsonPath: jsonPath, MaskingAction: action}
+	if pattern != "" {
+		rule.Pattern = sql.NullString{String: pattern, Valid: true}
+	}
+
+	compiled, err := compileMaskingRule(rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return compiled
+}
+
+func TestParseMaskingPath(t *testing.T) {
+
+	tests := []struct {
+		jsonPath string
+		want     []string
+	}{
+		{"$.customer.email", []string{"customer", "email"}},
+		{"customer.email", []string{"customer", "email"}},
+		{"contacts[*].phone", []string{"contacts", "*", "phone"}},
+		{"devices.*.ip", []string{"devices", "*", "ip"}},
+		{" $.ip ", []string{"ip"}},
+		{"$", nil},
+	}
+
+	for _, test := range tests {
+		if got := parseMaskingPath(test.jsonPath); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseMaskingPath(%q) = %v, want %v", test.jsonPath, got, test.want)
+		}
+	}
+}
+
+func TestCompileMaskingRuleErrors(t *testing.T) {
+
+	tests := []MaskingRule{
+		{JsonPath: "$", MaskingAction: maskingActionDrop},
+		{JsonPath: "email", MaskingAction: "encrypt"},
+		{JsonPath: "email", MaskingAction: maskingActionRedact, Pattern: sql.NullString{String: "([", Valid: true}},
+	}
+
+	for _, rule := range tests {
+		if _, err := compileMaskingRule(rule); err == nil {
+			t.Errorf("compileMaskingRule(%q, %q) succeeded, want an error", rule.JsonPath, rule.MaskingAction)
+		}
+	}
+}
+
+func TestMaskPayload(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		rules   []compiledMaskingRule
+		payload string
+		want    string
+	}{
+		{
+			name:    "drop",
+			rules:   []compiledMaskingRule{newMaskingRule(t, "$.customer.email", maskingActionDrop, "")},
+			payload: `{"customer":{"email":"jane@example.com","name":"Jane"}}`,
+			want:    `{"customer":{"name":"Jane"}}`,
+		},
+		{
+			name:    "drop in every array element",
+			rules:   []compiledMaskingRule{newMaskingRule(t, "contacts.phone", maskingActionDrop, "")},
+			payload: `{"contacts":[{"phone":"555-0100","kind":"home"},{"phone":"555-0101"}]}`,
+			want:    `{"contacts":[{"kind":"home"},{}]}`,
+		},
+		{
+			name:    "dropped array elements become null",
+			rules:   []compiledMaskingRule{newMaskingRule(t, "phones[*]", maskingActionDrop, "")},
+			payload: `{"phones":["555-0100","555-0101"]}`,
+			want:    `{"phones":[null,null]}`,
+		},
+		{
+			name:    "truncate IPv4 and IPv6",
+			rules:   []compiledMaskingRule{newMaskingRule(t, "devices.*.ip", maskingActionTruncateIP, "")},
+			payload: `{"devices":{"phone":{"ip":"203.0.113.57"},"laptop":{"ip":"2001:db8:85a3:8d3:1319:8a2e:370:7348"}}}`,
+			want:    `{"devices":{"phone":{"ip":"203.0.113.0"},"laptop":{"ip":"2001:db8:85a3::"}}}`,
+		},
+		{
+			name:    "truncate address with port",
+			rules:   []compiledMaskingRule{newMaskingRule(t, "ip", maskingActionTruncateIP, "")},
+			payload: `{"ip":"198.51.100.7:8080"}`,
+			want:    `{"ip":"198.51.100.0"}`,
+		},
+		{
+			name:    "truncate non address",
+			rules:   []compiledMaskingRule{newMaskingRule(t, "ip", maskingActionTruncateIP, "")},
+			payload: `{"ip":"localhost"}`,
+			want:    `{"ip":null}`,
+		},
+		{
+			name:    "redact pattern",
+			rules:   []compiledMaskingRule{newMaskingRule(t, "note", maskingActionRedact, `\d{3}-\d{4}`)},
+			payload: `{"note":"call 555-0100 or 555-0101"}`,
+			want:    `{"note":"call [REDACTED] or [REDACTED]"}`,
+		},
+		{
+			name:    "redact whole value",
+			rules:   []compiledMaskingRule{newMaskingRule(t, "ssn", maskingActionRedact, "")},
+			payload: `{"ssn":123456789}`,
+			want:    `{"ssn":"[REDACTED]"}`,
+		},
+		{
+			name:    "missing field",
+			rules:   []compiledMaskingRule{newMaskingRule(t, "customer.email", maskingActionDrop, "")},
+			payload: `{"customer":"Jane"}`,
+			want:    `{"customer":"Jane"}`,
+		},
+		{
+			name: "hash without key drops",
+			rules: []compiledMaskingRule{
+				newMaskingRule(t, "email", maskingActionHash, ""),
+				newMaskingRule(t, "name", maskingActionTokenize, ""),
+			},
+			payload: `{"email":"jane@example.com","name":"Jane","plan":"pro"}`,
+			want:    `{"plan":"pro"}`,
+		},
+	}
+
+	defer func(rules map[string][]compiledMaskingRule) { maskingRules = rules }(maskingRules)
+
+	for _, test := range tests {
+		maskingRules = map[string][]compiledMaskingRule{"stream": test.rules}
+
+		payload := decodePayload(t, test.payload)
+		maskPayload(payload, "stream")
+
+		if want := decodePayload(t, test.want); !reflect.DeepEqual(payload, want) {
+			got, _ := json.Marshal(payload)
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestMaskPayloadHash(t *testing.T) {
+
+	defer func(rules map[string][]compiledMaskingRule, keySets map[string]maskingKeys) {
+		maskingRules, maskingKeySets = rules, keySets
+	}(maskingRules, maskingKeySets)
+
+	maskingRules = map[string][]compiledMaskingRule{"stream": {newMaskingRule(t, "email", maskingActionHash, "")}}
+	maskingKeySets = map[string]maskingKeys{"stream": {hmacSalt: []byte("salt")}}
+
+	first := decodePayload(t, `{"email":"jane@example.com"}`)
+	second := decodePayload(t, `{"email":"jane@example.com"}`)
+	other := decodePayload(t, `{"email":"john@example.com"}`)
+	maskPayload(first, "stream")
+	maskPayload(second, "stream")
+	maskPayload(other, "stream")
+
+	hash, _ := first["email"].(string)
+	if len(hash) != 64 || strings.Contains(hash, "jane") {
+		t.Fatalf("email hashed to %q, want 64 hex characters", hash)
+	}
+	if second["email"] != hash {
+		t.Errorf("same email hashed to %v and %v", hash, second["email"])
+	}
+	if other["email"] == hash {
+		t.Errorf("different emails hashed to the same value")
+	}
+
+	maskingKeySets = map[string]maskingKeys{"stream": {hmacSalt: []byte("other salt")}}
+	salted := decodePayload(t, `{"email":"jane@example.com"}`)
+	maskPayload(salted, "stream")
+	if salted["email"] == hash {
+		t.Errorf("hash does not depend on the salt")
+	}
+}
+
+func TestTokenizeValue(t *testing.T) {
+
+	vaultKey := []byte("0123456789abcdef0123456789abcdef")
+
+	for _, value := range []interface{}{"jane@example.com", 42.0, true, map[string]interface{}{"zip": "94107"}} {
+
+		token, err := tokenizeValue(value, vaultKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, _ := tokenizeValue(value, vaultKey)
+		if token != again {
+			t.Errorf("%v tokenized to %q and %q", value, token, again)
+		}
+		if !strings.HasPrefix(token, maskingTokenPrefix) {
+			t.Fatalf("token %q lacks the %q prefix", token, maskingTokenPrefix)
+		}
+
+		//detokenized with the vault key, the value comes back with its type
+		sealed, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(token, maskingTokenPrefix))
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, _ := aes.NewCipher(vaultKey)
+		gcm, _ := cipher.NewGCM(block)
+		plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var detokenized interface{}
+		json.Unmarshal(plaintext, &detokenized)
+		if !reflect.DeepEqual(detokenized, value) {
+			t.Errorf("token of %v detokenized to %v", value, detokenized)
+		}
+	}
+
+	if _, err := tokenizeValue("jane", []byte("short")); err == nil {
+		t.Errorf("tokenizeValue with a short vault key succeeded, want an error")
+	}
+}
+
+func TestReadMaskingKeys(t *testing.T) {
+
+	vaultKey := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+
+	defer os.Unsetenv("RTDL_SECRET_TEST_MASKING_KEYS")
+
+	os.Setenv("RTDL_SECRET_TEST_MASKING_KEYS", `{"hmac_salt":"salt","vault_key":"`+vaultKey+`"}`)
+	keys, err := readMaskingKeys("env://RTDL_SECRET_TEST_MASKING_KEYS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(keys.hmacSalt) != "salt" || len(keys.vaultKey) != 32 {
+		t.Errorf("read salt %q and a vault key of %d bytes", keys.hmacSalt, len(keys.vaultKey))
+	}
+
+	os.Setenv("RTDL_SECRET_TEST_MASKING_KEYS", `{"vault_key":"c2hvcnQ="}`)
+	if _, err := readMaskingKeys("env://RTDL_SECRET_TEST_MASKING_KEYS"); err == nil {
+		t.Errorf("readMaskingKeys with a short vault key succeeded, want an error")
+	}
+}
+
+func TestMaskMetadataColumns(t *testing.T) {
+
+	defer func(rules map[string][]compiledMaskingRule) { maskingRules = rules }(maskingRules)
+
+	maskingRules = map[string][]compiledMaskingRule{"stream": {
+		newMaskingRule(t, "_rtdl_client_ip", maskingActionTruncateIP, ""),
+		newMaskingRule(t, "_rtdl_user_agent", maskingActionDrop, ""),
+		newMaskingRule(t, "email", maskingActionRedact, ""),
+	}}
+
+	request := IncomingMessage{
+		ClientIp:  "203.0.113.42",
+		UserAgent: "Mozilla/5.0",
+		IngestId:  "i-1",
+		Payload:   decodePayload(t, `{"email": "jane@example.com", "plan": "pro"}`), //as if no rule had matched on ingest
+	}
+	record := addMetadataColumns(request, Config{StreamId: sql.NullString{String: "stream", Valid: true}}, "track", "v1")
+
+	if record["_rtdl_client_ip"] != "203.0.113.0" {
+		t.Errorf("client IP column = %v, want it truncated", record["_rtdl_client_ip"])
+	}
+	if _, found := record["_rtdl_user_agent"]; found {
+		t.Errorf("user agent column kept, want it dropped")
+	}
+	//the payload was masked on ingest, it is not masked a second time
+	if record["_rtdl_ingest_id"] != "i-1" || record["email"] != "jane@example.com" || record["plan"] != "pro" {
+		t.Errorf("record %v, want the other columns and the payload as they were", record)
+	}
+
+	//without rules the columns are written as received
+	maskingRules = nil
+	record = addMetadataColumns(request, Config{StreamId: sql.NullString{String: "stream", Valid: true}}, "track", "v1")
+	if record["_rtdl_client_ip"] != "203.0.113.42" || record["_rtdl_user_agent"] != "Mozilla/5.0" {
+		t.Errorf("record %v, want the client columns unmasked", record)
+	}
+}
@@ -0,0 +1,56 @@
+//metadata columns: streams with `metadata_columns_enabled` get `_rtdl_*` system columns on every row they write
+//they tell when and from where a row came in, and which stream configuration and schema it was written under
+
+package main
+
+//prefix of the system columns, kept apart from payload fields
+const metadataColumnPrefix = "_rtdl_"
+
+//true when rows of a stream carry the metadata columns
+func hasMetadataColumns(configRecord Config) bool {
+	return configRecord.MetadataColumnsEnabled.Bool
+}
+
+//row of a message with the metadata columns added - the payload itself is left untouched, it is passed on to the egress topic as received
+//values that are not known, e.g. the ingest id and Kafka coordinates of derived rows such as sessions and rollups, are left out
+//client IP and user agent are personal data like payload fields, the masking rules of the stream apply to them as `_rtdl_client_ip` and `_rtdl_user_agent`
+func addMetadataColumns(request IncomingMessage, configRecord Config, messageType string, schemaVersion string) map[string]interface{} {
+
+	record := make(map[string]interface{}, len(request.Payload)+9)
+	for key, value := range request.Payload {
+		record[key] = value
+	}
+
+	columns := map[string]interface{}{
+		"stream_id":      configRecord.StreamId.String,
+		"message_type":   messageType,
+		"schema_version": schemaVersion,
+		"received_at":    request.ReceivedAt,
+		"ingest_id":      request.IngestId,
+	}
+	for name, value := range columns {
+		if value != "" {
+			record[metadataColumnPrefix+name] = value
+		}
+	}
+
+	if request.KafkaTopic != "" { //where in the ingress topic the message was written
+		record[metadataColumnPrefix+"kafka_partition"] = int64(request.KafkaPartition)
+		record[metadataColumnPrefix+"kafka_offset"] = request.KafkaOffset
+	}
+
+	//masked on their own, the payload was masked on ingest already
+	clientColumns := map[string]interface{}{}
+	if request.ClientIp != "" {
+		clientColumns[metadataColumnPrefix+"client_ip"] = request.ClientIp
+	}
+	if request.UserAgent != "" {
+		clientColumns[metadataColumnPrefix+"user_agent"] = request.UserAgent
+	}
+	maskPayload(clientColumns, configRecord.StreamId.String)
+	for name, value := range clientColumns { //dropped ones are gone
+		record[name] = value
+	}
+
+	return record
+}
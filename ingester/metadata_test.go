@@ -0,0 +1,43 @@
+package main
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func TestAddMetadataColumns(t *testing.T) {
+
+	configRecord := Config{StreamId: sql.NullString{String: "stream", Valid: true}}
+
+	request := IncomingMessage{
+		IngestId:       "i-1",
+		KafkaTopic:     "ingress",
+		KafkaPartition: 2,
+		KafkaOffset:    41,
+		ReceivedAt:     "2022-04-15T05:20:00Z",
+		Payload:        decodePayload(t, `{"plan": "pro"}`),
+	}
+
+	want := map[string]interface{}{
+		"plan":                  "pro",
+		"_rtdl_stream_id":       "stream",
+		"_rtdl_message_type":    "track",
+		"_rtdl_schema_version":  "v1",
+		"_rtdl_received_at":     "2022-04-15T05:20:00Z",
+		"_rtdl_ingest_id":       "i-1",
+		"_rtdl_kafka_partition": int64(2),
+		"_rtdl_kafka_offset":    int64(41),
+	}
+	if record := addMetadataColumns(request, configRecord, "track", "v1"); !reflect.DeepEqual(record, want) {
+		t.Errorf("record %v, want %v", record, want)
+	}
+
+	//derived rows carry neither an ingest id nor Kafka coordinates
+	record := addMetadataColumns(IncomingMessage{Payload: request.Payload}, configRecord, "track_sessions", "v1")
+	for _, column := range []string{"_rtdl_ingest_id", "_rtdl_kafka_partition", "_rtdl_kafka_offset", "_rtdl_received_at"} {
+		if _, found := record[column]; found {
+			t.Errorf("column %s added to a derived row", column)
+		}
+	}
+}
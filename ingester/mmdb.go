@@ -0,0 +1,349 @@
+//reader for MaxMind DB files (GeoLite2 / GeoIP2 `.mmdb`), see https://maxmind.github.io/MaxMind-DB/
+//the whole file is kept in memory, lookups decode the record of an address into plain Go values
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"math"
+	"net"
+	"strconv"
+)
+
+//marker preceding the metadata at the end of the file
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+//bounds on decoding one value, pointers can form cycles in a malicious file
+const (
+	mmdbMaxDepth  = 64      //nested maps, arrays and pointers
+	mmdbMaxValues = 1 << 16 //values decoded, records of real databases have a few hundred at most
+)
+
+//data section field types
+const (
+	mmdbTypeExtended  = 0
+	mmdbTypePointer   = 1
+	mmdbTypeString    = 2
+	mmdbTypeDouble    = 3
+	mmdbTypeBytes     = 4
+	mmdbTypeUint16    = 5
+	mmdbTypeUint32    = 6
+	mmdbTypeMap       = 7
+	mmdbTypeInt32     = 8
+	mmdbTypeUint64    = 9
+	mmdbTypeUint128   = 10
+	mmdbTypeArray     = 11
+	mmdbTypeContainer = 12
+	mmdbTypeEnd       = 13
+	mmdbTypeBool      = 14
+	mmdbTypeFloat     = 15
+)
+
+//an opened MaxMind DB file
+type mmdbReader struct {
+	tree         []byte
+	data         []byte
+	nodeCount    uint64
+	recordSize   uint64
+	ipVersion    uint64
+	ipv4Start    uint64 //node IPv4 addresses start from in an IPv6 tree
+	databaseType string
+}
+
+//open a MaxMind DB file
+func openMMDB(path string) (*mmdbReader, error) {
+
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseMMDB(file)
+}
+
+//parse the contents of a MaxMind DB file
+func parseMMDB(file []byte) (*mmdbReader, error) {
+
+	markerAt := bytes.LastIndex(file, mmdbMetadataMarker)
+	if markerAt < 0 {
+		return nil, errors.New("not a MaxMind DB file, metadata marker missing")
+	}
+
+	metadataSection := file[markerAt+len(mmdbMetadataMarker):]
+	value, _, err := decodeMMDBValue(metadataSection, 0)
+	if err != nil {
+		return nil, errors.New("invalid MaxMind DB metadata: " + err.Error())
+	}
+	metadata, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid MaxMind DB metadata")
+	}
+
+	reader := &mmdbReader{}
+	reader.nodeCount, _ = metadata["node_count"].(uint64)
+	reader.recordSize, _ = metadata["record_size"].(uint64)
+	reader.ipVersion, _ = metadata["ip_version"].(uint64)
+	reader.databaseType, _ = metadata["database_type"].(string)
+
+	if reader.recordSize != 24 && reader.recordSize != 28 && reader.recordSize != 32 {
+		return nil, errors.New("unsupported MaxMind DB record size " + strconv.FormatUint(reader.recordSize, 10))
+	}
+
+	if reader.nodeCount > uint64(markerAt) { //also keeps the tree size below from overflowing
+		return nil, errors.New("MaxMind DB search tree exceeds the file")
+	}
+	treeSize := reader.nodeCount * reader.recordSize / 4
+	if treeSize+16 > uint64(markerAt) {
+		return nil, errors.New("MaxMind DB search tree exceeds the file")
+	}
+	reader.tree = file[:treeSize]
+	reader.data = file[treeSize+16 : markerAt] //the tree is followed by 16 zero bytes
+
+	if reader.ipVersion == 6 { //IPv4 addresses live under ::/96
+		node := uint64(0)
+		for i := 0; i < 96 && node < reader.nodeCount; i++ {
+			node, _ = reader.readNode(node, 0)
+		}
+		reader.ipv4Start = node
+	}
+
+	return reader, nil
+}
+
+//the left (bit 0) or right (bit 1) record of a node
+func (reader *mmdbReader) readNode(node uint64, bit uint) (uint64, error) {
+
+	offset := node * reader.recordSize / 4
+	if offset+reader.recordSize/4 > uint64(len(reader.tree)) {
+		return 0, errors.New("MaxMind DB node out of range")
+	}
+	record := reader.tree[offset : offset+reader.recordSize/4]
+
+	switch reader.recordSize {
+	case 24:
+		record = record[bit*3 : bit*3+3]
+		return uint64(record[0])<<16 | uint64(record[1])<<8 | uint64(record[2]), nil
+	case 28:
+		if bit == 0 {
+			return uint64(record[3]&0xf0)<<20 | uint64(record[0])<<16 | uint64(record[1])<<8 | uint64(record[2]), nil
+		}
+		return uint64(record[3]&0x0f)<<24 | uint64(record[4])<<16 | uint64(record[5])<<8 | uint64(record[6]), nil
+	default:
+		return uint64(binary.BigEndian.Uint32(record[bit*4 : bit*4+4])), nil
+	}
+}
+
+//record of an IP address, nil when the database holds none
+func (reader *mmdbReader) lookup(ip net.IP) (interface{}, error) {
+
+	node := uint64(0)
+	address := ip.To16()
+	bitCount := 128
+
+	if ipv4 := ip.To4(); ipv4 != nil {
+		address = ipv4
+		bitCount = 32
+		node = reader.ipv4Start
+	} else if reader.ipVersion == 4 {
+		return nil, nil //IPv6 address, IPv4 database
+	}
+	if address == nil {
+		return nil, errors.New("invalid IP address")
+	}
+
+	for i := 0; i < bitCount && node < reader.nodeCount; i++ {
+		bit := uint(address[i/8]>>(7-uint(i%8))) & 1
+		next, err := reader.readNode(node, bit)
+		if err != nil {
+			return nil, err
+		}
+		node = next
+	}
+
+	if node == reader.nodeCount { //no record
+		return nil, nil
+	}
+	if node < reader.nodeCount {
+		return nil, errors.New("invalid MaxMind DB search tree")
+	}
+
+	value, _, err := decodeMMDBValue(reader.data, node-reader.nodeCount-16)
+	return value, err
+}
+
+//decode the data section value at an offset, returns the value and the offset following it
+func decodeMMDBValue(data []byte, offset uint64) (interface{}, uint64, error) {
+
+	values := 0
+	return decodeMMDBValueAt(data, offset, 0, &values)
+}
+
+//decode a value nested `depth` deep, counting the values decoded
+func decodeMMDBValueAt(data []byte, offset uint64, depth int, values *int) (interface{}, uint64, error) {
+
+	if offset >= uint64(len(data)) {
+		return nil, 0, errors.New("MaxMind DB data offset out of range")
+	}
+	if depth > mmdbMaxDepth {
+		return nil, 0, errors.New("MaxMind DB data nested too deep")
+	}
+	*values++
+	if *values > mmdbMaxValues {
+		return nil, 0, errors.New("MaxMind DB record too large")
+	}
+
+	control := data[offset]
+	offset++
+	fieldType := uint64(control >> 5)
+
+	if fieldType == mmdbTypePointer {
+		pointer, next, err := decodeMMDBPointer(data, offset, control)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := decodeMMDBValueAt(data, pointer, depth+1, values)
+		return value, next, err
+	}
+
+	if fieldType == mmdbTypeExtended {
+		if offset >= uint64(len(data)) {
+			return nil, 0, errors.New("MaxMind DB data truncated")
+		}
+		fieldType = 7 + uint64(data[offset])
+		offset++
+	}
+
+	size := uint64(control & 0x1f)
+	if size >= 29 {
+		extraBytes := size - 28
+		if offset+extraBytes > uint64(len(data)) {
+			return nil, 0, errors.New("MaxMind DB data truncated")
+		}
+		extra := uint64(0)
+		for _, b := range data[offset : offset+extraBytes] {
+			extra = extra<<8 | uint64(b)
+		}
+		offset += extraBytes
+		switch size {
+		case 29:
+			size = 29 + extra
+		case 30:
+			size = 285 + extra
+		default:
+			size = 65821 + extra
+		}
+	}
+
+	if (fieldType == mmdbTypeMap || fieldType == mmdbTypeArray) && size > uint64(len(data))-offset { //every entry takes a byte at least
+		return nil, 0, errors.New("MaxMind DB data truncated")
+	}
+
+	switch fieldType {
+
+	case mmdbTypeMap:
+		fields := make(map[string]interface{}, size)
+		for i := uint64(0); i < size; i++ {
+			key, next, err := decodeMMDBValueAt(data, offset, depth+1, values)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("MaxMind DB map key is not a string")
+			}
+			value, next, err := decodeMMDBValueAt(data, next, depth+1, values)
+			if err != nil {
+				return nil, 0, err
+			}
+			fields[name] = value
+			offset = next
+		}
+		return fields, offset, nil
+
+	case mmdbTypeArray:
+		elements := make([]interface{}, 0, size)
+		for i := uint64(0); i < size; i++ {
+			value, next, err := decodeMMDBValueAt(data, offset, depth+1, values)
+			if err != nil {
+				return nil, 0, err
+			}
+			elements = append(elements, value)
+			offset = next
+		}
+		return elements, offset, nil
+
+	case mmdbTypeBool:
+		return size != 0, offset, nil
+
+	case mmdbTypeContainer, mmdbTypeEnd:
+		return nil, offset, nil
+	}
+
+	if offset+size > uint64(len(data)) {
+		return nil, 0, errors.New("MaxMind DB data truncated")
+	}
+	raw := data[offset : offset+size]
+	offset += size
+
+	switch fieldType {
+	case mmdbTypeString:
+		return string(raw), offset, nil
+	case mmdbTypeBytes:
+		return append([]byte{}, raw...), offset, nil
+	case mmdbTypeDouble:
+		if size != 8 {
+			return nil, 0, errors.New("invalid MaxMind DB double")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(raw)), offset, nil
+	case mmdbTypeFloat:
+		if size != 4 {
+			return nil, 0, errors.New("invalid MaxMind DB float")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(raw))), offset, nil
+	case mmdbTypeUint16, mmdbTypeUint32, mmdbTypeUint64, mmdbTypeUint128:
+		number := uint64(0)
+		for _, b := range raw { //uint128 values beyond 64 bits keep their low bits
+			number = number<<8 | uint64(b)
+		}
+		return number, offset, nil
+	case mmdbTypeInt32:
+		number := uint32(0)
+		for _, b := range raw {
+			number = number<<8 | uint32(b)
+		}
+		if size == 4 {
+			return int64(int32(number)), offset, nil
+		}
+		return int64(number), offset, nil
+	}
+
+	return nil, 0, errors.New("unsupported MaxMind DB field type " + strconv.FormatUint(fieldType, 10))
+}
+
+//offset a pointer refers to, along with the offset following the pointer
+func decodeMMDBPointer(data []byte, offset uint64, control byte) (uint64, uint64, error) {
+
+	pointerSize := uint64((control>>3)&0x3) + 1
+	if offset+pointerSize > uint64(len(data)) {
+		return 0, 0, errors.New("MaxMind DB data truncated")
+	}
+
+	pointer := uint64(0)
+	if pointerSize < 4 {
+		pointer = uint64(control & 0x7)
+	}
+	for _, b := range data[offset : offset+pointerSize] {
+		pointer = pointer<<8 | uint64(b)
+	}
+
+	switch pointerSize {
+	case 2:
+		pointer += 2048
+	case 3:
+		pointer += 526336
+	}
+
+	return pointer, offset + pointerSize, nil
+}
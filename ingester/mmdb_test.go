@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+//data section field with a payload shorter than 29 bytes, extended types take their second byte
+func mmdbField(fieldType int, payload ...byte) []byte {
+
+	if fieldType > mmdbTypeMap {
+		return append([]byte{byte(len(payload)), byte(fieldType - 7)}, payload...)
+	}
+	return append([]byte{byte(fieldType<<5 | len(payload))}, payload...)
+}
+
+func mmdbString(text string) []byte {
+	return mmdbField(mmdbTypeString, []byte(text)...)
+}
+
+//map or array of fewer than 29 entries, a map takes a key and a value per entry
+func mmdbContainer(fieldType int, entries int, contents ...[]byte) []byte {
+
+	field := []byte{mmdbTypeMap<<5 | byte(entries)}
+	if fieldType != mmdbTypeMap {
+		field = []byte{byte(entries), byte(fieldType - 7)}
+	}
+	return append(field, bytes.Join(contents, nil)...)
+}
+
+//MaxMind DB file of an IPv4 search tree with a single 24 bit node: addresses in 0.0.0.0/1 have the record, the others none
+func newTestMMDB(record []byte, metadata ...[]byte) []byte {
+
+	if metadata == nil {
+		metadata = [][]byte{
+			mmdbString("node_count"), mmdbField(mmdbTypeUint32, 1),
+			mmdbString("record_size"), mmdbField(mmdbTypeUint16, 24),
+			mmdbString("ip_version"), mmdbField(mmdbTypeUint16, 4),
+			mmdbString("database_type"), mmdbString("Test-City"),
+		}
+	}
+
+	var file bytes.Buffer
+	file.Write([]byte{0, 0, 17, 0, 0, 1}) //left: data offset 0 (node count + 16), right: node count, no record
+	file.Write(make([]byte, 16))
+	file.Write(record)
+	file.Write(mmdbMetadataMarker)
+	file.Write(mmdbContainer(mmdbTypeMap, len(metadata)/2, metadata...))
+	return file.Bytes()
+}
+
+func TestDecodeMMDBValue(t *testing.T) {
+
+	double := make([]byte, 8)
+	binary.BigEndian.PutUint64(double, math.Float64bits(52.52))
+	float := make([]byte, 4)
+	binary.BigEndian.PutUint32(float, math.Float32bits(0.5))
+
+	tests := []struct {
+		name   string
+		data   []byte
+		offset uint64
+		want   interface{}
+		next   uint64
+	}{
+		{"string", mmdbString("Berlin"), 0, "Berlin", 7},
+		{"empty string", mmdbString(""), 0, "", 1},
+		{"double", mmdbField(mmdbTypeDouble, double...), 0, 52.52, 9},
+		{"float", mmdbField(mmdbTypeFloat, float...), 0, 0.5, 6},
+		{"bytes", mmdbField(mmdbTypeBytes, 1, 2), 0, []byte{1, 2}, 3},
+		{"uint16", mmdbField(mmdbTypeUint16, 1, 0), 0, uint64(256), 3},
+		{"uint32 of fewer bytes", mmdbField(mmdbTypeUint32, 5), 0, uint64(5), 2},
+		{"uint64", mmdbField(mmdbTypeUint64, 1, 0, 0, 0, 0, 0, 0, 0), 0, uint64(1) << 56, 10},
+		{"uint128 keeps the low bits", mmdbField(mmdbTypeUint128, 1, 0, 0, 0, 0, 0, 0, 0, 0, 42), 0, uint64(42), 12},
+		{"int32", mmdbField(mmdbTypeInt32, 0xff, 0xff, 0xff, 0xfe), 0, int64(-2), 6},
+		{"int32 of fewer bytes", mmdbField(mmdbTypeInt32, 0xff), 0, int64(255), 3},
+		{"bool", []byte{1, mmdbTypeBool - 7}, 0, true, 2},
+		{"map", mmdbContainer(mmdbTypeMap, 2, mmdbString("a"), mmdbString("x"), mmdbString("b"), mmdbField(mmdbTypeUint16, 7)), 0, map[string]interface{}{"a": "x", "b": uint64(7)}, 9},
+		{"array", mmdbContainer(mmdbTypeArray, 2, mmdbString("x"), mmdbString("y")), 0, []interface{}{"x", "y"}, 6},
+		{"nested", mmdbContainer(mmdbTypeMap, 1, mmdbString("names"), mmdbContainer(mmdbTypeMap, 1, mmdbString("en"), mmdbString("Germany"))), 0, map[string]interface{}{"names": map[string]interface{}{"en": "Germany"}}, 19},
+		{"value at an offset", append(mmdbString("a"), mmdbString("b")...), 2, "b", 4},
+		{"pointer", append(mmdbString("shared"), 0x20, 0x00), 7, "shared", 9},
+		{"pointer of two bytes", append(make([]byte, 2049), append(mmdbString("far"), 0x28, 0x00, 0x01)...), 2053, "far", 2056},
+		{"map of pointers", append(mmdbString("de"), mmdbContainer(mmdbTypeMap, 1, []byte{0x20, 0x00}, []byte{0x20, 0x00})...), 3, map[string]interface{}{"de": "de"}, 8},
+		{"size of one more byte", append([]byte{mmdbTypeString<<5 | 29, 1}, []byte(strings.Repeat("x", 30))...), 0, strings.Repeat("x", 30), 32},
+		{"size of two more bytes", append([]byte{mmdbTypeString<<5 | 30, 0, 1}, []byte(strings.Repeat("x", 286))...), 0, strings.Repeat("x", 286), 289},
+	}
+
+	for _, test := range tests {
+		got, next, err := decodeMMDBValue(test.data, test.offset)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) || next != test.next {
+			t.Errorf("%s: decoded %#v up to %d, want %#v up to %d", test.name, got, next, test.want, test.next)
+		}
+	}
+}
+
+func TestDecodeMMDBValueErrors(t *testing.T) {
+
+	//a pointer cycle through a map
+	cycle := mmdbContainer(mmdbTypeMap, 2, mmdbString("a"), []byte{0x20, 0x00}, mmdbString("b"), []byte{0x20, 0x00})
+
+	tests := []struct {
+		name    string
+		data    []byte
+		message string
+	}{
+		{"no data", nil, "out of range"},
+		{"truncated string", append([]byte{mmdbTypeString<<5 | 5}, "abc"...), "truncated"},
+		{"truncated size", []byte{mmdbTypeString<<5 | 30, 1}, "truncated"},
+		{"truncated extended type", []byte{0x03}, "truncated"},
+		{"truncated pointer", []byte{0x28, 0x00}, "truncated"},
+		{"pointer out of range", []byte{0x20, 0xff}, "out of range"},
+		{"truncated map", mmdbContainer(mmdbTypeMap, 2, mmdbString("a"), mmdbString("x")), "out of range"},
+		{"map larger than the data", []byte{mmdbTypeMap<<5 | 31, 0xff, 0xff, 0xff}, "truncated"},
+		{"array larger than the data", []byte{31, mmdbTypeArray - 7, 0xff, 0xff, 0xff}, "truncated"},
+		{"map key not a string", mmdbContainer(mmdbTypeMap, 1, mmdbField(mmdbTypeUint16, 1), mmdbString("x")), "not a string"},
+		{"double of 4 bytes", mmdbField(mmdbTypeDouble, 0, 0, 0, 0), "invalid MaxMind DB double"},
+		{"float of 8 bytes", mmdbField(mmdbTypeFloat, 0, 0, 0, 0, 0, 0, 0, 0), "invalid MaxMind DB float"},
+		{"unknown type", []byte{0x00, 9}, "unsupported MaxMind DB field type 16"},
+		{"pointer to itself", []byte{0x20, 0x00}, "nested too deep"},
+		{"map containing itself", cycle, "nested too deep"},
+	}
+
+	for _, test := range tests {
+		_, _, err := decodeMMDBValue(test.data, 0)
+		if err == nil {
+			t.Errorf("%s: decoded, want an error", test.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.message) {
+			t.Errorf("%s: failed with %q, want it to mention %q", test.name, err, test.message)
+		}
+	}
+
+	//arrays of pointers to the array before, a few levels deep but more values than any record has
+	fanOut := mmdbString("x")
+	level := 0
+	for i := 0; i < 4; i++ {
+		pointers := make([][]byte, 28)
+		for j := range pointers {
+			pointers[j] = []byte{0x20, byte(level)}
+		}
+		level = len(fanOut)
+		fanOut = append(fanOut, mmdbContainer(mmdbTypeArray, len(pointers), pointers...)...)
+	}
+	if _, _, err := decodeMMDBValue(fanOut, uint64(level)); err == nil || !strings.Contains(err.Error(), "record too large") {
+		t.Errorf("fanning out pointers: failed with %v, want the record to be too large", err)
+	}
+}
+
+func TestParseMMDB(t *testing.T) {
+
+	record := mmdbContainer(mmdbTypeMap, 1, mmdbString("country"), mmdbContainer(mmdbTypeMap, 1, mmdbString("iso_code"), mmdbString("DE")))
+
+	reader, err := parseMMDB(newTestMMDB(record))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reader.databaseType != "Test-City" {
+		t.Errorf("database type %q, want Test-City", reader.databaseType)
+	}
+
+	tests := []struct {
+		address string
+		want    interface{}
+	}{
+		{"1.2.3.4", map[string]interface{}{"country": map[string]interface{}{"iso_code": "DE"}}},
+		{"127.255.255.255", map[string]interface{}{"country": map[string]interface{}{"iso_code": "DE"}}},
+		{"128.0.0.1", nil},
+		{"2001:db8::1", nil}, //IPv4 database
+	}
+
+	for _, test := range tests {
+		got, err := reader.lookup(net.ParseIP(test.address))
+		if err != nil {
+			t.Errorf("lookup(%s): %v", test.address, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("lookup(%s) = %v, want %v", test.address, got, test.want)
+		}
+	}
+
+	//the tree pointing past the data section
+	broken := newTestMMDB(record)
+	broken[2] = 0xff
+	reader, err = parseMMDB(broken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.lookup(net.ParseIP("1.2.3.4")); err == nil {
+		t.Errorf("lookup through a record out of range succeeded, want an error")
+	}
+}
+
+func TestParseMMDBErrors(t *testing.T) {
+
+	metadata := func(nodeCount []byte, recordSize []byte) [][]byte {
+		return [][]byte{
+			mmdbString("node_count"), nodeCount,
+			mmdbString("record_size"), recordSize,
+			mmdbString("ip_version"), mmdbField(mmdbTypeUint16, 4),
+		}
+	}
+	record := mmdbString("x")
+
+	tests := []struct {
+		name    string
+		file    []byte
+		message string
+	}{
+		{"no marker", []byte("not a database"), "metadata marker missing"},
+		{"truncated metadata", append(append([]byte{}, mmdbMetadataMarker...), mmdbTypeMap<<5|1), "invalid MaxMind DB metadata"},
+		{"metadata not a map", append(append([]byte{}, mmdbMetadataMarker...), mmdbString("x")...), "invalid MaxMind DB metadata"},
+		{"record size", newTestMMDB(record, metadata(mmdbField(mmdbTypeUint32, 1), mmdbField(mmdbTypeUint16, 16))...), "record size 16"},
+		{"tree larger than the file", newTestMMDB(record, metadata(mmdbField(mmdbTypeUint32, 100), mmdbField(mmdbTypeUint16, 24))...), "exceeds the file"},
+		{"node count overflowing the tree size", newTestMMDB(record, metadata(mmdbField(mmdbTypeUint64, 0x40, 0, 0, 0, 0, 0, 0, 0), mmdbField(mmdbTypeUint16, 24))...), "exceeds the file"},
+	}
+
+	for _, test := range tests {
+		_, err := parseMMDB(test.file)
+		if err == nil {
+			t.Errorf("%s: parsed, want an error", test.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.message) {
+			t.Errorf("%s: failed with %q, want it to mention %q", test.name, err, test.message)
+		}
+	}
+}
+
+func TestLoadGeoIPDatabase(t *testing.T) {
+
+	defer func(path string) { geoIPDatabasePath = path }(geoIPDatabasePath)
+	defer func(loaded *geoIPDatabaseFile) { geoIPDatabase.Store(loaded) }(func() *geoIPDatabaseFile {
+		loaded, _ := geoIPDatabase.Load().(*geoIPDatabaseFile)
+		return loaded
+	}())
+
+	geoIPDatabasePath = filepath.Join(t.TempDir(), "test.mmdb")
+	writeDatabase := func(countryCode string, modTime time.Time) {
+		record := mmdbContainer(mmdbTypeMap, 1, mmdbString("country"), mmdbContainer(mmdbTypeMap, 1, mmdbString("iso_code"), mmdbString(countryCode)))
+		if err := ioutil.WriteFile(geoIPDatabasePath, newTestMMDB(record), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(geoIPDatabasePath, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lookupCountry := func() interface{} {
+		return lookupGeoIP("1.2.3.4")["country_code"]
+	}
+
+	modTime := time.Date(2022, 4, 15, 5, 20, 0, 0, time.UTC)
+	writeDatabase("DE", modTime)
+	loadGeoIPDatabase()
+	if got := lookupCountry(); got != "DE" {
+		t.Fatalf("country %v, want DE", got)
+	}
+
+	//the file is only read again once its modification time changes
+	writeDatabase("FR", modTime)
+	loadGeoIPDatabase()
+	if got := lookupCountry(); got != "DE" {
+		t.Errorf("country %v after a reload of an unchanged file, want DE", got)
+	}
+
+	writeDatabase("FR", modTime.Add(time.Minute))
+	loadGeoIPDatabase()
+	if got := lookupCountry(); got != "FR" {
+		t.Errorf("country %v after the file changed, want FR", got)
+	}
+
+	//a file that cannot be read keeps the database loaded before
+	if err := ioutil.WriteFile(geoIPDatabasePath, []byte("broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loadGeoIPDatabase()
+	if got := lookupCountry(); got != "FR" {
+		t.Errorf("country %v after a broken file, want FR", got)
+	}
+}
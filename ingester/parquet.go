@@ -0,0 +1,424 @@
+//Parquet writer tuning of a stream: compression codec, dictionary encoding and in-file sort order
+//and the schema of files holding several records
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/xitongsys/parquet-go/common"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/schema"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+//column rows are sorted by, a dotted path into the payload
+type parquetSortColumn struct {
+	path       []string
+	descending bool
+}
+
+//Parquet codec of a `compression_type_name`
+//lzo and brotli keep their `compression_types` rows but parquet-go v1.6.2 ships no compressor for them, the config service refuses them
+func getParquetCompressionCodec(compressionTypeName string) (parquet.CompressionCodec, error) {
+
+	switch compressionTypeName {
+	case "uncompressed":
+		return parquet.CompressionCodec_UNCOMPRESSED, nil
+	case "snappy":
+		return parquet.CompressionCodec_SNAPPY, nil
+	case "gzip":
+		return parquet.CompressionCodec_GZIP, nil
+	case "zstd":
+		return parquet.CompressionCodec_ZSTD, nil
+	case "lz4":
+		return parquet.CompressionCodec_LZ4, nil
+	}
+
+	return parquet.CompressionCodec_UNCOMPRESSED, errors.New("compression " + compressionTypeName + " is not supported by the Parquet writer")
+}
+
+//schema of records written into one file and the records as written against it
+//the schema has the fields of every record, fields some records lack (or have null) are OPTIONAL
+//and fields whose type differs between records are strings, their other values written as JSON
+//records of one shape keep the schema GenerateSchema gives each of them
+func generateRecordsSchema(records []map[string]interface{}, messageType string) (string, []map[string]interface{}) {
+
+	var schemas []string
+	for _, record := range records {
+		schema := strings.TrimRight(GenerateSchema(record, messageType, ""), ",") + "]}"
+		if !containsString(schemas, schema) {
+			schemas = append(schemas, schema)
+		}
+	}
+
+	if len(schemas) == 1 {
+		return schemas[0], records
+	}
+
+	var root parquetSchemaNode
+	for i, schema := range schemas {
+		var node parquetSchemaNode
+		json.Unmarshal([]byte(schema), &node)
+		if i == 0 {
+			root = node
+			continue
+		}
+		mergeSchemaFields(&root, node)
+	}
+
+	coerced := make([]map[string]interface{}, len(records))
+	for i, record := range records {
+		coerced[i], _ = coerceSchemaValue(root, record).(map[string]interface{})
+	}
+
+	schema, _ := json.Marshal(root)
+	return string(schema), coerced
+}
+
+//merge the fields of a schema node of another record into the node, fields only one of them has become OPTIONAL
+func mergeSchemaFields(existing *parquetSchemaNode, node parquetSchemaNode) {
+
+	for i := range existing.Fields {
+		if findSchemaField(node.Fields, existing.Fields[i].tagValue("name")) == nil {
+			setSchemaFieldOptional(&existing.Fields[i])
+		}
+	}
+
+	for _, field := range node.Fields {
+
+		match := findSchemaField(existing.Fields, field.tagValue("name"))
+
+		switch {
+		case match == nil:
+			setSchemaFieldOptional(&field)
+			existing.Fields = append(existing.Fields, field)
+		case match.tagValue("type") != field.tagValue("type"):
+			*match = parquetSchemaNode{Tag: "name=" + match.tagValue("name") + ", type=BYTE_ARRAY, repetitiontype=" + match.tagValue("repetitiontype")}
+		default:
+			mergeSchemaFields(match, field)
+		}
+	}
+
+	sort.SliceStable(existing.Fields, func(i, j int) bool { //in name order, as GenerateSchema writes them
+		return existing.Fields[i].tagValue("name") < existing.Fields[j].tagValue("name")
+	})
+}
+
+func findSchemaField(fields []parquetSchemaNode, name string) *parquetSchemaNode {
+
+	for i := range fields {
+		if fields[i].tagValue("name") == name {
+			return &fields[i]
+		}
+	}
+	return nil
+}
+
+func setSchemaFieldOptional(field *parquetSchemaNode) {
+	field.Tag = strings.Replace(field.Tag, "repetitiontype=REQUIRED", "repetitiontype=OPTIONAL", 1)
+}
+
+//value as written against a schema node, values of string columns that are not strings are written as JSON
+func coerceSchemaValue(node parquetSchemaNode, value interface{}) interface{} {
+
+	switch typedValue := value.(type) {
+	case nil, string:
+		return value
+	case map[string]interface{}:
+		if node.tagValue("type") == "" {
+			coerced := make(map[string]interface{}, len(typedValue))
+			for key, fieldValue := range typedValue {
+				if field := findSchemaField(node.Fields, key); field != nil {
+					coerced[key] = coerceSchemaValue(*field, fieldValue)
+				}
+			}
+			return coerced
+		}
+	case []interface{}:
+		if node.tagValue("type") == "LIST" && len(node.Fields) == 1 {
+			coerced := make([]interface{}, len(typedValue))
+			for i, element := range typedValue {
+				coerced[i] = coerceSchemaValue(node.Fields[0], element)
+			}
+			return coerced
+		}
+	}
+
+	if node.tagValue("type") == "BYTE_ARRAY" {
+		encoded, _ := json.Marshal(value)
+		return string(encoded)
+	}
+	return value
+}
+
+//Parquet schema with dictionary encoding on every column type that supports it
+func generateDictionarySchema(schema string) string {
+
+	var root parquetSchemaNode
+	if err := json.Unmarshal([]byte(schema), &root); err != nil {
+		log.Println("Error reading schema, dictionary encoding not applied", err)
+		return schema
+	}
+
+	setDictionaryEncoding(&root)
+
+	dictionarySchema, _ := json.Marshal(root)
+	return string(dictionarySchema)
+}
+
+func setDictionaryEncoding(node *parquetSchemaNode) {
+
+	switch node.tagValue("type") {
+	case "BYTE_ARRAY", "INT32", "INT64", "FLOAT", "DOUBLE":
+		node.Tag += ", encoding=PLAIN_DICTIONARY"
+	}
+
+	for i := range node.Fields {
+		setDictionaryEncoding(&node.Fields[i])
+	}
+}
+
+//dictionary encoding on every leaf column of a schema handler whose type supports it
+func setSchemaHandlerDictionaryEncoding(schemaHandler *schema.SchemaHandler) {
+
+	for i, schemaElement := range schemaHandler.SchemaElements {
+
+		if schemaElement.Type == nil || schemaElement.GetNumChildren() > 0 {
+			continue
+		}
+
+		switch *schemaElement.Type {
+		case parquet.Type_BYTE_ARRAY, parquet.Type_INT32, parquet.Type_INT64, parquet.Type_FLOAT, parquet.Type_DOUBLE:
+			schemaHandler.Infos[i].Encoding = parquet.Encoding_PLAIN_DICTIONARY
+		}
+	}
+}
+
+//parse `parquet_sort_columns`, e.g. "customer.id, amount desc"
+func parseParquetSortColumns(sortColumns string) []parquetSortColumn {
+
+	var columns []parquetSortColumn
+
+	for _, column := range strings.Split(sortColumns, ",") {
+
+		parts := strings.Fields(column)
+		if len(parts) == 0 {
+			continue
+		}
+
+		columns = append(columns, parquetSortColumn{
+			path:       strings.Split(parts[0], "."),
+			descending: len(parts) > 1 && strings.EqualFold(parts[1], "desc"),
+		})
+	}
+
+	return columns
+}
+
+//value at a dotted path, nil when missing
+func getPayloadValue(record map[string]interface{}, path []string) interface{} {
+
+	var value interface{} = record
+	for _, key := range path {
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = fields[key]
+	}
+	return value
+}
+
+//order of two payload values: nulls first, then booleans, numbers and strings
+func compareParquetValues(a interface{}, b interface{}) int {
+
+	rank := func(value interface{}) int {
+		switch value.(type) {
+		case nil:
+			return 0
+		case bool:
+			return 1
+		case float64:
+			return 2
+		case string:
+			return 3
+		}
+		return 4 //objects and arrays are not ordered
+	}
+
+	if rank(a) != rank(b) {
+		return rank(a) - rank(b)
+	}
+
+	switch typedA := a.(type) {
+	case bool:
+		if typedA == b.(bool) {
+			return 0
+		} else if !typedA {
+			return -1
+		}
+		return 1
+	case float64:
+		if typedA < b.(float64) {
+			return -1
+		} else if typedA > b.(float64) {
+			return 1
+		}
+	case string:
+		return strings.Compare(typedA, b.(string))
+	}
+	return 0
+}
+
+//records in sort column order, records that cannot be decoded keep their place at the end
+func sortParquetRecords(records [][]byte, sortColumns []parquetSortColumn) [][]byte {
+
+	if len(records) < 2 {
+		return records
+	}
+
+	type sortableRecord struct {
+		raw     []byte
+		decoded map[string]interface{}
+	}
+
+	sortable := make([]sortableRecord, len(records))
+	for i, record := range records {
+		sortable[i].raw = record
+		json.Unmarshal(record, &sortable[i].decoded)
+	}
+
+	sort.SliceStable(sortable, func(i, j int) bool {
+		for _, column := range sortColumns {
+			order := compareParquetValues(getPayloadValue(sortable[i].decoded, column.path), getPayloadValue(sortable[j].decoded, column.path))
+			if column.descending {
+				order = -order
+			}
+			if order != 0 {
+				return order < 0
+			}
+		}
+		return false
+	})
+
+	sorted := make([][]byte, len(sortable))
+	for i := range sortable {
+		sorted[i] = sortable[i].raw
+	}
+	return sorted
+}
+
+//dotted paths of the leaf columns of a Parquet JSON schema in column order, empty for columns inside lists
+func getJSONSchemaLeafPaths(schema string) []string {
+
+	var root parquetSchemaNode
+	if err := json.Unmarshal([]byte(schema), &root); err != nil {
+		return nil
+	}
+
+	var leafPaths []string
+
+	var walk func(node parquetSchemaNode, path string, inList bool)
+	walk = func(node parquetSchemaNode, path string, inList bool) {
+		if len(node.Fields) == 0 && node.tagValue("type") != "" {
+			if inList {
+				path = ""
+			}
+			leafPaths = append(leafPaths, path)
+			return
+		}
+		for _, field := range node.Fields {
+			walk(field, strings.TrimPrefix(path+"."+field.tagValue("name"), "."), inList || node.tagValue("type") == "LIST")
+		}
+	}
+	walk(root, "", false)
+
+	return leafPaths
+}
+
+//dotted paths of the leaf columns of a Parquet file schema in column order, empty for columns inside lists
+func getSchemaHandlerLeafPaths(schemaHandler *schema.SchemaHandler) []string {
+
+	var leafPaths []string
+
+	for _, inPath := range schemaHandler.ValueColumns {
+
+		inParts := strings.Split(inPath, common.PAR_GO_PATH_DELIMITER)
+		exParts := strings.Split(schemaHandler.InPathToExPath[inPath], common.PAR_GO_PATH_DELIMITER)
+
+		leafPath := strings.Join(exParts[1:], ".") //without the root
+		for i := 1; i < len(inParts); i++ {
+			ancestor := schemaHandler.SchemaElements[schemaHandler.MapIndex[strings.Join(inParts[:i], common.PAR_GO_PATH_DELIMITER)]]
+			if ancestor.ConvertedType != nil && *ancestor.ConvertedType == parquet.ConvertedType_LIST {
+				leafPath = ""
+			}
+		}
+
+		leafPaths = append(leafPaths, leafPath)
+	}
+
+	return leafPaths
+}
+
+//sorting columns of a row group, leaf column indexes follow the schema order
+//columns not in the schema (or inside lists) are left out
+func getParquetSortingColumns(leafPaths []string, sortColumns []parquetSortColumn) []*parquet.SortingColumn {
+
+	var sortingColumns []*parquet.SortingColumn
+
+	for _, column := range sortColumns {
+		for columnIndex, leafPath := range leafPaths {
+			if leafPath != "" && leafPath == strings.Join(column.path, ".") {
+				sortingColumns = append(sortingColumns, &parquet.SortingColumn{
+					ColumnIdx:  int32(columnIndex),
+					Descending: column.descending,
+					NullsFirst: !column.descending,
+				})
+			}
+		}
+	}
+
+	return sortingColumns
+}
+
+//apply the compression, row group and page size settings of the stream, writer defaults apply where the stream has none
+func tuneParquetWriter(pw *writer.ParquetWriter, configRecord Config) error {
+
+	if compressionTypeName := getCompressionTypeName(configRecord); compressionTypeName != "" {
+		compressionCodec, err := getParquetCompressionCodec(compressionTypeName)
+		if err != nil {
+			return err
+		}
+		pw.CompressionType = compressionCodec
+	}
+
+	if configRecord.ParquetRowGroupSize.Int64 > 0 {
+		pw.RowGroupSize = configRecord.ParquetRowGroupSize.Int64
+	}
+
+	if configRecord.ParquetPageSize.Int64 > 0 {
+		pw.PageSize = configRecord.ParquetPageSize.Int64
+	}
+
+	return nil
+}
+
+//close the last row group so every row group of the file declares the ordering of its rows
+func setParquetSortingColumns(pw *writer.ParquetWriter, leafPaths []string, sortColumns []parquetSortColumn) error {
+
+	if err := pw.Flush(true); err != nil {
+		return err
+	}
+
+	sortingColumns := getParquetSortingColumns(leafPaths, sortColumns)
+	for _, rowGroup := range pw.Footer.RowGroups {
+		rowGroup.SortingColumns = sortingColumns
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xitongsys/parquet-go-source/writerfile"
+)
+
+func TestGenerateRecordsSchema(t *testing.T) {
+
+	first := decodePayload(t, `{"id": "s-1", "user": {"id": "u-1", "plan": "pro"}, "count": 2}`)
+	second := decodePayload(t, `{"id": "s-2", "user": {"id": "u-2"}, "page": "/a", "count": "many"}`)
+
+	//records of one shape keep their own schema
+	schema, records := generateRecordsSchema([]map[string]interface{}{first, first}, "sessions")
+	if want := strings.TrimRight(GenerateSchema(first, "sessions", ""), ",") + "]}"; schema != want {
+		t.Errorf("schema of records of one shape = %s, want %s", schema, want)
+	}
+	if !reflect.DeepEqual(records[1], first) {
+		t.Errorf("records of one shape changed to %v", records[1])
+	}
+
+	schema, records = generateRecordsSchema([]map[string]interface{}{first, second}, "sessions")
+
+	var root parquetSchemaNode
+	if err := json.Unmarshal([]byte(schema), &root); err != nil {
+		t.Fatal(err)
+	}
+
+	wantTags := map[string]string{
+		"count":     "name=count, type=BYTE_ARRAY, repetitiontype=REQUIRED",
+		"id":        "name=id, type=BYTE_ARRAY, repetitiontype=REQUIRED",
+		"page":      "name=page, type=BYTE_ARRAY, repetitiontype=OPTIONAL",
+		"user.id":   "name=id, type=BYTE_ARRAY, repetitiontype=REQUIRED",
+		"user.plan": "name=plan, type=BYTE_ARRAY, repetitiontype=OPTIONAL",
+	}
+	tags := map[string]string{}
+	var names []string
+	for _, field := range root.Fields {
+		names = append(names, field.tagValue("name"))
+		tags[field.tagValue("name")] = field.Tag
+		for _, nested := range field.Fields {
+			tags[field.tagValue("name")+"."+nested.tagValue("name")] = nested.Tag
+		}
+	}
+	if !reflect.DeepEqual(names, []string{"count", "id", "page", "user"}) {
+		t.Errorf("fields %v, want them in name order", names)
+	}
+	for path, want := range wantTags {
+		if tags[path] != want {
+			t.Errorf("field %s = %q, want %q", path, tags[path], want)
+		}
+	}
+
+	if records[0]["count"] != "2" || records[1]["count"] != "many" {
+		t.Errorf("counts written as %#v and %#v, want strings", records[0]["count"], records[1]["count"])
+	}
+
+	//the union schema takes every record
+	payloads := make([][]byte, len(records))
+	for i, record := range records {
+		payloads[i], _ = json.Marshal(record)
+	}
+	var file bytes.Buffer
+	if err := WriteRecordsToFile(schema, writerfile.NewWriterFile(&file), payloads, Config{}); err != nil {
+		t.Fatal(err)
+	}
+	_, rows, err := readParquetRows([][]byte{file.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Errorf("%d rows read back, want 2", len(rows))
+	}
+}
@@ -0,0 +1,493 @@
+//per-partition bookkeeping: manifest of committed files and the _SUCCESS marker once a time partition closes
+//one `com.rtdl.sf/partition` function instance per stream partition, so manifest updates are never concurrent
+//a sealed partition is announced with a partition closed event, for batch jobs downstream to start on it
+//readers of the partition folder wait for _SUCCESS - until then files may still be added, merged and deleted
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/flink-statefun/statefun-sdk-go/v3/pkg/statefun"
+)
+
+//files written next to the data of a partition - "_" prefixed so query engines skip them
+const (
+	manifestFileName = "_manifest.json"
+	successFileName  = "_SUCCESS"
+)
+
+//partition function actions
+const (
+	partitionActionCommit  = "commit"  //a file has been committed to the partition
+	partitionActionFlush   = "flush"   //write the manifest
+	partitionActionSeal    = "seal"    //partition closed, compact, then write manifest and _SUCCESS
+	partitionActionCompact = "compact" //merge the small files of a sealed partition on request
+	partitionActionErase   = "erase"   //remove the rows of subjects from the files of the partition
+)
+
+var (
+	PartitionTypeName    = statefun.TypeNameFrom("com.rtdl.sf/partition")
+	PartitionMessageType = statefun.MakeJsonType(statefun.TypeNameFrom("com.rtdl.sf/PartitionMessage"))
+	PartitionStateType   = statefun.MakeJsonType(statefun.TypeNameFrom("com.rtdl.sf/PartitionState"))
+)
+
+//state of a partition, kept a week after the last invocation to absorb late files
+var PartitionState = statefun.ValueSpec{
+	Name:       "partition",
+	ValueType:  PartitionStateType,
+	Expiration: statefun.ExpireAfterCall(7 * 24 * time.Hour),
+}
+
+//manifest updates are batched, the partition is sealed after a grace period for in-flight files
+var manifestFlushInterval = getEnvDuration("MANIFEST_FLUSH_INTERVAL", 30*time.Second)
+var partitionSealGrace = getEnvDuration("PARTITION_SEAL_GRACE", 5*time.Minute)
+
+//Kafka topic partition closed events are published to, webhooks of the stream with `content` partitions get them as well
+var partitionEventsTopic = GetEnv("PARTITION_EVENTS_TOPIC", "partition-events")
+
+//`event` of a partition closed event
+const partitionClosedEvent = "partition_closed"
+
+//a file committed to a partition, as reported by the ingest function
+type CommittedFile struct {
+	StreamId      string    `json:"stream_id"`
+	Partition     string    `json:"partition"` //folder of the partition relative to the store root
+	PartitionEnd  time.Time `json:"partition_end"`
+	File          string    `json:"file"`
+	Rows          int64     `json:"rows"`
+	SizeBytes     int64     `json:"size_bytes"`
+	SchemaHash    string    `json:"schema_hash"`
+	CommittedAt   time.Time `json:"committed_at"`
+	Table         string    `json:"table,omitempty"` //root of the table the file belongs to, table formats only
+	MessageType   string    `json:"message_type,omitempty"`
+	Schema        string    `json:"schema,omitempty"`
+	Stats         string    `json:"stats,omitempty"`
+	PostgresTable string    `json:"postgres_table,omitempty"` //schema and name of the table the rows went into as well, Postgres sink only
+}
+
+type PartitionMessage struct {
+	Action  string          `json:"action"`
+	File    *CommittedFile  `json:"file,omitempty"`
+	Trigger string          `json:"trigger,omitempty"` //compact action only, policy when empty
+	Erasure *ErasureRequest `json:"erasure,omitempty"`
+}
+
+type ManifestEntry struct {
+	File          string    `json:"file"`
+	Rows          int64     `json:"rows"`
+	SizeBytes     int64     `json:"size_bytes,omitempty"`
+	SchemaHash    string    `json:"schema_hash"`
+	CommittedAt   time.Time `json:"committed_at"`
+	CompactedFrom []string  `json:"compacted_from,omitempty"` //files merged into this one
+}
+
+//content of _manifest.json
+type PartitionManifest struct {
+	StreamId     string          `json:"stream_id"`
+	Partition    string          `json:"partition"`
+	PartitionEnd time.Time       `json:"partition_end"`
+	MessageType  string          `json:"message_type,omitempty"`
+	Sealed       bool            `json:"sealed"`
+	Files        []ManifestEntry `json:"files"`
+}
+
+//published once a partition is sealed - all files the partition has, it takes no more
+type PartitionClosed struct {
+	Event        string          `json:"event"`
+	StreamId     string          `json:"stream_id"`
+	MessageType  string          `json:"message_type,omitempty"`
+	Partition    string          `json:"partition"` //folder of the partition relative to the store root
+	PartitionEnd time.Time       `json:"partition_end"`
+	Files        []ManifestEntry `json:"files"`
+	FileCount    int             `json:"file_count"`
+	Rows         int64           `json:"rows"`
+	SizeBytes    int64           `json:"size_bytes"`
+	ClosedAt     time.Time       `json:"closed_at"`
+}
+
+type PartitionStateValue struct {
+	Manifest      PartitionManifest `json:"manifest"`
+	FlushPending  bool              `json:"flush_pending"`
+	SealScheduled bool              `json:"seal_scheduled"`
+	ClosedSent    bool              `json:"closed_sent"` //partition closed event published
+}
+
+//duration from environment, default when unset or invalid
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+
+	duration, err := time.ParseDuration(GetEnv(key, ""))
+	if err != nil || duration <= 0 {
+		return defaultValue
+	}
+	return duration
+}
+
+//positive integer from environment, default when unset or invalid
+func getEnvInt(key string, defaultValue int) int {
+
+	value, err := strconv.Atoi(GetEnv(key, ""))
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
+
+//hash identifying the schema of a file, files sharing it can be read together
+func generateSchemaHash(schema string) string {
+
+	hash := sha256.Sum256([]byte(schema))
+	return hex.EncodeToString(hash[:])
+}
+
+//end of the time partition containing partitionTime - zero when the stream is not time partitioned
+func generatePartitionEnd(configRecord Config, partitionTime time.Time) time.Time {
+
+	for _, partitionTimeRecord := range partitionTimes {
+
+		if partitionTimeRecord.PartitionTimeId == configRecord.PartitionTimeId.Int64 {
+
+			year, month, day := partitionTime.Date()
+			location := partitionTime.Location()
+
+			switch partitionTimeRecord.PartitionTimeName {
+			case "Hourly":
+				return time.Date(year, month, day, partitionTime.Hour(), 0, 0, 0, location).Add(time.Hour)
+			case "Daily":
+				return time.Date(year, month, day+1, 0, 0, 0, 0, location)
+			case "Weekly": //ISO weeks start on Monday
+				daysFromMonday := (int(partitionTime.Weekday()) + 6) % 7
+				return time.Date(year, month, day-daysFromMonday+7, 0, 0, 0, 0, location)
+			case "Monthly":
+				return time.Date(year, month+1, 1, 0, 0, 0, 0, location)
+			case "Quarterly":
+				quarterStart := time.Month((int(month)-1)/3*3 + 1)
+				return time.Date(year, quarterStart+3, 1, 0, 0, 0, 0, location)
+			}
+		}
+	}
+
+	return time.Time{}
+}
+
+//find the configuration record of a stream
+func findStreamConfig(streamId string) (Config, bool) {
+
+	for _, configRecord := range configs {
+		if configRecord.StreamId.String == streamId {
+			return configRecord, true
+		}
+	}
+	return Config{}, false
+}
+
+//hand a committed file over to the function instance of its partition
+func sendCommittedFile(ctx statefun.Context, committedFile *CommittedFile) {
+
+	ctx.Send(statefun.MessageBuilder{
+		Target:    statefun.Address{FunctionType: PartitionTypeName, Id: committedFile.StreamId + "/" + committedFile.Partition},
+		Value:     PartitionMessage{Action: partitionActionCommit, File: committedFile},
+		ValueType: PartitionMessageType,
+	})
+}
+
+//announce a sealed partition on the partition events topic and the partition webhooks of its stream
+func sendPartitionClosed(ctx statefun.Context, manifest PartitionManifest) {
+
+	event := PartitionClosed{
+		Event:        partitionClosedEvent,
+		StreamId:     manifest.StreamId,
+		MessageType:  manifest.MessageType,
+		Partition:    manifest.Partition,
+		PartitionEnd: manifest.PartitionEnd,
+		Files:        manifest.Files,
+		FileCount:    len(manifest.Files),
+		ClosedAt:     time.Now().UTC(),
+	}
+	for _, entry := range manifest.Files {
+		event.Rows += entry.Rows
+		event.SizeBytes += entry.SizeBytes
+	}
+
+	value, _ := json.Marshal(event)
+
+	ctx.SendEgress(statefun.KafkaEgressBuilder{
+		Target: KafkaEgressTypeName,
+		Topic:  partitionEventsTopic,
+		Key:    manifest.StreamId,
+		Value:  value,
+	})
+
+	queueWebhookEvent(manifest.StreamId, webhookContentPartitions, manifest.MessageType, value)
+}
+
+//schedule an action on the calling partition instance
+func sendPartitionAction(ctx statefun.Context, delay time.Duration, action string) {
+
+	ctx.SendAfter(delay, statefun.MessageBuilder{
+		Target:    ctx.Self(),
+		Value:     PartitionMessage{Action: action},
+		ValueType: PartitionMessageType,
+	})
+}
+
+//stream id and partition folder of a partition function id
+func splitPartitionId(id string) (string, string) {
+
+	parts := strings.SplitN(id, "/", 2) //stream ids are uuids, partition folders contain slashes
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+//write a file into the partition folder of the manifest
+func writePartitionFile(manifest PartitionManifest, fileName string, data []byte) error {
+
+	configRecord, found := findStreamConfig(manifest.StreamId)
+	if !found {
+		log.Println("No configuration found for stream", manifest.StreamId)
+		return nil //stream has been removed, nothing to write to
+	}
+
+	store, err := newFileStore(getFileStoreTypeName(configRecord), configRecord)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	return putFile(store, manifest.Partition+"/"+fileName, data)
+}
+
+//delete a file from the partition folder of the manifest
+func deletePartitionFile(manifest PartitionManifest, fileName string) error {
+
+	configRecord, found := findStreamConfig(manifest.StreamId)
+	if !found {
+		log.Println("No configuration found for stream", manifest.StreamId)
+		return nil
+	}
+
+	store, err := newFileStore(getFileStoreTypeName(configRecord), configRecord)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	return store.Delete(manifest.Partition + "/" + fileName)
+}
+
+//true when a file has been merged into another file of the manifest by compaction
+func isCompactedFile(manifest PartitionManifest, fileName string) bool {
+
+	for _, entry := range manifest.Files {
+		for _, compactedFile := range entry.CompactedFrom {
+			if compactedFile == fileName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//partition stateful function
+func Partition(ctx statefun.Context, message statefun.Message) error {
+
+	var request PartitionMessage
+	if err := message.As(PartitionMessageType, &request); err != nil {
+		return err
+	}
+
+	var state PartitionStateValue
+	ctx.Storage().Get(PartitionState, &state)
+
+	switch request.Action {
+
+	case partitionActionCommit:
+		committedFile := request.File
+		if committedFile == nil {
+			return nil
+		}
+
+		state.Manifest.StreamId = committedFile.StreamId
+		state.Manifest.Partition = committedFile.Partition
+		state.Manifest.PartitionEnd = committedFile.PartitionEnd
+		state.Manifest.MessageType = committedFile.MessageType
+
+		entry := ManifestEntry{
+			File:        committedFile.File,
+			Rows:        committedFile.Rows,
+			SizeBytes:   committedFile.SizeBytes,
+			SchemaHash:  committedFile.SchemaHash,
+			CommittedAt: committedFile.CommittedAt,
+		}
+
+		if isCompactedFile(state.Manifest, entry.File) { //redelivered file whose rows are already in a merged file
+			log.Println("File", entry.File, "of partition", state.Manifest.Partition, "has been compacted, removing the redelivered copy")
+			if err := deletePartitionFile(state.Manifest, entry.File); err != nil {
+				log.Println("Error deleting", state.Manifest.Partition+"/"+entry.File, err)
+			}
+			break
+		}
+
+		replaced := false
+		for i := range state.Manifest.Files { //redelivered file with a deterministic name replaces its entry
+			if state.Manifest.Files[i].File == entry.File {
+				state.Manifest.Files[i] = entry
+				replaced = true
+			}
+		}
+		if !replaced {
+			state.Manifest.Files = append(state.Manifest.Files, entry)
+		}
+
+		if state.Manifest.Sealed {
+			log.Println("File", entry.File, "committed to already sealed partition", state.Manifest.Partition)
+		}
+
+		if !state.FlushPending {
+			sendPartitionAction(ctx, manifestFlushInterval, partitionActionFlush)
+			state.FlushPending = true
+		}
+
+		if !state.SealScheduled && !state.Manifest.PartitionEnd.IsZero() {
+			delay := time.Until(state.Manifest.PartitionEnd) + partitionSealGrace
+			if delay < partitionSealGrace {
+				delay = partitionSealGrace
+			}
+			sendPartitionAction(ctx, delay, partitionActionSeal)
+			state.SealScheduled = true
+		}
+
+	case partitionActionFlush, partitionActionSeal:
+		if request.Action == partitionActionSeal {
+			state.Manifest.Sealed = true
+
+			//compacted before _SUCCESS, so readers waiting for it never see merged and original files side by side
+			if configRecord, found := findStreamConfig(state.Manifest.StreamId); found && needsCompaction(state.Manifest, configRecord) {
+				compacted, err := compactPartition(state.Manifest, configRecord, compactionTriggerPolicy)
+				if err != nil { //sealed with the files as they are
+					log.Println("Error compacting partition", state.Manifest.Partition, err)
+				} else {
+					state.Manifest = compacted
+				}
+			}
+		}
+
+		manifest, _ := json.MarshalIndent(state.Manifest, "", "  ")
+
+		err := writePartitionFile(state.Manifest, manifestFileName, manifest)
+		if err == nil && request.Action == partitionActionSeal {
+			//manifest first - a _SUCCESS marker always comes with a complete manifest
+			err = writePartitionFile(state.Manifest, successFileName, []byte{})
+		}
+
+		if err != nil { //keep the state and try again later
+			log.Println("Error writing", request.Action, "of partition", state.Manifest.Partition, err)
+			sendPartitionAction(ctx, manifestFlushInterval, request.Action)
+			break
+		}
+
+		if request.Action == partitionActionFlush {
+			state.FlushPending = false
+		}
+
+		log.Println("Partition", state.Manifest.Partition, request.Action, "written")
+
+		if request.Action == partitionActionSeal && !state.ClosedSent {
+			sendPartitionClosed(ctx, state.Manifest)
+			state.ClosedSent = true
+		}
+
+	case partitionActionCompact:
+		streamId, partition := splitPartitionId(ctx.Self().Id)
+
+		configRecord, found := findStreamConfig(streamId)
+		if !found {
+			log.Println("No configuration found for stream", streamId)
+			break
+		}
+
+		if state.Manifest.StreamId == "" { //state expired, continue from the manifest in the store
+			manifest, err := readPartitionManifest(configRecord, partition)
+			if err != nil {
+				log.Println("Error reading manifest of partition", partition, err)
+				break
+			}
+			state.Manifest = manifest
+		}
+
+		trigger := request.Trigger
+		if trigger == "" {
+			trigger = compactionTriggerPolicy
+		}
+
+		switch {
+		case state.Manifest.StreamId != streamId:
+			log.Println("Manifest of partition", partition, "belongs to stream", state.Manifest.StreamId)
+		case !canCompact(configRecord):
+			log.Println("Files of stream", streamId, "cannot be compacted")
+		case !state.Manifest.Sealed: //files may still be arriving
+			log.Println("Partition", partition, "is not sealed, compaction skipped")
+		case trigger == compactionTriggerPolicy && !needsCompaction(state.Manifest, configRecord):
+			//policy no longer applies, e.g. changed since the partition was sealed
+		case len(state.Manifest.Files) < 2:
+			//nothing to merge
+		default:
+			//the partition is unsealed while its files are rewritten, readers waiting for _SUCCESS hold off until it is back
+			if err := deletePartitionFile(state.Manifest, successFileName); err != nil {
+				log.Println("Error removing", successFileName, "of partition", partition, err)
+				break
+			}
+
+			compacted, err := compactPartition(state.Manifest, configRecord, trigger)
+			if err != nil {
+				log.Println("Error compacting partition", partition, err)
+			} else {
+				state.Manifest = compacted
+			}
+
+			if err := writePartitionFile(state.Manifest, successFileName, []byte{}); err != nil { //sealed again by the next seal
+				log.Println("Error writing", successFileName, "of partition", partition, err)
+				sendPartitionAction(ctx, manifestFlushInterval, partitionActionSeal)
+			}
+		}
+
+	case partitionActionErase:
+		if request.Erasure == nil {
+			return nil
+		}
+
+		streamId, partition := splitPartitionId(ctx.Self().Id)
+
+		var result erasureResult
+
+		configRecord, found := findStreamConfig(streamId)
+		if !found {
+			result.Errors = append(result.Errors, "no configuration found for stream "+streamId)
+		} else {
+			if state.Manifest.StreamId == "" { //state expired, continue from the manifest in the store if there is one
+				if manifest, err := readPartitionManifest(configRecord, partition); err == nil {
+					state.Manifest = manifest
+				}
+			}
+			result = erasePartition(&state.Manifest, partition, configRecord, *request.Erasure)
+		}
+
+		log.Println("Erasure job", request.Erasure.JobId, "removed", result.RowsDeleted, "rows from partition", partition)
+
+		if err := recordErasurePartition(request.Erasure.JobId, partition, result); err != nil {
+			log.Println("Error recording erasure of partition", partition, err)
+		}
+	}
+
+	ctx.Storage().Set(PartitionState, state)
+
+	return nil
+}
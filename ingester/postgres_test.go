@@ -0,0 +1,92 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestGetPostgresValue(t *testing.T) {
+
+	tests := []struct {
+		value    interface{}
+		dataType string
+		want     interface{}
+	}{
+		{map[string]interface{}{"plan": "pro"}, "jsonb", `{"plan":"pro"}`},
+		{"pro", "text", "pro"},
+		{2.0, "text", "2"},
+		{true, "boolean", true},
+		{"true", "boolean", nil},
+		{3.0, "bigint", int64(3)},
+		{3.5, "bigint", nil},
+		{3.0, "double precision", 3.0},
+		{"2022-04-15T05:20:00Z", "timestamp with time zone", "2022-04-15T05:20:00Z"},
+		{nil, "text", nil},
+	}
+
+	for _, test := range tests {
+		if got := getPostgresValue(test.value, test.dataType); got != test.want {
+			t.Errorf("getPostgresValue(%#v, %s) = %#v, want %#v", test.value, test.dataType, got, test.want)
+		}
+	}
+}
+
+func TestGetPostgresSubjectExpression(t *testing.T) {
+
+	tests := []struct {
+		subjectField string
+		dataType     string
+		want         string
+		ok           bool
+	}{
+		{"user_id", "text", `"user_id"::text`, true},
+		{"user_id", "bigint", `"user_id"::text`, true},
+		{"user", "jsonb", `"user" #>> '{}'`, true},
+		{"user.id", "jsonb", `"user" #>> '{"id"}'`, true},
+		{"user.o'brien", "jsonb", `"user" #>> '{"o''brien"}'`, true},
+		{"user.id", "text", "", false},
+		{"user_id", "", "", false},
+	}
+
+	for _, test := range tests {
+		got, ok := getPostgresSubjectExpression(strings.Split(test.subjectField, "."), test.dataType)
+		if got != test.want || ok != test.ok {
+			t.Errorf("getPostgresSubjectExpression(%s, %s) = %s, %t, want %s, %t", test.subjectField, test.dataType, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestGetPartitionMessageTypes(t *testing.T) {
+
+	var streamConfig Config
+	streamConfig.FolderName = sql.NullString{String: "events", Valid: true}
+
+	partitions := []string{"events/click/2022-04-15", "events/click/2022-04-16", "events/view/2022-04-15"}
+	got := getPartitionMessageTypes(partitions, streamConfig)
+	if len(got) != 2 || got[0] != "click" || got[1] != "view" {
+		t.Errorf("getPartitionMessageTypes = %v, want [click view]", got)
+	}
+}
+
+func TestWriteMessagesPostgresFailure(t *testing.T) {
+
+	defer func(streams []Config) { configs = streams }(configs)
+	defer useLocalStore(t)()
+
+	//nothing listens on the port, the insert fails before the file is written
+	streamConfig := newLocalTableConfig("stream")
+	streamConfig.PostgresEnabled = sql.NullBool{Bool: true, Valid: true}
+	streamConfig.PostgresUrl = sql.NullString{String: "postgres://rtdl@127.0.0.1:1/rtdl?sslmode=disable&connect_timeout=1", Valid: true}
+	configs = []Config{streamConfig}
+
+	request := IncomingMessage{StreamId: "stream", Payload: decodePayload(t, `{"userId": "u-1"}`)}
+	committedFile, err := WriteParquet(request)
+	if err == nil || committedFile != nil {
+		t.Fatalf("write with the database down returned %+v and %v, want an error", committedFile, err)
+	}
+	if _, err := os.Stat("datastore"); !os.IsNotExist(err) {
+		t.Errorf("file written although the rows were not, want neither")
+	}
+}
@@ -0,0 +1,352 @@
+//data retention: a background job deletes the partitions of a stream once they are older than its `retention_days`
+//the age of a partition comes from the folder name generateSubFolderName gave it, a partition expires once its whole time range has
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//outcome of an expired partition
+const (
+	retentionStatusDeleted     = "deleted"
+	retentionStatusWouldDelete = "would_delete" //dry run
+	retentionStatusFailed      = "failed"
+)
+
+//how often the retention job looks for expired partitions
+var retentionInterval = getEnvDuration("RETENTION_INTERVAL", time.Hour)
+
+//partition past the retention of its stream
+type expiredPartition struct {
+	Path         string //relative to the store root
+	MessageType  string
+	PartitionEnd time.Time
+	Files        []StoredFile
+	Bytes        int64
+}
+
+//`partition_time_name` of a stream, empty when the stream is not time partitioned
+func getPartitionTimeName(configRecord Config) string {
+
+	for _, partitionTimeRecord := range partitionTimes {
+		if partitionTimeRecord.PartitionTimeId == configRecord.PartitionTimeId.Int64 {
+			return partitionTimeRecord.PartitionTimeName
+		}
+	}
+	return ""
+}
+
+//retention of a stream, zero when partitions are kept forever
+func getRetention(configRecord Config) time.Duration {
+
+	if configRecord.RetentionDays.Int64 <= 0 {
+		return 0
+	}
+	return time.Duration(configRecord.RetentionDays.Int64) * 24 * time.Hour
+}
+
+//start of the time partition a folder written by generateSubFolderName stands for
+func parsePartitionFolderTime(partitionTimeName string, folder string) (time.Time, bool) {
+
+	var layout string
+
+	switch partitionTimeName {
+	case "Hourly":
+		layout = "2006-01-02-15"
+	case "Daily":
+		layout = "2006-01-02"
+	case "Monthly":
+		layout = "2006-01"
+	case "Weekly", "Quarterly": //<year>-<ISO week> and <year>-<quarter>
+		parts := strings.Split(folder, "-")
+		if len(parts) != 2 {
+			return time.Time{}, false
+		}
+		year, err1 := strconv.Atoi(parts[0])
+		number, err2 := strconv.Atoi(parts[1])
+		if err1 != nil || err2 != nil || number < 1 {
+			return time.Time{}, false
+		}
+		if partitionTimeName == "Quarterly" {
+			if number > 4 {
+				return time.Time{}, false
+			}
+			return time.Date(year, time.Month((number-1)*3+1), 1, 0, 0, 0, 0, time.Local), true
+		}
+		//Monday of ISO week 1 is the Monday on or before January 4th
+		january4 := time.Date(year, time.January, 4, 0, 0, 0, 0, time.Local)
+		week1 := january4.AddDate(0, 0, -((int(january4.Weekday()) + 6) % 7))
+		weekStart := week1.AddDate(0, 0, (number-1)*7)
+		if weekYear, week := weekStart.ISOWeek(); weekYear != year || week != number {
+			return time.Time{}, false
+		}
+		return weekStart, true
+	default:
+		return time.Time{}, false
+	}
+
+	partitionTime, err := time.ParseInLocation(layout, folder, time.Local) //folders are named in local time
+	if err != nil {
+		return time.Time{}, false
+	}
+	return partitionTime, true
+}
+
+//partitions of a stream whose time range ended before the retention, oldest first
+//files are expected at [folder/]<message type>/<partition>/<file name>, anything else is left alone
+func findExpiredPartitions(store FileStore, configRecord Config, now time.Time) ([]expiredPartition, error) {
+
+	partitionTimeName := getPartitionTimeName(configRecord)
+	retention := getRetention(configRecord)
+
+	prefix := ""
+	if configRecord.FolderName.String != "" {
+		prefix = configRecord.FolderName.String + "/"
+	}
+
+	storedFiles, err := store.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	partitions := map[string]*expiredPartition{}
+
+	for _, storedFile := range storedFiles {
+
+		parts := strings.Split(strings.TrimPrefix(storedFile.Path, prefix), "/")
+		if len(parts) != 3 {
+			continue
+		}
+
+		partitionStart, ok := parsePartitionFolderTime(partitionTimeName, parts[1])
+		if !ok {
+			continue
+		}
+
+		partitionEnd := generatePartitionEnd(configRecord, partitionStart)
+		if partitionEnd.Add(retention).After(now) {
+			continue
+		}
+
+		path := prefix + parts[0] + "/" + parts[1]
+		partition, found := partitions[path]
+		if !found {
+			partition = &expiredPartition{Path: path, MessageType: parts[0], PartitionEnd: partitionEnd}
+			partitions[path] = partition
+		}
+		partition.Files = append(partition.Files, storedFile)
+		partition.Bytes += storedFile.Size
+	}
+
+	expired := make([]expiredPartition, 0, len(partitions))
+	for _, partition := range partitions {
+		expired = append(expired, *partition)
+	}
+	sort.Slice(expired, func(i, j int) bool {
+		if expired[i].PartitionEnd.Equal(expired[j].PartitionEnd) {
+			return expired[i].Path < expired[j].Path
+		}
+		return expired[i].PartitionEnd.Before(expired[j].PartitionEnd)
+	})
+
+	return expired, nil
+}
+
+//stream the manifest among the files of a partition names, empty when there is none
+func getPartitionStreamId(store FileStore, partitionPath string, files []StoredFile) string {
+
+	for _, storedFile := range files {
+
+		if storedFile.Path != partitionPath+"/"+manifestFileName {
+			continue
+		}
+
+		data, err := store.Get(storedFile.Path)
+		if err != nil {
+			return "" //gone in the meantime
+		}
+
+		var manifest PartitionManifest
+		if json.Unmarshal(data, &manifest) == nil {
+			return manifest.StreamId
+		}
+	}
+
+	return ""
+}
+
+//true when a partition is the stream's own: its manifest names the stream, or it has no manifest and lies in the stream's folder
+//without a folder the store is shared with other streams, a partition nothing names the owner of is left alone
+func belongsToStream(store FileStore, partitionPath string, files []StoredFile, configRecord Config) bool {
+
+	partitionStreamId := getPartitionStreamId(store, partitionPath, files)
+	if partitionStreamId == "" {
+		return configRecord.FolderName.String != ""
+	}
+	return partitionStreamId == configRecord.StreamId.String
+}
+
+//delete every file of a partition
+//the _SUCCESS marker goes first and the manifest last, so a partition that is half deleted never looks complete
+func deletePartition(store FileStore, partition expiredPartition) error {
+
+	rank := func(path string) int {
+		switch path {
+		case partition.Path + "/" + successFileName:
+			return 0
+		case partition.Path + "/" + manifestFileName:
+			return 2
+		}
+		return 1
+	}
+
+	files := append([]StoredFile(nil), partition.Files...)
+	sort.SliceStable(files, func(i, j int) bool { return rank(files[i].Path) < rank(files[j].Path) })
+
+	for _, storedFile := range files {
+		if err := store.Delete(storedFile.Path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//delete (or, in dry run mode, report) the expired partitions of a stream
+func applyRetention(configRecord Config, now time.Time) error {
+
+	streamId := configRecord.StreamId.String
+
+	if getRetention(configRecord) == 0 {
+		return nil
+	}
+
+	if usesTableFormat(configRecord) { //data files are referenced from the table metadata
+		log.Println("Retention of table format stream", streamId, "has to be handled by the table format")
+		return nil
+	}
+
+	if getPartitionTimeName(configRecord) == "" {
+		log.Println("Stream", streamId, "is not time partitioned, retention skipped")
+		return nil
+	}
+
+	storeType := getFileStoreTypeName(configRecord)
+
+	store, err := newFileStore(storeType, configRecord)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	expired, err := findExpiredPartitions(store, configRecord, now)
+	if err != nil {
+		return err
+	}
+
+	dryRun := configRecord.RetentionDryRun.Bool
+	deletedMessageTypes := map[string]bool{}
+
+	for _, partition := range expired {
+
+		if !belongsToStream(store, partition.Path, partition.Files, configRecord) {
+			continue
+		}
+
+		status := retentionStatusWouldDelete
+		errorMessage := ""
+
+		if dryRun {
+			log.Println("Retention dry run: would delete partition", partition.Path, "with", len(partition.Files), "files,", partition.Bytes, "bytes")
+		} else if err := deletePartition(store, partition); err != nil {
+			log.Println("Error deleting partition", partition.Path, err)
+			status = retentionStatusFailed
+			errorMessage = err.Error()
+		} else {
+			log.Println("Retention: deleted partition", partition.Path, "with", len(partition.Files), "files,", partition.Bytes, "bytes")
+			status = retentionStatusDeleted
+			deletedMessageTypes[partition.MessageType] = true
+		}
+
+		if err := recordRetentionRun(streamId, partition, dryRun, status, errorMessage); err != nil {
+			log.Println("Error recording retention of partition", partition.Path, err)
+		}
+	}
+
+	for messageType := range deletedMessageTypes { //queries must not plan against deleted files
+		if err := refreshDremioDataset(messageType, storeType, configRecord); err != nil {
+			log.Println("Error refreshing Dremio dataset", messageType, "of stream", streamId, err)
+		}
+	}
+
+	return nil
+}
+
+//refresh the metadata of the Dremio dataset of a message type, as registered by UpdateDremio
+func refreshDremioDataset(messageType string, storeType string, configRecord Config) error {
+
+	path := []string{configRecord.StreamId.String}
+	if strings.Contains(dremioHost, "cloud") && storeType != "Local" && storeType != "HDFS" { //Dremio Cloud paths include bucket and folder
+		path = append(path, configRecord.BucketName.String)
+		if configRecord.FolderName.String != "" {
+			path = append(path, configRecord.FolderName.String)
+		}
+	}
+	path = append(path, messageType)
+
+	for i := range path {
+		path[i] = `"` + strings.Replace(path[i], `"`, `""`, -1) + `"`
+	}
+
+	query, _ := json.Marshal(map[string]string{"sql": "ALTER TABLE " + strings.Join(path, ".") + " REFRESH METADATA"})
+
+	_, err := DremioReqRes("sql", query)
+	return err
+}
+
+//insert an expired partition into `retention_runs`
+//a dry run keeps one row per partition, refreshed on every pass, as the partition is found expired again each time
+func recordRetentionRun(streamId string, partition expiredPartition, dryRun bool, status string, errorMessage string) error {
+
+	db, err := sqlx.Open("postgres", psqlCon)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`INSERT INTO retention_runs (stream_id, partition_path, partition_end, dry_run, status, files, bytes, error_message)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (stream_id, partition_path) WHERE dry_run DO UPDATE
+		SET partition_end = EXCLUDED.partition_end, status = EXCLUDED.status, files = EXCLUDED.files, bytes = EXCLUDED.bytes, executed_at = NOW()`,
+		streamId, partition.Path, partition.PartitionEnd, dryRun, status, len(partition.Files), partition.Bytes,
+		sql.NullString{String: errorMessage, Valid: errorMessage != ""})
+
+	return err
+}
+
+//background job applying the retention of every stream, one pass per retention interval
+func runRetention() {
+
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+
+		now := time.Now()
+
+		for _, configRecord := range configs {
+			if err := applyRetention(configRecord, now); err != nil {
+				log.Println("Error applying retention of stream", configRecord.StreamId.String, err)
+			}
+		}
+	}
+}
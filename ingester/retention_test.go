@@ -0,0 +1,184 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// partition times as seeded in `partition_times`
+var testPartitionTimes = []PartitionTime{{1, "Hourly"}, {2, "Daily"}, {3, "Weekly"}, {4, "Monthly"}, {5, "Quarterly"}}
+
+// store failing to delete one path, recording the deletes before it
+type failingDeleteStore struct {
+	FileStore
+	failPath string
+	deleted  []string
+}
+
+func (store *failingDeleteStore) Delete(path string) error {
+
+	if path == store.failPath {
+		return errors.New("delete failed")
+	}
+	store.deleted = append(store.deleted, path)
+	return store.FileStore.Delete(path)
+}
+
+func TestParsePartitionFolderTime(t *testing.T) {
+
+	tests := []struct {
+		partitionTime string
+		folder        string
+		want          time.Time
+		ok            bool
+	}{
+		{"Hourly", "2022-04-15-05", time.Date(2022, 4, 15, 5, 0, 0, 0, time.Local), true},
+		{"Daily", "2022-04-15", time.Date(2022, 4, 15, 0, 0, 0, 0, time.Local), true},
+		{"Monthly", "2022-04", time.Date(2022, 4, 1, 0, 0, 0, 0, time.Local), true},
+		{"Weekly", "2022-15", time.Date(2022, 4, 11, 0, 0, 0, 0, time.Local), true},
+		{"Weekly", "2021-1", time.Date(2021, 1, 4, 0, 0, 0, 0, time.Local), true},
+		{"Weekly", "2020-53", time.Date(2020, 12, 28, 0, 0, 0, 0, time.Local), true}, //ISO week of January 1st 2021
+		{"Weekly", "2021-53", time.Time{}, false},                                    //2021 has 52 ISO weeks
+		{"Weekly", "2022-0", time.Time{}, false},
+		{"Quarterly", "2022-1", time.Date(2022, 1, 1, 0, 0, 0, 0, time.Local), true},
+		{"Quarterly", "2022-4", time.Date(2022, 10, 1, 0, 0, 0, 0, time.Local), true},
+		{"Quarterly", "2022-5", time.Time{}, false},
+		{"Daily", "2022-04-15-05", time.Time{}, false},
+		{"Daily", "notadate", time.Time{}, false},
+		{"", "2022-04-15", time.Time{}, false},
+	}
+
+	for _, test := range tests {
+		got, ok := parsePartitionFolderTime(test.partitionTime, test.folder)
+		if !got.Equal(test.want) || ok != test.ok {
+			t.Errorf("parsePartitionFolderTime(%s, %s) = %v, %t, want %v, %t", test.partitionTime, test.folder, got, ok, test.want, test.ok)
+		}
+	}
+
+	//folders written by generateSubFolderName parse back to the start of their partition
+	defer func(times []PartitionTime) { partitionTimes = times }(partitionTimes)
+	partitionTimes = testPartitionTimes
+
+	for _, partitionTime := range testPartitionTimes {
+		configRecord := Config{PartitionTimeId: sql.NullInt64{Int64: partitionTime.PartitionTimeId, Valid: true}}
+		for _, written := range []time.Time{time.Date(2021, 1, 1, 12, 0, 0, 0, time.Local), time.Date(2022, 12, 31, 23, 30, 0, 0, time.Local)} {
+			folder := generateSubFolderName("track", configRecord, written)[len("track/"):]
+			start, ok := parsePartitionFolderTime(partitionTime.PartitionTimeName, folder)
+			if !ok || start.After(written) || !generatePartitionEnd(configRecord, start).After(written) {
+				t.Errorf("%s folder %s of %v parsed to %v, %t, want the start of its partition", partitionTime.PartitionTimeName, folder, written, start, ok)
+			}
+		}
+	}
+}
+
+func TestFindExpiredPartitions(t *testing.T) {
+
+	defer func(times []PartitionTime) { partitionTimes = times }(partitionTimes)
+	partitionTimes = testPartitionTimes
+	defer useLocalStore(t)()
+
+	configRecord := Config{
+		StreamId:        sql.NullString{String: "stream", Valid: true},
+		FileStoreTypeId: sql.NullInt64{Int64: 1, Valid: true},
+		FolderName:      sql.NullString{String: "s", Valid: true},
+		PartitionTimeId: sql.NullInt64{Int64: 2, Valid: true},
+		RetentionDays:   sql.NullInt64{Int64: 7, Valid: true},
+	}
+	store, err := newFileStore("Local", configRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	putTestFiles(t, store, map[string]string{
+		"s/track/2022-04-01/a.parquet":           "a",
+		"s/track/2022-04-01/" + manifestFileName: newTestManifest(t, "stream", "s/track/2022-04-01"),
+		"s/page/2022-03-31/b.parquet":            "b",
+		"s/track/2022-04-09/c.parquet":           "c", //ends April 10th, within the retention
+		"s/track/notadate/d.parquet":             "d",
+		"s/e.parquet":                            "e",
+		"other/track/2022-04-01/f.parquet":       "f", //outside the folder of the stream
+		"s/track/2022-04-01/nested/g.parquet":    "g",
+		"s/track/2022-03-01/" + manifestFileName: newTestManifest(t, "other", "s/track/2022-03-01"),
+	})
+
+	now := time.Date(2022, 4, 16, 12, 0, 0, 0, time.Local)
+	expired, err := findExpiredPartitions(store, configRecord, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	for _, partition := range expired {
+		paths = append(paths, partition.Path)
+	}
+	if want := []string{"s/track/2022-03-01", "s/page/2022-03-31", "s/track/2022-04-01"}; !reflect.DeepEqual(paths, want) {
+		t.Fatalf("expired partitions %v, want %v oldest first", paths, want)
+	}
+	if partition := expired[2]; partition.MessageType != "track" || len(partition.Files) != 2 || partition.Bytes == 0 || !partition.PartitionEnd.Equal(time.Date(2022, 4, 2, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("expired partition %+v, want the two track files of April 1st, ending April 2nd", partition)
+	}
+
+	//the partition whose manifest names another stream is found but not the stream's own
+	if belongsToStream(store, expired[0].Path, expired[0].Files, configRecord) {
+		t.Errorf("partition %s of another stream taken as the stream's own", expired[0].Path)
+	}
+	if !belongsToStream(store, expired[1].Path, expired[1].Files, configRecord) {
+		t.Errorf("partition %s without a manifest in the stream's folder not taken as the stream's own", expired[1].Path)
+	}
+	configRecord.FolderName = sql.NullString{}
+	if belongsToStream(store, expired[1].Path, expired[1].Files, configRecord) {
+		t.Errorf("partition %s without a manifest taken as the stream's own without a folder", expired[1].Path)
+	}
+}
+
+func TestDeletePartition(t *testing.T) {
+
+	defer useLocalStore(t)()
+
+	localStore, err := newFileStore("Local", Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer localStore.Close()
+
+	putTestFiles(t, localStore, map[string]string{
+		"track/2022-04-01/" + manifestFileName: newTestManifest(t, "stream", "track/2022-04-01"),
+		"track/2022-04-01/a.parquet":           "a",
+		"track/2022-04-01/" + successFileName:  "",
+		"track/2022-04-01/b.parquet":           "b",
+		"track/2022-04-02/c.parquet":           "c",
+	})
+	storedFiles, err := localStore.List("track/2022-04-01/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	partition := expiredPartition{Path: "track/2022-04-01", MessageType: "track", Files: storedFiles}
+
+	//a failed delete leaves the manifest, the partition is retried on the next run
+	store := &failingDeleteStore{FileStore: localStore, failPath: "track/2022-04-01/b.parquet"}
+	if err := deletePartition(store, partition); err == nil {
+		t.Fatal("failed delete not reported")
+	}
+	if want := []string{"track/2022-04-01/" + successFileName, "track/2022-04-01/a.parquet"}; !reflect.DeepEqual(store.deleted, want) {
+		t.Errorf("deleted before the failure %v, want %v", store.deleted, want)
+	}
+	if paths := listTestFiles(t, localStore, ""); !reflect.DeepEqual(paths, []string{"track/2022-04-01/" + manifestFileName, "track/2022-04-01/b.parquet", "track/2022-04-02/c.parquet"}) {
+		t.Errorf("files after the failed delete %v, want the manifest and the files not deleted yet", paths)
+	}
+
+	//the _SUCCESS marker goes first and the manifest last, other partitions stay
+	store = &failingDeleteStore{FileStore: localStore}
+	if err := deletePartition(store, partition); err != nil {
+		t.Fatal(err)
+	}
+	if last := store.deleted[len(store.deleted)-1]; last != "track/2022-04-01/"+manifestFileName {
+		t.Errorf("last deleted %s, want the manifest", last)
+	}
+	if paths := listTestFiles(t, localStore, ""); !reflect.DeepEqual(paths, []string{"track/2022-04-02/c.parquet"}) {
+		t.Errorf("files after deleting the partition %v, want only the other partition", paths)
+	}
+}
@@ -0,0 +1,491 @@
+//rollups: per-stream aggregations (count, sum, min, max, distinct_approx) grouped by payload fields over tumbling event-time windows
+//rules are managed through the config service, one `com.rtdl.sf/rollup` function instance per stream and rule keeps the open windows
+//a window is written once it is over and `ROLLUP_ALLOWED_LATENESS` has passed, one file with a row per group, as the `<rollup_name>` dataset
+//of the stream - a window whose write fails is kept and written again after `ROLLUP_RETRY_INTERVAL`
+
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/flink-statefun/statefun-sdk-go/v3/pkg/statefun"
+	"github.com/jmoiron/sqlx"
+)
+
+//rollup function actions
+const (
+	rollupActionEvent = "event" //an event of the stream has been ingested
+	rollupActionFlush = "flush" //a window is over, its rows are written
+)
+
+//supported aggregation functions
+const (
+	rollupFunctionCount          = "count" //events, or events having `field` when set
+	rollupFunctionSum            = "sum"
+	rollupFunctionMin            = "min"
+	rollupFunctionMax            = "max"
+	rollupFunctionDistinctApprox = "distinct_approx" //HyperLogLog estimate of the distinct values of `field`
+)
+
+//HyperLogLog registers are addressed by this many hash bits, 1024 registers for an error of about 3%
+const rollupSketchPrecision = 10
+
+//how long a window stays open for late events after it is over, and how long a window whose write failed waits for the next try
+var (
+	rollupAllowedLateness = getEnvDuration("ROLLUP_ALLOWED_LATENESS", time.Minute)
+	rollupRetryInterval   = getEnvDuration("ROLLUP_RETRY_INTERVAL", time.Minute)
+)
+
+var (
+	RollupTypeName    = statefun.TypeNameFrom("com.rtdl.sf/rollup")
+	RollupMessageType = statefun.MakeJsonType(statefun.TypeNameFrom("com.rtdl.sf/RollupMessage"))
+	RollupStateType   = statefun.MakeJsonType(statefun.TypeNameFrom("com.rtdl.sf/RollupState"))
+)
+
+//open windows of a rollup
+var RollupState = statefun.ValueSpec{
+	Name:      "rollup",
+	ValueType: RollupStateType,
+}
+
+//struct representation of a rollup rule
+type RollupRule struct {
+	RollupRuleId  int64          `db:"rollup_rule_id"`
+	StreamId      string         `db:"stream_id"`
+	RollupName    string         `db:"rollup_name"`
+	MessageType   sql.NullString `db:"message_type"`
+	GroupBy       sql.NullString `db:"group_by"` //JSON array of field paths
+	Aggregations  string         `db:"aggregations"`
+	WindowSeconds int64          `db:"window_seconds"`
+	CreatedAt     time.Time      `db:"created_at"`
+}
+
+//aggregation as stored on the rule, e.g.
+//{"function": "count"}, {"function": "sum", "field": "properties.revenue", "as": "revenue"},
+//{"function": "distinct_approx", "field": "userId", "as": "users"}
+type RollupAggregation struct {
+	Function string `json:"function"`
+	Field    string `json:"field,omitempty"`
+	As       string `json:"as,omitempty"`
+}
+
+type compiledRollupAggregation struct {
+	function string
+	path     []string //nil when counting events
+	name     string
+}
+
+//rollup rule ready to be applied
+type compiledRollupRule struct {
+	id           int64
+	name         string
+	messageType  string //empty for every message type
+	groupBy      [][]string
+	groupNames   []string
+	aggregations []compiledRollupAggregation
+	window       time.Duration
+}
+
+//rollup rules per stream id
+var rollupRules map[string][]compiledRollupRule
+
+type RollupMessage struct {
+	Action      string        `json:"action"`
+	StreamId    string        `json:"stream_id"`
+	RuleId      int64         `json:"rule_id"`
+	WindowStart int64         `json:"window_start"` //unix seconds
+	Group       []interface{} `json:"group,omitempty"`
+	Values      []interface{} `json:"values,omitempty"` //one per aggregation
+}
+
+type RollupStateValue struct {
+	Windows map[int64]*RollupWindow `json:"windows"`
+}
+
+type RollupWindow struct {
+	Groups map[string]*RollupGroup `json:"groups"` //by JSON of the group values
+}
+
+type RollupGroup struct {
+	Values     []interface{}     `json:"values"`
+	Aggregates []RollupAggregate `json:"aggregates"`
+}
+
+type RollupAggregate struct {
+	Count  int64       `json:"count,omitempty"`
+	Sum    float64     `json:"sum,omitempty"`
+	Min    interface{} `json:"min,omitempty"`
+	Max    interface{} `json:"max,omitempty"`
+	Sketch []byte      `json:"sketch,omitempty"`
+}
+
+//column name of a field path - a `type` column would be taken for the message type of the row, so it is written as `event_type`
+func getRollupColumnName(path []string) string {
+
+	name := strings.Join(path, "_")
+	if name == "type" {
+		return "event_type"
+	}
+	return name
+}
+
+//check and compile a rollup rule
+func compileRollupRule(rule RollupRule) (compiledRollupRule, error) {
+
+	compiled := compiledRollupRule{
+		id:          rule.RollupRuleId,
+		name:        rule.RollupName,
+		messageType: rule.MessageType.String,
+		window:      time.Duration(rule.WindowSeconds) * time.Second,
+	}
+
+	if compiled.name == "" {
+		return compiled, errors.New("rollup name is required")
+	}
+	if compiled.window <= 0 {
+		return compiled, errors.New("window has to be positive")
+	}
+
+	if rule.GroupBy.String != "" {
+		var fields []string
+		if err := json.Unmarshal([]byte(rule.GroupBy.String), &fields); err != nil {
+			return compiled, errors.New("group by has to be a JSON array of fields: " + err.Error())
+		}
+		for _, field := range fields {
+			if path := parsePayloadPath(field); path != nil {
+				compiled.groupBy = append(compiled.groupBy, path)
+				compiled.groupNames = append(compiled.groupNames, getRollupColumnName(path))
+			}
+		}
+	}
+
+	var aggregations []RollupAggregation
+	if err := json.Unmarshal([]byte(rule.Aggregations), &aggregations); err != nil {
+		return compiled, errors.New("aggregations have to be a JSON array: " + err.Error())
+	}
+	if len(aggregations) == 0 {
+		return compiled, errors.New("at least one aggregation is required")
+	}
+
+	for i, aggregation := range aggregations {
+
+		compiledAggregation := compiledRollupAggregation{function: aggregation.Function, path: parsePayloadPath(aggregation.Field), name: aggregation.As}
+		aggregationName := "aggregation " + strconv.Itoa(i+1) + " (" + aggregation.Function + ")"
+
+		switch aggregation.Function {
+		case rollupFunctionCount:
+		case rollupFunctionSum, rollupFunctionMin, rollupFunctionMax, rollupFunctionDistinctApprox:
+			if compiledAggregation.path == nil {
+				return compiled, errors.New(aggregationName + ": `field` is required")
+			}
+		default:
+			return compiled, errors.New(aggregationName + ": unsupported function")
+		}
+
+		if compiledAggregation.name == "" { //count, sum_amount, distinct_approx_userId
+			compiledAggregation.name = aggregation.Function
+			if compiledAggregation.path != nil {
+				compiledAggregation.name += "_" + strings.Join(compiledAggregation.path, "_")
+			}
+		}
+
+		compiled.aggregations = append(compiled.aggregations, compiledAggregation)
+	}
+
+	return compiled, nil
+}
+
+//load the rollup rules of every stream, rules that do not compile are left out
+func loadRollupRules(db *sqlx.DB) (map[string][]compiledRollupRule, error) {
+
+	var rules []RollupRule
+	err := db.Select(&rules, "SELECT * FROM rollup_rules ORDER BY stream_id, rollup_rule_id")
+	if err != nil {
+		return nil, err
+	}
+
+	compiledRules := map[string][]compiledRollupRule{}
+
+	for _, rule := range rules {
+		compiled, err := compileRollupRule(rule)
+		if err != nil {
+			log.Println("Invalid rollup rule", rule.RollupRuleId, "of stream", rule.StreamId, err)
+			continue
+		}
+		compiledRules[rule.StreamId] = append(compiledRules[rule.StreamId], compiled)
+	}
+
+	return compiledRules, nil
+}
+
+func findRollupRule(streamId string, ruleId int64) (compiledRollupRule, bool) {
+
+	for _, rule := range rollupRules[streamId] {
+		if rule.id == ruleId {
+			return rule, true
+		}
+	}
+	return compiledRollupRule{}, false
+}
+
+//hand an ingested event over to the rollups of its stream, with only the values they aggregate
+func sendRollupEvents(ctx statefun.Context, request IncomingMessage, configRecord Config) {
+
+	streamId := configRecord.StreamId.String
+	messageType := getMessageType(request, configRecord)
+	eventTime := getEventTime(request)
+
+	for _, rule := range rollupRules[streamId] {
+
+		if rule.messageType != "" && rule.messageType != messageType {
+			continue
+		}
+
+		windowSeconds := int64(rule.window / time.Second)
+		message := RollupMessage{
+			Action:      rollupActionEvent,
+			StreamId:    streamId,
+			RuleId:      rule.id,
+			WindowStart: eventTime.Unix() - ((eventTime.Unix()%windowSeconds)+windowSeconds)%windowSeconds,
+		}
+
+		for _, path := range rule.groupBy {
+			message.Group = append(message.Group, getPayloadValue(request.Payload, path))
+		}
+		for _, aggregation := range rule.aggregations {
+			var value interface{} = true //counted events
+			if aggregation.path != nil {
+				value = getPayloadValue(request.Payload, aggregation.path)
+			}
+			message.Values = append(message.Values, value)
+		}
+
+		ctx.Send(statefun.MessageBuilder{
+			Target:    statefun.Address{FunctionType: RollupTypeName, Id: streamId + "/" + strconv.FormatInt(rule.id, 10)},
+			Value:     message,
+			ValueType: RollupMessageType,
+		})
+	}
+}
+
+//add a value to a HyperLogLog sketch
+func addToSketch(sketch []byte, value interface{}) []byte {
+
+	if sketch == nil {
+		sketch = make([]byte, 1<<rollupSketchPrecision)
+	}
+
+	key, _ := json.Marshal(value)
+	hash := sha256.Sum256(key)
+	hashValue := binary.BigEndian.Uint64(hash[:8])
+
+	register := hashValue >> (64 - rollupSketchPrecision)
+	rank := byte(bits.LeadingZeros64(hashValue<<rollupSketchPrecision|1<<(rollupSketchPrecision-1)) + 1)
+	if rank > sketch[register] {
+		sketch[register] = rank
+	}
+	return sketch
+}
+
+//distinct values estimated by a HyperLogLog sketch
+func estimateSketch(sketch []byte) int64 {
+
+	if len(sketch) == 0 {
+		return 0
+	}
+
+	registers := float64(len(sketch))
+	sum := 0.0
+	zeros := 0
+	for _, rank := range sketch {
+		sum += math.Pow(2, -float64(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+
+	estimate := 0.7213 / (1 + 1.079/registers) * registers * registers / sum
+	if estimate <= 2.5*registers && zeros > 0 { //small cardinalities are counted on the empty registers
+		estimate = registers * math.Log(registers/float64(zeros))
+	}
+	return int64(math.Round(estimate))
+}
+
+//fold the values of an event into the aggregates of its group
+func updateRollupGroup(group *RollupGroup, rule compiledRollupRule, values []interface{}) {
+
+	for i, aggregation := range rule.aggregations {
+
+		if i >= len(values) || values[i] == nil {
+			continue
+		}
+		value := values[i]
+		aggregate := &group.Aggregates[i]
+
+		switch aggregation.function {
+		case rollupFunctionCount:
+			aggregate.Count++
+		case rollupFunctionSum:
+			if number, ok := toNumber(value); ok {
+				aggregate.Sum += number
+				aggregate.Count++
+			}
+		case rollupFunctionMin:
+			if aggregate.Min == nil || (describeValue(value) == describeValue(aggregate.Min) && compareExpressionValues(value, aggregate.Min) < 0) {
+				aggregate.Min = value
+			}
+		case rollupFunctionMax:
+			if aggregate.Max == nil || (describeValue(value) == describeValue(aggregate.Max) && compareExpressionValues(value, aggregate.Max) > 0) {
+				aggregate.Max = value
+			}
+		case rollupFunctionDistinctApprox:
+			aggregate.Sketch = addToSketch(aggregate.Sketch, value)
+		}
+	}
+}
+
+//rows of a finished window as written to the rollup dataset
+func generateRollupRecords(rule compiledRollupRule, windowStart int64, window *RollupWindow) []map[string]interface{} {
+
+	start := time.Unix(windowStart, 0).UTC()
+	var records []map[string]interface{}
+
+	for _, group := range window.Groups {
+
+		record := map[string]interface{}{
+			"window_start": start.Format(time.RFC3339),
+			"window_end":   start.Add(rule.window).Format(time.RFC3339),
+		}
+
+		for i, name := range rule.groupNames {
+			if i < len(group.Values) && group.Values[i] != nil { //nulls are left out, as they are in the schema
+				record[name] = group.Values[i]
+			}
+		}
+
+		for i, aggregation := range rule.aggregations {
+			aggregate := group.Aggregates[i]
+			switch aggregation.function {
+			case rollupFunctionCount:
+				record[aggregation.name] = aggregate.Count
+			case rollupFunctionSum:
+				record[aggregation.name] = aggregate.Sum
+			case rollupFunctionMin:
+				record[aggregation.name] = aggregate.Min
+			case rollupFunctionMax:
+				record[aggregation.name] = aggregate.Max
+			case rollupFunctionDistinctApprox:
+				record[aggregation.name] = estimateSketch(aggregate.Sketch)
+			}
+		}
+
+		records = append(records, record)
+	}
+
+	return records
+}
+
+//write the rows of a finished window as one file through the sinks of its stream, the dataset is registered in Dremio as files are written
+func writeRollupWindow(ctx statefun.Context, streamId string, rule compiledRollupRule, windowStart int64, window *RollupWindow) error {
+
+	var requests []IncomingMessage
+	for _, record := range generateRollupRecords(rule, windowStart, window) {
+		requests = append(requests, IncomingMessage{StreamId: streamId, MessageType: rule.name, Payload: record})
+	}
+
+	committedFile, err := WriteMessages(requests)
+	if err != nil {
+		return err
+	}
+	recordCommittedFile(ctx, committedFile)
+
+	return nil
+}
+
+//rollup stateful function
+func Rollup(ctx statefun.Context, message statefun.Message) error {
+
+	var request RollupMessage
+	if err := message.As(RollupMessageType, &request); err != nil {
+		return err
+	}
+
+	var state RollupStateValue
+	ctx.Storage().Get(RollupState, &state)
+	if state.Windows == nil {
+		state.Windows = map[int64]*RollupWindow{}
+	}
+
+	rule, found := findRollupRule(request.StreamId, request.RuleId)
+	if !found { //rule deleted, its open windows go with it
+		ctx.Storage().Remove(RollupState)
+		return nil
+	}
+
+	flushAt := time.Unix(request.WindowStart, 0).Add(rule.window).Add(rollupAllowedLateness)
+
+	switch request.Action {
+
+	case rollupActionEvent:
+		if !time.Now().Before(flushAt) { //the window has been written already, or is kept until its write succeeds
+			log.Println("Late event dropped by rollup", rule.name, "of stream", request.StreamId)
+			return nil
+		}
+
+		window, open := state.Windows[request.WindowStart]
+		if !open {
+			window = &RollupWindow{Groups: map[string]*RollupGroup{}}
+			state.Windows[request.WindowStart] = window
+			sendRollupFlush(ctx, request, time.Until(flushAt))
+		}
+
+		groupKey, _ := json.Marshal(request.Group)
+		group, exists := window.Groups[string(groupKey)]
+		if !exists {
+			group = &RollupGroup{Values: request.Group, Aggregates: make([]RollupAggregate, len(rule.aggregations))}
+			window.Groups[string(groupKey)] = group
+		}
+		updateRollupGroup(group, rule, request.Values)
+
+	case rollupActionFlush:
+		window, open := state.Windows[request.WindowStart]
+		if !open {
+			return nil
+		}
+		if err := writeRollupWindow(ctx, request.StreamId, rule, request.WindowStart, window); err != nil {
+			log.Println("Error writing rollup", rule.name, "of stream", request.StreamId, err)
+			sendRollupFlush(ctx, request, rollupRetryInterval)
+			break
+		}
+		delete(state.Windows, request.WindowStart)
+
+		if len(state.Windows) == 0 {
+			ctx.Storage().Remove(RollupState)
+			return nil
+		}
+	}
+
+	ctx.Storage().Set(RollupState, state)
+
+	return nil
+}
+
+//schedule the flush of a window on the calling rollup instance
+func sendRollupFlush(ctx statefun.Context, request RollupMessage, delay time.Duration) {
+
+	ctx.SendAfter(delay, statefun.MessageBuilder{
+		Target:    ctx.Self(),
+		Value:     RollupMessage{Action: rollupActionFlush, StreamId: request.StreamId, RuleId: request.RuleId, WindowStart: request.WindowStart},
+		ValueType: RollupMessageType,
+	})
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/apache/flink-statefun/statefun-sdk-go/v3/pkg/statefun"
+)
+
+func TestEstimateSketch(t *testing.T) {
+
+	if got := estimateSketch(nil); got != 0 {
+		t.Errorf("estimate of an empty sketch = %d, want 0", got)
+	}
+
+	tests := []struct {
+		distinct  int
+		tolerance float64 //relative, the standard error of 1024 registers is about 3%
+	}{
+		{1, 0},
+		{10, 0},
+		{100, 0.02},
+		{1000, 0.065},
+		{10000, 0.065},
+		{100000, 0.065},
+	}
+
+	for _, test := range tests {
+
+		var sketch []byte
+		for i := 0; i < test.distinct; i++ {
+			sketch = addToSketch(sketch, "user-"+strconv.Itoa(i))
+		}
+		estimate := estimateSketch(sketch)
+
+		if math.Abs(float64(estimate)-float64(test.distinct)) > test.tolerance*float64(test.distinct) {
+			t.Errorf("estimate of %d distinct values = %d", test.distinct, estimate)
+		}
+
+		//values seen before change nothing
+		for i := 0; i < test.distinct; i++ {
+			sketch = addToSketch(sketch, "user-"+strconv.Itoa(i))
+		}
+		if again := estimateSketch(sketch); again != estimate {
+			t.Errorf("estimate of %d distinct values went from %d to %d on repeated values", test.distinct, estimate, again)
+		}
+	}
+
+	//values of different types are different values
+	sketch := addToSketch(addToSketch(nil, "1"), 1.0)
+	if got := estimateSketch(sketch); got != 2 {
+		t.Errorf("estimate of \"1\" and 1 = %d, want 2", got)
+	}
+}
+
+func TestRollup(t *testing.T) {
+
+	defer func(streams []Config) { configs = streams }(configs)
+	defer func(rules map[string][]compiledRollupRule) { rollupRules = rules }(rollupRules)
+	defer useLocalStore(t)()
+
+	rule, err := compileRollupRule(RollupRule{
+		RollupRuleId:  1,
+		StreamId:      "stream",
+		RollupName:    "plans",
+		GroupBy:       sql.NullString{String: `["plan"]`, Valid: true},
+		Aggregations:  `[{"function": "count"}, {"function": "distinct_approx", "field": "userId", "as": "users"}]`,
+		WindowSeconds: 3600,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs = []Config{newLocalTableConfig("stream")}
+	rollupRules = map[string][]compiledRollupRule{"stream": {rule}}
+
+	ctx := newTestContext(statefun.Address{FunctionType: RollupTypeName, Id: "stream/1"}, nil)
+	windowStart := time.Now().Unix() - time.Now().Unix()%3600
+
+	send := func(message RollupMessage) {
+		message.StreamId, message.RuleId = "stream", 1
+		if err := Rollup(ctx, newTestMessage(t, message, RollupMessageType)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	windows := func() int {
+		var state RollupStateValue
+		ctx.storage.Get(RollupState, &state)
+		return len(state.Windows)
+	}
+
+	for _, event := range []struct {
+		plan interface{}
+		user string
+	}{
+		{"pro", "u-1"}, {"pro", "u-2"}, {"pro", "u-1"}, {"free", "u-3"}, {nil, "u-4"},
+	} {
+		send(RollupMessage{Action: rollupActionEvent, WindowStart: windowStart, Group: []interface{}{event.plan}, Values: []interface{}{true, event.user}})
+	}
+	if len(ctx.delayed) != 1 || windows() != 1 {
+		t.Fatalf("%d flushes scheduled for %d windows, want one window flushed once", len(ctx.delayed), windows())
+	}
+
+	//a failed write keeps the window and tries again
+	if err := ioutil.WriteFile("datastore", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx.reset()
+	send(RollupMessage{Action: rollupActionFlush, WindowStart: windowStart})
+	if len(ctx.sent) != 0 || windows() != 1 {
+		t.Fatalf("failed write: %d messages sent and %d windows, want the window kept", len(ctx.sent), windows())
+	}
+	if len(ctx.delayed) != 1 || ctx.delayed[0].delay != rollupRetryInterval {
+		t.Fatalf("failed write: flushes %v, want one after %v", ctx.delayed, rollupRetryInterval)
+	}
+
+	//the rows of the window go into one file
+	if err := os.Remove("datastore"); err != nil {
+		t.Fatal(err)
+	}
+	ctx.reset()
+	send(RollupMessage{Action: rollupActionFlush, WindowStart: windowStart})
+
+	files := readCommittedFiles(t, ctx)
+	if len(files) != 1 || files[0].Rows != 3 || files[0].MessageType != "plans" {
+		t.Fatalf("committed %+v, want one file with a row per group", files)
+	}
+	if rows := readLocalParquetRows(t, files[0]); rows != 3 {
+		t.Errorf("%d rows in the file, want 3", rows)
+	}
+	if len(ctx.storage) != 0 {
+		t.Errorf("written window kept")
+	}
+
+	//events of a window past its lateness are dropped, also while the window waits for its write
+	ctx.reset()
+	pastStart := windowStart - 2*3600
+	ctx.storage.Set(RollupState, RollupStateValue{Windows: map[int64]*RollupWindow{pastStart: {Groups: map[string]*RollupGroup{}}}})
+	send(RollupMessage{Action: rollupActionEvent, WindowStart: pastStart, Group: []interface{}{"pro"}, Values: []interface{}{true, "u-5"}})
+
+	var state RollupStateValue
+	ctx.storage.Get(RollupState, &state)
+	if len(state.Windows[pastStart].Groups) != 0 || len(ctx.delayed) != 0 {
+		t.Errorf("late event added to a window waiting for its write")
+	}
+}
@@ -0,0 +1,240 @@
+//S3-compatible object storage support (MinIO, Ceph, Cloudflare R2 and similar)
+//custom endpoints, path-style addressing, TLS options and signature versions are driven by the stream configuration
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+//region used for custom endpoints when none has been configured - most S3-compatible stores ignore it
+const s3CompatDefaultRegion = "us-east-1"
+
+//sub-resources that take part in the v2 canonicalized resource
+var s3V2SubResources = map[string]bool{
+	"acl": true, "delete": true, "lifecycle": true, "location": true, "logging": true, "notification": true,
+	"partNumber": true, "policy": true, "requestPayment": true, "tagging": true, "torrent": true, "uploadId": true,
+	"uploads": true, "versionId": true, "versioning": true, "versions": true, "website": true,
+	"response-cache-control": true, "response-content-disposition": true, "response-content-encoding": true,
+	"response-content-language": true, "response-content-type": true, "response-expires": true,
+}
+
+//true when the stream points at an S3-compatible store instead of AWS proper
+func isS3Compatible(configRecord Config) bool {
+
+	return strings.TrimSpace(configRecord.EndpointUrl.String) != ""
+}
+
+//build the HTTP client honouring the TLS verification options of the stream
+func newTLSHttpClient(configRecord Config) (*http.Client, error) {
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: configRecord.TLSSkipVerify.Bool}
+
+	if configRecord.TLSCACert.String != "" { //custom CA bundle, e.g. for a self-signed MinIO certificate
+
+		certPool, err := x509.SystemCertPool()
+		if err != nil || certPool == nil {
+			certPool = x509.NewCertPool()
+		}
+
+		if !certPool.AppendCertsFromPEM([]byte(configRecord.TLSCACert.String)) {
+			return nil, errors.New("unable to parse TLS CA certificate from configuration record")
+		}
+		tlsConfig.RootCAs = certPool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{Transport: transport}, nil
+}
+
+//base AWS configuration (region, endpoint, TLS) for the stream - nil credentials select the SDK default chain
+func newBaseAWSConfig(configRecord Config, awsCredentials *credentials.Credentials) (*aws.Config, error) {
+
+	region := strings.TrimSpace(configRecord.Region.String)
+	endpoint := strings.TrimSpace(configRecord.EndpointUrl.String)
+
+	if region == "" {
+		if endpoint == "" {
+			return nil, errors.New("AWS Region cannot be null or empty")
+		}
+		region = s3CompatDefaultRegion
+	}
+
+	awsConfig := &aws.Config{
+		Region:      aws.String(region),
+		Credentials: awsCredentials,
+	}
+
+	if endpoint != "" {
+
+		awsConfig.Endpoint = aws.String(endpoint)
+		awsConfig.S3ForcePathStyle = aws.Bool(configRecord.S3ForcePathStyle.Bool)
+		awsConfig.DisableSSL = aws.Bool(strings.HasPrefix(endpoint, "http://"))
+
+		httpClient, err := newTLSHttpClient(configRecord)
+		if err != nil {
+			return nil, err
+		}
+		awsConfig.HTTPClient = httpClient
+	}
+
+	return awsConfig, nil
+}
+
+//create AWS session from stream configuration
+func newAWSSession(configRecord Config) (*session.Session, error) {
+
+	awsCredentials, err := getAWSCredentials(configRecord) //depends on the stream's auth mode
+	if err != nil {
+		return nil, err
+	}
+
+	awsConfig, err := newBaseAWSConfig(configRecord, awsCredentials)
+	if err != nil {
+		return nil, err
+	}
+
+	return session.NewSession(awsConfig)
+}
+
+//create S3 client, swapping in the request signer the stream asks for
+func newS3Client(configRecord Config) (*s3.S3, error) {
+
+	awsSession, err := newAWSSession(configRecord)
+	if err != nil {
+		return nil, err
+	}
+
+	client := s3.New(awsSession)
+
+	switch strings.ToLower(strings.TrimSpace(configRecord.S3SignatureVersion.String)) {
+	case "", "v4", "s3v4":
+		//default SDK signer
+	case "v2", "s3v2":
+		if !configRecord.S3ForcePathStyle.Bool {
+			return nil, errors.New("S3 signature version v2 requires path-style addressing")
+		}
+		client.Handlers.Sign.Swap(v4.SignRequestHandler.Name, request.NamedHandler{Name: "rtdl.S3SignV2Handler", Fn: signS3V2})
+	default:
+		return nil, errors.New("unsupported S3 signature version " + configRecord.S3SignatureVersion.String)
+	}
+
+	return client, nil
+}
+
+//legacy AWS signature version 2 for S3 REST requests, still required by older Ceph RGW and MinIO gateways
+func signS3V2(r *request.Request) {
+
+	if r.Config.Credentials == credentials.AnonymousCredentials {
+		return
+	}
+
+	creds, err := r.Config.Credentials.Get()
+	if err != nil {
+		r.Error = err
+		return
+	}
+
+	httpRequest := r.HTTPRequest
+	httpRequest.Header.Del("Authorization")
+	httpRequest.Header.Set("X-Amz-Date", time.Now().UTC().Format(http.TimeFormat))
+	if creds.SessionToken != "" {
+		httpRequest.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	//canonicalized x-amz-* headers
+	var amzHeaders []string
+	for name, values := range httpRequest.Header {
+		lowerName := strings.ToLower(name)
+		if strings.HasPrefix(lowerName, "x-amz-") {
+			amzHeaders = append(amzHeaders, lowerName+":"+strings.Join(values, ","))
+		}
+	}
+	sort.Strings(amzHeaders)
+
+	//canonicalized resource - path-style only, so the bucket is already part of the path
+	resource := httpRequest.URL.EscapedPath()
+	if resource == "" {
+		resource = "/"
+	}
+
+	var subResources []string
+	for key, values := range httpRequest.URL.Query() {
+		if !s3V2SubResources[key] {
+			continue
+		}
+		if len(values) == 0 || values[0] == "" {
+			subResources = append(subResources, key)
+		} else {
+			subResources = append(subResources, key+"="+values[0])
+		}
+	}
+	sort.Strings(subResources)
+	if len(subResources) > 0 {
+		resource += "?" + strings.Join(subResources, "&")
+	}
+
+	stringToSign := httpRequest.Method + "\n" +
+		httpRequest.Header.Get("Content-MD5") + "\n" +
+		httpRequest.Header.Get("Content-Type") + "\n" +
+		"\n" //date is carried by X-Amz-Date
+	for _, amzHeader := range amzHeaders {
+		stringToSign += amzHeader + "\n"
+	}
+	stringToSign += resource
+
+	mac := hmac.New(sha1.New, []byte(creds.SecretAccessKey))
+	mac.Write([]byte(stringToSign))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	httpRequest.Header.Set("Authorization", "AWS "+creds.AccessKeyID+":"+signature)
+}
+
+//Dremio source properties for an S3-compatible endpoint
+func dremioS3CompatProperties(configRecord Config) string {
+
+	endpoint := strings.TrimSpace(configRecord.EndpointUrl.String)
+	secure := !strings.HasPrefix(endpoint, "http://")
+
+	//Dremio expects host[:port] without the scheme
+	host := endpoint
+	if parsedUrl, err := url.Parse(endpoint); err == nil && parsedUrl.Host != "" {
+		host = parsedUrl.Host
+	}
+
+	properties := `, "compatibilityMode": true`
+	if secure {
+		properties += `, "secure": true`
+	} else {
+		properties += `, "secure": false`
+	}
+	properties += `, "propertyList": [{"name": "fs.s3a.endpoint", "value": "` + host + `"}`
+	if configRecord.S3ForcePathStyle.Bool {
+		properties += `, {"name": "fs.s3a.path.style.access", "value": "true"}`
+	}
+	if !secure {
+		properties += `, {"name": "fs.s3a.connection.ssl.enabled", "value": "false"}`
+	}
+	properties += `]`
+
+	return properties
+}
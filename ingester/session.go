@@ -0,0 +1,368 @@
+//sessionization: events of streams with `sessionization_enabled` are grouped into sessions per user (Segment `userId`, else `anonymousId`)
+//one `com.rtdl.sf/session` function instance per stream and user, a session closes after `session_timeout_seconds` without events
+//closed sessions are handed to the `com.rtdl.sf/sessionwriter` instance of the stream, which writes them in batches
+//like events, as the `<message_type>_sessions` dataset of the stream - a batch stays buffered until its write succeeds
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/apache/flink-statefun/statefun-sdk-go/v3/pkg/statefun"
+)
+
+//session function actions
+const (
+	sessionActionEvent   = "event"   //an event of the user has been ingested
+	sessionActionTimeout = "timeout" //inactivity timer of the session fired
+	sessionActionClosed  = "closed"  //a session is over, sent to the session writer of its stream
+	sessionActionFlush   = "flush"   //the buffered sessions of a stream are due to be written
+)
+
+//inactivity timeout of streams that set none
+const defaultSessionTimeout = 30 * time.Minute
+
+//closed sessions written per file, and how long they wait for more (or for the next try after a failed write)
+var (
+	sessionBatchSize     = getEnvInt("SESSION_BATCH_SIZE", 1000)
+	sessionFlushInterval = getEnvDuration("SESSION_FLUSH_INTERVAL", time.Minute)
+)
+
+//suffix of the message type session records are written under
+const sessionMessageTypeSuffix = "_sessions"
+
+var (
+	SessionTypeName    = statefun.TypeNameFrom("com.rtdl.sf/session")
+	SessionMessageType = statefun.MakeJsonType(statefun.TypeNameFrom("com.rtdl.sf/SessionMessage"))
+	SessionStateType   = statefun.MakeJsonType(statefun.TypeNameFrom("com.rtdl.sf/SessionState"))
+
+	SessionWriterTypeName  = statefun.TypeNameFrom("com.rtdl.sf/sessionwriter")
+	SessionWriterStateType = statefun.MakeJsonType(statefun.TypeNameFrom("com.rtdl.sf/SessionWriterState"))
+)
+
+//open session of a user, dropped a day after the last invocation should a timer ever get lost
+var SessionState = statefun.ValueSpec{
+	Name:       "session",
+	ValueType:  SessionStateType,
+	Expiration: statefun.ExpireAfterCall(24 * time.Hour),
+}
+
+//an ingested event, as far as sessions are concerned
+type SessionEvent struct {
+	StreamId    string    `json:"stream_id"`
+	UserId      string    `json:"user_id,omitempty"`
+	AnonymousId string    `json:"anonymous_id,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+	Page        string    `json:"page,omitempty"`
+}
+
+//closed sessions of a stream not written yet
+var SessionWriterState = statefun.ValueSpec{
+	Name:      "session_writer",
+	ValueType: SessionWriterStateType,
+}
+
+type SessionMessage struct {
+	Action  string             `json:"action"`
+	Event   *SessionEvent      `json:"event,omitempty"`
+	Session *SessionStateValue `json:"session,omitempty"` //closed session
+}
+
+type SessionStateValue struct {
+	StreamId    string    `json:"stream_id"`
+	UserId      string    `json:"user_id,omitempty"`
+	AnonymousId string    `json:"anonymous_id,omitempty"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"` //time of the latest event
+	EventCount  int64     `json:"event_count"`
+	FirstPage   string    `json:"first_page,omitempty"`
+	LastPage    string    `json:"last_page,omitempty"`
+	LastSeenAt  time.Time `json:"last_seen_at"` //processing time of the latest event, the timeout runs from here
+	TimerDue    time.Time `json:"timer_due"`    //zero when no timer is pending
+}
+
+type SessionWriterStateValue struct {
+	Sessions []SessionStateValue `json:"sessions"`
+	FlushDue time.Time           `json:"flush_due"` //zero when no flush is pending
+}
+
+//true when the events of a stream are sessionized
+func isSessionized(configRecord Config) bool {
+	return configRecord.SessionizationEnabled.Bool
+}
+
+//inactivity timeout of a stream
+func getSessionTimeout(configRecord Config) time.Duration {
+
+	if configRecord.SessionTimeoutSeconds.Int64 <= 0 {
+		return defaultSessionTimeout
+	}
+	return time.Duration(configRecord.SessionTimeoutSeconds.Int64) * time.Second
+}
+
+//first string found at one of the dotted paths
+func getPayloadString(payload map[string]interface{}, paths ...string) string {
+
+	for _, path := range paths {
+		if value, ok := getPayloadValue(payload, strings.Split(path, ".")).(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+//hand an ingested event over to the session function instance of its user, events without user are left out
+func sendSessionEvent(ctx statefun.Context, request IncomingMessage, configRecord Config) {
+
+	event := SessionEvent{
+		StreamId:    configRecord.StreamId.String,
+		UserId:      getPayloadString(request.Payload, "userId"),
+		AnonymousId: getPayloadString(request.Payload, "anonymousId"),
+		Page:        getPayloadString(request.Payload, "context.page.path", "properties.path", "context.page.url", "properties.url"),
+		Timestamp:   getEventTime(request),
+	}
+
+	userKey := "user:" + event.UserId
+	if event.UserId == "" {
+		if event.AnonymousId == "" {
+			return
+		}
+		userKey = "anonymous:" + event.AnonymousId
+	}
+
+	ctx.Send(statefun.MessageBuilder{
+		Target:    statefun.Address{FunctionType: SessionTypeName, Id: event.StreamId + "/" + userKey},
+		Value:     SessionMessage{Action: sessionActionEvent, Event: &event},
+		ValueType: SessionMessageType,
+	})
+}
+
+//session record as written to the sessions dataset
+func generateSessionRecord(session SessionStateValue) map[string]interface{} {
+
+	hash := sha256.Sum256([]byte(session.StreamId + "/" + session.UserId + "/" + session.AnonymousId + "/" + session.Start.Format(time.RFC3339Nano)))
+
+	record := map[string]interface{}{
+		"session_id":       hex.EncodeToString(hash[:16]),
+		"session_start":    session.Start.Format(time.RFC3339Nano),
+		"session_end":      session.End.Format(time.RFC3339Nano),
+		"duration_seconds": session.End.Sub(session.Start).Seconds(),
+		"event_count":      float64(session.EventCount),
+	}
+
+	//empty values are left out, as nulls are in the schema
+	for name, value := range map[string]string{
+		"user_id":      session.UserId,
+		"anonymous_id": session.AnonymousId,
+		"first_page":   session.FirstPage,
+		"last_page":    session.LastPage,
+	} {
+		if value != "" {
+			record[name] = value
+		}
+	}
+
+	return record
+}
+
+//hand a closed session over to the session writer of its stream
+func sendClosedSession(ctx statefun.Context, session SessionStateValue) {
+
+	session.TimerDue = time.Time{}
+
+	ctx.Send(statefun.MessageBuilder{
+		Target:    statefun.Address{FunctionType: SessionWriterTypeName, Id: session.StreamId},
+		Value:     SessionMessage{Action: sessionActionClosed, Session: &session},
+		ValueType: SessionMessageType,
+	})
+}
+
+//write the buffered sessions of a stream as one file through the sinks of the stream
+//the sessions are only dropped from the state once written, or when the stream is gone
+func writeSessions(ctx statefun.Context, state *SessionWriterStateValue) error {
+
+	streamId := ctx.Self().Id
+
+	configRecord, found := findStreamConfig(streamId)
+	if !found {
+		state.Sessions = nil
+		return nil
+	}
+
+	messageType := getMessageType(IncomingMessage{}, configRecord) + sessionMessageTypeSuffix
+
+	requests := make([]IncomingMessage, len(state.Sessions))
+	for i, session := range state.Sessions {
+		requests[i] = IncomingMessage{StreamId: streamId, MessageType: messageType, Payload: generateSessionRecord(session)}
+	}
+
+	committedFile, err := WriteMessages(requests)
+	if err != nil {
+		return err
+	}
+	recordCommittedFile(ctx, committedFile)
+
+	state.Sessions = nil
+	return nil
+}
+
+//session stateful function
+func Session(ctx statefun.Context, message statefun.Message) error {
+
+	var request SessionMessage
+	if err := message.As(SessionMessageType, &request); err != nil {
+		return err
+	}
+
+	var state SessionStateValue
+	ctx.Storage().Get(SessionState, &state)
+
+	configRecord, _ := findStreamConfig(state.StreamId)
+	now := time.Now().UTC()
+
+	switch request.Action {
+
+	case sessionActionEvent:
+		event := request.Event
+		if event == nil {
+			return nil
+		}
+
+		configRecord, _ = findStreamConfig(event.StreamId)
+		timeout := getSessionTimeout(configRecord)
+
+		if state.EventCount > 0 && event.Timestamp.Sub(state.End) > timeout { //gap in event time, the open session is over
+			sendClosedSession(ctx, state)
+			state = SessionStateValue{TimerDue: state.TimerDue}
+		}
+
+		if state.EventCount == 0 {
+			state.StreamId = event.StreamId
+			state.UserId = event.UserId
+			state.AnonymousId = event.AnonymousId
+			state.Start = event.Timestamp
+			state.End = event.Timestamp
+		}
+
+		if event.Timestamp.Before(state.Start) { //late event
+			state.Start = event.Timestamp
+			if event.Page != "" {
+				state.FirstPage = event.Page
+			}
+		}
+		if !event.Timestamp.Before(state.End) {
+			state.End = event.Timestamp
+			if event.Page != "" {
+				state.LastPage = event.Page
+			}
+		}
+		if state.FirstPage == "" {
+			state.FirstPage = event.Page
+		}
+		if state.LastPage == "" {
+			state.LastPage = event.Page
+		}
+		if state.UserId == "" { //anonymous user identified later in the session
+			state.UserId = event.UserId
+		}
+
+		state.EventCount++
+		state.LastSeenAt = now
+
+		if state.TimerDue.IsZero() { //one timer per session, moved on when it fires early
+			state.TimerDue = now.Add(timeout)
+			sendSessionTimeout(ctx, timeout)
+		}
+
+	case sessionActionTimeout:
+		state.TimerDue = time.Time{}
+
+		if state.EventCount == 0 {
+			ctx.Storage().Remove(SessionState)
+			return nil
+		}
+
+		deadline := state.LastSeenAt.Add(getSessionTimeout(configRecord))
+		if now.Before(deadline) { //events arrived since the timer was set
+			state.TimerDue = deadline
+			sendSessionTimeout(ctx, deadline.Sub(now))
+			break
+		}
+
+		sendClosedSession(ctx, state)
+		ctx.Storage().Remove(SessionState)
+		return nil
+	}
+
+	ctx.Storage().Set(SessionState, state)
+
+	return nil
+}
+
+//schedule the inactivity timer of the calling session instance
+func sendSessionTimeout(ctx statefun.Context, delay time.Duration) {
+
+	ctx.SendAfter(delay, statefun.MessageBuilder{
+		Target:    ctx.Self(),
+		Value:     SessionMessage{Action: sessionActionTimeout},
+		ValueType: SessionMessageType,
+	})
+}
+
+//session writer stateful function, buffers the closed sessions of a stream and writes them in batches
+func SessionWriter(ctx statefun.Context, message statefun.Message) error {
+
+	var request SessionMessage
+	if err := message.As(SessionMessageType, &request); err != nil {
+		return err
+	}
+
+	var state SessionWriterStateValue
+	ctx.Storage().Get(SessionWriterState, &state)
+
+	switch request.Action {
+
+	case sessionActionClosed:
+		if request.Session == nil {
+			return nil
+		}
+		state.Sessions = append(state.Sessions, *request.Session)
+
+		if len(state.Sessions) >= sessionBatchSize {
+			if err := writeSessions(ctx, &state); err != nil {
+				log.Println("Error writing sessions of stream", ctx.Self().Id, err)
+			}
+		}
+
+	case sessionActionFlush:
+		state.FlushDue = time.Time{}
+
+		if len(state.Sessions) > 0 {
+			if err := writeSessions(ctx, &state); err != nil {
+				log.Println("Error writing sessions of stream", ctx.Self().Id, err)
+			}
+		}
+	}
+
+	if len(state.Sessions) > 0 && state.FlushDue.IsZero() { //written once the interval is over, retried as often should writes fail
+		state.FlushDue = time.Now().UTC().Add(sessionFlushInterval)
+		ctx.SendAfter(sessionFlushInterval, statefun.MessageBuilder{
+			Target:    ctx.Self(),
+			Value:     SessionMessage{Action: sessionActionFlush},
+			ValueType: SessionMessageType,
+		})
+	}
+
+	if len(state.Sessions) == 0 && state.FlushDue.IsZero() {
+		ctx.Storage().Remove(SessionWriterState)
+		return nil
+	}
+
+	ctx.Storage().Set(SessionWriterState, state)
+
+	return nil
+}
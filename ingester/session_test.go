@@ -0,0 +1,224 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/apache/flink-statefun/statefun-sdk-go/v3/pkg/statefun"
+)
+
+//stream writing Parquet files to the local store, as a Delta table so no Dremio is needed
+func newLocalTableConfig(streamId string) Config {
+
+	return Config{
+		StreamId:        sql.NullString{String: streamId, Valid: true},
+		MessageType:     sql.NullString{String: "track", Valid: true},
+		FileStoreTypeId: sql.NullInt64{Int64: 1, Valid: true},
+		TableFormat:     sql.NullString{String: tableFormatDelta, Valid: true},
+	}
+}
+
+//run in an empty working directory with the local store type loaded, the local store writes under ./datastore
+//returns the function restoring both
+func useLocalStore(t *testing.T) func() {
+
+	t.Helper()
+
+	workingDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	storeTypes := fileStoreTypes
+	fileStoreTypes = []FileStoreType{{FileStoreTypeId: 1, FileStoreTypeName: "Local"}}
+
+	return func() {
+		fileStoreTypes = storeTypes
+		os.Chdir(workingDir)
+	}
+}
+
+//files committed through a test context, as the partition functions receive them
+func readCommittedFiles(t *testing.T, ctx *testContext) []CommittedFile {
+
+	t.Helper()
+
+	var files []CommittedFile
+	for _, sent := range ctx.sent {
+		if sent.Target.FunctionType != PartitionTypeName {
+			continue
+		}
+		var message PartitionMessage
+		readTestMessage(t, sent, &message)
+		files = append(files, *message.File)
+	}
+	return files
+}
+
+//rows of a Parquet file written to the local store
+func readLocalParquetRows(t *testing.T, committedFile CommittedFile) int {
+
+	t.Helper()
+
+	data, err := ioutil.ReadFile("datastore/" + committedFile.Partition + "/" + committedFile.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, rows, err := readParquetRows([][]byte{data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(rows)
+}
+
+func TestSession(t *testing.T) {
+
+	defer func(streams []Config) { configs = streams }(configs)
+	configs = []Config{newLocalTableConfig("stream")}
+
+	writer := statefun.Address{FunctionType: SessionWriterTypeName, Id: "stream"}
+	ctx := newTestContext(statefun.Address{FunctionType: SessionTypeName, Id: "stream/user:u-1"}, nil)
+	start := time.Date(2022, 4, 15, 5, 20, 0, 0, time.UTC)
+
+	sendEvent := func(offset time.Duration, page string) {
+		event := SessionEvent{StreamId: "stream", UserId: "u-1", Timestamp: start.Add(offset), Page: page}
+		if err := Session(ctx, newTestMessage(t, SessionMessage{Action: sessionActionEvent, Event: &event}, SessionMessageType)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sendEvent(0, "/a")
+	sendEvent(time.Minute, "/b")
+	if len(ctx.delayed) != 1 {
+		t.Fatalf("%d timers set, want one per session", len(ctx.delayed))
+	}
+
+	//a gap in event time closes the session and hands it to the writer of the stream
+	ctx.reset()
+	sendEvent(2*time.Hour, "/c")
+	if len(ctx.sent) != 1 || ctx.sent[0].Target != writer {
+		t.Fatalf("closed session sent to %v, want %v", ctx.sent, writer)
+	}
+	var closed SessionMessage
+	readTestMessage(t, ctx.sent[0], &closed)
+	if closed.Action != sessionActionClosed || closed.Session.EventCount != 2 || closed.Session.FirstPage != "/a" || closed.Session.LastPage != "/b" {
+		t.Errorf("sent %+v, want the closed session of two events", closed.Session)
+	}
+
+	//the timer moves on while events arrive and closes the session once they stop
+	ctx.reset()
+	if err := Session(ctx, newTestMessage(t, SessionMessage{Action: sessionActionTimeout}, SessionMessageType)); err != nil {
+		t.Fatal(err)
+	}
+	if len(ctx.sent) != 0 || len(ctx.delayed) != 1 {
+		t.Fatalf("timer fired early: %d sent and %d timers, want the timer moved on", len(ctx.sent), len(ctx.delayed))
+	}
+
+	var state SessionStateValue
+	ctx.storage.Get(SessionState, &state)
+	state.LastSeenAt = state.LastSeenAt.Add(-time.Hour)
+	ctx.storage.Set(SessionState, state)
+
+	ctx.reset()
+	if err := Session(ctx, newTestMessage(t, SessionMessage{Action: sessionActionTimeout}, SessionMessageType)); err != nil {
+		t.Fatal(err)
+	}
+	if len(ctx.sent) != 1 || ctx.sent[0].Target != writer {
+		t.Fatalf("timed out session sent to %v, want %v", ctx.sent, writer)
+	}
+	readTestMessage(t, ctx.sent[0], &closed)
+	if closed.Session.EventCount != 1 || closed.Session.FirstPage != "/c" || !closed.Session.TimerDue.IsZero() {
+		t.Errorf("sent %+v, want the session of the last event", closed.Session)
+	}
+	if len(ctx.storage) != 0 {
+		t.Errorf("state of a closed session kept")
+	}
+}
+
+func TestSessionWriter(t *testing.T) {
+
+	defer func(streams []Config) { configs = streams }(configs)
+	defer func(batchSize int) { sessionBatchSize = batchSize }(sessionBatchSize)
+	defer useLocalStore(t)()
+
+	configs = []Config{newLocalTableConfig("stream")}
+	sessionBatchSize = 2
+
+	ctx := newTestContext(statefun.Address{FunctionType: SessionWriterTypeName, Id: "stream"}, nil)
+	start := time.Date(2022, 4, 15, 5, 20, 0, 0, time.UTC)
+
+	sendSession := func(session SessionStateValue) {
+		session.StreamId = "stream"
+		session.Start, session.End, session.EventCount = start, start.Add(time.Minute), 3
+		if err := SessionWriter(ctx, newTestMessage(t, SessionMessage{Action: sessionActionClosed, Session: &session}, SessionMessageType)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flush := func() {
+		ctx.reset()
+		if err := SessionWriter(ctx, newTestMessage(t, SessionMessage{Action: sessionActionFlush}, SessionMessageType)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bufferedSessions := func() int {
+		var state SessionWriterStateValue
+		ctx.storage.Get(SessionWriterState, &state)
+		return len(state.Sessions)
+	}
+
+	//the store cannot be written to while datastore is a file
+	if err := ioutil.WriteFile("datastore", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sendSession(SessionStateValue{UserId: "u-1", FirstPage: "/a", LastPage: "/b"})
+	if len(ctx.sent) != 0 || len(ctx.delayed) != 1 || bufferedSessions() != 1 {
+		t.Fatalf("first session: %d sent, %d flushes scheduled, %d buffered, want it buffered until the flush", len(ctx.sent), len(ctx.delayed), bufferedSessions())
+	}
+
+	//a full batch is written at once, a failed write keeps it
+	ctx.reset()
+	sendSession(SessionStateValue{AnonymousId: "a-1"})
+	if len(ctx.sent) != 0 || len(ctx.delayed) != 0 || bufferedSessions() != 2 {
+		t.Fatalf("failed batch: %d sent, %d flushes scheduled, %d buffered, want it kept for the pending flush", len(ctx.sent), len(ctx.delayed), bufferedSessions())
+	}
+
+	flush()
+	if len(ctx.sent) != 0 || len(ctx.delayed) != 1 || bufferedSessions() != 2 {
+		t.Fatalf("failed flush: %d sent, %d flushes scheduled, %d buffered, want it kept and retried", len(ctx.sent), len(ctx.delayed), bufferedSessions())
+	}
+
+	//once the store is back the batch goes into one file, sessions of users and of anonymous ones alike
+	if err := os.Remove("datastore"); err != nil {
+		t.Fatal(err)
+	}
+	flush()
+
+	files := readCommittedFiles(t, ctx)
+	if len(files) != 1 || files[0].Rows != 2 || files[0].MessageType != "track"+sessionMessageTypeSuffix {
+		t.Fatalf("committed %+v, want one file of both sessions", files)
+	}
+	if rows := readLocalParquetRows(t, files[0]); rows != 2 {
+		t.Errorf("%d rows in the file, want 2", rows)
+	}
+
+	var stats struct {
+		NumRecords int64                  `json:"numRecords"`
+		NullCount  map[string]interface{} `json:"nullCount"`
+	}
+	json.Unmarshal([]byte(files[0].Stats), &stats)
+	if stats.NumRecords != 2 || stats.NullCount["user_id"] != 1.0 || stats.NullCount["anonymous_id"] != 1.0 || stats.NullCount["session_id"] != 0.0 {
+		t.Errorf("stats %s, want each id null in one of the rows", files[0].Stats)
+	}
+
+	if len(ctx.storage) != 0 || len(ctx.delayed) != 0 {
+		t.Errorf("written sessions kept")
+	}
+}
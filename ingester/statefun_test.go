@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/apache/flink-statefun/statefun-sdk-go/v3/pkg/statefun"
+)
+
+//in-memory statefun.Context of one function instance, recording what the function sends
+type testContext struct {
+	context.Context
+	self    statefun.Address
+	caller  *statefun.Address
+	storage testStorage
+	sent    []statefun.MessageBuilder
+	delayed []testDelayedMessage
+	egress  []statefun.EgressBuilder
+}
+
+type testDelayedMessage struct {
+	delay   time.Duration
+	message statefun.MessageBuilder
+}
+
+//values kept as JSON, as the runtime keeps them
+type testStorage map[string][]byte
+
+func newTestContext(self statefun.Address, caller *statefun.Address) *testContext {
+
+	return &testContext{Context: context.Background(), self: self, caller: caller, storage: testStorage{}}
+}
+
+func (ctx *testContext) Self() statefun.Address                 { return ctx.self }
+func (ctx *testContext) Caller() *statefun.Address              { return ctx.caller }
+func (ctx *testContext) Storage() statefun.AddressScopedStorage { return ctx.storage }
+
+func (ctx *testContext) Send(message statefun.MessageBuilder) {
+	ctx.sent = append(ctx.sent, message)
+}
+
+func (ctx *testContext) SendAfter(delay time.Duration, message statefun.MessageBuilder) {
+	ctx.delayed = append(ctx.delayed, testDelayedMessage{delay: delay, message: message})
+}
+
+func (ctx *testContext) SendAfterWithCancellationToken(delay time.Duration, token statefun.CancellationToken, message statefun.MessageBuilder) {
+	ctx.SendAfter(delay, message)
+}
+
+func (ctx *testContext) CancelDelayedMessage(token statefun.CancellationToken) {}
+
+func (ctx *testContext) SendEgress(egress statefun.EgressBuilder) {
+	ctx.egress = append(ctx.egress, egress)
+}
+
+//forget what has been sent, the state stays
+func (ctx *testContext) reset() {
+	ctx.sent, ctx.delayed, ctx.egress = nil, nil, nil
+}
+
+func (storage testStorage) Get(spec statefun.ValueSpec, receiver interface{}) bool {
+
+	data, found := storage[spec.Name]
+	if !found {
+		return false
+	}
+	if err := json.Unmarshal(data, receiver); err != nil {
+		panic(err)
+	}
+	return true
+}
+
+func (storage testStorage) Set(spec statefun.ValueSpec, value interface{}) {
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		panic(err)
+	}
+	storage[spec.Name] = data
+}
+
+func (storage testStorage) Remove(spec statefun.ValueSpec) {
+	delete(storage, spec.Name)
+}
+
+//message as the runtime delivers it, the target is not looked at by the functions
+func newTestMessage(t *testing.T, value interface{}, valueType statefun.SimpleType) statefun.Message {
+
+	t.Helper()
+
+	target := statefun.Address{FunctionType: statefun.TypeNameFrom("com.rtdl.sf/test"), Id: "test"}
+	message, err := statefun.MessageBuilder{Target: target, Value: value, ValueType: valueType}.ToMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return message
+}
+
+//value of a sent message, as the receiving function reads it
+func readTestMessage(t *testing.T, builder statefun.MessageBuilder, receiver interface{}) {
+
+	t.Helper()
+
+	message, err := builder.ToMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := message.As(builder.ValueType, receiver); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,269 @@
+//table format output: the Parquet files of a stream are committed into a table (Apache Iceberg or Delta Lake)
+//one `com.rtdl.sf/table` function instance per stream and message type, so commits to a table are never concurrent
+
+package main
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/apache/flink-statefun/statefun-sdk-go/v3/pkg/statefun"
+)
+
+//supported values of `table_format`
+const (
+	tableFormatNone    = "none"    //loose Parquet files promoted as a folder dataset (default)
+	tableFormatIceberg = "iceberg" //Apache Iceberg table, Hadoop catalog layout
+	tableFormatDelta   = "delta"   //Delta Lake table, _delta_log next to the files
+)
+
+//table function actions
+const (
+	tableActionAppend = "append" //a file is waiting to be committed
+	tableActionCommit = "commit" //commit the waiting files as one snapshot
+)
+
+var (
+	TableTypeName    = statefun.TypeNameFrom("com.rtdl.sf/table")
+	TableMessageType = statefun.MakeJsonType(statefun.TypeNameFrom("com.rtdl.sf/TableMessage"))
+	TableStateType   = statefun.MakeJsonType(statefun.TypeNameFrom("com.rtdl.sf/TableState"))
+)
+
+var TableState = statefun.ValueSpec{
+	Name:       "table",
+	ValueType:  TableStateType,
+	Expiration: statefun.ExpireAfterCall(7 * 24 * time.Hour),
+}
+
+//files are batched into one snapshot per interval
+var tableCommitInterval = getEnvDuration("TABLE_COMMIT_INTERVAL", time.Minute)
+
+//how long committed paths are remembered, a redelivered deterministic file is not committed twice
+const tableDedupWindow = 24 * time.Hour
+
+type TableMessage struct {
+	Action string         `json:"action"`
+	File   *CommittedFile `json:"file,omitempty"`
+}
+
+//data file waiting to be committed, path is relative to the store root
+type TableDataFile struct {
+	Path       string `json:"path"`
+	Rows       int64  `json:"rows"`
+	SizeBytes  int64  `json:"size_bytes"`
+	SchemaHash string `json:"schema_hash"`
+	Stats      string `json:"stats,omitempty"` //Delta add action statistics
+}
+
+type TableStateValue struct {
+	StreamId        string               `json:"stream_id"`
+	Table           string               `json:"table"`
+	MessageType     string               `json:"message_type"`
+	Pending         []TableDataFile      `json:"pending"`
+	Schemas         map[string]string    `json:"schemas"` //Parquet schema by hash
+	Committed       map[string]time.Time `json:"committed"`
+	CommitScheduled bool                 `json:"commit_scheduled"`
+}
+
+//normalised table format of a stream
+func getTableFormat(configRecord Config) string {
+
+	tableFormat := strings.ToLower(strings.TrimSpace(configRecord.TableFormat.String))
+	if tableFormat == "" {
+		return tableFormatNone
+	}
+	return tableFormat
+}
+
+//true when files are committed into a table instead of being picked up as a folder
+func usesTableFormat(configRecord Config) bool {
+
+	return getTableFormat(configRecord) != tableFormatNone
+}
+
+//root of the table of a message type relative to the store root: [folder/]<message type>
+func generateTablePath(messageType string, configRecord Config) string {
+
+	if configRecord.FolderName.String != "" {
+		return configRecord.FolderName.String + "/" + messageType
+	}
+	return messageType
+}
+
+//hand a written file over to the function instance of its table
+func sendTableFile(ctx statefun.Context, committedFile *CommittedFile) {
+
+	ctx.Send(statefun.MessageBuilder{
+		Target:    statefun.Address{FunctionType: TableTypeName, Id: committedFile.StreamId + "/" + committedFile.Table},
+		Value:     TableMessage{Action: tableActionAppend, File: committedFile},
+		ValueType: TableMessageType,
+	})
+}
+
+//register the table in Dremio once it has a commit
+func registerTableInDremio(messageType string, storeType string, configRecord Config) error {
+
+	switch storeType {
+	case "Local":
+		return UpdateDremio(messageType, "Local", "", configRecord)
+	case "AWS":
+		return UpdateDremio(messageType, "S3", configRecord.BucketName.String, configRecord)
+	case "GCP":
+		return UpdateDremio(messageType, "GCS", configRecord.BucketName.String, configRecord)
+	case "Azure":
+		return UpdateDremio(messageType, "Azure", configRecord.BucketName.String, configRecord)
+	case "HDFS":
+		err := UpdateDremio(messageType, "HDFS", configRecord.BucketName.String, configRecord)
+		if err != nil {
+			return err
+		}
+		return CreateHDFSDataset(messageType, configRecord)
+	}
+	return nil
+}
+
+//commit the pending files of a table
+func commitTable(state TableStateValue) error {
+
+	configRecord, found := findStreamConfig(state.StreamId)
+	if !found {
+		log.Println("No configuration found for stream", state.StreamId)
+		return nil //stream has been removed, nothing to commit to
+	}
+
+	storeType := getFileStoreTypeName(configRecord)
+
+	store, err := newFileStore(storeType, configRecord)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	switch getTableFormat(configRecord) {
+	case tableFormatIceberg:
+		if storeType != "Local" && storeType != "AWS" && storeType != "HDFS" {
+			return errors.New("Iceberg tables are supported on Local, AWS and HDFS file stores, not " + storeType)
+		}
+		err = commitIcebergTable(store, state.Table, state.Pending, state.Schemas)
+	case tableFormatDelta:
+		err = commitDeltaTable(store, state.Table, state.Pending, state.Schemas)
+	default:
+		log.Println("Stream", state.StreamId, "no longer uses a table format, dropping", len(state.Pending), "files")
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err = registerTableInDremio(state.MessageType, storeType, configRecord); err != nil {
+		log.Println("Error registering table in Dremio", err) //table commit stands, registration is retried with the next commit
+	}
+
+	return nil
+}
+
+//table stateful function
+func Table(ctx statefun.Context, message statefun.Message) error {
+
+	var request TableMessage
+	if err := message.As(TableMessageType, &request); err != nil {
+		return err
+	}
+
+	var state TableStateValue
+	ctx.Storage().Get(TableState, &state)
+
+	switch request.Action {
+
+	case tableActionAppend:
+		committedFile := request.File
+		if committedFile == nil {
+			return nil
+		}
+
+		state.StreamId = committedFile.StreamId
+		state.Table = committedFile.Table
+		state.MessageType = committedFile.MessageType
+
+		dataFile := TableDataFile{
+			Path:       committedFile.Partition + "/" + committedFile.File,
+			Rows:       committedFile.Rows,
+			SizeBytes:  committedFile.SizeBytes,
+			SchemaHash: committedFile.SchemaHash,
+			Stats:      committedFile.Stats,
+		}
+
+		if _, committed := state.Committed[dataFile.Path]; committed { //redelivered file overwrote itself
+			log.Println("File", dataFile.Path, "is already part of table", state.Table)
+			break
+		}
+
+		pending := false
+		for i := range state.Pending {
+			if state.Pending[i].Path == dataFile.Path {
+				state.Pending[i] = dataFile
+				pending = true
+			}
+		}
+		if !pending {
+			state.Pending = append(state.Pending, dataFile)
+		}
+
+		if state.Schemas == nil {
+			state.Schemas = map[string]string{}
+		}
+		state.Schemas[dataFile.SchemaHash] = committedFile.Schema
+
+		if !state.CommitScheduled {
+			ctx.SendAfter(tableCommitInterval, statefun.MessageBuilder{
+				Target:    ctx.Self(),
+				Value:     TableMessage{Action: tableActionCommit},
+				ValueType: TableMessageType,
+			})
+			state.CommitScheduled = true
+		}
+
+	case tableActionCommit:
+		state.CommitScheduled = false
+
+		if len(state.Pending) == 0 {
+			break
+		}
+
+		if err := commitTable(state); err != nil { //keep the files and try again with the next interval
+			log.Println("Error committing", len(state.Pending), "files to table", state.Table, err)
+			ctx.SendAfter(tableCommitInterval, statefun.MessageBuilder{
+				Target:    ctx.Self(),
+				Value:     TableMessage{Action: tableActionCommit},
+				ValueType: TableMessageType,
+			})
+			state.CommitScheduled = true
+			break
+		}
+
+		log.Println("Committed", len(state.Pending), "files to table", state.Table)
+
+		now := time.Now()
+		if state.Committed == nil {
+			state.Committed = map[string]time.Time{}
+		}
+		for path, committedAt := range state.Committed {
+			if now.Sub(committedAt) > tableDedupWindow {
+				delete(state.Committed, path)
+			}
+		}
+		for _, dataFile := range state.Pending {
+			state.Committed[dataFile.Path] = now
+		}
+
+		state.Pending = nil
+		state.Schemas = nil
+	}
+
+	ctx.Storage().Set(TableState, state)
+
+	return nil
+}
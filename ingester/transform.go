@@ -0,0 +1,394 @@
+//declarative transformations: an ordered list of steps per stream (`transformations` on the stream record) reshapes payloads before schema generation
+//steps: rename, cast, project, remove, set (constant) and compute (expression, see expression.go)
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//supported values of a step's `op`
+const (
+	transformOpRename  = "rename"
+	transformOpCast    = "cast"
+	transformOpProject = "project"
+	transformOpRemove  = "remove"
+	transformOpSet     = "set"
+	transformOpCompute = "compute"
+)
+
+//target types of `cast` steps
+const (
+	castTypeString    = "string"
+	castTypeInt       = "int"
+	castTypeFloat     = "float"
+	castTypeBool      = "bool"
+	castTypeTimestamp = "timestamp" //RFC 3339 in UTC, from strings or epoch seconds/milliseconds
+)
+
+//transformation step as stored on the stream record, e.g.
+//{"op": "rename", "from": "usr", "to": "user.id"}, {"op": "cast", "field": "amount", "type": "float"},
+//{"op": "project", "fields": ["user", "amount"]}, {"op": "set", "field": "source", "value": "web"},
+//{"op": "compute", "field": "total", "expression": "amount * quantity"}
+type TransformationStep struct {
+	Op         string      `json:"op"`
+	Field      string      `json:"field,omitempty"`
+	From       string      `json:"from,omitempty"`
+	To         string      `json:"to,omitempty"`
+	Type       string      `json:"type,omitempty"`
+	Fields     []string    `json:"fields,omitempty"`
+	Value      interface{} `json:"value,omitempty"`
+	Expression string      `json:"expression,omitempty"`
+}
+
+//transformation step ready to be applied
+type compiledTransformationStep struct {
+	op         string
+	path       []string
+	toPath     []string
+	castType   string
+	paths      [][]string
+	value      interface{}
+	expression expressionNode
+}
+
+//transformation pipelines per stream id
+var streamTransformations map[string][]compiledTransformationStep
+
+//dotted path of a payload field, a leading "$." is optional
+func parsePayloadPath(path string) []string {
+
+	path = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(path), "$"), ".")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, ".")
+}
+
+//parse and check the `transformations` of a stream
+func compileTransformations(transformations string) ([]compiledTransformationStep, error) {
+
+	if strings.TrimSpace(transformations) == "" {
+		return nil, nil
+	}
+
+	var steps []TransformationStep
+	if err := json.Unmarshal([]byte(transformations), &steps); err != nil {
+		return nil, errors.New("transformations have to be a JSON array of steps: " + err.Error())
+	}
+
+	compiledSteps := make([]compiledTransformationStep, 0, len(steps))
+
+	for i, step := range steps {
+
+		compiled := compiledTransformationStep{op: step.Op, path: parsePayloadPath(step.Field)}
+		stepName := "step " + strconv.Itoa(i+1) + " (" + step.Op + ")"
+
+		switch step.Op {
+
+		case transformOpRename:
+			compiled.path = parsePayloadPath(step.From)
+			compiled.toPath = parsePayloadPath(step.To)
+			if compiled.path == nil || compiled.toPath == nil {
+				return nil, errors.New(stepName + ": `from` and `to` are required")
+			}
+
+		case transformOpCast:
+			switch step.Type {
+			case castTypeString, castTypeInt, castTypeFloat, castTypeBool, castTypeTimestamp:
+			default:
+				return nil, errors.New(stepName + ": `type` has to be one of string, int, float, bool, timestamp")
+			}
+			compiled.castType = step.Type
+
+		case transformOpProject, transformOpRemove:
+			for _, field := range step.Fields {
+				if path := parsePayloadPath(field); path != nil {
+					compiled.paths = append(compiled.paths, path)
+				}
+			}
+			if len(compiled.paths) == 0 {
+				return nil, errors.New(stepName + ": `fields` are required")
+			}
+
+		case transformOpSet:
+			compiled.value = step.Value
+
+		case transformOpCompute:
+			expression, err := parseExpression(step.Expression)
+			if err != nil {
+				return nil, errors.New(stepName + ": " + err.Error())
+			}
+			compiled.expression = expression
+
+		default:
+			return nil, errors.New(stepName + ": unsupported op")
+		}
+
+		if compiled.path == nil && (step.Op == transformOpCast || step.Op == transformOpSet || step.Op == transformOpCompute) {
+			return nil, errors.New(stepName + ": `field` is required")
+		}
+
+		compiledSteps = append(compiledSteps, compiled)
+	}
+
+	return compiledSteps, nil
+}
+
+//compile the transformations of every stream, streams whose pipeline does not compile are written as received
+func loadTransformations(streams []Config) map[string][]compiledTransformationStep {
+
+	pipelines := map[string][]compiledTransformationStep{}
+
+	for _, configRecord := range streams {
+
+		steps, err := compileTransformations(configRecord.Transformations.String)
+		if err != nil {
+			log.Println("Invalid transformations of stream", configRecord.StreamId.String, err)
+			continue
+		}
+		if len(steps) > 0 {
+			pipelines[configRecord.StreamId.String] = steps
+		}
+	}
+
+	return pipelines
+}
+
+//apply the transformations of a stream to a payload, steps that fail on a field leave it as it was
+func transformPayload(payload map[string]interface{}, streamId string) map[string]interface{} {
+
+	transformed, errs := applyTransformations(payload, streamTransformations[streamId])
+	for _, err := range errs {
+		log.Println("Error transforming payload of stream", streamId, err)
+	}
+	return transformed
+}
+
+//apply transformation steps in order, along with the errors of the steps that failed
+func applyTransformations(payload map[string]interface{}, steps []compiledTransformationStep) (map[string]interface{}, []error) {
+
+	var errs []error
+
+	for i, step := range steps {
+
+		stepName := "step " + strconv.Itoa(i+1) + " (" + step.op + ")"
+
+		switch step.op {
+
+		case transformOpRename:
+			if value, found := deletePayloadValue(payload, step.path); found {
+				setPayloadValue(payload, step.toPath, value)
+			}
+
+		case transformOpCast:
+			value := getPayloadValue(payload, step.path)
+			if value == nil {
+				continue
+			}
+			castedValue, err := castValue(value, step.castType)
+			if err != nil {
+				errs = append(errs, errors.New(stepName+": "+err.Error()))
+				continue
+			}
+			setPayloadValue(payload, step.path, castedValue)
+
+		case transformOpProject:
+			projected := map[string]interface{}{}
+			for _, path := range step.paths {
+				if value := getPayloadValue(payload, path); value != nil {
+					setPayloadValue(projected, path, value)
+				}
+			}
+			payload = projected
+
+		case transformOpRemove:
+			for _, path := range step.paths {
+				deletePayloadValue(payload, path)
+			}
+
+		case transformOpSet:
+			setPayloadValue(payload, step.path, step.value)
+
+		case transformOpCompute:
+			value, err := step.expression.eval(payload)
+			if err != nil {
+				errs = append(errs, errors.New(stepName+": "+err.Error()))
+				continue
+			}
+			setPayloadValue(payload, step.path, value)
+		}
+	}
+
+	return payload, errs
+}
+
+//set the value at a dotted path, creating the objects on the way
+func setPayloadValue(record map[string]interface{}, path []string, value interface{}) {
+
+	for _, key := range path[:len(path)-1] {
+		fields, ok := record[key].(map[string]interface{})
+		if !ok {
+			fields = map[string]interface{}{}
+			record[key] = fields
+		}
+		record = fields
+	}
+	record[path[len(path)-1]] = value
+}
+
+//remove the value at a dotted path, returning it
+func deletePayloadValue(record map[string]interface{}, path []string) (interface{}, bool) {
+
+	parent, ok := getPayloadValue(record, path[:len(path)-1]).(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	value, found := parent[path[len(path)-1]]
+	delete(parent, path[len(path)-1])
+	return value, found
+}
+
+//convert a value to a cast type
+func castValue(value interface{}, castType string) (interface{}, error) {
+
+	if value == nil {
+		return nil, nil
+	}
+
+	number, isNumber := toNumber(value)
+	text, isString := value.(string)
+	flag, isBool := value.(bool)
+
+	switch castType {
+
+	case castTypeString:
+		switch {
+		case isString:
+			return text, nil
+		case isBool:
+			return strconv.FormatBool(flag), nil
+		case isNumber:
+			return strconv.FormatFloat(number, 'f', -1, 64), nil
+		}
+		encoded, _ := json.Marshal(value) //objects and arrays as JSON
+		return string(encoded), nil
+
+	case castTypeInt, castTypeFloat:
+		switch {
+		case isString:
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+			if err != nil {
+				return nil, errors.New("cannot cast " + strconv.Quote(text) + " to " + castType)
+			}
+			number = parsed
+		case isBool:
+			number = 0
+			if flag {
+				number = 1
+			}
+		case !isNumber:
+			return nil, errors.New("cannot cast " + describeValue(value) + " to " + castType)
+		}
+		if castType == castTypeInt {
+			return int64(math.Trunc(number)), nil
+		}
+		return number, nil
+
+	case castTypeBool:
+		switch {
+		case isBool:
+			return flag, nil
+		case isNumber:
+			return number != 0, nil
+		case isString:
+			parsed, err := strconv.ParseBool(strings.TrimSpace(text))
+			if err != nil {
+				return nil, errors.New("cannot cast " + strconv.Quote(text) + " to bool")
+			}
+			return parsed, nil
+		}
+
+	case castTypeTimestamp:
+		switch {
+		case isNumber:
+			if math.Abs(number) >= 1e11 { //milliseconds
+				return time.Unix(0, int64(number)*int64(time.Millisecond)).UTC().Format(time.RFC3339Nano), nil
+			}
+			return time.Unix(0, int64(number*float64(time.Second))).UTC().Format(time.RFC3339Nano), nil
+		case isString:
+			for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"} {
+				if parsed, err := time.Parse(layout, strings.TrimSpace(text)); err == nil {
+					return parsed.UTC().Format(time.RFC3339Nano), nil
+				}
+			}
+			return nil, errors.New("cannot cast " + strconv.Quote(text) + " to timestamp")
+		}
+	}
+
+	return nil, errors.New("cannot cast " + describeValue(value) + " to " + castType)
+}
+
+//request of the transformation preview
+type transformationPreview struct {
+	StreamId        string                 `json:"stream_id,omitempty"`
+	Transformations json.RawMessage        `json:"transformations,omitempty"` //steps to try, the stream's own when missing
+	Payload         map[string]interface{} `json:"payload"`
+}
+
+//POST /previewTransformations: output of the enrichment, masking rules and transformations of a stream for a sample event
+//nothing is written, errors of individual steps are returned along with the output
+func previewTransformationsHandler(wrt http.ResponseWriter, req *http.Request) {
+
+	if req.Method != http.MethodPost {
+		http.Error(wrt, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(wrt, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	var preview transformationPreview
+	if err := json.Unmarshal(body, &preview); err != nil || preview.Payload == nil {
+		http.Error(wrt, "`payload` is required", http.StatusUnprocessableEntity)
+		return
+	}
+
+	steps := streamTransformations[preview.StreamId]
+	if len(preview.Transformations) > 0 {
+		steps, err = compileTransformations(string(preview.Transformations))
+		if err != nil {
+			http.Error(wrt, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	if preview.StreamId != "" {
+		if configRecord, found := findStreamConfig(preview.StreamId); found && isEnriched(configRecord) {
+			enrichPayload(preview.Payload, "", "")
+		}
+		maskPayload(preview.Payload, preview.StreamId)
+	}
+
+	output, errs := applyTransformations(preview.Payload, steps)
+
+	errorMessages := []string{}
+	for _, err := range errs {
+		errorMessages = append(errorMessages, err.Error())
+	}
+
+	jsonData, _ := json.MarshalIndent(map[string]interface{}{"payload": output, "errors": errorMessages}, "", "    ")
+	wrt.Header().Set("Content-Type", "application/json")
+	wrt.Write(jsonData)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCastValue(t *testing.T) {
+
+	tests := []struct {
+		value    interface{}
+		castType string
+		want     interface{}
+	}{
+		{"text", castTypeString, "text"},
+		{12.5, castTypeString, "12.5"},
+		{int64(7), castTypeString, "7"},
+		{true, castTypeString, "true"},
+		{map[string]interface{}{"a": 1.0}, castTypeString, `{"a":1}`},
+		{" 42.9 ", castTypeInt, int64(42)},
+		{-3.7, castTypeInt, int64(-3)},
+		{true, castTypeInt, int64(1)},
+		{"1e3", castTypeFloat, 1000.0},
+		{false, castTypeFloat, 0.0},
+		{int64(2), castTypeFloat, 2.0},
+		{"TRUE", castTypeBool, true},
+		{"0", castTypeBool, false},
+		{0.0, castTypeBool, false},
+		{2.0, castTypeBool, true},
+		{1650000000.0, castTypeTimestamp, "2022-04-15T05:20:00Z"},
+		{1650000000500.0, castTypeTimestamp, "2022-04-15T05:20:00.5Z"},
+		{"2022-04-15T07:20:00+02:00", castTypeTimestamp, "2022-04-15T05:20:00Z"},
+		{"2022-04-15 05:20:00", castTypeTimestamp, "2022-04-15T05:20:00Z"},
+		{"2022-04-15", castTypeTimestamp, "2022-04-15T00:00:00Z"},
+		{nil, castTypeInt, nil},
+	}
+
+	for _, test := range tests {
+		got, err := castValue(test.value, test.castType)
+		if err != nil {
+			t.Errorf("castValue(%#v, %s): %v", test.value, test.castType, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("castValue(%#v, %s) = %#v, want %#v", test.value, test.castType, got, test.want)
+		}
+	}
+}
+
+func TestCastValueErrors(t *testing.T) {
+
+	tests := []struct {
+		value    interface{}
+		castType string
+	}{
+		{"abc", castTypeInt},
+		{"", castTypeFloat},
+		{[]interface{}{1.0}, castTypeFloat},
+		{"maybe", castTypeBool},
+		{map[string]interface{}{}, castTypeBool},
+		{"yesterday", castTypeTimestamp},
+		{true, castTypeTimestamp},
+		{"text", "uuid"},
+	}
+
+	for _, test := range tests {
+		if got, err := castValue(test.value, test.castType); err == nil {
+			t.Errorf("castValue(%#v, %s) = %#v, want an error", test.value, test.castType, got)
+		}
+	}
+}
+
+func TestCompileTransformationsErrors(t *testing.T) {
+
+	tests := []struct {
+		transformations string
+		message         string
+	}{
+		{`{"op": "rename"}`, "JSON array of steps"},
+		{`[{"op": "rename", "from": "a"}]`, "step 1 (rename): `from` and `to` are required"},
+		{`[{"op": "set", "field": "a"}, {"op": "cast", "field": "a", "type": "uuid"}]`, "step 2 (cast): `type` has to be one of"},
+		{`[{"op": "cast", "type": "int"}]`, "step 1 (cast): `field` is required"},
+		{`[{"op": "project", "fields": []}]`, "step 1 (project): `fields` are required"},
+		{`[{"op": "remove", "fields": ["$."]}]`, "step 1 (remove): `fields` are required"},
+		{`[{"op": "compute", "field": "a", "expression": "b +"}]`, "step 1 (compute): unexpected end"},
+		{`[{"op": "flatten"}]`, "step 1 (flatten): unsupported op"},
+	}
+
+	for _, test := range tests {
+		_, err := compileTransformations(test.transformations)
+		if err == nil {
+			t.Errorf("compileTransformations(%s) succeeded, want an error", test.transformations)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.message) {
+			t.Errorf("compileTransformations(%s) failed with %q, want it to mention %q", test.transformations, err, test.message)
+		}
+	}
+
+	if steps, err := compileTransformations("  "); steps != nil || err != nil {
+		t.Errorf("compileTransformations of nothing = %v, %v, want no steps", steps, err)
+	}
+}
+
+func TestApplyTransformations(t *testing.T) {
+
+	tests := []struct {
+		name            string
+		transformations string
+		payload         string
+		want            string
+		errors          int
+	}{
+		{
+			name:            "rename into a nested field",
+			transformations: `[{"op": "rename", "from": "usr", "to": "user.id"}]`,
+			payload:         `{"usr": 7, "user": {"name": "Jane"}}`,
+			want:            `{"user": {"id": 7, "name": "Jane"}}`,
+		},
+		{
+			name:            "rename of a missing field",
+			transformations: `[{"op": "rename", "from": "usr", "to": "user.id"}]`,
+			payload:         `{"name": "Jane"}`,
+			want:            `{"name": "Jane"}`,
+		},
+		{
+			name:            "cast",
+			transformations: `[{"op": "cast", "field": "amount", "type": "float"}, {"op": "cast", "field": "missing", "type": "int"}]`,
+			payload:         `{"amount": "12.50"}`,
+			want:            `{"amount": 12.5}`,
+		},
+		{
+			name:            "failed cast leaves the field",
+			transformations: `[{"op": "cast", "field": "amount", "type": "float"}, {"op": "set", "field": "source", "value": "web"}]`,
+			payload:         `{"amount": "n/a"}`,
+			want:            `{"amount": "n/a", "source": "web"}`,
+			errors:          1,
+		},
+		{
+			name:            "project",
+			transformations: `[{"op": "project", "fields": ["user.id", "amount", "missing"]}]`,
+			payload:         `{"user": {"id": 7, "name": "Jane"}, "amount": 3, "debug": true}`,
+			want:            `{"user": {"id": 7}, "amount": 3}`,
+		},
+		{
+			name:            "remove",
+			transformations: `[{"op": "remove", "fields": ["debug", "user.name", "missing.field"]}]`,
+			payload:         `{"user": {"id": 7, "name": "Jane"}, "debug": true}`,
+			want:            `{"user": {"id": 7}}`,
+		},
+		{
+			name:            "set replaces a scalar on the path",
+			transformations: `[{"op": "set", "field": "meta.source", "value": "web"}]`,
+			payload:         `{"meta": "old"}`,
+			want:            `{"meta": {"source": "web"}}`,
+		},
+		{
+			name:            "compute from earlier steps",
+			transformations: `[{"op": "cast", "field": "quantity", "type": "int"}, {"op": "compute", "field": "total", "expression": "price * quantity"}, {"op": "remove", "fields": ["price"]}]`,
+			payload:         `{"price": 2.5, "quantity": "4"}`,
+			want:            `{"quantity": 4, "total": 10}`,
+		},
+		{
+			name:            "failed compute leaves the field",
+			transformations: `[{"op": "compute", "field": "ratio", "expression": "a / b"}]`,
+			payload:         `{"a": 1, "b": 0}`,
+			want:            `{"a": 1, "b": 0}`,
+			errors:          1,
+		},
+	}
+
+	for _, test := range tests {
+
+		steps, err := compileTransformations(test.transformations)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		got, errs := applyTransformations(decodePayload(t, test.payload), steps)
+		if len(errs) != test.errors {
+			t.Errorf("%s: %d errors %v, want %d", test.name, len(errs), errs, test.errors)
+		}
+
+		//compared as JSON, casts to int give int64 where decoded payloads have float64
+		gotJSON, _ := json.Marshal(got)
+		if !reflect.DeepEqual(decodePayload(t, string(gotJSON)), decodePayload(t, test.want)) {
+			t.Errorf("%s: got %s, want %s", test.name, gotJSON, test.want)
+		}
+	}
+}
+
+func TestPreviewTransformationsHandler(t *testing.T) {
+
+	defer func(transformations map[string][]compiledTransformationStep, rules map[string][]compiledMaskingRule) {
+		streamTransformations, maskingRules = transformations, rules
+	}(streamTransformations, maskingRules)
+
+	steps, err := compileTransformations(`[{"op": "compute", "field": "total", "expression": "price * quantity"}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamTransformations = map[string][]compiledTransformationStep{"stream": steps}
+	maskingRules = map[string][]compiledMaskingRule{"stream": {newMaskingRule(t, "email", maskingActionDrop, "")}}
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+		want   string
+	}{
+		{
+			name:   "transformations of the stream, after its masking rules",
+			method: http.MethodPost,
+			body:   `{"stream_id": "stream", "payload": {"price": 2, "quantity": 3, "email": "jane@example.com"}}`,
+			status: http.StatusOK,
+			want:   `{"payload": {"price": 2, "quantity": 3, "total": 6}, "errors": []}`,
+		},
+		{
+			name:   "transformations of the request",
+			method: http.MethodPost,
+			body:   `{"transformations": [{"op": "rename", "from": "a", "to": "b"}, {"op": "cast", "field": "b", "type": "int"}], "payload": {"a": "x"}}`,
+			status: http.StatusOK,
+			want:   `{"payload": {"b": "x"}, "errors": ["step 2 (cast): cannot cast \"x\" to int"]}`,
+		},
+		{
+			name:   "invalid transformations",
+			method: http.MethodPost,
+			body:   `{"transformations": [{"op": "flatten"}], "payload": {}}`,
+			status: http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "no payload",
+			method: http.MethodPost,
+			body:   `{"stream_id": "stream"}`,
+			status: http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "not JSON",
+			method: http.MethodPost,
+			body:   `payload`,
+			status: http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "wrong method",
+			method: http.MethodGet,
+			status: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, test := range tests {
+
+		recorder := httptest.NewRecorder()
+		previewTransformationsHandler(recorder, httptest.NewRequest(test.method, "/previewTransformations", strings.NewReader(test.body)))
+
+		if recorder.Code != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, recorder.Code, test.status)
+			continue
+		}
+		if test.want == "" {
+			continue
+		}
+
+		var got, want interface{}
+		json.Unmarshal(recorder.Body.Bytes(), &got)
+		json.Unmarshal([]byte(test.want), &want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %s, want %s", test.name, recorder.Body.String(), test.want)
+		}
+	}
+}
@@ -0,0 +1,407 @@
+//webhook sink: events of a stream are pushed to HTTP endpoints once they have been written, next to the file store sink
+//each webhook, managed through the config service, batches events and posts them, HMAC signed when it has a signing secret
+//webhooks with `content` partitions get the partition closed events of the stream instead, see partition.go
+//failed posts are retried with exponential backoff, batches that still fail are kept in `webhook_failures`
+//events turned away by a full queue are kept there as well, in batches - one writer records the failures over a shared connection
+//batches waiting to be posted live in memory, events written right before the ingester stops may not reach the endpoint
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//headers of signed requests, the signature is HMAC-SHA256 over "<timestamp>.<body>"
+const (
+	webhookTimestampHeader = "X-Rtdl-Timestamp"
+	webhookSignatureHeader = "X-Rtdl-Signature" //sha256=<hex>
+)
+
+//supported values of a webhook's `content`
+const (
+	webhookContentEvents     = "events"     //events of the stream once written
+	webhookContentPartitions = "partitions" //partition closed events of the stream
+)
+
+//events queued per webhook before new ones are turned away into the failure log
+const webhookQueueSize = 10000
+
+//failed batches waiting for the failure log, batches of turned away events beyond it are dropped
+const webhookFailureQueueSize = 1000
+
+//request timeout and retry backoff, doubled per attempt up to the maximum
+var (
+	webhookTimeout      = getEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second)
+	webhookRetryBackoff = getEnvDuration("WEBHOOK_RETRY_BACKOFF", time.Second)
+	webhookRetryMax     = getEnvDuration("WEBHOOK_RETRY_MAX", 5*time.Minute)
+)
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+//struct representation of a webhook
+type Webhook struct {
+	WebhookId        int64          `db:"webhook_id"`
+	StreamId         string         `db:"stream_id"`
+	MessageType      sql.NullString `db:"message_type"`
+	Content          string         `db:"content"`
+	Url              string         `db:"url"`
+	SigningSecretRef sql.NullString `db:"signing_secret_ref"`
+	BatchSize        int64          `db:"batch_size"`
+	BatchIntervalMs  int64          `db:"batch_interval_ms"`
+	MaxAttempts      int64          `db:"max_attempts"`
+	MaxConcurrency   int64          `db:"max_concurrency"`
+	CreatedAt        time.Time      `db:"created_at"`
+}
+
+//a webhook with its queue and delivery slots
+type webhookSink struct {
+	webhook     Webhook
+	secret      []byte //nil for unsigned requests
+	fingerprint string
+	events      chan json.RawMessage
+	overflow    []json.RawMessage //turned away by the full queue, guarded by webhookSinksMutex
+	slots       chan struct{}     //one per delivery in flight
+	stop        chan struct{}
+}
+
+//batch on its way to the failure log
+type webhookFailure struct {
+	webhook  Webhook
+	batch    []json.RawMessage
+	attempts int64
+	status   int
+	err      error
+}
+
+//body posted to a webhook
+type webhookBatch struct {
+	WebhookId int64             `json:"webhook_id"`
+	StreamId  string            `json:"stream_id"`
+	Events    []json.RawMessage `json:"events"`
+}
+
+//running sinks per webhook id, and the sinks of every stream - both swapped by LoadConfig while events are queued
+var webhookSinksMutex sync.Mutex
+var webhookSinks = map[int64]*webhookSink{}
+var streamWebhooks map[string][]*webhookSink
+
+//failure log queue and its writer, started with the first sink
+var webhookFailures = make(chan webhookFailure, webhookFailureQueueSize)
+var webhookFailureWriter sync.Once
+
+//settings a running sink depends on, a sink is replaced when they change
+func getWebhookFingerprint(webhook Webhook) string {
+	return fmt.Sprint(webhook.StreamId, webhook.MessageType.String, webhook.Content, webhook.Url, webhook.SigningSecretRef.String, webhook.BatchSize, webhook.BatchIntervalMs, webhook.MaxAttempts, webhook.MaxConcurrency)
+}
+
+//load the webhooks of every stream, starting sinks for new and changed webhooks and stopping those of removed ones
+func loadWebhooks(db *sqlx.DB) error {
+
+	var webhooks []Webhook
+	err := db.Select(&webhooks, "SELECT * FROM webhooks ORDER BY stream_id, webhook_id")
+	if err != nil {
+		return err
+	}
+
+	webhookSinksMutex.Lock()
+	defer webhookSinksMutex.Unlock()
+
+	sinks := map[int64]*webhookSink{}
+	streamSinks := map[string][]*webhookSink{}
+
+	for _, webhook := range webhooks {
+
+		sink, running := webhookSinks[webhook.WebhookId]
+		if !running || sink.fingerprint != getWebhookFingerprint(webhook) {
+			newSink, err := newWebhookSink(webhook)
+			if err != nil {
+				log.Println("Invalid webhook", webhook.WebhookId, "of stream", webhook.StreamId, err)
+				continue
+			}
+			sink = newSink
+		}
+
+		sinks[webhook.WebhookId] = sink
+		streamSinks[webhook.StreamId] = append(streamSinks[webhook.StreamId], sink)
+	}
+
+	for webhookId, sink := range webhookSinks {
+		if sinks[webhookId] != sink {
+			close(sink.stop) //posts what it has queued, then exits
+		}
+	}
+
+	webhookSinks = sinks
+	streamWebhooks = streamSinks
+	return nil
+}
+
+//start the sink of a webhook
+func newWebhookSink(webhook Webhook) (*webhookSink, error) {
+
+	if webhook.BatchSize <= 0 || webhook.BatchIntervalMs <= 0 || webhook.MaxAttempts <= 0 || webhook.MaxConcurrency <= 0 {
+		return nil, errors.New("batch size, batch interval, max attempts and max concurrency have to be positive")
+	}
+
+	sink := &webhookSink{
+		webhook:     webhook,
+		fingerprint: getWebhookFingerprint(webhook),
+		events:      make(chan json.RawMessage, webhookQueueSize),
+		slots:       make(chan struct{}, webhook.MaxConcurrency),
+		stop:        make(chan struct{}),
+	}
+
+	if webhook.SigningSecretRef.String != "" {
+		secret, err := readSecret(webhook.SigningSecretRef.String)
+		if err != nil {
+			return nil, errors.New("signing secret: " + err.Error())
+		}
+		sink.secret = []byte(strings.TrimSpace(string(secret)))
+	}
+
+	webhookFailureWriter.Do(func() { go writeWebhookFailures() })
+	go sink.run()
+
+	return sink, nil
+}
+
+//queue an event written for a stream on the webhooks of the stream
+func sendToWebhooks(streamId string, messageType string, payload map[string]interface{}) {
+
+	webhookSinksMutex.Lock()
+	hasWebhooks := len(streamWebhooks[streamId]) > 0
+	webhookSinksMutex.Unlock()
+
+	if !hasWebhooks {
+		return
+	}
+
+	event, _ := json.Marshal(payload)
+	queueWebhookEvent(streamId, webhookContentEvents, messageType, event)
+}
+
+//queue an event on the webhooks of a stream taking its content and message type
+func queueWebhookEvent(streamId string, content string, messageType string, event json.RawMessage) {
+
+	webhookSinksMutex.Lock()
+	defer webhookSinksMutex.Unlock()
+
+	for _, sink := range streamWebhooks[streamId] {
+
+		if sink.webhook.Content != content {
+			continue
+		}
+		if sink.webhook.MessageType.String != "" && sink.webhook.MessageType.String != messageType {
+			continue
+		}
+
+		select {
+		case sink.events <- event:
+		default: //endpoint too far behind, the event goes to the failure log with the others turned away
+			sink.overflow = append(sink.overflow, event)
+			if int64(len(sink.overflow)) >= sink.webhook.BatchSize {
+				sink.recordOverflow()
+			}
+		}
+	}
+}
+
+//hand the events turned away to the failure log as one batch, they are dropped when the failure log is behind as well
+//called with webhookSinksMutex held
+func (sink *webhookSink) recordOverflow() {
+
+	if len(sink.overflow) == 0 {
+		return
+	}
+
+	select {
+	case webhookFailures <- webhookFailure{webhook: sink.webhook, batch: sink.overflow, err: errors.New("webhook queue full")}:
+	default:
+		log.Println("Failure log full, dropping", len(sink.overflow), "events turned away by webhook", sink.webhook.WebhookId, "of stream", sink.webhook.StreamId)
+	}
+	sink.overflow = nil
+}
+
+//batch queued events by size and interval until the sink is stopped
+func (sink *webhookSink) run() {
+
+	ticker := time.NewTicker(time.Duration(sink.webhook.BatchIntervalMs) * time.Millisecond)
+	defer ticker.Stop()
+
+	var batch []json.RawMessage
+
+	for {
+		select {
+
+		case event := <-sink.events:
+			batch = append(batch, event)
+			if int64(len(batch)) >= sink.webhook.BatchSize {
+				sink.dispatch(batch)
+				batch = nil
+			}
+
+		case <-ticker.C:
+			if len(batch) > 0 {
+				sink.dispatch(batch)
+				batch = nil
+			}
+			webhookSinksMutex.Lock()
+			sink.recordOverflow()
+			webhookSinksMutex.Unlock()
+
+		case <-sink.stop:
+		drain:
+			for {
+				select {
+				case event := <-sink.events:
+					batch = append(batch, event)
+				default:
+					break drain
+				}
+			}
+			for len(batch) > 0 {
+				size := int(sink.webhook.BatchSize)
+				if size > len(batch) {
+					size = len(batch)
+				}
+				sink.dispatch(batch[:size])
+				batch = batch[size:]
+			}
+			webhookSinksMutex.Lock()
+			sink.recordOverflow()
+			webhookSinksMutex.Unlock()
+			return
+		}
+	}
+}
+
+//deliver a batch once a delivery slot is free, a busy endpoint holds up batching rather than being flooded
+func (sink *webhookSink) dispatch(batch []json.RawMessage) {
+
+	sink.slots <- struct{}{}
+
+	go func() {
+		defer func() { <-sink.slots }()
+		sink.deliver(batch)
+	}()
+}
+
+//post a batch, retrying with exponential backoff - batches that cannot be delivered are recorded in the failure log
+func (sink *webhookSink) deliver(batch []json.RawMessage) {
+
+	body, _ := json.Marshal(webhookBatch{WebhookId: sink.webhook.WebhookId, StreamId: sink.webhook.StreamId, Events: batch})
+
+	var status int
+	var err error
+
+	attempt := int64(1)
+	for ; ; attempt++ {
+
+		status, err = sink.post(body)
+		if err == nil {
+			return
+		}
+
+		retryable := status == 0 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= 500
+		if !retryable || attempt >= sink.webhook.MaxAttempts {
+			break
+		}
+
+		time.Sleep(getWebhookBackoff(attempt))
+	}
+
+	log.Println("Giving up on webhook", sink.webhook.WebhookId, "of stream", sink.webhook.StreamId, "after", attempt, "attempts:", err)
+	webhookFailures <- webhookFailure{webhook: sink.webhook, batch: batch, attempts: attempt, status: status, err: err} //waits for the writer, delivery slots bound the waiting
+}
+
+//wait before the next attempt: the backoff doubled per attempt made, capped, with up to 20% jitter so retries do not line up
+func getWebhookBackoff(attempt int64) time.Duration {
+
+	backoff := webhookRetryBackoff
+	for i := int64(1); i < attempt && backoff < webhookRetryMax; i++ {
+		backoff *= 2
+	}
+	if backoff > webhookRetryMax {
+		backoff = webhookRetryMax
+	}
+	return backoff + time.Duration(rand.Int63n(int64(backoff)/5+1))
+}
+
+//post a body to the webhook, returns the response status (zero when there was none)
+func (sink *webhookSink) post(body []byte) (int, error) {
+
+	req, err := http.NewRequest(http.MethodPost, sink.webhook.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if sink.secret != nil {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(webhookTimestampHeader, timestamp)
+		req.Header.Set(webhookSignatureHeader, "sha256="+signWebhookBody(sink.secret, timestamp, body))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body) //lets the connection be reused
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, errors.New("webhook responded " + resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+//hex HMAC-SHA256 of "<timestamp>.<body>", the timestamp lets receivers reject replayed requests
+func signWebhookBody(secret []byte, timestamp string, body []byte) string {
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//keep the batches that could not be delivered in `webhook_failures`, one at a time over one connection
+func writeWebhookFailures() {
+
+	db, err := sqlx.Open("postgres", psqlCon)
+	if err != nil {
+		log.Println("Failed to open a DB connection: ", err)
+		return
+	}
+	db.SetMaxOpenConns(1)
+
+	for failure := range webhookFailures {
+
+		events, _ := json.Marshal(failure.batch)
+
+		_, err = db.Exec(`INSERT INTO webhook_failures (webhook_id, stream_id, events, event_count, attempts, last_status, last_error)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			failure.webhook.WebhookId, failure.webhook.StreamId, string(events), len(failure.batch), failure.attempts,
+			sql.NullInt64{Int64: int64(failure.status), Valid: failure.status != 0}, failure.err.Error())
+		if err != nil {
+			log.Println("Error recording failed webhook batch of webhook", failure.webhook.WebhookId, err)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestQueueWebhookEventOverflow(t *testing.T) {
+
+	defer func(sinks map[string][]*webhookSink) { streamWebhooks = sinks }(streamWebhooks)
+	defer func(failures chan webhookFailure) { webhookFailures = failures }(webhookFailures)
+
+	//no sink is running, the queue holds one event
+	sink := &webhookSink{
+		webhook: Webhook{WebhookId: 1, StreamId: "stream", Content: webhookContentEvents, BatchSize: 2},
+		events:  make(chan json.RawMessage, 1),
+	}
+	streamWebhooks = map[string][]*webhookSink{"stream": {sink}}
+	webhookFailures = make(chan webhookFailure, 1)
+
+	for i := 0; i < 6; i++ {
+		queueWebhookEvent("stream", webhookContentEvents, "track", json.RawMessage(`{}`))
+	}
+	queueWebhookEvent("stream", webhookContentPartitions, "track", json.RawMessage(`{}`))
+
+	//events turned away go to the failure log a batch at a time, batches beyond a full failure log are dropped
+	if len(sink.events) != 1 || len(webhookFailures) != 1 || len(sink.overflow) != 1 {
+		t.Fatalf("%d queued, %d failed batches, %d turned away, want 1, 1 and 1", len(sink.events), len(webhookFailures), len(sink.overflow))
+	}
+	failure := <-webhookFailures
+	if len(failure.batch) != 2 || failure.attempts != 0 || failure.webhook.WebhookId != 1 {
+		t.Errorf("failed batch of %d events after %d attempts, want the first two turned away", len(failure.batch), failure.attempts)
+	}
+
+	//the rest is recorded on the next tick
+	webhookSinksMutex.Lock()
+	sink.recordOverflow()
+	webhookSinksMutex.Unlock()
+	if failure := <-webhookFailures; len(failure.batch) != 1 || len(sink.overflow) != 0 {
+		t.Errorf("failed batch of %d events with %d left, want the last one", len(failure.batch), len(sink.overflow))
+	}
+}